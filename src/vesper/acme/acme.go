@@ -0,0 +1,354 @@
+// Package acme is an ACME (RFC 8555) client that enrolls SHAKEN certificates with an STI-CA
+// using the TNAuthList identifier and the tkauth-01 (SPC token) challenge (ATIS-1000080, RFC 9447, RFC 9448)
+package acme
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"bytes"
+	"strings"
+	"strconv"
+	"io/ioutil"
+	"net/http"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/base64"
+	"vesper/tnauthlist"
+)
+
+// globals
+var (
+	// interval between polls of an authorization or order that is still being processed
+	pollInterval		= 2 * time.Second
+	// max number of polls before giving up on an authorization or order
+	pollAttempts		= 30
+)
+
+// IdentifierType - ACME identifier type for TNAuthList (RFC 9448)
+const IdentifierType = "TNAuthList"
+
+// ChallengeType - ACME challenge type for authority (SPC) tokens (RFC 9447)
+const ChallengeType = "tkauth-01"
+
+// TokenSource returns an SPC token (authority token) bound to the ACME account whose
+// key has fingerprint fp
+type TokenSource func(fp string) (string, error)
+
+// Client - ACME client with an account key
+type Client struct {
+	sync.Mutex	// A field declared with a type but no explicit field name is an
+					// anonymous field, also called an embedded field or an embedding of
+					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	httpClient		*http.Client
+	directoryUrl	string
+	accountKey		*ecdsa.PrivateKey
+	dir						*directory
+	kid						string
+	nonce					string
+}
+
+// directory - ACME directory object (subset)
+type directory struct {
+	NewNonce			string		`json:"newNonce"`
+	NewAccount		string		`json:"newAccount"`
+	NewOrder			string		`json:"newOrder"`
+}
+
+type identifier struct {
+	Type					string		`json:"type"`
+	Value					string		`json:"value"`
+}
+
+type order struct {
+	Status					string				`json:"status"`
+	Identifiers			[]identifier	`json:"identifiers"`
+	Authorizations	[]string			`json:"authorizations"`
+	Finalize				string				`json:"finalize"`
+	Certificate			string				`json:"certificate,omitempty"`
+	Error						*Problem			`json:"error,omitempty"`
+}
+
+type authorization struct {
+	Status				string				`json:"status"`
+	Identifier		identifier		`json:"identifier"`
+	Challenges		[]challenge		`json:"challenges"`
+}
+
+type challenge struct {
+	Type					string				`json:"type"`
+	Url						string				`json:"url"`
+	Status				string				`json:"status"`
+	Error					*Problem			`json:"error,omitempty"`
+}
+
+// Problem - ACME error (RFC 7807 problem document)
+type Problem struct {
+	Type					string				`json:"type"`
+	Detail				string				`json:"detail"`
+	Status				int						`json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("ACME error %v (status: %v) - %v", p.Type, p.Status, p.Detail)
+}
+
+// NewClient creates a client for the ACME server with directory at u, using account key k
+func NewClient(h *http.Client, u string, k *ecdsa.PrivateKey) *Client {
+	return &Client{httpClient: h, directoryUrl: u, accountKey: k}
+}
+
+// Fingerprint returns the fingerprint of the account key, in the form expected in SPC token
+// requests to STI-PA ("SHA256 " followed by colon separated upper case hex of SHA-256 digest of the public key)
+func (c *Client) Fingerprint() string {
+	der, _ := x509.MarshalPKIXPublicKey(&c.accountKey.PublicKey)
+	d := sha256.Sum256(der)
+	h := make([]string, len(d))
+	for i, b := range d {
+		h[i] = fmt.Sprintf("%02X", b)
+	}
+	return "SHA256 " + strings.Join(h, ":")
+}
+
+// Register creates the ACME account (or looks up the existing account) for the account key
+func (c *Client) Register(contact []string) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.discover(); err != nil {
+		return err
+	}
+	p := map[string]interface{}{"termsOfServiceAgreed": true}
+	if len(contact) > 0 {
+		p["contact"] = contact
+	}
+	resp, _, err := c.post(c.dir.NewAccount, p, nil, true)
+	if err != nil {
+		return fmt.Errorf("%v - ACME account registration", err)
+	}
+	c.kid = resp.Header.Get("Location")
+	if len(c.kid) == 0 {
+		return fmt.Errorf("ACME account registration response has no Location header")
+	}
+	return nil
+}
+
+// Obtain orders, validates and downloads a certificate for TNAuthList l with public key of k.
+// The tkauth-01 challenge is answered with the SPC token from token.
+// The certificate chain is returned in PEM
+func (c *Client) Obtain(l tnauthlist.List, token TokenSource, k *ecdsa.PrivateKey) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.kid) == 0 {
+		return nil, fmt.Errorf("ACME account is not registered")
+	}
+	der, err := l.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	id := identifier{Type: IdentifierType, Value: base64.RawURLEncoding.EncodeToString(der)}
+	var o order
+	resp, _, err := c.post(c.dir.NewOrder, map[string]interface{}{"identifiers": []identifier{id}}, &o, false)
+	if err != nil {
+		return nil, fmt.Errorf("%v - ACME new order", err)
+	}
+	orderUrl := resp.Header.Get("Location")
+	for _, u := range o.Authorizations {
+		if err := c.authorize(u, token); err != nil {
+			return nil, err
+		}
+	}
+	csr, err := certificateRequest(l, der, k)
+	if err != nil {
+		return nil, err
+	}
+	if resp, _, err = c.post(o.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}, &o, false); err != nil {
+		return nil, fmt.Errorf("%v - ACME finalize order", err)
+	}
+	for i := 0; o.Status != "valid"; i++ {
+		if o.Status == "invalid" || i >= pollAttempts {
+			return nil, fmt.Errorf("ACME order %v status is %v - %v", orderUrl, o.Status, o.Error)
+		}
+		time.Sleep(retryAfter(resp))
+		if resp, _, err = c.post(orderUrl, nil, &o, false); err != nil {
+			return nil, fmt.Errorf("%v - ACME order", err)
+		}
+	}
+	_, b, err := c.post(o.Certificate, nil, nil, false)
+	if err != nil {
+		return nil, fmt.Errorf("%v - ACME certificate download", err)
+	}
+	return b, nil
+}
+
+// authorize answers the tkauth-01 challenge of authorization at u (if pending) and waits
+// until the authorization is valid
+func (c *Client) authorize(u string, token TokenSource) error {
+	var a authorization
+	resp, _, err := c.post(u, nil, &a, false)
+	if err != nil {
+		return fmt.Errorf("%v - ACME authorization", err)
+	}
+	if a.Status == "valid" {
+		return nil
+	}
+	var ch *challenge
+	for i := range a.Challenges {
+		if a.Challenges[i].Type == ChallengeType {
+			ch = &a.Challenges[i]
+		}
+	}
+	if ch == nil {
+		return fmt.Errorf("ACME authorization %v has no %v challenge", u, ChallengeType)
+	}
+	t, err := token(c.Fingerprint())
+	if err != nil {
+		return fmt.Errorf("%v - SPC token", err)
+	}
+	if _, _, err := c.post(ch.Url, map[string]string{"tkauth": t}, nil, false); err != nil {
+		return fmt.Errorf("%v - ACME %v challenge", err, ChallengeType)
+	}
+	for i := 0; a.Status != "valid"; i++ {
+		if a.Status == "invalid" || i >= pollAttempts {
+			for _, v := range a.Challenges {
+				if v.Error != nil {
+					return fmt.Errorf("ACME authorization %v status is %v - %v", u, a.Status, v.Error)
+				}
+			}
+			return fmt.Errorf("ACME authorization %v status is %v", u, a.Status)
+		}
+		time.Sleep(retryAfter(resp))
+		if resp, _, err = c.post(u, nil, &a, false); err != nil {
+			return fmt.Errorf("%v - ACME authorization", err)
+		}
+	}
+	return nil
+}
+
+// certificateRequest returns DER encoded CSR with TNAuthList extension (DER in der) signed by k
+func certificateRequest(l tnauthlist.List, der []byte, k *ecdsa.PrivateKey) ([]byte, error) {
+	cn := "SHAKEN"
+	if s := l.SPCs(); len(s) > 0 {
+		cn += " " + s[0]
+	}
+	tmpl := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: cn},
+		ExtraExtensions: []pkix.Extension{{Id: tnauthlist.OID, Value: der}},
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, tmpl, k)
+	if err != nil {
+		return nil, fmt.Errorf("%v - create CSR", err)
+	}
+	return csr, nil
+}
+
+// fetch ACME directory, once
+func (c *Client) discover() error {
+	if c.dir != nil {
+		return nil
+	}
+	resp, err := c.httpClient.Get(c.directoryUrl)
+	if err != nil {
+		return fmt.Errorf("%v - GET %v failed", err, c.directoryUrl)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v response status - %v", c.directoryUrl, resp.StatusCode)
+	}
+	var d directory
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return fmt.Errorf("%v - decode ACME directory", err)
+	}
+	if len(d.NewNonce) == 0 || len(d.NewAccount) == 0 || len(d.NewOrder) == 0 {
+		return fmt.Errorf("ACME directory %v is missing newNonce, newAccount or newOrder", c.directoryUrl)
+	}
+	c.dir = &d
+	return nil
+}
+
+// returns a fresh nonce (from the last response or newNonce)
+func (c *Client) getNonce() (string, error) {
+	if n := c.nonce; len(n) > 0 {
+		c.nonce = ""
+		return n, nil
+	}
+	resp, err := c.httpClient.Head(c.dir.NewNonce)
+	if err != nil {
+		return "", fmt.Errorf("%v - HEAD %v failed", err, c.dir.NewNonce)
+	}
+	resp.Body.Close()
+	n := resp.Header.Get("Replay-Nonce")
+	if len(n) == 0 {
+		return "", fmt.Errorf("HEAD %v response has no Replay-Nonce header", c.dir.NewNonce)
+	}
+	return n, nil
+}
+
+// post sends JWS signed request with payload p (POST-as-GET if p is nil) to u and decodes
+// the JSON response body into v, if not nil. The request is signed with jwk (account key)
+// if useJwk is true, with kid (account URL) otherwise. A request rejected for a bad nonce is retried once
+func (c *Client) post(u string, p interface{}, v interface{}, useJwk bool) (*http.Response, []byte, error) {
+	var payload []byte
+	if p != nil {
+		var err error
+		if payload, err = json.Marshal(p); err != nil {
+			return nil, nil, err
+		}
+	}
+	for retry := 0; ; retry++ {
+		n, err := c.getNonce()
+		if err != nil {
+			return nil, nil, err
+		}
+		h := map[string]interface{}{"alg": "ES256", "nonce": n, "url": u}
+		if useJwk {
+			h["jwk"] = jwk(&c.accountKey.PublicKey)
+		} else {
+			h["kid"] = c.kid
+		}
+		body, err := signJws(c.accountKey, h, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v - http.NewRequest failed", err)
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v - POST %v failed", err, u)
+		}
+		rb, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.nonce = resp.Header.Get("Replay-Nonce")
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v - POST %v response body", err, u)
+		}
+		if resp.StatusCode >= 400 {
+			pr := &Problem{Status: resp.StatusCode}
+			json.Unmarshal(rb, pr)
+			if pr.Type == "urn:ietf:params:acme:error:badNonce" && retry == 0 {
+				continue
+			}
+			return nil, nil, pr
+		}
+		if v != nil {
+			if err := json.Unmarshal(rb, v); err != nil {
+				return nil, nil, fmt.Errorf("%v - POST %v unable to parse JSON object in response body", err, u)
+			}
+		}
+		return resp, rb, nil
+	}
+}
+
+// returns the time to wait before polling again (Retry-After header in seconds, or default poll interval)
+func retryAfter(resp *http.Response) time.Duration {
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 && time.Duration(s)*time.Second < time.Minute {
+		return time.Duration(s) * time.Second
+	}
+	return pollInterval
+}
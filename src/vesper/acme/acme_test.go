@@ -0,0 +1,321 @@
+package acme
+
+import (
+	"testing"
+	"fmt"
+	"time"
+	"sync"
+	"strings"
+	"math/big"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/base64"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"vesper/sticr"
+	"vesper/tnauthlist"
+	"vesper/signcredentials"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// standIn - minimal ACME server (STI-CA) that verifies JWS signed requests, validates
+// tkauth-01 challenges against a fixed SPC token and issues certificates for CSRs
+type standIn struct {
+	sync.Mutex
+	t						*testing.T
+	srv					*httptest.Server
+	caKey				*ecdsa.PrivateKey
+	caCert			*x509.Certificate
+	token				string
+	validity		time.Duration
+	nonce				int
+	nonces			map[string]bool
+	badNonce		bool								// reject next request with badNonce
+	account			*ecdsa.PublicKey
+	identifier	string
+	authorized	bool
+	issued			int
+	cert				[]byte
+}
+
+func newStandIn(t *testing.T, token string, validity time.Duration) *standIn {
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "STI-CA"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(24 * time.Hour), IsCA: true, BasicConstraintsValid: true}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	ca, _ := x509.ParseCertificate(der)
+	s := &standIn{t: t, caKey: k, caCert: ca, token: token, validity: validity, nonces: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"newNonce": s.srv.URL + "/new-nonce", "newAccount": s.srv.URL + "/new-account", "newOrder": s.srv.URL + "/new-order"})
+	})
+	mux.HandleFunc("/new-nonce", func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+		s.addNonce(w)
+	})
+	mux.HandleFunc("/", s.handle)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+func (s *standIn) addNonce(w http.ResponseWriter) {
+	s.nonce++
+	n := fmt.Sprintf("nonce-%v", s.nonce)
+	s.nonces[n] = true
+	w.Header().Set("Replay-Nonce", n)
+}
+
+func (s *standIn) problem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Problem{Type: "urn:ietf:params:acme:error:" + typ, Detail: detail, Status: status})
+}
+
+// verify JWS in request body and return its payload
+func (s *standIn) verify(r *http.Request) ([]byte, error) {
+	var j jws
+	if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+		return nil, err
+	}
+	hb, _ := base64.RawURLEncoding.DecodeString(j.Protected)
+	var h struct {
+		Alg		string							`json:"alg"`
+		Nonce	string							`json:"nonce"`
+		Url		string							`json:"url"`
+		Jwk		map[string]string		`json:"jwk"`
+		Kid		string							`json:"kid"`
+	}
+	if err := json.Unmarshal(hb, &h); err != nil {
+		return nil, err
+	}
+	if h.Alg != "ES256" || h.Url != s.srv.URL + r.URL.Path {
+		return nil, fmt.Errorf("unexpected alg %v or url %v", h.Alg, h.Url)
+	}
+	if !s.nonces[h.Nonce] {
+		return nil, fmt.Errorf("badNonce")
+	}
+	delete(s.nonces, h.Nonce)
+	k := s.account
+	switch {
+	case h.Jwk != nil && len(h.Kid) == 0 && r.URL.Path == "/new-account" :
+		x, _ := base64.RawURLEncoding.DecodeString(h.Jwk["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(h.Jwk["y"])
+		k = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case h.Kid != s.srv.URL + "/account/1" || k == nil :
+		return nil, fmt.Errorf("unknown kid %v", h.Kid)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(j.Signature)
+	d := sha256.Sum256([]byte(j.Protected + "." + j.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(k, d[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, fmt.Errorf("bad signature")
+	}
+	s.account = k
+	return base64.RawURLEncoding.DecodeString(j.Payload)
+}
+
+func (s *standIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if s.badNonce {
+		s.badNonce = false
+		s.addNonce(w)
+		s.problem(w, http.StatusBadRequest, "badNonce", "stale nonce")
+		return
+	}
+	p, err := s.verify(r)
+	s.addNonce(w)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	o := order{Status: "pending", Authorizations: []string{s.srv.URL + "/authz/1"}, Finalize: s.srv.URL + "/finalize/1"}
+	if s.authorized {
+		o.Status = "ready"
+	}
+	switch r.URL.Path {
+	case "/new-account":
+		w.Header().Set("Location", s.srv.URL + "/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+	case "/new-order":
+		var req struct {
+			Identifiers		[]identifier		`json:"identifiers"`
+		}
+		json.Unmarshal(p, &req)
+		if len(req.Identifiers) != 1 || req.Identifiers[0].Type != IdentifierType {
+			s.problem(w, http.StatusBadRequest, "unsupportedIdentifier", "TNAuthList identifier expected")
+			return
+		}
+		s.identifier = req.Identifiers[0].Value
+		s.authorized = false
+		o.Status = "pending"
+		o.Identifiers = req.Identifiers
+		w.Header().Set("Location", s.srv.URL + "/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(o)
+	case "/authz/1":
+		a := authorization{Status: "pending", Identifier: identifier{Type: IdentifierType, Value: s.identifier}, Challenges: []challenge{{Type: ChallengeType, Url: s.srv.URL + "/challenge/1", Status: "pending"}}}
+		if s.authorized {
+			a.Status, a.Challenges[0].Status = "valid", "valid"
+		}
+		json.NewEncoder(w).Encode(a)
+	case "/challenge/1":
+		var req map[string]string
+		json.Unmarshal(p, &req)
+		if req["tkauth"] != s.token {
+			s.problem(w, http.StatusForbidden, "unauthorized", "invalid SPC token")
+			return
+		}
+		s.authorized = true
+		json.NewEncoder(w).Encode(challenge{Type: ChallengeType, Url: s.srv.URL + "/challenge/1", Status: "processing"})
+	case "/finalize/1":
+		var req map[string]string
+		json.Unmarshal(p, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req["csr"])
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || csr.CheckSignature() != nil || !s.authorized {
+			s.problem(w, http.StatusForbidden, "badCSR", "CSR is not valid or order is not authorized")
+			return
+		}
+		var ext []pkix.Extension
+		for _, e := range csr.Extensions {
+			if e.Id.Equal(tnauthlist.OID) && base64.RawURLEncoding.EncodeToString(e.Value) == s.identifier {
+				ext = append(ext, e)
+			}
+		}
+		if len(ext) != 1 {
+			s.problem(w, http.StatusBadRequest, "badCSR", "CSR TNAuthList does not match order identifier")
+			return
+		}
+		s.issued++
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(int64(100 + s.issued)), Subject: csr.Subject, NotBefore: time.Now().Add(-time.Minute), NotAfter: time.Now().Add(s.validity), ExtraExtensions: ext}
+		c, _ := x509.CreateCertificate(rand.Reader, tmpl, s.caCert, csr.PublicKey, s.caKey)
+		s.cert = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...)
+		// certificate is issued asynchronously; the client polls the order
+		o.Status = "processing"
+		w.Header().Set("Retry-After", "0")
+		json.NewEncoder(w).Encode(o)
+	case "/order/1":
+		if len(s.cert) > 0 {
+			o.Status = "valid"
+			o.Certificate = s.srv.URL + "/cert/1"
+		}
+		json.NewEncoder(w).Encode(o)
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.cert)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func init() {
+	pollInterval = 10 * time.Millisecond
+}
+
+func testConfig(t *testing.T, s *standIn) Config {
+	d := t.TempDir()
+	tf := filepath.Join(d, "spc-token")
+	if err := ioutil.WriteFile(tf, []byte(s.token + "\n"), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return Config{
+		DirectoryUrl: s.srv.URL + "/directory",
+		TNAuthList: tnauthlist.List{{SPC: "1234"}},
+		TokenFile: tf,
+		StateDir: filepath.Join(d, "acme"),
+		Filename: "shaken-1234.pem",
+		RenewBefore: 24 * time.Hour,
+	}
+}
+
+func testCredentials(t *testing.T) *signcredentials.SigningCredentials {
+	cr, err := sticr.NewObject("https://cr.example.com")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	sc, err := signcredentials.InitManagedObject(kitlog.NewNopLogger(), "test", http.DefaultClient, nil, cr, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return sc
+}
+
+func TestObtain(t *testing.T) {
+	s := newStandIn(t, "spc-token-1234", 90 * 24 * time.Hour)
+	defer s.srv.Close()
+	s.badNonce = true
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c := NewClient(s.srv.Client(), s.srv.URL + "/directory", k)
+	if err := c.Register([]string{"mailto:noc@example.com"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	cert, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fp string
+	b, err := c.Obtain(tnauthlist.List{{SPC: "1234"}}, func(f string) (string, error) { fp = f; return s.token, nil }, cert)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.HasPrefix(fp, "SHA256 ") || fp != c.Fingerprint() {
+		t.Errorf("token source got fingerprint %q; want %q", fp, c.Fingerprint())
+	}
+	l, err := leaf(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if tl, err := tnauthlist.FromCertificate(l); err != nil || len(tl.SPCs()) != 1 || tl.SPCs()[0] != "1234" {
+		t.Errorf("issued certificate TNAuthList = %+v, %v; want SPC 1234", tl, err)
+	}
+	// wrong SPC token
+	if _, err := c.Obtain(tnauthlist.List{{SPC: "1234"}}, func(string) (string, error) { return "bad", nil }, cert); err == nil {
+		t.Errorf("Obtain succeeded with invalid SPC token")
+	}
+}
+
+func TestManager(t *testing.T) {
+	s := newStandIn(t, "spc-token-1234", 90 * 24 * time.Hour)
+	defer s.srv.Close()
+	cfg := testConfig(t, s)
+	sc := testCredentials(t)
+	m, err := InitObject(kitlog.NewNopLogger(), s.srv.Client(), cfg, sc)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	x5u, k := sc.Signing()
+	if x5u != "https://cr.example.com/shaken-1234.pem" || k == nil || sc.Certificate("shaken-1234.pem") == nil {
+		t.Fatalf("signing credentials not set by ACME manager (x5u %v)", x5u)
+	}
+	// not due for renewal
+	if err := m.Renew(); err != nil || s.issued != 1 {
+		t.Fatalf("Renew() = %v with %v certificates issued; want no renewal", err, s.issued)
+	}
+	// restart: certificate in state directory is used
+	if _, err := InitObject(kitlog.NewNopLogger(), s.srv.Client(), cfg, testCredentials(t)); err != nil || s.issued != 1 {
+		t.Fatalf("InitObject() = %v with %v certificates issued; want certificate from state directory", err, s.issued)
+	}
+	// due for renewal; new private key
+	m.config.RenewBefore = 91 * 24 * time.Hour
+	if err := m.Renew(); err != nil || s.issued != 2 {
+		t.Fatalf("Renew() = %v with %v certificates issued; want renewal", err, s.issued)
+	}
+	if _, nk := sc.Signing(); nk.Equal(k) {
+		t.Errorf("private key not replaced on renewal")
+	}
+	// failed renewal keeps current certificate
+	na := m.NotAfter()
+	m.SetTokenSource(func(string) (string, error) { return "", fmt.Errorf("STI-PA unavailable") })
+	if err := m.Renew(); err == nil {
+		t.Fatalf("Renew() succeeded without SPC token")
+	}
+	if m.NotAfter() != na || sc.Certificate("shaken-1234.pem") == nil {
+		t.Errorf("certificate not retained after failed renewal")
+	}
+}
@@ -0,0 +1,46 @@
+package acme
+
+import (
+	"fmt"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/base64"
+)
+
+// JWS in flattened JSON serialization (RFC 7515), as sent in ACME requests
+type jws struct {
+	Protected			string		`json:"protected"`
+	Payload				string		`json:"payload"`
+	Signature			string		`json:"signature"`
+}
+
+// jwk returns JSON web key of P-256 public key k
+func jwk(k *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x": base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// signJws returns JWS with protected header h and payload p (empty for POST-as-GET), signed with ES256
+func signJws(k *ecdsa.PrivateKey, h map[string]interface{}, p []byte) ([]byte, error) {
+	hb, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	j := jws{Protected: base64.RawURLEncoding.EncodeToString(hb), Payload: base64.RawURLEncoding.EncodeToString(p)}
+	d := sha256.Sum256([]byte(j.Protected + "." + j.Payload))
+	r, s, err := ecdsa.Sign(rand.Reader, k, d[:])
+	if err != nil {
+		return nil, fmt.Errorf("%v - sign ACME request", err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	j.Signature = base64.RawURLEncoding.EncodeToString(sig)
+	return json.Marshal(j)
+}
@@ -0,0 +1,266 @@
+package acme
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"os"
+	"strings"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"encoding/pem"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"vesper/tnauthlist"
+	"vesper/signcredentials"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// files in state directory
+const (
+	accountKeyFile		= "account.key"
+	privateKeyFile		= "key.pem"
+	certificateFile		= "cert.pem"
+)
+
+// Config - ACME enrollment settings
+type Config struct {
+	DirectoryUrl		string							// ACME directory URL of STI-CA
+	Contact					[]string						// account contact URLs (e.g. "mailto:noc@example.com")
+	TNAuthList			tnauthlist.List			// TNAuthList the certificate is requested for
+	TokenFile				string							// file with SPC token, used if Tokens is nil
+	Tokens					TokenSource					// source of SPC tokens (e.g. STI-PA API client)
+	StateDir				string							// directory in which account key, private key and certificate are kept
+	Filename				string							// filename under which certificate is published (x5u)
+	RenewBefore			time.Duration				// certificate is renewed this long before it expires
+}
+
+// Manager - enrolls the signing certificate and renews it ahead of expiry. The private key and
+// certificate are handed to the (managed) signing credentials, which also publishes the
+// certificate through the built-in STI-CR
+type Manager struct {
+	sync.RWMutex	// A field declared with a type but no explicit field name is an
+					// anonymous field, also called an embedded field or an embedding of
+					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	config				Config
+	client				*Client
+	credentials		*signcredentials.SigningCredentials
+	token					TokenSource
+	notAfter			time.Time
+}
+
+// Initialize object
+// The certificate in state directory is used if it is still valid and not due for renewal.
+// Otherwise a certificate is obtained from the ACME server
+func InitObject(l kitlog.Logger, h *http.Client, cfg Config, sc *signcredentials.SigningCredentials) (*Manager, error) {
+	glogger = l
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.StateDir, 0700); err != nil {
+		return nil, fmt.Errorf("%v - ACME state directory", err)
+	}
+	k, err := loadOrCreateKey(filepath.Join(cfg.StateDir, accountKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{config: cfg, client: NewClient(h, cfg.DirectoryUrl, k), credentials: sc}
+	m.token = cfg.Tokens
+	if m.token == nil {
+		m.token = m.tokenFromFile
+	}
+	logInfo("type", "acme", "module", "InitObject", "message", "ACME account key loaded", "fingerprint", m.client.Fingerprint())
+	if err := m.load(); err != nil {
+		logError("type", "acme", "module", "InitObject", "message", fmt.Sprintf("%v - certificate in ACME state directory is not used", err))
+	}
+	if err := m.Renew(); err != nil {
+		if time.Now().After(m.notAfter) {
+			return nil, err
+		}
+		// certificate in state directory is due for renewal but still valid; renewal is retried later
+		logError("type", "acme", "module", "InitObject", "message", fmt.Sprintf("%v", err), "notAfter", m.notAfter.UTC().Format(time.RFC3339))
+	}
+	return m, nil
+}
+
+// Validate returns an error if a mandatory setting is missing
+func (cfg Config) Validate() error {
+	switch {
+	case len(strings.TrimSpace(cfg.DirectoryUrl)) == 0 :
+		return fmt.Errorf("ACME directory URL is an empty string")
+	case len(cfg.TNAuthList) == 0 :
+		return fmt.Errorf("TNAuthList (SPC) for ACME is not configured")
+	case len(strings.TrimSpace(cfg.StateDir)) == 0 :
+		return fmt.Errorf("ACME state directory is an empty string")
+	case len(strings.TrimSpace(cfg.Filename)) == 0 || strings.Contains(cfg.Filename, "/") :
+		return fmt.Errorf("filename %q of ACME certificate is not valid", cfg.Filename)
+	case cfg.RenewBefore <= 0 :
+		return fmt.Errorf("ACME renew before period MUST be positive")
+	}
+	return nil
+}
+
+// SetTokenSource replaces the source of SPC tokens
+func (m *Manager) SetTokenSource(t TokenSource) {
+	m.Lock()
+	defer m.Unlock()
+	m.token = t
+}
+
+// NotAfter returns the expiry of the current certificate
+func (m *Manager) NotAfter() time.Time {
+	m.RLock()
+	defer m.RUnlock()
+	return m.notAfter
+}
+
+// Renew obtains a new certificate (with a new private key) if there is no certificate or the
+// current one is due for renewal. The current certificate remains in use if renewal fails
+func (m *Manager) Renew() error {
+	m.Lock()
+	defer m.Unlock()
+	if time.Now().Add(m.config.RenewBefore).Before(m.notAfter) {
+		return nil
+	}
+	if err := m.client.Register(m.config.Contact); err != nil {
+		return err
+	}
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("%v - generate private key", err)
+	}
+	b, err := m.client.Obtain(m.config.TNAuthList, m.token, k)
+	if err != nil {
+		return err
+	}
+	c, err := leaf(b)
+	if err != nil {
+		return fmt.Errorf("%v - certificate issued by ACME server", err)
+	}
+	if err := m.credentials.Set(m.config.Filename, k, b); err != nil {
+		return fmt.Errorf("%v - certificate issued by ACME server", err)
+	}
+	m.notAfter = c.NotAfter
+	if err := m.save(k, b); err != nil {
+		// the certificate is in use; it is obtained again after restart
+		logError("type", "acme", "module", "Renew", "message", fmt.Sprintf("%v", err))
+	}
+	logInfo("type", "acme", "module", "Renew", "message", "signing certificate obtained", "serial", fmt.Sprintf("%x", c.SerialNumber), "notAfter", c.NotAfter.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// load private key and certificate from state directory and hand them to signing credentials
+func (m *Manager) load() error {
+	kb, err := ioutil.ReadFile(filepath.Join(m.config.StateDir, privateKeyFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(m.config.StateDir, certificateFile))
+	if err != nil {
+		return err
+	}
+	k, err := parseKey(kb)
+	if err != nil {
+		return err
+	}
+	c, err := leaf(b)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(c.NotAfter) {
+		return fmt.Errorf("certificate expired at %v", c.NotAfter)
+	}
+	if err := m.credentials.Set(m.config.Filename, k, b); err != nil {
+		return err
+	}
+	m.notAfter = c.NotAfter
+	return nil
+}
+
+// save private key and certificate in state directory
+func (m *Manager) save(k *ecdsa.PrivateKey, b []byte) error {
+	der, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(m.config.StateDir, privateKeyFile), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(m.config.StateDir, certificateFile), b)
+}
+
+// tokenFromFile - default token source; the token file is read on every use so that
+// the token can be replaced without restarting Vesper
+func (m *Manager) tokenFromFile(fp string) (string, error) {
+	if len(strings.TrimSpace(m.config.TokenFile)) == 0 {
+		return "", fmt.Errorf("SPC token file is not configured")
+	}
+	b, err := ioutil.ReadFile(m.config.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("%v - SPC token file", err)
+	}
+	t := strings.TrimSpace(string(b))
+	if len(t) == 0 {
+		return "", fmt.Errorf("SPC token file %v is empty", m.config.TokenFile)
+	}
+	return t, nil
+}
+
+// returns the first (leaf) certificate in PEM certificate chain b
+func leaf(b []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in PEM data")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(b []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in private key file")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// returns the private key in file n; a new P-256 key is generated and saved if the file does not exist
+func loadOrCreateKey(n string) (*ecdsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(n)
+	if err == nil {
+		k, err := parseKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%v - ACME account key", err)
+		}
+		return k, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%v - ACME account key", err)
+	}
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("%v - generate ACME account key", err)
+	}
+	der, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(n, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("%v - ACME account key", err)
+	}
+	return k, nil
+}
+
+// write file n atomically, readable by owner only
+func writeFile(n string, b []byte) error {
+	t := n + ".tmp"
+	if err := ioutil.WriteFile(t, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(t, n)
+}
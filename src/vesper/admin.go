@@ -0,0 +1,188 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"io"
+	"time"
+	"net/http"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/httprouter"
+	"github.com/satori/go.uuid"
+	"vesper/configuration"
+	"vesper/denylist"
+	"vesper/signcredentials"
+	"vesper/tenants"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// adminAuthorized returns true if admin API is enabled ("admin_api_key" is configured) and
+// request contains the admin API key in X-Admin-Api-Key header. Otherwise, it writes the
+// error response and returns false
+func adminAuthorized(start time.Time, response http.ResponseWriter, request *http.Request, traceID, clientIP, action string) bool {
+	k := configuration.ConfigurationInstance().AdminApiKey
+	if len(k) == 0 {
+		lg := kitlog.With(glogger, "type", "adminRequest", "clientIP", clientIP, "module", "adminAuthorized", "error", "admin API is not enabled")
+		serveHttpResponse(start, response, lg, http.StatusForbidden, "error", traceID, action, "VESPER-4202", nil)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(request.Header.Get("X-Admin-Api-Key")), []byte(k)) != 1 {
+		lg := kitlog.With(glogger, "type", "adminRequest", "clientIP", clientIP, "module", "adminAuthorized", "error", "admin API key in X-Admin-Api-Key header is missing or invalid")
+		serveHttpResponse(start, response, lg, http.StatusUnauthorized, "error", traceID, action, "VESPER-4201", nil)
+		return false
+	}
+	return true
+}
+
+// denyListAuthorized returns true if the admin request is authorized and deny list is configured.
+// Otherwise, it writes the error response and returns false
+func denyListAuthorized(start time.Time, response http.ResponseWriter, request *http.Request, traceID, clientIP string) bool {
+	if !adminAuthorized(start, response, request, traceID, clientIP, "denyListResponse") {
+		return false
+	}
+	if denyList == nil {
+		lg := kitlog.With(glogger, "type", "adminRequest", "clientIP", clientIP, "module", "denyListAuthorized", "error", "deny list is not configured")
+		serveHttpResponse(start, response, lg, http.StatusBadRequest, "error", traceID, "denyListResponse", "VESPER-4203", nil)
+		return false
+	}
+	return true
+}
+
+// Retrieves deny list
+func getDenyList(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	start := time.Now()
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Content-Type", "application/json")
+	clientIP := getClientIP(request)
+	traceID := request.Header.Get("Trace-Id")
+	if traceID == "" {
+		traceID = "VESPER-" + uuid.NewV1().String()
+	}
+	response.Header().Set("Trace-Id", traceID)
+	if !denyListAuthorized(start, response, request, traceID, clientIP) {
+		return
+	}
+	resp := make(map[string]interface{})
+	resp["denyListResponse"] = denyList.Entries()
+	lg := kitlog.With(glogger, "type", "requestResponseTime", "module", "getDenyList")
+	serveHttpResponse(start, response, lg, http.StatusOK, "info", traceID, "", "", resp)
+}
+
+// Adds entries in request payload to deny list
+func addDenyListEntries(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	updateDenyList(response, request, "addDenyListEntries", denyList.Add)
+}
+
+// Removes entries in request payload from deny list
+func removeDenyListEntries(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	updateDenyList(response, request, "removeDenyListEntries", denyList.Remove)
+}
+
+// updateDenyList applies f (add or remove) to entries in request payload and returns the
+// resulting deny list
+func updateDenyList(response http.ResponseWriter, request *http.Request, module string, f func(denylist.Entries) error) {
+	start := time.Now()
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Content-Type", "application/json")
+	clientIP := getClientIP(request)
+	traceID := request.Header.Get("Trace-Id")
+	if traceID == "" {
+		traceID = "VESPER-" + uuid.NewV1().String()
+	}
+	response.Header().Set("Trace-Id", traceID)
+	if !denyListAuthorized(start, response, request, traceID, clientIP) {
+		return
+	}
+	var e denylist.Entries
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&e)
+	switch {
+	case err == io.EOF:
+		// empty request body
+		lg := kitlog.With(glogger, "type", "requestPayload", "clientIP", clientIP, "module", module, "error", err)
+		serveHttpResponse(start, response, lg, http.StatusBadRequest, "error", traceID, "denyListResponse", "VESPER-4204", nil)
+		return
+	case err != nil :
+		lg := kitlog.With(glogger, "type", "requestPayload", "clientIP", clientIP, "module", module, "error", err)
+		serveHttpResponse(start, response, lg, http.StatusBadRequest, "error", traceID, "denyListResponse", "VESPER-4205", nil)
+		return
+	}
+	if err := e.Validate(); err != nil {
+		lg := kitlog.With(glogger, "type", "requestPayload", "clientIP", clientIP, "module", module, "requestPayload", e, "error", err)
+		serveHttpResponse(start, response, lg, http.StatusBadRequest, "error", traceID, "denyListResponse", "VESPER-4206", nil)
+		return
+	}
+	if err := f(e); err != nil {
+		lg := kitlog.With(glogger, "type", "denyList", "clientIP", clientIP, "module", module, "requestPayload", e, "error", err)
+		serveHttpResponse(start, response, lg, http.StatusInternalServerError, "error", traceID, "denyListResponse", "VESPER-5201", nil)
+		return
+	}
+	logInfo("type", "adminRequest", "traceID", traceID, "clientIP", clientIP, "module", module, "requestPayload", e)
+	resp := make(map[string]interface{})
+	resp["denyListResponse"] = denyList.Entries()
+	lg := kitlog.With(glogger, "type", "requestResponseTime", "module", module)
+	serveHttpResponse(start, response, lg, http.StatusOK, "info", traceID, "", "", resp)
+}
+
+// Retrieves active, pending and retiring signing keys
+func getSigningKeys(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	updateSigningKeys(response, request, "getSigningKeys", nil)
+}
+
+// Activates pending signing key without waiting for its x5u to be published
+func promoteSigningKey(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	updateSigningKeys(response, request, "promoteSigningKey", (*signcredentials.SigningCredentials).Promote)
+}
+
+// Reactivates retiring signing key
+func rollbackSigningKey(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	updateSigningKeys(response, request, "rollbackSigningKey", (*signcredentials.SigningCredentials).Rollback)
+}
+
+// updateSigningKeys applies f (promote or rollback, if not nil) to signing credentials of Vesper or
+// of the tenant in "tenant" query parameter, and returns the resulting signing keys
+func updateSigningKeys(response http.ResponseWriter, request *http.Request, module string, f func(*signcredentials.SigningCredentials) error) {
+	start := time.Now()
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Content-Type", "application/json")
+	clientIP := getClientIP(request)
+	traceID := request.Header.Get("Trace-Id")
+	if traceID == "" {
+		traceID = "VESPER-" + uuid.NewV1().String()
+	}
+	response.Header().Set("Trace-Id", traceID)
+	if !adminAuthorized(start, response, request, traceID, clientIP, "signingKeysResponse") {
+		return
+	}
+	sc := signingCredentials
+	if id := request.URL.Query().Get("tenant"); len(id) > 0 {
+		var t *tenants.Tenant
+		if signingTenants != nil {
+			t = signingTenants.ByID(id)
+		}
+		if t == nil {
+			lg := kitlog.With(glogger, "type", "adminRequest", "clientIP", clientIP, "module", module, "tenant", id, "error", "tenant not found")
+			serveHttpResponse(start, response, lg, http.StatusNotFound, "error", traceID, "signingKeysResponse", "VESPER-4207", nil)
+			return
+		}
+		sc = t.Credentials()
+	}
+	if f != nil {
+		if err := f(sc); err != nil {
+			eCode := "VESPER-4208"
+			if err == signcredentials.ErrNoRetiringKey {
+				eCode = "VESPER-4209"
+			}
+			lg := kitlog.With(glogger, "type", "adminRequest", "clientIP", clientIP, "module", module, "error", err)
+			serveHttpResponse(start, response, lg, http.StatusBadRequest, "error", traceID, "signingKeysResponse", eCode, nil)
+			return
+		}
+		logInfo("type", "adminRequest", "traceID", traceID, "clientIP", clientIP, "module", module, "tenant", request.URL.Query().Get("tenant"))
+	}
+	resp := make(map[string]interface{})
+	resp["signingKeysResponse"] = sc.RotationState()
+	lg := kitlog.With(glogger, "type", "requestResponseTime", "module", module)
+	serveHttpResponse(start, response, lg, http.StatusOK, "info", traceID, "", "", resp)
+}
@@ -0,0 +1,265 @@
+// Package attestation determines the SHAKEN attestation level of a call from the
+// calling customer/trunk and a configured TN inventory
+package attestation
+
+import (
+	"fmt"
+	"sync"
+	"os"
+	"strings"
+	"encoding/json"
+	"github.com/satori/go.uuid"
+	"vesper/e164"
+	"vesper/tnauthlist"
+)
+
+// globals
+var (
+	inventoryFileModifiedTime		int64
+	inventoryFileName						string
+)
+
+// TNRange - range of TNs [start, start + count - 1]
+type TNRange struct {
+	Start		string	`json:"start"`
+	Count		int64		`json:"count"`
+}
+
+// customerConfig - customer as configured in attestation file
+type customerConfig struct {
+	ID								string		`json:"id"`
+	Authenticated			bool			`json:"authenticated"`
+	TNs								[]string	`json:"tns"`
+	TNRanges					[]TNRange	`json:"tnRanges"`
+}
+
+// trunkConfig - ingress point (trunk) as configured in attestation file
+type trunkConfig struct {
+	ID								string		`json:"id"`
+	Customer					string		`json:"customer"`
+	Authenticated			bool			`json:"authenticated"`
+	OrigID						string		`json:"origid"`
+}
+
+// inventory - TN inventory, customers and trunks
+type inventory struct {
+	owned				tnauthlist.List
+	customers		map[string]customer
+	trunks			map[string]trunkConfig
+}
+
+type customer struct {
+	authenticated		bool
+	tns							tnauthlist.List
+}
+
+// Decision - attestation level and origid determined for a call, with the reasoning
+type Decision struct {
+	Attest				string		`json:"attest"`
+	OrigID				string		`json:"origid"`
+	Reason				string		`json:"reason"`
+	Customer			string		`json:"customer,omitempty"`
+	Trunk					string		`json:"trunk,omitempty"`
+}
+
+// Engine - attestation decision engine
+type Engine struct {
+	sync.RWMutex	// A field declared with a type but no explicit field name is an
+					// anonymous field, also called an embedded field or an embedding of
+					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	inv			*inventory
+}
+
+// Initialize object
+// Saves file modified time for future use
+func InitObject(f string) (*Engine, error) {
+	if len(strings.TrimSpace(f)) == 0 {
+		return nil, fmt.Errorf("attestation file name is an empty string")
+	}
+	inventoryFileName = f
+	inv, err := readInventoryFile(inventoryFileName)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{inv: inv}, nil
+}
+
+// update TN inventory if attestation file has changed
+func (e *Engine) UpdateInventory() error {
+	inv, err := readInventoryFile(inventoryFileName)
+	if err != nil {
+		return err
+	}
+	if inv != nil {
+		e.Lock()
+		defer e.Unlock()
+		e.inv = inv
+	}
+	return nil
+}
+
+// Decide determines the attestation level for a call with originating TN tn, from
+// customer c and/or trunk t (ingress point). At least one of c and t MUST be non empty.
+//  A - caller is authenticated and tn is assigned to the calling customer
+//  B - caller is authenticated but tn is not assigned to the calling customer
+//  C - caller is not authenticated (unknown or unauthenticated customer/trunk)
+// The origid is stable per ingress point (trunk) or per customer if trunk is not known.
+// tn is matched against TN inventory in canonical form (see e164), if it is a telephone number
+func (e *Engine) Decide(c, t, tn string) (*Decision, error) {
+	e.RLock()
+	inv := e.inv
+	e.RUnlock()
+	if n, err := e164.Normalize(tn); err == nil {
+		tn = n
+	}
+	d := &Decision{Customer: c, Trunk: t}
+	authenticated := true
+	var reasons []string
+	if len(t) > 0 {
+		tc, ok := inv.trunks[t]
+		switch {
+		case !ok :
+			authenticated = false
+			reasons = append(reasons, fmt.Sprintf("trunk %v is unknown", t))
+		case len(c) > 0 && c != tc.Customer :
+			return nil, fmt.Errorf("customer %v does not match customer %v of trunk %v", c, tc.Customer, t)
+		default:
+			d.Customer = tc.Customer
+			if !tc.Authenticated {
+				authenticated = false
+				reasons = append(reasons, fmt.Sprintf("trunk %v is not authenticated", t))
+			}
+		}
+		if ok && len(tc.OrigID) > 0 {
+			d.OrigID = tc.OrigID
+		} else {
+			d.OrigID = uuid.NewV5(uuid.NamespaceURL, "vesper:trunk:" + t).String()
+		}
+	} else {
+		d.OrigID = uuid.NewV5(uuid.NamespaceURL, "vesper:customer:" + c).String()
+	}
+	cust, ok := inv.customers[d.Customer]
+	switch {
+	case len(d.Customer) == 0 :
+		authenticated = false
+		reasons = append(reasons, "customer is unknown")
+	case !ok :
+		authenticated = false
+		reasons = append(reasons, fmt.Sprintf("customer %v is unknown", d.Customer))
+	case !cust.authenticated :
+		authenticated = false
+		reasons = append(reasons, fmt.Sprintf("customer %v is not authenticated", d.Customer))
+	}
+	if !authenticated {
+		d.Attest = "C"
+		d.Reason = strings.Join(reasons, "; ")
+		return d, nil
+	}
+	if assigned, _ := cust.tns.Covers(tn); assigned {
+		d.Attest = "A"
+		d.Reason = fmt.Sprintf("customer %v is authenticated and orig TN %v is assigned to customer", d.Customer, tn)
+		return d, nil
+	}
+	d.Attest = "B"
+	if owned, _ := inv.owned.Covers(tn); owned {
+		d.Reason = fmt.Sprintf("customer %v is authenticated; orig TN %v is owned but is not assigned to customer", d.Customer, tn)
+	} else {
+		d.Reason = fmt.Sprintf("customer %v is authenticated; orig TN %v is not in TN inventory of customer", d.Customer, tn)
+	}
+	return d, nil
+}
+
+// convert TNs and TN ranges into a list that can be matched against. TNs and starts of
+// ranges are canonicalized (see e164), as orig TNs are
+func toList(tns []string, ranges []TNRange) (tnauthlist.List, error) {
+	var l tnauthlist.List
+	for _, tn := range tns {
+		if len(strings.TrimSpace(tn)) == 0 {
+			return nil, fmt.Errorf("empty TN")
+		}
+		n, err := e164.Normalize(tn)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, tnauthlist.Entry{TN: n})
+	}
+	for _, r := range ranges {
+		if len(strings.TrimSpace(r.Start)) == 0 || r.Count < 1 {
+			return nil, fmt.Errorf("invalid TN range (start: %v, count: %v)", r.Start, r.Count)
+		}
+		n, err := e164.Normalize(r.Start)
+		if err != nil {
+			return nil, fmt.Errorf("%v - TN range", err)
+		}
+		l = append(l, tnauthlist.Entry{Start: n, Count: r.Count})
+	}
+	return l, nil
+}
+
+// Read attestation file only if file modified time has changed
+// returns nil (and no error) if the file has not been modified since last lookup
+func readInventoryFile(n string) (*inventory, error) {
+	f, err := os.Open(n)
+	if err != nil {
+		return nil, fmt.Errorf("%v - attestation file", err)
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err == nil {
+		m := fi.ModTime().Unix()
+		if inventoryFileModifiedTime == m {
+			return nil, nil
+		}
+		// save the latest modified time
+		inventoryFileModifiedTime = m
+	}
+	var c struct {
+		Owned			struct {
+			TNs				[]string	`json:"tns"`
+			TNRanges	[]TNRange	`json:"tnRanges"`
+		}	`json:"owned"`
+		Customers	[]customerConfig	`json:"customers"`
+		Trunks		[]trunkConfig			`json:"trunks"`
+	}
+	decoder := json.NewDecoder(f)
+	err = decoder.Decode(&c)
+	if err != nil {
+		return nil, fmt.Errorf("%v - decode JSON object in attestation file", err)
+	}
+	inv := &inventory{customers: make(map[string]customer), trunks: make(map[string]trunkConfig)}
+	inv.owned, err = toList(c.Owned.TNs, c.Owned.TNRanges)
+	if err != nil {
+		return nil, fmt.Errorf("%v - \"owned\" field in attestation file", err)
+	}
+	for i, cc := range c.Customers {
+		if len(strings.TrimSpace(cc.ID)) == 0 {
+			return nil, fmt.Errorf("\"id\" field missing or empty for customer at index %v", i)
+		}
+		if _, ok := inv.customers[cc.ID]; ok {
+			return nil, fmt.Errorf("customer %v is configured more than once", cc.ID)
+		}
+		l, err := toList(cc.TNs, cc.TNRanges)
+		if err != nil {
+			return nil, fmt.Errorf("%v - customer %v", err, cc.ID)
+		}
+		inv.customers[cc.ID] = customer{authenticated: cc.Authenticated, tns: l}
+	}
+	for i, tc := range c.Trunks {
+		if len(strings.TrimSpace(tc.ID)) == 0 {
+			return nil, fmt.Errorf("\"id\" field missing or empty for trunk at index %v", i)
+		}
+		if _, ok := inv.trunks[tc.ID]; ok {
+			return nil, fmt.Errorf("trunk %v is configured more than once", tc.ID)
+		}
+		if _, ok := inv.customers[tc.Customer]; !ok {
+			return nil, fmt.Errorf("customer %v of trunk %v is not configured", tc.Customer, tc.ID)
+		}
+		if len(tc.OrigID) > 0 {
+			if _, err := uuid.FromString(tc.OrigID); err != nil {
+				return nil, fmt.Errorf("%v - \"origid\" of trunk %v is not a UUID", err, tc.ID)
+			}
+		}
+		inv.trunks[tc.ID] = tc
+	}
+	return inv, nil
+}
@@ -0,0 +1,107 @@
+package attestation
+
+import (
+	"os"
+	"testing"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+	"github.com/satori/go.uuid"
+)
+
+const inventoryJSON = `{
+	"owned": {"tns": ["12155559999"], "tnRanges": [{"start": "12155553000", "count": 10}]},
+	"customers": [
+		{"id": "c1", "authenticated": true, "tns": ["+1 (215) 555-1212"], "tnRanges": [{"start": "+1-215-555-2000", "count": 100}]},
+		{"id": "c2", "authenticated": false, "tns": ["12155554444"]}
+	],
+	"trunks": [
+		{"id": "t1", "customer": "c1", "authenticated": true, "origid": "123e4567-e89b-12d3-a456-426655440000"},
+		{"id": "t2", "customer": "c1", "authenticated": false},
+		{"id": "t3", "customer": "c2", "authenticated": true}
+	]
+}`
+
+// newEngine returns an engine with the TN inventory in JSON j
+func newEngine(t *testing.T, j string) *Engine {
+	e, err := initEngine(t, j)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return e
+}
+
+// initEngine initializes an engine with the TN inventory in JSON j
+func initEngine(t *testing.T, j string) (*Engine, error) {
+	d, err := ioutil.TempDir("", "attestation")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(d)
+	f := filepath.Join(d, "attestation.json")
+	if err := ioutil.WriteFile(f, []byte(j), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// the file is read even if it has the modified time of the previous one
+	inventoryFileModifiedTime = 0
+	return InitObject(f)
+}
+
+func TestDecide(t *testing.T) {
+	e := newEngine(t, inventoryJSON)
+	trunkOrigID := func(t string) string { return uuid.NewV5(uuid.NamespaceURL, "vesper:trunk:" + t).String() }
+	customerOrigID := func(c string) string { return uuid.NewV5(uuid.NamespaceURL, "vesper:customer:" + c).String() }
+	tests := []struct {
+		customer, trunk, tn		string
+		attest								string
+		origid								string
+		reason								string
+	}{
+		// A - authenticated caller and assigned TN (TN, TN in range, trunk only)
+		{"c1", "", "12155551212", "A", customerOrigID("c1"), "is assigned to customer"},
+		{"c1", "", "12155552099", "A", customerOrigID("c1"), "is assigned to customer"},
+		{"c1", "", "+1 (215) 555-1212", "A", customerOrigID("c1"), "orig TN 12155551212 is assigned"},
+		{"c1", "", "+1.215.555.2001", "A", customerOrigID("c1"), "is assigned to customer"},
+		{"", "t1", "12155551212", "A", "123e4567-e89b-12d3-a456-426655440000", "customer c1 is authenticated"},
+		{"c1", "t1", "12155552000", "A", "123e4567-e89b-12d3-a456-426655440000", "is assigned to customer"},
+		// B - authenticated caller, TN not assigned (past end of range, owned, not owned)
+		{"c1", "", "12155552100", "B", customerOrigID("c1"), "is not in TN inventory of customer"},
+		{"c1", "", "12155559999", "B", customerOrigID("c1"), "is owned but is not assigned"},
+		{"c1", "", "12155553005", "B", customerOrigID("c1"), "is owned but is not assigned"},
+		{"c1", "", "12025550000", "B", customerOrigID("c1"), "is not in TN inventory of customer"},
+		// C - unknown trunk, unauthenticated trunk, unauthenticated or unknown customer
+		{"c1", "tx", "12155551212", "C", trunkOrigID("tx"), "trunk tx is unknown"},
+		{"", "tx", "12155551212", "C", trunkOrigID("tx"), "customer is unknown"},
+		{"", "t2", "12155551212", "C", trunkOrigID("t2"), "trunk t2 is not authenticated"},
+		{"c2", "", "12155554444", "C", customerOrigID("c2"), "customer c2 is not authenticated"},
+		{"", "t3", "12155554444", "C", trunkOrigID("t3"), "customer c2 is not authenticated"},
+		{"cx", "", "12155551212", "C", customerOrigID("cx"), "customer cx is unknown"},
+	}
+	for i, test := range tests {
+		d, err := e.Decide(test.customer, test.trunk, test.tn)
+		if err != nil {
+			t.Errorf("%v: Decide(%q, %q, %q) - %v", i, test.customer, test.trunk, test.tn, err)
+			continue
+		}
+		if d.Attest != test.attest || d.OrigID != test.origid || !strings.Contains(d.Reason, test.reason) {
+			t.Errorf("%v: Decide(%q, %q, %q) = %+v; want %v, origid %v, reason %q", i, test.customer, test.trunk, test.tn, d, test.attest, test.origid, test.reason)
+		}
+	}
+	// customer of trunk is returned, and MUST match the customer in the request
+	if d, _ := e.Decide("", "t1", "12155551212"); d.Customer != "c1" || d.Trunk != "t1" {
+		t.Errorf("got customer %v and trunk %v, want c1 and t1", d.Customer, d.Trunk)
+	}
+	if _, err := initEngine(t, `{"customers": [{"id": "c", "tns": ["215-555-121x"]}]}`); err == nil {
+		t.Errorf("TN that is not a telephone number accepted in TN inventory")
+	}
+	if _, err := e.Decide("c2", "t1", "12155551212"); err == nil || !strings.Contains(err.Error(), "does not match customer c1 of trunk t1") {
+		t.Errorf("customer mismatch - got %v", err)
+	}
+	// origid is stable per trunk (or customer) and differs between trunks
+	d1, _ := e.Decide("", "tx", "12155551212")
+	d2, _ := e.Decide("c1", "tx", "12025550000")
+	d3, _ := e.Decide("", "t2", "12155551212")
+	if d1.OrigID != d2.OrigID || d1.OrigID == d3.OrigID {
+		t.Errorf("origid is not stable per trunk - %v, %v, %v", d1.OrigID, d2.OrigID, d3.OrigID)
+	}
+}
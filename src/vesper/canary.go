@@ -0,0 +1,192 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"bytes"
+	"context"
+	"net/http"
+	"encoding/json"
+	"github.com/httprouter"
+	"github.com/satori/go.uuid"
+	"vesper/configuration"
+	"vesper/stats"
+)
+
+var (
+	canaryMtx				sync.RWMutex
+	lastCanary			*CanaryResult
+)
+
+// canaryKey - context key marking canary requests
+type canaryKey struct{}
+
+// CanaryResult - outcome of a canary run: a synthetic PASSporT signed with the signing credentials
+// of Vesper and verified through the full verification path (x5u, chain and signature)
+type CanaryResult struct {
+	Ok										bool			`json:"ok"`
+	Time									string		`json:"time"`
+	X5u										string		`json:"x5u,omitempty"`
+	FailedStep						string		`json:"failedStep,omitempty"`			// "signing" or "verification"
+	Code									string		`json:"code,omitempty"`
+	Message								string		`json:"message,omitempty"`
+	SigningTime						int64			`json:"signingTimeInMilliSeconds"`
+	VerificationTime			int64			`json:"verificationTimeInMilliSeconds"`
+}
+
+// canaryResponse - response writer of canary requests. Canary requests are not included in
+// API processing time stats (see serveHttpResponse)
+type canaryResponse struct {
+	header		http.Header
+	code			int
+	body			bytes.Buffer
+}
+
+func (w *canaryResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *canaryResponse) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *canaryResponse) WriteHeader(c int) {
+	w.code = c
+}
+
+// isCanary returns true if request r is made by the canary
+func isCanary(r *http.Request) bool {
+	v, _ := r.Context().Value(canaryKey{}).(bool)
+	return v
+}
+
+// canaryCall calls handler h (signRequest or verifyRequest) with JSON payload p and decodes the
+// response body in v. The HTTP response status is returned
+func canaryCall(h httprouter.Handle, traceID string, p map[string]interface{}, v interface{}) (int, error) {
+	b, _ := json.Marshal(p)
+	req, err := http.NewRequest("POST", "/", bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(context.WithValue(req.Context(), canaryKey{}, true))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Trace-Id", traceID)
+	w := &canaryResponse{header: make(http.Header), code: http.StatusOK}
+	h(w, req, nil)
+	if err := json.Unmarshal(w.body.Bytes(), v); err != nil {
+		return w.code, fmt.Errorf("%v - response body", err)
+	}
+	return w.code, nil
+}
+
+// runCanary signs a synthetic PASSporT (using signing credentials of Vesper) and verifies it.
+// Both requests go through the API handlers; only the replay attack cache is bypassed
+func runCanary() CanaryResult {
+	traceID := "VESPER-CANARY-" + uuid.NewV1().String()
+	start := time.Now()
+	res := CanaryResult{Time: start.UTC().Format(time.RFC3339)}
+	p := map[string]interface{}{
+		"attest": "A",
+		"dest": map[string]interface{}{"tn": []string{configuration.ConfigurationInstance().CanaryDestTN}},
+		"iat": start.Unix(),
+		"orig": map[string]interface{}{"tn": configuration.ConfigurationInstance().CanaryOrigTN},
+		"origid": uuid.NewV4().String(),
+	}
+	var s struct {
+		SigningResponse struct {
+			Identity		string										`json:"identity"`
+			X5u					string										`json:"x5u"`
+			Claims			map[string]interface{}		`json:"claims"`
+			Code				string										`json:"code"`
+			Message			string										`json:"message"`
+		}	`json:"signingResponse"`
+	}
+	httpCode, err := canaryCall(signRequest, traceID, p, &s)
+	res.SigningTime = int64(time.Since(start).Seconds()*1000)
+	switch {
+	case err != nil :
+		return finishCanary(res, "signing", "", fmt.Sprintf("%v - HTTP status %v", err, httpCode))
+	case httpCode != http.StatusOK :
+		return finishCanary(res, "signing", s.SigningResponse.Code, s.SigningResponse.Message)
+	}
+	res.X5u = s.SigningResponse.X5u
+	// claims as signed (iat and TNs may have been stamped or normalized)
+	c := s.SigningResponse.Claims
+	orig, _ := c["orig"].(map[string]interface{})
+	v := map[string]interface{}{
+		"dest": c["dest"],
+		"iat": c["iat"],
+		"orig": map[string]interface{}{"tn": []interface{}{orig["tn"]}},
+		"identity": s.SigningResponse.Identity,
+	}
+	var vr struct {
+		VerificationResponse struct {
+			Code				string		`json:"code"`
+			Message			string		`json:"message"`
+		}	`json:"verificationResponse"`
+	}
+	vstart := time.Now()
+	httpCode, err = canaryCall(verifyRequest, traceID, v, &vr)
+	res.VerificationTime = int64(time.Since(vstart).Seconds()*1000)
+	switch {
+	case err != nil :
+		return finishCanary(res, "verification", "", fmt.Sprintf("%v - HTTP status %v", err, httpCode))
+	case httpCode != http.StatusOK :
+		return finishCanary(res, "verification", vr.VerificationResponse.Code, vr.VerificationResponse.Message)
+	}
+	res.Ok = true
+	return finishCanary(res, "", "", "")
+}
+
+// finishCanary saves canary result r (failed at step, if not empty), updates stats and logs it
+func finishCanary(r CanaryResult, step, code, message string) CanaryResult {
+	r.FailedStep, r.Code, r.Message = step, code, message
+	stats.UpdateCanary(r.Ok, r.SigningTime + r.VerificationTime)
+	canaryMtx.Lock()
+	lastCanary = &r
+	canaryMtx.Unlock()
+	if r.Ok {
+		logInfo("type", "canary", "x5u", r.X5u, "signingTimeInMilliSeconds", r.SigningTime, "verificationTimeInMilliSeconds", r.VerificationTime)
+	} else {
+		logCritical("type", "canary", "failedStep", step, "code", code, "message", message, "x5u", r.X5u)
+	}
+	return r
+}
+
+// canaryState returns the result of the last canary run (nil if there was none)
+func canaryState() *CanaryResult {
+	canaryMtx.RLock()
+	defer canaryMtx.RUnlock()
+	if lastCanary == nil {
+		return nil
+	}
+	r := *lastCanary
+	return &r
+}
+
+// Runs canary on demand
+func canaryRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	start := time.Now()
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Content-Type", "application/json")
+	clientIP := getClientIP(request)
+	traceID := request.Header.Get("Trace-Id")
+	if traceID == "" {
+		traceID = "VESPER-" + uuid.NewV1().String()
+	}
+	response.Header().Set("Trace-Id", traceID)
+	// the canary signs with the signing credentials of Vesper and fetches x5u
+	if !adminAuthorized(start, response, request, traceID, clientIP, "canaryResponse") {
+		return
+	}
+	r := runCanary()
+	if r.Ok {
+		response.WriteHeader(http.StatusOK)
+	} else {
+		response.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(response).Encode(map[string]interface{}{"canaryResponse": r})
+}
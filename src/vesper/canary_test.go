@@ -0,0 +1,38 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	"vesper/configuration"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// the canary signs with the signing credentials of Vesper - on demand runs are admin requests
+func TestCanaryRequestAdmin(t *testing.T) {
+	glogger = kitlog.NewNopLogger()
+	c := configuration.ConfigurationInstance()
+	defer func(k string) { c.AdminApiKey = k }(c.AdminApiKey)
+	tests := []struct {
+		adminKey, header		string
+		status							int
+		code								string
+	}{
+		{"", "secret", http.StatusForbidden, "VESPER-4202"},
+		{"secret", "", http.StatusUnauthorized, "VESPER-4201"},
+		{"secret", "wrong", http.StatusUnauthorized, "VESPER-4201"},
+	}
+	for i, test := range tests {
+		c.AdminApiKey = test.adminKey
+		req := httptest.NewRequest("POST", "/stir/v1/canary", nil)
+		req.Header.Set("X-Admin-Api-Key", test.header)
+		w := httptest.NewRecorder()
+		canaryRequest(w, req, nil)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.code) {
+			t.Errorf("%v: got %v %s, want %v %v", i, w.Code, w.Body.Bytes(), test.status, test.code)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"fmt"
+	"bytes"
+	"time"
+	"strings"
+	"net/http"
+	"crypto/sha256"
+	"github.com/httprouter"
+	"github.com/satori/go.uuid"
+	"vesper/configuration"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// getCertificate - built-in STI-CR (certificate repository)
+// serves the certificate (chain) published under filename in signing credentials of Vesper or of a tenant,
+// so that x5u in signed PASSporTs can point to Vesper itself
+func getCertificate(response http.ResponseWriter, request *http.Request, ps httprouter.Params) {
+	start := time.Now()
+	clientIP := getClientIP(request)
+	traceID := request.Header.Get("Trace-Id")
+	if traceID == "" {
+		traceID = "VESPER-" + uuid.NewV1().String()
+	}
+	response.Header().Set("Trace-Id", traceID)
+	fn := ps.ByName("filename")
+	c := signingCredentials.Certificate(fn)
+	if signingTenants != nil {
+		for _, t := range signingTenants.All() {
+			tc := t.Credentials().Certificate(fn)
+			if tc == nil {
+				continue
+			}
+			// filenames are unique across tenants when loaded, but may collide after signing credentials are refreshed
+			if c != nil && !bytes.Equal(c, tc) {
+				logError("type", "sticrServer", "module", "getCertificate", "traceID", traceID, "message", fmt.Sprintf("filename %v is published by more than one tenant - certificate is not served", fn))
+				response.WriteHeader(http.StatusInternalServerError)
+				logCertificateResponse(start, clientIP, traceID, fn, "error", http.StatusInternalServerError)
+				return
+			}
+			c = tc
+		}
+	}
+	if c == nil {
+		response.WriteHeader(http.StatusNotFound)
+		logCertificateResponse(start, clientIP, traceID, fn, "error", http.StatusNotFound)
+		return
+	}
+	etag := fmt.Sprintf("\"%x\"", sha256.Sum256(c))
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", configuration.ConfigurationInstance().SticrServerMaxAge))
+	response.Header().Set("ETag", etag)
+	if etagMatch(request.Header.Get("If-None-Match"), etag) {
+		response.WriteHeader(http.StatusNotModified)
+		logCertificateResponse(start, clientIP, traceID, fn, "info", http.StatusNotModified)
+		return
+	}
+	response.Header().Set("Content-Type", "application/pem-certificate-chain")
+	response.WriteHeader(http.StatusOK)
+	response.Write(c)
+	logCertificateResponse(start, clientIP, traceID, fn, "info", http.StatusOK)
+}
+
+// returns true if If-None-Match header value h matches entity tag etag
+func etagMatch(h, etag string) bool {
+	for _, v := range strings.Split(h, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// certificate requests are not included in API processing time stats (signing and verification only)
+func logCertificateResponse(start time.Time, clientIP, traceID, fn, level string, httpCode int) {
+	lg := kitlog.With(
+		glogger,
+		"code", level,
+		"type", "requestResponseTime",
+		"module", "getCertificate",
+		"clientIP", clientIP,
+		"traceID", traceID,
+		"filename", fn,
+		"httpResponseCode", httpCode,
+		"apiProcessingTimeInMilliSeconds", int64(time.Since(start).Seconds()*1000),
+	)
+	lg.Log()
+}
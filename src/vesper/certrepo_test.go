@@ -0,0 +1,105 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"fmt"
+	"time"
+	"testing"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"encoding/pem"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/httprouter"
+	"vesper/sticr"
+	"vesper/signcredentials"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// newTestCredentials sets signing credentials of Vesper to a self signed certificate published under
+// filename fn at STI-CR host, and returns the certificate (PEM)
+func newTestCredentials(t *testing.T, host, fn string) []byte {
+	glogger = kitlog.NewNopLogger()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "SHAKEN 1234"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	cr, err := sticr.NewObject(host)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	signingCredentials, err = signcredentials.InitManagedObject(glogger, "test", http.DefaultClient, nil, cr, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := signingCredentials.Set(fn, k, c); err != nil {
+		t.Fatalf("%v", err)
+	}
+	signingTenants = nil
+	return c
+}
+
+func TestGetCertificate(t *testing.T) {
+	c := newTestCredentials(t, "https://cr.example.com", "sp.pem")
+	etag := fmt.Sprintf("\"%x\"", sha256.Sum256(c))
+	tests := []struct {
+		fn, ifNoneMatch		string
+		status						int
+	}{
+		{"sp.pem", "", http.StatusOK},
+		{"sp.pem", "\"0123\"", http.StatusOK},
+		// If-None-Match - entity tag, weak entity tag, one of a list, any
+		{"sp.pem", etag, http.StatusNotModified},
+		{"sp.pem", "W/" + etag, http.StatusNotModified},
+		{"sp.pem", "\"0123\", " + etag, http.StatusNotModified},
+		{"sp.pem", "*", http.StatusNotModified},
+		// unknown filename
+		{"other.pem", "", http.StatusNotFound},
+		{"other.pem", "*", http.StatusNotFound},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "/sticr/" + test.fn, nil)
+		if len(test.ifNoneMatch) > 0 {
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		getCertificate(w, r, httprouter.Params{{Key: "filename", Value: test.fn}})
+		if w.Code != test.status {
+			t.Errorf("%v: got HTTP status %v, want %v", i, w.Code, test.status)
+			continue
+		}
+		if len(w.Header().Get("Trace-Id")) == 0 {
+			t.Errorf("%v: Trace-Id header missing", i)
+		}
+		switch test.status {
+		case http.StatusOK :
+			if w.Header().Get("Content-Type") != "application/pem-certificate-chain" || w.Body.String() != string(c) {
+				t.Errorf("%v: got Content-Type %q and %v bytes, want application/pem-certificate-chain and certificate", i, w.Header().Get("Content-Type"), w.Body.Len())
+			}
+			fallthrough
+		case http.StatusNotModified :
+			if w.Header().Get("ETag") != etag {
+				t.Errorf("%v: got ETag %v, want %v", i, w.Header().Get("ETag"), etag)
+			}
+			if w.Header().Get("Cache-Control") != "public, max-age=3600" {
+				t.Errorf("%v: got Cache-Control %q, want public, max-age=3600", i, w.Header().Get("Cache-Control"))
+			}
+		case http.StatusNotFound :
+			if w.Body.Len() > 0 || len(w.Header().Get("ETag")) > 0 {
+				t.Errorf("%v: certificate served for unknown filename", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	"vesper/configuration"
+	"vesper/eks"
+	"vesper/sticr"
+)
+
+const usage = "usage: vesper [check-config] [-<config key> <value> ...] [config file]"
+
+// loadConfiguration reads the configuration layers: defaults, config file (in args or in
+// VESPER_CONFIG environment variable, optional), VESPER_* environment variables and command line
+// flags in args. The config file name and the ignored environment variables (see
+// configuration.EnvSettings) are returned
+func loadConfiguration(args []string) (string, []string, error) {
+	flags, rest, err := configuration.ParseFlags(args)
+	if err != nil {
+		return "", nil, fmt.Errorf("%v\n%v", err, usage)
+	}
+	if len(rest) > 1 {
+		return "", nil, fmt.Errorf("%v", usage)
+	}
+	f := os.Getenv(configuration.EnvConfigFile)
+	if len(rest) == 1 {
+		f = rest[0]
+	}
+	env, ignored := configuration.EnvSettings(os.Environ())
+	return f, ignored, configuration.ConfigurationInstance().Load(f, append(env, flags...))
+}
+
+// checkConfig validates the configuration (see loadConfiguration) and the eks and sticr files it
+// refers to, without starting Vesper. Every problem found is printed; the exit status is returned
+func checkConfig(args []string) int {
+	var problems []string
+	report := func(file string, err error) {
+		prefix := ""
+		if len(file) > 0 {
+			prefix = file + ": "
+		}
+		if errs, ok := err.(configuration.Errors); ok {
+			for _, e := range errs {
+				problems = append(problems, prefix + e)
+			}
+			return
+		}
+		problems = append(problems, fmt.Sprintf("%v%v", prefix, err))
+	}
+	f, ignored, err := loadConfiguration(args)
+	if err != nil {
+		report(f, err)
+	}
+	for _, e := range ignored {
+		fmt.Printf("warning: environment variable %v is not a config key - ignored\n", e)
+	}
+	c := configuration.ConfigurationInstance()
+	if n := strings.TrimSpace(c.EksCredentialsFile); len(n) > 0 {
+		if err := eks.CheckFile(n); err != nil {
+			report(n, err)
+		}
+	}
+	if n := strings.TrimSpace(c.SticrHostFile); len(n) > 0 && c.Role != roleVerifier {
+		if err := sticr.CheckFile(n); err != nil {
+			report(n, err)
+		}
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%v problem(s) found\n", len(problems))
+		return 1
+	}
+	if len(f) == 0 {
+		f = "configuration"
+	}
+	fmt.Printf("%v: OK\n", f)
+	return 0
+}
@@ -0,0 +1,14 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckConfigDefault(t *testing.T) {
+	// config file shipped with Vesper
+	if s := checkConfig([]string{"../../config/config.json.default"}); s != 0 {
+		t.Errorf("check-config config/config.json.default exited with %v, want 0", s)
+	}
+}
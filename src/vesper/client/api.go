@@ -0,0 +1,113 @@
+package client
+
+// Orig - orig of a signing request or of PASSporT claims: a TN or a URI
+type Orig struct {
+	TN					string			`json:"tn,omitempty"`
+	URI					string			`json:"uri,omitempty"`
+}
+
+// Identities - TNs and/or URIs. dest of requests and claims, and orig of verification requests
+// (an array with one TN or URI)
+type Identities struct {
+	TN					[]string		`json:"tn,omitempty"`
+	URI					[]string		`json:"uri,omitempty"`
+}
+
+// Caller - calling customer and/or trunk, to determine attestation level (see attestation_file)
+type Caller struct {
+	Customer		string			`json:"customer,omitempty"`
+	Trunk				string			`json:"trunk,omitempty"`
+}
+
+// Rph - resource priority values (RFC 8443)
+type Rph struct {
+	Auth				[]string		`json:"auth"`
+}
+
+// SignRequest - request payload of POST /stir/v1/signing. Attest or Caller is required for
+// "shaken" PASSporTs; Iat and OrigID may be set by Vesper, if configured
+type SignRequest struct {
+	Attest			string			`json:"attest,omitempty"`
+	Caller			*Caller			`json:"caller,omitempty"`
+	Dest				Identities	`json:"dest"`
+	Iat					int64				`json:"iat,omitempty"`
+	Orig				Orig				`json:"orig"`
+	OrigID			string			`json:"origid,omitempty"`
+	Ppt					string			`json:"ppt,omitempty"`				// "shaken" (default) or "rph"
+	Rph					*Rph				`json:"rph,omitempty"`
+	Tenant			string			`json:"tenant,omitempty"`
+}
+
+// Claims - PASSporT claims
+type Claims struct {
+	Attest			string			`json:"attest,omitempty"`
+	Dest				Identities	`json:"dest"`
+	Iat					int64				`json:"iat"`
+	Orig				Orig				`json:"orig"`
+	OrigID			string			`json:"origid,omitempty"`
+	Rph					*Rph				`json:"rph,omitempty"`
+}
+
+// Decision - attestation decision of Vesper, if attest was determined from caller (see attestation_file)
+type Decision struct {
+	Attest			string			`json:"attest"`
+	OrigID			string			`json:"origid"`
+	Reason			string			`json:"reason"`
+	Customer		string			`json:"customer,omitempty"`
+	Trunk				string			`json:"trunk,omitempty"`
+}
+
+// SignResponse - signingResponse of POST /stir/v1/signing
+type SignResponse struct {
+	Identity				string			`json:"identity"`
+	X5u							string			`json:"x5u"`
+	Claims					Claims			`json:"claims"`
+	Attestation			*Decision		`json:"attestation,omitempty"`
+	TraceID					string			`json:"-"`
+}
+
+// VerifyRequest - request payload of POST /stir/v1/verification
+type VerifyRequest struct {
+	Dest				Identities	`json:"dest"`
+	Iat					int64				`json:"iat"`
+	Orig				Identities	`json:"orig"`
+	Identity		string			`json:"identity"`
+}
+
+// Header - JWT header of a PASSporT
+type Header struct {
+	Alg					string			`json:"alg"`
+	Ppt					string			`json:"ppt"`
+	Typ					string			`json:"typ"`
+	X5u					string			`json:"x5u"`
+}
+
+// JWT - header and claims of a verified PASSporT
+type JWT struct {
+	Header			Header			`json:"header"`
+	Claims			Claims			`json:"claims"`
+}
+
+// VerifyResponse - verificationResponse of POST /stir/v1/verification
+type VerifyResponse struct {
+	Dest						Identities	`json:"dest"`
+	Iat							int64				`json:"iat"`
+	Orig						Identities	`json:"orig"`
+	JWT							JWT					`json:"jwt"`
+	Rph							*Rph				`json:"rph,omitempty"`
+	TrustDomain			string			`json:"trustDomain,omitempty"`
+	TraceID					string			`json:"-"`
+}
+
+// VerifyRequest returns the verification request of the PASSporT in r, as signed (iat and TNs
+// may have been stamped or normalized by Vesper)
+func (r *SignResponse) VerifyRequest() VerifyRequest {
+	v := VerifyRequest{Dest: r.Claims.Dest, Iat: r.Claims.Iat, Identity: r.Identity}
+	if len(r.Claims.Orig.TN) > 0 {
+		v.Orig.TN = []string{r.Claims.Orig.TN}
+	}
+	if len(r.Claims.Orig.URI) > 0 {
+		v.Orig.URI = []string{r.Claims.Orig.URI}
+	}
+	return v
+}
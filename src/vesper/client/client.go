@@ -0,0 +1,210 @@
+// Package client is a Go client of the Vesper HTTP API (see APIs.md). Requests and responses are
+// typed, errors are *Error carrying the VESPER code, Trace-Id is propagated (see WithTraceID),
+// connections are pooled and idempotent requests are retried. Fake is a fake Vesper for tests
+package client
+
+import (
+	"fmt"
+	"time"
+	"bytes"
+	"context"
+	"strings"
+	"net/http"
+	"net/url"
+	"io/ioutil"
+	"encoding/json"
+	"github.com/satori/go.uuid"
+)
+
+// Options - options of a Client. URL is required
+type Options struct {
+	URL							string					// base URL of Vesper (e.g. https://vesper.example.com:8443)
+	APIKey					string					// sent in X-Api-Key header, if not empty (tenant or trust domain API key)
+	Timeout					time.Duration		// timeout of each attempt (default 2 seconds)
+	MaxRetries			int							// retries of idempotent requests (default 2, -1 - no retries)
+	RetryBackoff		time.Duration		// wait before first retry, doubled for each retry (default 100 ms)
+	MaxIdleConns		int							// idle (keep-alive) connections to Vesper (default 64)
+	HTTPClient			*http.Client		// overrides Timeout and MaxIdleConns, if not nil
+}
+
+// Client - client of a Vesper instance. A Client is safe for concurrent use and SHOULD be reused
+// (connections to Vesper are pooled)
+type Client struct {
+	url					string
+	apiKey			string
+	retries			int
+	backoff			time.Duration
+	httpClient	*http.Client
+}
+
+// traceKey - context key of Trace-Id
+type traceKey struct{}
+
+// WithTraceID returns a copy of ctx with trace ID id. Requests made with the returned context
+// carry id in Trace-Id header; a trace ID is generated for requests made without one
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceKey{}, id)
+}
+
+// TraceID returns the trace ID in ctx, if any
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
+
+// NewObject returns a Client with options o
+func NewObject(o Options) (*Client, error) {
+	u, err := url.Parse(o.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Vesper URL %q is not an HTTP(S) URL", o.URL)
+	}
+	c := &Client{url: strings.TrimRight(o.URL, "/"), apiKey: o.APIKey, retries: o.MaxRetries, backoff: o.RetryBackoff, httpClient: o.HTTPClient}
+	switch {
+	case c.retries == 0 :
+		c.retries = 2
+	case c.retries < 0 :
+		c.retries = 0
+	}
+	if c.backoff <= 0 {
+		c.backoff = 100 * time.Millisecond
+	}
+	if c.httpClient == nil {
+		timeout, idle := o.Timeout, o.MaxIdleConns
+		if timeout <= 0 {
+			timeout = 2 * time.Second
+		}
+		if idle <= 0 {
+			idle = 64
+		}
+		// all requests go to one host - its idle connections are the pool
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConns = idle
+		t.MaxIdleConnsPerHost = idle
+		c.httpClient = &http.Client{Timeout: timeout, Transport: t}
+	}
+	return c, nil
+}
+
+// Sign signs request r. Signing requests are retried (signing has no side effect in Vesper)
+func (c *Client) Sign(ctx context.Context, r SignRequest) (*SignResponse, error) {
+	var resp SignResponse
+	traceID, err := c.do(ctx, "POST", "/stir/v1/signing", r, "signingResponse", &resp, true)
+	if err != nil {
+		return nil, err
+	}
+	resp.TraceID = traceID
+	return &resp, nil
+}
+
+// Verify verifies request r. Verification requests are NOT retried: a verified PASSporT is
+// cached by Vesper and verifying it again fails as a replay attack (VESPER-4169)
+func (c *Client) Verify(ctx context.Context, r VerifyRequest) (*VerifyResponse, error) {
+	var resp VerifyResponse
+	traceID, err := c.do(ctx, "POST", "/stir/v1/verification", r, "verificationResponse", &resp, false)
+	if err != nil {
+		return nil, err
+	}
+	resp.TraceID = traceID
+	return &resp, nil
+}
+
+// Ready returns true if Vesper is ready to serve requests (GET /v1/ready). It is not retried
+func (c *Client) Ready(ctx context.Context) (bool, error) {
+	var resp struct {
+		Ready		bool		`json:"ready"`
+	}
+	_, err := c.do(ctx, "GET", "/v1/ready", nil, "", &resp, false)
+	if e, ok := err.(*Error); ok && e.Status == http.StatusServiceUnavailable && len(e.Code) == 0 {
+		return false, nil
+	}
+	return resp.Ready, err
+}
+
+// Version returns the software version of Vesper (GET /v1/version)
+func (c *Client) Version(ctx context.Context) (string, error) {
+	var resp struct {
+		Version		string
+	}
+	_, err := c.do(ctx, "GET", "/v1/version", nil, "", &resp, true)
+	return resp.Version, err
+}
+
+// do makes request with JSON payload p (if not nil) and decodes the response (in field action of
+// the response body, if not empty) in v. Idempotent requests are retried if the error is temporary.
+// The trace ID of the request is returned
+func (c *Client) do(ctx context.Context, method, path string, p interface{}, action string, v interface{}, idempotent bool) (string, error) {
+	traceID := TraceID(ctx)
+	if len(traceID) == 0 {
+		traceID = "VESPER-" + uuid.NewV1().String()
+	}
+	var body []byte
+	if p != nil {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return traceID, &Error{TraceID: traceID, Err: fmt.Errorf("%v - request payload", err)}
+		}
+		body = b
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, path, body, traceID, action, v)
+		e, ok := err.(*Error)
+		if err == nil || !ok || !idempotent || !e.Temporary() || attempt >= c.retries || ctx.Err() != nil {
+			return traceID, err
+		}
+		select {
+		case <- ctx.Done():
+			return traceID, err
+		case <- time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt makes request once (see do)
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, traceID, action string, v interface{}) error {
+	req, err := http.NewRequest(method, c.url + path, bytes.NewReader(body))
+	if err != nil {
+		return &Error{TraceID: traceID, Err: err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Trace-Id", traceID)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(c.apiKey) > 0 {
+		req.Header.Set("X-Api-Key", c.apiKey)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return &Error{TraceID: traceID, Err: err}
+	}
+	defer resp.Body.Close()
+	// body is read to the end so that the connection is reused
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &Error{Status: resp.StatusCode, TraceID: traceID, Err: fmt.Errorf("%v - response body", err)}
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		// not a response of Vesper (e.g. of a proxy)
+		return &Error{Status: resp.StatusCode, TraceID: traceID, Message: strings.TrimSpace(string(b))}
+	}
+	raw := json.RawMessage(b)
+	if len(action) > 0 {
+		raw = m[action]
+	}
+	if resp.StatusCode != http.StatusOK {
+		var eb struct {
+			Code			Code			`json:"code"`
+			Message		string		`json:"message"`
+			Verstat		string		`json:"verstat"`
+		}
+		json.Unmarshal(raw, &eb)
+		return &Error{Status: resp.StatusCode, Code: eb.Code, Message: eb.Message, Verstat: eb.Verstat, TraceID: traceID}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &Error{Status: resp.StatusCode, TraceID: traceID, Err: fmt.Errorf("%v - response body", err)}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"context"
+	"net/http"
+	"vesper/errorhandler"
+)
+
+func newFake(t *testing.T, o Options) (*Fake, *Client) {
+	f, err := NewFake()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	o.RetryBackoff = time.Millisecond
+	c, err := f.Client(o)
+	if err != nil {
+		f.Close()
+		t.Fatalf("%v", err)
+	}
+	return f, c
+}
+
+func TestSignVerify(t *testing.T) {
+	f, c := newFake(t, Options{})
+	defer f.Close()
+	ctx := WithTraceID(context.Background(), "trace-1")
+	s, err := c.Sign(ctx, SignRequest{
+		Attest: "A",
+		Dest: Identities{TN: []string{"12155551213"}},
+		Iat: time.Now().Unix(),
+		Orig: Orig{TN: "12155551212"},
+		OrigID: "123e4567-e89b-12d3-a456-426655440000",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if s.X5u != f.X5u() || s.Claims.Attest != "A" || s.TraceID != "trace-1" {
+		t.Errorf("unexpected signing response %+v", s)
+	}
+	v, err := c.Verify(ctx, s.VerifyRequest())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if v.JWT.Header.X5u != f.X5u() || v.JWT.Claims.Orig.TN != "12155551212" || v.JWT.Claims.OrigID != s.Claims.OrigID {
+		t.Errorf("unexpected verification response %+v", v)
+	}
+	// replay attack
+	_, err = c.Verify(ctx, s.VerifyRequest())
+	var e *Error
+	if !errors.Is(err, Vesper4169) || !errors.As(err, &e) || e.Status != http.StatusBadRequest {
+		t.Errorf("replay - got %v, want VESPER-4169", err)
+	}
+	for _, id := range f.TraceIDs() {
+		if id != "trace-1" {
+			t.Errorf("got Trace-Id %q, want trace-1", id)
+		}
+	}
+	// trace ID is generated
+	if _, err := c.Version(context.Background()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if ids := f.TraceIDs(); len(ids[len(ids) - 1]) == 0 || ids[len(ids) - 1] == "trace-1" {
+		t.Errorf("trace ID not generated - %v", ids)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	f, c := newFake(t, Options{})
+	defer f.Close()
+	ctx := context.Background()
+	_, err := c.Sign(ctx, SignRequest{Attest: "D", Dest: Identities{TN: []string{"1"}}, Iat: 1, Orig: Orig{TN: "2"}, OrigID: "x"})
+	if !errors.Is(err, Vesper4006) {
+		t.Errorf("got %v, want VESPER-4006", err)
+	}
+	// signing is retried, verification is not
+	f.Fail("/stir/v1/signing", http.StatusServiceUnavailable, "", 2)
+	if _, err := c.Sign(ctx, SignRequest{Attest: "A", Dest: Identities{TN: []string{"12155551213"}}, Orig: Orig{TN: "12155551212"}, Iat: time.Now().Unix(), OrigID: "123e4567-e89b-12d3-a456-426655440000"}); err != nil {
+		t.Errorf("signing not retried - %v", err)
+	}
+	if n := f.Requests("/stir/v1/signing"); n != 4 {
+		t.Errorf("got %v signing requests, want 4", n)
+	}
+	f.Fail("/stir/v1/verification", http.StatusServiceUnavailable, "", 1)
+	_, err = c.Verify(ctx, VerifyRequest{})
+	e, ok := err.(*Error)
+	if !ok || e.Status != http.StatusServiceUnavailable || len(e.Code) > 0 || e.Message != "Service Unavailable" || f.Requests("/stir/v1/verification") != 1 {
+		t.Errorf("got %#v, want HTTP status 503 and no retry", err)
+	}
+	f.Fail("/stir/v1/signing", http.StatusInternalServerError, "VESPER-5050", 1)
+	if _, err := c.Sign(ctx, SignRequest{}); !errors.Is(err, Vesper5050) || f.Requests("/stir/v1/signing") != 5 {
+		t.Errorf("got %v, want VESPER-5050 and no retry", err)
+	}
+	if ready, err := c.Ready(ctx); !ready || err != nil {
+		t.Errorf("got ready %v (%v), want true", ready, err)
+	}
+	f.Fail("/v1/ready", http.StatusServiceUnavailable, "", 1)
+	if ready, err := c.Ready(ctx); ready || err != nil {
+		t.Errorf("got ready %v (%v), want false", ready, err)
+	}
+	if _, err := NewObject(Options{URL: "vesper:8080"}); err == nil {
+		t.Errorf("URL without scheme accepted")
+	}
+}
+
+func TestCodes(t *testing.T) {
+	// codes.go is generated from errorhandler.ReasonString (go generate)
+	if len(codes) != len(errorhandler.ReasonString) {
+		t.Errorf("got %v codes, want %v - run go generate", len(codes), len(errorhandler.ReasonString))
+	}
+	for _, c := range codes {
+		if !c.Known() {
+			t.Errorf("%v is not a code of Vesper - run go generate", c)
+		}
+	}
+	if Vesper4169 != "VESPER-4169" || len(Vesper4169.Error()) <= len("VESPER-4169") {
+		t.Errorf("got %v", Vesper4169.Error())
+	}
+}
@@ -0,0 +1,461 @@
+// Code generated by gencodes.go from errorhandler.ReasonString; DO NOT EDIT.
+
+package client
+
+// VESPER codes (see APIs.md)
+const (
+	// empty request body
+	Vesper4001 Code = "VESPER-4001"
+	// Unable to parse request body
+	Vesper4002 Code = "VESPER-4002"
+	// one or more of the require fields missing in request payload
+	Vesper4003 Code = "VESPER-4003"
+	// request payload has more than expected fields
+	Vesper4004 Code = "VESPER-4004"
+	// attest field in request payload is an empty string
+	Vesper4005 Code = "VESPER-4005"
+	// attest field in request payload is not as per SHAKEN spec
+	Vesper4006 Code = "VESPER-4006"
+	// attest field in request payload MUST be a string
+	Vesper4007 Code = "VESPER-4007"
+	// iat value in request payload is 0
+	Vesper4008 Code = "VESPER-4008"
+	// iat field in request payload MUST be a number
+	Vesper4009 Code = "VESPER-4009"
+	// origid field in request payload is an empty string
+	Vesper4010 Code = "VESPER-4010"
+	// origid field in request payload MUST be a string
+	Vesper4011 Code = "VESPER-4011"
+	// orig in request payload is an empty object
+	Vesper4012 Code = "VESPER-4012"
+	// orig in request payload should contain only one field
+	Vesper4013 Code = "VESPER-4013"
+	// orig in request payload does not contain field "tn" or "uri"
+	Vesper4014 Code = "VESPER-4014"
+	// orig tn in request payload is not of type string
+	Vesper4015 Code = "VESPER-4015"
+	// orig tn in request payload is an empty string
+	Vesper4016 Code = "VESPER-4016"
+	// orig field in request payload MUST be a JSON object
+	Vesper4017 Code = "VESPER-4017"
+	// dest in request payload is an empty object
+	Vesper4018 Code = "VESPER-4018"
+	// dest in request payload should contain only "tn" and/or "uri" fields
+	Vesper4019 Code = "VESPER-4019"
+	// dest in request payload contains field other than "tn" and "uri"
+	Vesper4020 Code = "VESPER-4020"
+	// dest tn in request payload is an empty array
+	Vesper4021 Code = "VESPER-4021"
+	// one or more dest tns in request payload is not a string
+	Vesper4022 Code = "VESPER-4022"
+	// one or more dest tns in request payload is an empty string
+	Vesper4023 Code = "VESPER-4023"
+	// dest tn in request payload is not an array
+	Vesper4024 Code = "VESPER-4024"
+	// dest field in request payload MUST be a JSON object
+	Vesper4025 Code = "VESPER-4025"
+	// tenant field in request payload MUST be a string
+	Vesper4026 Code = "VESPER-4026"
+	// tenant is not configured
+	Vesper4027 Code = "VESPER-4027"
+	// API key is missing or is not valid for tenant
+	Vesper4028 Code = "VESPER-4028"
+	// attest level is not permitted by tenant policy
+	Vesper4029 Code = "VESPER-4029"
+	// caller field in request payload MUST be a JSON object
+	Vesper4030 Code = "VESPER-4030"
+	// caller in request payload MUST contain customer and/or trunk strings only
+	Vesper4031 Code = "VESPER-4031"
+	// attest field MUST NOT be present in request payload when caller field is present
+	Vesper4032 Code = "VESPER-4032"
+	// customer in caller does not match customer of trunk
+	Vesper4033 Code = "VESPER-4033"
+	// caller field in request payload is not supported
+	Vesper4034 Code = "VESPER-4034"
+	// iat value in request payload drifts too far from current time
+	Vesper4035 Code = "VESPER-4035"
+	// origid field in request payload is not a RFC 4122 UUID
+	Vesper4036 Code = "VESPER-4036"
+	// orig tn in request payload is not a valid telephone number
+	Vesper4037 Code = "VESPER-4037"
+	// one or more dest tns in request payload is not a valid telephone number
+	Vesper4038 Code = "VESPER-4038"
+	// orig uri in request payload is not of type string
+	Vesper4039 Code = "VESPER-4039"
+	// orig uri in request payload is an empty string
+	Vesper4040 Code = "VESPER-4040"
+	// orig uri in request payload is not a valid URI
+	Vesper4041 Code = "VESPER-4041"
+	// dest uri in request payload is not an array
+	Vesper4042 Code = "VESPER-4042"
+	// dest uri in request payload is an empty array
+	Vesper4043 Code = "VESPER-4043"
+	// one or more dest uris in request payload is not a string
+	Vesper4044 Code = "VESPER-4044"
+	// one or more dest uris in request payload is an empty string
+	Vesper4045 Code = "VESPER-4045"
+	// one or more dest uris in request payload is not a valid URI
+	Vesper4046 Code = "VESPER-4046"
+	// rph field in request payload MUST be a JSON object containing only "auth" field
+	Vesper4047 Code = "VESPER-4047"
+	// rph auth in request payload MUST be a non-empty array
+	Vesper4048 Code = "VESPER-4048"
+	// one or more rph auth values in request payload is not a resource priority value (namespace.priority)
+	Vesper4049 Code = "VESPER-4049"
+	// ppt field in request payload is not supported
+	Vesper4050 Code = "VESPER-4050"
+	// rph PASSporT signing is not permitted for caller
+	Vesper4051 Code = "VESPER-4051"
+	// empty request body
+	Vesper4100 Code = "VESPER-4100"
+	// Unable to parse request body
+	Vesper4102 Code = "VESPER-4102"
+	// one or more of the require fields missing in request payload
+	Vesper4103 Code = "VESPER-4103"
+	// request payload has more than expected fields
+	Vesper4104 Code = "VESPER-4104"
+	// iat value in request payload is 0
+	Vesper4105 Code = "VESPER-4105"
+	// iat field in request payload MUST be a number
+	Vesper4106 Code = "VESPER-4106"
+	// identity field in request payload is an empty string
+	Vesper4107 Code = "VESPER-4107"
+	// attest field in request payload MUST be a string
+	Vesper4108 Code = "VESPER-4108"
+	// orig in request payload is an empty object
+	Vesper4109 Code = "VESPER-4109"
+	// orig in request payload should contain only one field
+	Vesper4110 Code = "VESPER-4110"
+	// orig in request payload does not contain field "tn" or "uri"
+	Vesper4111 Code = "VESPER-4111"
+	// orig tn in request payload is an empty array
+	Vesper4112 Code = "VESPER-4112"
+	// orig tn array contains more than one element in request payload
+	Vesper4113 Code = "VESPER-4113"
+	// one or more orig tns in request payload is not a string
+	Vesper4114 Code = "VESPER-4114"
+	// one or more orig tns in request payload is an empty string
+	Vesper4115 Code = "VESPER-4115"
+	// orig tn in request payload is not an array
+	Vesper4116 Code = "VESPER-4116"
+	// orig field in request payload MUST be a JSON object
+	Vesper4117 Code = "VESPER-4117"
+	// dest in request payload is an empty object
+	Vesper4118 Code = "VESPER-4118"
+	// dest in request payload should contain only "tn" and/or "uri" fields
+	Vesper4119 Code = "VESPER-4119"
+	// dest in request payload contains field other than "tn" and "uri"
+	Vesper4120 Code = "VESPER-4120"
+	// dest tn in request payload is an empty array
+	Vesper4121 Code = "VESPER-4121"
+	// one or more dest tns in request payload is not a string
+	Vesper4122 Code = "VESPER-4122"
+	// one or more dest tns in request payload is an empty string
+	Vesper4123 Code = "VESPER-4123"
+	// dest tn in request payload is not an array
+	Vesper4124 Code = "VESPER-4124"
+	// dest field in request payload MUST be a JSON object
+	Vesper4125 Code = "VESPER-4125"
+	// Identity field does not contain all the relevant parameters
+	Vesper4126 Code = "VESPER-4126"
+	// Invalid JWT format in identity field
+	Vesper4127 Code = "VESPER-4127"
+	// Invalid info parameter in identity field
+	Vesper4128 Code = "VESPER-4128"
+	// Invalid alg parameter in identity field
+	Vesper4129 Code = "VESPER-4129"
+	// Invalid ppt parameter in identity field
+	Vesper4130 Code = "VESPER-4130"
+	// x5u value in JWT header does not match info parameter in identity field
+	Vesper4131 Code = "VESPER-4131"
+	// decoded header does not have the expected number of fields (4)
+	Vesper4132 Code = "VESPER-4132"
+	// one or more of the required fields missing in JWT header
+	Vesper4133 Code = "VESPER-4133"
+	// alg field value in JWT header is not "ES256"
+	Vesper4134 Code = "VESPER-4134"
+	// alg field value in JWT header is not a string
+	Vesper4135 Code = "VESPER-4135"
+	// ppt field value in JWT header is not "shaken" or "rph"
+	Vesper4136 Code = "VESPER-4136"
+	// ppt field value in JWT header is not a string
+	Vesper4137 Code = "VESPER-4137"
+	// typ field value in JWT header is not "passport"
+	Vesper4138 Code = "VESPER-4138"
+	// typ field value in JWT header is not a string
+	Vesper4139 Code = "VESPER-4139"
+	// x5u field value in JWT header is not a string
+	Vesper4140 Code = "VESPER-4140"
+	// unable to base64 url decode header part of JWT
+	Vesper4150 Code = "VESPER-4150"
+	// unable to unmarshal decoded JWT header
+	Vesper4151 Code = "VESPER-4151"
+	// unable to base64 url decode claims part of JWT
+	Vesper4152 Code = "VESPER-4152"
+	// unable to unmarshal decoded JWT claims
+	Vesper4153 Code = "VESPER-4153"
+	// orig TN in request payload does not match orig TN in JWT claims
+	Vesper4154 Code = "VESPER-4154"
+	// dest TNs in request payload does not match dest TNs in JWT claims
+	Vesper4155 Code = "VESPER-4155"
+	// http request to retrieve cert from sticr failed
+	Vesper4156 Code = "VESPER-4156"
+	// error encountered reading response body
+	Vesper4157 Code = "VESPER-4157"
+	// error encountered decoding cert retrieved from sticr
+	Vesper4158 Code = "VESPER-4158"
+	// error encountered when parsing decoded pem data
+	Vesper4159 Code = "VESPER-4159"
+	// certificate has expired or is not yet valid
+	Vesper4160 Code = "VESPER-4160"
+	// certificate signed by unknown authority
+	Vesper4161 Code = "VESPER-4161"
+	// certificate is not authorized to sign other certificates
+	Vesper4162 Code = "VESPER-4162"
+	// issuer name does not match subject from issuing certificate
+	Vesper4163 Code = "VESPER-4163"
+	// other errors - certificate issuer, unauthorized root/intermediate certificate,...
+	Vesper4164 Code = "VESPER-4164"
+	// public key is not a ECDSA public key
+	Vesper4165 Code = "VESPER-4165"
+	// error encountered in verifying signature
+	Vesper4166 Code = "VESPER-4166"
+	// iat value indicates stale date
+	Vesper4167 Code = "VESPER-4167"
+	// unable to validate replay attack
+	Vesper4168 Code = "VESPER-4168"
+	// JWT claims repeated; possible replay attack
+	Vesper4169 Code = "VESPER-4169"
+	// orig tn in request payload is not a valid telephone number
+	Vesper4170 Code = "VESPER-4170"
+	// one or more dest tns in request payload is not a valid telephone number
+	Vesper4171 Code = "VESPER-4171"
+	// orig tn in JWT claims is not a valid telephone number
+	Vesper4172 Code = "VESPER-4172"
+	// one or more dest tns in JWT claims is not a valid telephone number
+	Vesper4173 Code = "VESPER-4173"
+	// orig uri in request payload is not an array
+	Vesper4174 Code = "VESPER-4174"
+	// orig uri in request payload is an empty array
+	Vesper4175 Code = "VESPER-4175"
+	// orig uri array contains more than one element in request payload
+	Vesper4176 Code = "VESPER-4176"
+	// orig uri in request payload is not a string
+	Vesper4177 Code = "VESPER-4177"
+	// orig uri in request payload is an empty string
+	Vesper4178 Code = "VESPER-4178"
+	// orig uri in request payload is not a valid URI
+	Vesper4179 Code = "VESPER-4179"
+	// dest uri in request payload is not an array
+	Vesper4180 Code = "VESPER-4180"
+	// dest uri in request payload is an empty array
+	Vesper4181 Code = "VESPER-4181"
+	// one or more dest uris in request payload is not a string
+	Vesper4182 Code = "VESPER-4182"
+	// one or more dest uris in request payload is an empty string
+	Vesper4183 Code = "VESPER-4183"
+	// one or more dest uris in request payload is not a valid URI
+	Vesper4184 Code = "VESPER-4184"
+	// orig URI in request payload does not match orig URI in JWT claims
+	Vesper4185 Code = "VESPER-4185"
+	// dest URIs in request payload does not match dest URIs in JWT claims
+	Vesper4186 Code = "VESPER-4186"
+	// ppt parameter in identity field does not match ppt in JWT header
+	Vesper4187 Code = "VESPER-4187"
+	// alg field value in JWT header is not an allowed algorithm
+	Vesper4188 Code = "VESPER-4188"
+	// public key in certificate does not match alg in JWT header
+	Vesper4189 Code = "VESPER-4189"
+	// certificate, service provider or x5u host is on deny list
+	Vesper4190 Code = "VESPER-4190"
+	// certificate is revoked (STI-PA CRL)
+	Vesper4191 Code = "VESPER-4191"
+	// admin API key in X-Admin-Api-Key header is missing or invalid
+	Vesper4201 Code = "VESPER-4201"
+	// admin API is not enabled
+	Vesper4202 Code = "VESPER-4202"
+	// deny list is not configured
+	Vesper4203 Code = "VESPER-4203"
+	// empty request body
+	Vesper4204 Code = "VESPER-4204"
+	// Unable to parse request body
+	Vesper4205 Code = "VESPER-4205"
+	// one or more deny list entries in request payload is invalid
+	Vesper4206 Code = "VESPER-4206"
+	// tenant in request is not found
+	Vesper4207 Code = "VESPER-4207"
+	// there is no pending signing key to activate
+	Vesper4208 Code = "VESPER-4208"
+	// there is no retiring signing key to roll back to
+	Vesper4209 Code = "VESPER-4209"
+	// configuration is not reloaded
+	Vesper4210 Code = "VESPER-4210"
+	// error in converting header to byte array
+	Vesper5050 Code = "VESPER-5050"
+	// error in converting claims to byte array
+	Vesper5051 Code = "VESPER-5051"
+	// error in signing request
+	Vesper5052 Code = "VESPER-5052"
+	// signing or verification is not configured
+	Vesper5053 Code = "VESPER-5053"
+	// unexpected error in signing request
+	Vesper5054 Code = "VESPER-5054"
+	// unexpected error in verifying request
+	Vesper5055 Code = "VESPER-5055"
+	// error in saving deny list
+	Vesper5201 Code = "VESPER-5201"
+)
+
+// codes - all VESPER codes
+var codes = []Code{
+	Vesper4001,
+	Vesper4002,
+	Vesper4003,
+	Vesper4004,
+	Vesper4005,
+	Vesper4006,
+	Vesper4007,
+	Vesper4008,
+	Vesper4009,
+	Vesper4010,
+	Vesper4011,
+	Vesper4012,
+	Vesper4013,
+	Vesper4014,
+	Vesper4015,
+	Vesper4016,
+	Vesper4017,
+	Vesper4018,
+	Vesper4019,
+	Vesper4020,
+	Vesper4021,
+	Vesper4022,
+	Vesper4023,
+	Vesper4024,
+	Vesper4025,
+	Vesper4026,
+	Vesper4027,
+	Vesper4028,
+	Vesper4029,
+	Vesper4030,
+	Vesper4031,
+	Vesper4032,
+	Vesper4033,
+	Vesper4034,
+	Vesper4035,
+	Vesper4036,
+	Vesper4037,
+	Vesper4038,
+	Vesper4039,
+	Vesper4040,
+	Vesper4041,
+	Vesper4042,
+	Vesper4043,
+	Vesper4044,
+	Vesper4045,
+	Vesper4046,
+	Vesper4047,
+	Vesper4048,
+	Vesper4049,
+	Vesper4050,
+	Vesper4051,
+	Vesper4100,
+	Vesper4102,
+	Vesper4103,
+	Vesper4104,
+	Vesper4105,
+	Vesper4106,
+	Vesper4107,
+	Vesper4108,
+	Vesper4109,
+	Vesper4110,
+	Vesper4111,
+	Vesper4112,
+	Vesper4113,
+	Vesper4114,
+	Vesper4115,
+	Vesper4116,
+	Vesper4117,
+	Vesper4118,
+	Vesper4119,
+	Vesper4120,
+	Vesper4121,
+	Vesper4122,
+	Vesper4123,
+	Vesper4124,
+	Vesper4125,
+	Vesper4126,
+	Vesper4127,
+	Vesper4128,
+	Vesper4129,
+	Vesper4130,
+	Vesper4131,
+	Vesper4132,
+	Vesper4133,
+	Vesper4134,
+	Vesper4135,
+	Vesper4136,
+	Vesper4137,
+	Vesper4138,
+	Vesper4139,
+	Vesper4140,
+	Vesper4150,
+	Vesper4151,
+	Vesper4152,
+	Vesper4153,
+	Vesper4154,
+	Vesper4155,
+	Vesper4156,
+	Vesper4157,
+	Vesper4158,
+	Vesper4159,
+	Vesper4160,
+	Vesper4161,
+	Vesper4162,
+	Vesper4163,
+	Vesper4164,
+	Vesper4165,
+	Vesper4166,
+	Vesper4167,
+	Vesper4168,
+	Vesper4169,
+	Vesper4170,
+	Vesper4171,
+	Vesper4172,
+	Vesper4173,
+	Vesper4174,
+	Vesper4175,
+	Vesper4176,
+	Vesper4177,
+	Vesper4178,
+	Vesper4179,
+	Vesper4180,
+	Vesper4181,
+	Vesper4182,
+	Vesper4183,
+	Vesper4184,
+	Vesper4185,
+	Vesper4186,
+	Vesper4187,
+	Vesper4188,
+	Vesper4189,
+	Vesper4190,
+	Vesper4191,
+	Vesper4201,
+	Vesper4202,
+	Vesper4203,
+	Vesper4204,
+	Vesper4205,
+	Vesper4206,
+	Vesper4207,
+	Vesper4208,
+	Vesper4209,
+	Vesper4210,
+	Vesper5050,
+	Vesper5051,
+	Vesper5052,
+	Vesper5053,
+	Vesper5054,
+	Vesper5055,
+	Vesper5201,
+}
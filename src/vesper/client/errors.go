@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"vesper/errorhandler"
+)
+
+//go:generate go run gencodes.go
+
+// Code - VESPER code (see errorhandler and codes.go). Codes are errors, so that the code of an error
+// returned by the client is tested with errors.Is (e.g. errors.Is(err, client.Vesper4169))
+type Code string
+
+func (c Code) Error() string {
+	if r, ok := errorhandler.ReasonString[string(c)]; ok {
+		return string(c) + " - " + r
+	}
+	return string(c)
+}
+
+// Known returns true if c is a code of this version of Vesper
+func (c Code) Known() bool {
+	_, ok := errorhandler.ReasonString[string(c)]
+	return ok
+}
+
+// Verstat returns the verification status (3GPP TS 24.229) of a verification error with code c,
+// if it has a definite outcome
+func (c Code) Verstat() string {
+	return errorhandler.Verstat[string(c)]
+}
+
+// Error - error returned by the client. Either Vesper responded with an error (Status and, for
+// errors of the API, Code and Message) or no response was received (Err)
+type Error struct {
+	Status			int					// HTTP status (0 if no response)
+	Code				Code
+	Message			string
+	Verstat			string			// verification errors only
+	TraceID			string
+	Err					error
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err != nil :
+		return fmt.Sprintf("%v - trace ID %v", e.Err, e.TraceID)
+	case len(e.Code) > 0 :
+		return fmt.Sprintf("%v - %v (HTTP status %v, trace ID %v)", string(e.Code), e.Message, e.Status, e.TraceID)
+	}
+	return fmt.Sprintf("%v (HTTP status %v, trace ID %v)", e.Message, e.Status, e.TraceID)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the code of e
+func (e *Error) Is(target error) bool {
+	c, ok := target.(Code)
+	return ok && len(e.Code) > 0 && c == e.Code
+}
+
+// Temporary returns true if the request may succeed if it is made again: no response was
+// received or Vesper (or a proxy in front of it) is not available
+func (e *Error) Temporary() bool {
+	switch e.Status {
+	case 0, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
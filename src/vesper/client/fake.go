@@ -0,0 +1,245 @@
+package client
+
+import (
+	"io"
+	"sync"
+	"time"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"vesper/errorhandler"
+	"vesper/replayattack"
+	"vesper/stir"
+)
+
+// Fake - fake Vesper for tests of applications using the client. It serves the signing,
+// verification, readiness and version APIs with the request validation and VESPER codes of Vesper
+// (default configuration): PASSporTs are signed with a self-signed certificate, served by the fake
+// at x5u, and verified against it. Failures can be injected (see Fail)
+type Fake struct {
+	sync.Mutex	// A field declared with a type but no explicit field name is an
+					// anonymous field, also called an embedded field or an embedding of
+					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	*httptest.Server
+	cert					[]byte
+	key						*ecdsa.PrivateKey
+	roots					*x509.CertPool
+	cache					*replayattack.Cache
+	failures			map[string][]fakeFailure
+	requests			map[string]int
+	traceIDs			[]string
+}
+
+type fakeFailure struct {
+	status		int
+	code			string
+}
+
+// fakeCredentials - signing credentials of Fake
+type fakeCredentials struct {
+	x5u			string
+	key			*ecdsa.PrivateKey
+}
+
+func (c fakeCredentials) SigningForTN(tn string) (string, *ecdsa.PrivateKey) {
+	return c.x5u, c.key
+}
+
+// fakeTrust - root certs of Fake (its certificate)
+type fakeTrust struct {
+	roots		*x509.CertPool
+}
+
+func (t fakeTrust) Roots(apiKey, x5u string) (string, *x509.CertPool) {
+	return "", t.roots
+}
+
+// NewFake starts a Fake. It is stopped by Close
+func NewFake() (*Fake, error) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "Vesper fake"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(24*time.Hour),
+		IsCA: true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		return nil, err
+	}
+	c, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fake{
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b}),
+		key: k,
+		roots: x509.NewCertPool(),
+		cache: replayattack.InitObject(),
+		failures: make(map[string][]fakeFailure),
+		requests: make(map[string]int),
+	}
+	f.roots.AddCert(c)
+	f.Server = httptest.NewServer(f)
+	return f, nil
+}
+
+// Client returns a client of f with options o (URL is set to the URL of f)
+func (f *Fake) Client(o Options) (*Client, error) {
+	o.URL = f.URL
+	return NewObject(o)
+}
+
+// X5u returns the x5u of PASSporTs signed by f
+func (f *Fake) X5u() string {
+	return f.URL + "/certs/fake.pem"
+}
+
+// Fail makes the next n requests to path (e.g. /stir/v1/signing) fail with HTTP status and VESPER
+// code. If code is empty, the response body is not a response of Vesper (e.g. of a proxy)
+func (f *Fake) Fail(path string, status int, code string, n int) {
+	f.Lock()
+	defer f.Unlock()
+	for i := 0; i < n; i++ {
+		f.failures[path] = append(f.failures[path], fakeFailure{status: status, code: code})
+	}
+}
+
+// Requests returns the number of requests made to path
+func (f *Fake) Requests(path string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.requests[path]
+}
+
+// TraceIDs returns Trace-Id of requests made to f (except x5u), in order
+func (f *Fake) TraceIDs() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string(nil), f.traceIDs...)
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/certs/fake.pem" {
+		w.Write(f.cert)
+		return
+	}
+	traceID := r.Header.Get("Trace-Id")
+	f.Lock()
+	f.requests[r.URL.Path]++
+	f.traceIDs = append(f.traceIDs, traceID)
+	var fail *fakeFailure
+	if l := f.failures[r.URL.Path]; len(l) > 0 {
+		fail, f.failures[r.URL.Path] = &l[0], l[1:]
+	}
+	f.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Trace-Id", traceID)
+	action := map[string]string{"/stir/v1/signing": "signingResponse", "/stir/v1/verification": "verificationResponse"}[r.URL.Path]
+	switch {
+	case fail != nil && len(fail.code) == 0 :
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(fail.status)
+		io.WriteString(w, http.StatusText(fail.status))
+	case fail != nil :
+		fakeError(w, action, fail.status, fail.code)
+	case r.URL.Path == "/v1/ready" && r.Method == "GET" :
+		fakeResponse(w, map[string]interface{}{"ready": true})
+	case r.URL.Path == "/v1/version" && r.Method == "GET" :
+		fakeResponse(w, map[string]interface{}{"Version": "Vesper Server (fake)"})
+	case len(action) > 0 && r.Method == "POST" :
+		var p map[string]interface{}
+		switch err := json.NewDecoder(r.Body).Decode(&p); {
+		case err == io.EOF && action == "signingResponse" :
+			fakeError(w, action, http.StatusBadRequest, "VESPER-4001")
+		case err != nil && action == "signingResponse" :
+			fakeError(w, action, http.StatusBadRequest, "VESPER-4002")
+		case err == io.EOF :
+			fakeError(w, action, http.StatusBadRequest, "VESPER-4100")
+		case err != nil :
+			fakeError(w, action, http.StatusBadRequest, "VESPER-4102")
+		case action == "signingResponse" :
+			f.sign(w, p)
+		default:
+			f.verify(w, r, p)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sign - POST /stir/v1/signing
+func (f *Fake) sign(w http.ResponseWriter, p map[string]interface{}) {
+	// tenants are not configured
+	if _, ok := p["tenant"]; ok {
+		fakeError(w, "signingResponse", http.StatusBadRequest, "VESPER-4027")
+		return
+	}
+	s, _ := stir.NewSigner(stir.SignerOptions{Credentials: fakeCredentials{x5u: f.X5u(), key: f.key}})
+	res, err := s.Sign(stir.SignRequest{Payload: p})
+	if err != nil {
+		e, ok := err.(*stir.Error)
+		if !ok {
+			e = &stir.Error{Code: "VESPER-5054", Status: http.StatusInternalServerError, Err: err}
+		}
+		fakeError(w, "signingResponse", e.Status, e.Code)
+		return
+	}
+	fakeResponse(w, map[string]interface{}{"signingResponse": map[string]interface{}{"identity": res.Identity, "x5u": res.X5u, "claims": res.Claims}})
+}
+
+// verify - POST /stir/v1/verification
+func (f *Fake) verify(w http.ResponseWriter, r *http.Request, p map[string]interface{}) {
+	v, _ := stir.NewVerifier(stir.VerifierOptions{Trust: fakeTrust{f.roots}, Cache: f.cache, ValidIatPeriod: 60, VerifyRootCA: true})
+	res, err := v.Verify(r.Context(), stir.VerifyRequest{Payload: p})
+	if err != nil {
+		e, ok := err.(*stir.Error)
+		if !ok {
+			e = &stir.Error{Code: "VESPER-5055", Status: http.StatusInternalServerError, Err: err}
+		}
+		fakeError(w, "verificationResponse", e.Status, e.Code)
+		return
+	}
+	resp := map[string]interface{}{
+		"dest": p["dest"],
+		"iat": p["iat"],
+		"orig": p["orig"],
+		"jwt": map[string]interface{}{"header": res.Header, "claims": res.Claims},
+	}
+	if res.Ppt == "rph" {
+		resp["rph"] = res.Claims["rph"]
+	}
+	fakeResponse(w, map[string]interface{}{"verificationResponse": resp})
+}
+
+func fakeResponse(w http.ResponseWriter, v interface{}) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+// fakeError writes error response with code, as Vesper does (see serveHttpResponse)
+func fakeError(w http.ResponseWriter, action string, status int, code string) {
+	e := map[string]interface{}{"code": code, "message": errorhandler.ReasonString[code]}
+	if v, ok := errorhandler.Verstat[code]; ok && action == "verificationResponse" {
+		e["verstat"] = v
+	}
+	w.WriteHeader(status)
+	if len(action) == 0 {
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{action: e})
+}
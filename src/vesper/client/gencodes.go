@@ -0,0 +1,52 @@
+// +build ignore
+
+// gencodes generates codes.go - a constant for each VESPER code in errorhandler.ReasonString.
+// Run go generate in vesper/client when codes are added to errorhandler
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"bytes"
+	"strings"
+	"go/format"
+	"io/ioutil"
+	"vesper/errorhandler"
+)
+
+func main() {
+	var codes []string
+	for c := range errorhandler.ReasonString {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gencodes.go from errorhandler.ReasonString; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package client\n\n")
+	fmt.Fprintf(&b, "// VESPER codes (see APIs.md)\n")
+	fmt.Fprintf(&b, "const (\n")
+	for _, c := range codes {
+		fmt.Fprintf(&b, "\t// %v\n", errorhandler.ReasonString[c])
+		fmt.Fprintf(&b, "\t%v Code = %q\n", name(c), c)
+	}
+	fmt.Fprintf(&b, ")\n\n")
+	fmt.Fprintf(&b, "// codes - all VESPER codes\n")
+	fmt.Fprintf(&b, "var codes = []Code{\n")
+	for _, c := range codes {
+		fmt.Fprintf(&b, "\t%v,\n", name(c))
+	}
+	fmt.Fprintf(&b, "}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatalf("%v - generated source", err)
+	}
+	if err := ioutil.WriteFile("codes.go", src, 0644); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// name returns the name of the constant of code c, e.g. Vesper4169 for VESPER-4169
+func name(c string) string {
+	return "Vesper" + strings.TrimPrefix(c, "VESPER-")
+}
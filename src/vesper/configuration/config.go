@@ -1,8 +1,12 @@
 package configuration
 
 import (
-	"os"
-	"encoding/json"
+	"fmt"
+	"sync"
+	"strings"
+	"reflect"
+	"io/ioutil"
+	"path/filepath"
 )
 
 
@@ -15,58 +19,295 @@ type Configuration struct {
 	LogHost																			string		`json:"log_host"`
 	HttpHost																		string		`json:"http_host"`
 	HttpPort																		string		`json:"http_port"`
+	GrpcPort																		string		`json:"grpc_port"`
+	Role																				string		`json:"role"`
 	SslCertFile																	string		`json:"ssl_cert_file"`
 	SslKeyFile																	string		`json:"ssl_key_file"`
 	EksCredentialsFile													string		`json:"eks_credentials_file"`
+	EksAumUrl																		string		`json:"eks_aum_url"`
+	EksAumKey																		string		`json:"eks_aum_key"`
+	EksAumSecret																string		`json:"eks_aum_secret"`
+	EksUrl																			string		`json:"eks_url"`
 	EksCredentialsRefreshInterval								int64			`json:"eks_credentials_refresh_interval"`
 	SticrHostFile																string		`json:"sticr_host_file"`
+	SticrHost																		string		`json:"sticr_host"`
 	SticrFileCheckInterval											int64			`json:"sticr_file_check_interval"`
+	TenantsFile																	string		`json:"tenants_file"`
+	TenantsFileCheckInterval										int64			`json:"tenants_file_check_interval"`
+	AttestationFile															string		`json:"attestation_file"`
+	AttestationFileCheckInterval								int64			`json:"attestation_file_check_interval"`
+	TrustDomainsFile														string		`json:"trust_domains_file"`
+	TrustDomainsFileCheckInterval								int64			`json:"trust_domains_file_check_interval"`
+	DenyListFile																string		`json:"deny_list_file"`
+	DenyListFileCheckInterval										int64			`json:"deny_list_file_check_interval"`
+	AdminApiKey																	string		`json:"admin_api_key"`
+	SticrServerPath															string		`json:"sticr_server_path"`
+	SticrServerMaxAge														int64			`json:"sticr_server_max_age"`
 	
 	RootCertsFetchInterval											int64			`json:"root_certs_fetch_interval"`
+	RootCertsTrustListUrl												string		`json:"root_certs_trust_list_url"`
+	RootCertsTrustListFile											string		`json:"root_certs_trust_list_file"`
+	RootCertsTrustListKeyFile										string		`json:"root_certs_trust_list_key_file"`
+	RootCertsTrustListMaxAge										int64			`json:"root_certs_trust_list_max_age"`
 	SigningCredentialsFetchInterval 						int64			`json:"signing_credentials_fetch_interval"`
+	SigningDelegatesEksPath											string		`json:"signing_delegates_eks_path"`
+	SigningStagedRotation												bool			`json:"signing_staged_rotation"`
+	SigningKeyRetiringPeriod										int64			`json:"signing_key_retiring_period"`
+	SigningSelfCheck														bool			`json:"signing_self_check"`
+	SigningSelfCheckMinLifetime									int64			`json:"signing_self_check_min_lifetime"`
+	SigningSelfCheckWebhookUrl									string		`json:"signing_self_check_webhook_url"`
+	SigningStampIat															bool			`json:"signing_stamp_iat"`
+	SigningMaxIatDrift													int64			`json:"signing_max_iat_drift"`
+	SigningOrigIDUUID														bool			`json:"signing_origid_uuid"`
+	AcmeDirectoryUrl														string		`json:"acme_directory_url"`
+	AcmeContact																	[]string	`json:"acme_contact"`
+	AcmeSpc																			string		`json:"acme_spc"`
+	AcmeSpcTokenFile														string		`json:"acme_spc_token_file"`
+	AcmeStateDir																string		`json:"acme_state_dir"`
+	AcmeCertFilename														string		`json:"acme_cert_filename"`
+	AcmeRenewBefore															int64			`json:"acme_renew_before"`
+	AcmeCheckInterval														int64			`json:"acme_check_interval"`
+	StipaCredentialsFile												string		`json:"stipa_credentials_file"`
+	StipaCrlUrl																	string		`json:"stipa_crl_url"`
+	StipaCrlIssuerFile													string		`json:"stipa_crl_issuer_file"`
+	StipaCrlFetchInterval												int64			`json:"stipa_crl_fetch_interval"`
 	ReplayAttackCacheValidationInterval					int64			`json:"replay_attack_cache_validation_interval"`
 	PublicKeysCacheFlushInterval								int64			`json:"public_keys_cache_flush_interval"`
+	CanaryInterval															int64			`json:"canary_interval"`
+	CanaryOrigTN																string		`json:"canary_orig_tn"`
+	CanaryDestTN																string		`json:"canary_dest_tn"`
 	
+	NormalizeTNs																bool			`json:"normalize_tns"`
 	VerifyRootCA																bool			`json:"verify_root_ca"`
+	VerifyBasePassport													bool			`json:"verify_base_passport"`
+	VerifyAllowedAlgs														[]string	`json:"verify_allowed_algs"`
 	ValidIatPeriod															int64			`json:"valid_iat_period"`
 }
 
-var configurationInstance *Configuration = nil
+var (
+	mtx												sync.RWMutex
+	reloadMtx									sync.Mutex
+	configurationInstance			*Configuration
+	configurationFile					string
+	configurationSettings			[]Setting
+)
+
+// reloadable - config keys whose changes take effect without restarting Vesper (see Reload)
+var reloadable = map[string]bool{
+	"log_host"																	: true,
+	"eks_credentials_refresh_interval"					: true,
+	"sticr_file_check_interval"									: true,
+	"tenants_file_check_interval"								: true,
+	"attestation_file_check_interval"						: true,
+	"trust_domains_file_check_interval"					: true,
+	"deny_list_file_check_interval"							: true,
+	"admin_api_key"															: true,
+	"sticr_server_max_age"											: true,
+	"root_certs_fetch_interval"									: true,
+	"signing_credentials_fetch_interval"				: true,
+	"signing_self_check_webhook_url"						: true,
+	"signing_stamp_iat"													: true,
+	"signing_max_iat_drift"											: true,
+	"signing_origid_uuid"												: true,
+	"acme_check_interval"												: true,
+	"stipa_crl_fetch_interval"									: true,
+	"replay_attack_cache_validation_interval"		: true,
+	"public_keys_cache_flush_interval"					: true,
+	"canary_interval"														: true,
+	"canary_orig_tn"														: true,
+	"canary_dest_tn"														: true,
+	"normalize_tns"															: true,
+	"verify_root_ca"														: true,
+	"verify_base_passport"											: true,
+	"verify_allowed_algs"												: true,
+	"valid_iat_period"													: true,
+}
+
+// secret - config keys whose values are not reported in changes
+var secret = map[string]bool{
+	"eks_aum_key"																: true,
+	"eks_aum_secret"														: true,
+	"admin_api_key"															: true,
+}
+
+// Change - config key whose value is changed by Reload
+type Change struct {
+	Key			string				`json:"key"`
+	Old			interface{}		`json:"old"`
+	New			interface{}		`json:"new"`
+}
 
+// ConfigurationInstance returns the current configuration. The returned configuration is never
+// modified; Reload replaces it with a new one
 func ConfigurationInstance() *Configuration {
+	mtx.RLock()
+	c := configurationInstance
+	mtx.RUnlock()
+	if c != nil {
+		return c
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
 	if configurationInstance == nil {
+		configurationInstance = defaults()
+	}
+	return configurationInstance
+}
 
-		config := &Configuration{
-			LogFile																: "/var/log/vesper/vesper.log",
-			LogFileMaxSize												: 50000000,
-			LogHost																: "",
-			HttpHost															: "",
-			HttpPort															: "",
-			SslCertFile														: "",
-			SslKeyFile														: "",
-			EksCredentialsFile										: "",
-			EksCredentialsRefreshInterval					: 60,
-			SticrHostFile													: "",
-			SticrFileCheckInterval								: 60,
-			
-			RootCertsFetchInterval								: 300,
-			SigningCredentialsFetchInterval				: 300,
-			ReplayAttackCacheValidationInterval		: 70,
-			PublicKeysCacheFlushInterval					: 300,
-			
-			VerifyRootCA													: true,
-			ValidIatPeriod												: 60,
+// defaults returns a configuration with default values
+func defaults() *Configuration {
+	return &Configuration{
+		LogFile																: "/var/log/vesper/vesper.log",
+		LogFileMaxSize												: 50000000,
+		LogHost																: "",
+		HttpHost															: "",
+		HttpPort															: "",
+		GrpcPort															: "",
+		Role																	: "both",
+		SslCertFile														: "",
+		SslKeyFile														: "",
+		EksCredentialsFile										: "",
+		EksAumUrl															: "",
+		EksAumKey															: "",
+		EksAumSecret													: "",
+		EksUrl																: "",
+		EksCredentialsRefreshInterval					: 60,
+		SticrHostFile													: "",
+		SticrHost															: "",
+		SticrFileCheckInterval								: 60,
+		TenantsFile														: "",
+		TenantsFileCheckInterval							: 60,
+		AttestationFile												: "",
+		AttestationFileCheckInterval					: 60,
+		TrustDomainsFile											: "",
+		TrustDomainsFileCheckInterval					: 60,
+		DenyListFile													: "",
+		DenyListFileCheckInterval							: 1,
+		AdminApiKey														: "",
+		SticrServerPath												: "",
+		SticrServerMaxAge											: 3600,
+		
+		RootCertsFetchInterval								: 300,
+		RootCertsTrustListUrl									: "",
+		RootCertsTrustListFile								: "",
+		RootCertsTrustListKeyFile							: "",
+		RootCertsTrustListMaxAge							: 0,
+		SigningCredentialsFetchInterval				: 300,
+		SigningDelegatesEksPath								: "",
+		SigningStagedRotation									: false,
+		SigningKeyRetiringPeriod							: 60,
+		SigningSelfCheck											: true,
+		SigningSelfCheckMinLifetime						: 7,
+		SigningSelfCheckWebhookUrl						: "",
+		SigningStampIat												: false,
+		SigningMaxIatDrift										: 0,
+		SigningOrigIDUUID											: false,
+		AcmeDirectoryUrl											: "",
+		AcmeContact														: []string{},
+		AcmeSpc																: "",
+		AcmeSpcTokenFile											: "",
+		AcmeStateDir													: "",
+		AcmeCertFilename											: "",
+		AcmeRenewBefore												: 30,
+		AcmeCheckInterval											: 60,
+		StipaCredentialsFile									: "",
+		StipaCrlUrl														: "",
+		StipaCrlIssuerFile										: "",
+		StipaCrlFetchInterval									: 3600,
+		ReplayAttackCacheValidationInterval		: 70,
+		PublicKeysCacheFlushInterval					: 300,
+		CanaryInterval												: 300,
+		CanaryOrigTN													: "12155550100",
+		CanaryDestTN													: "12155550199",
+		
+		NormalizeTNs													: false,
+		VerifyRootCA													: true,
+		VerifyBasePassport										: false,
+		VerifyAllowedAlgs											: []string{},
+		ValidIatPeriod												: 60,
+	}
+}
+
+// GetConfiguration reads config file f into c. If the file cannot be read, the error is returned.
+// Otherwise, every problem found (unknown keys, values of the wrong type and values that are not
+// valid, see Validate) is returned in Errors
+func (c *Configuration) GetConfiguration(f string) error {
+	return c.Load(f, nil)
+}
+
+// Load reads the configuration layers into c, each overriding the previous one: config file f
+// (if not empty; YAML if named *.yaml or *.yml, JSON otherwise) and then settings (environment
+// variables and command line flags). The configuration is validated once all layers are read
+func (c *Configuration) Load(f string, settings []Setting) error {
+	configurationFile, configurationSettings = f, settings
+	var errs Errors
+	if len(f) > 0 {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if ext := strings.ToLower(filepath.Ext(f)); ext == ".yaml" || ext == ".yml" {
+			var yerrs Errors
+			if b, yerrs = yamlToJSON(b, c.fields()); len(yerrs) > 0 {
+				return yerrs
+			}
 		}
-		configurationInstance = config
+		errs = c.decode(b)
 	}
-	return configurationInstance
+	errs = append(errs, c.apply(settings)...)
+	// keys not decoded keep their previous values and are validated as such
+	errs = append(errs, c.Validate()...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Reload reads the config file and settings (see Load) again into a new configuration, which replaces
+// the current one if only reloadable keys are changed and validate (if not nil) returns no error.
+// The current configuration is kept otherwise. Changed keys are returned in either case
+func Reload(validate func(*Configuration) error) ([]Change, error) {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+	n := defaults()
+	if err := n.Load(configurationFile, configurationSettings); err != nil {
+		return nil, fmt.Errorf("%v - config file %v", err, configurationFile)
+	}
+	changes := Diff(ConfigurationInstance(), n)
+	var rejected []string
+	for _, c := range changes {
+		if !reloadable[c.Key] {
+			rejected = append(rejected, c.Key)
+		}
+	}
+	if len(rejected) > 0 {
+		return changes, fmt.Errorf("%v cannot be changed without restarting Vesper", strings.Join(rejected, ", "))
+	}
+	if validate != nil {
+		if err := validate(n); err != nil {
+			return changes, err
+		}
+	}
+	mtx.Lock()
+	configurationInstance = n
+	mtx.Unlock()
+	return changes, nil
 }
 
-func (c *Configuration) GetConfiguration(f string) (err error) {
-	file, err := os.Open(f)
-	if err == nil {
-		decoder := json.NewDecoder(file)
-		err = decoder.Decode(c)	
+// Diff returns the keys whose values differ in configurations o and n
+func Diff(o, n *Configuration) []Change {
+	var changes []Change
+	ov, nv := reflect.ValueOf(o).Elem(), reflect.ValueOf(n).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		k := strings.Split(ov.Type().Field(i).Tag.Get("json"), ",")[0]
+		if secret[k] {
+			a, b = "********", "********"
+		}
+		changes = append(changes, Change{Key: k, Old: a, New: b})
 	}
-	return
+	return changes
 }
@@ -0,0 +1,160 @@
+package configuration
+
+import (
+	"testing"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func TestReload(t *testing.T) {
+	d := t.TempDir()
+	f := filepath.Join(d, "config.json")
+	write := func(s string) {
+		if err := ioutil.WriteFile(f, []byte(s), 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	write(`{"role": "verifier", "http_port": "8080", "valid_iat_period": 60, "admin_api_key": "a"}`)
+	if err := ConfigurationInstance().GetConfiguration(f); err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := ConfigurationInstance()
+
+	// reloadable keys
+	write(`{"role": "verifier", "http_port": "8080", "valid_iat_period": 30, "admin_api_key": "b"}`)
+	changes, err := Reload(nil)
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("Reload() = %+v, %v", changes, err)
+	}
+	if ConfigurationInstance() == c || ConfigurationInstance().ValidIatPeriod != 30 || c.ValidIatPeriod != 60 {
+		t.Errorf("configuration not replaced by a new one")
+	}
+	for _, ch := range changes {
+		if ch.Key == "admin_api_key" && ch.New == "b" {
+			t.Errorf("value of secret key reported in changes")
+		}
+	}
+
+	// non-reloadable key
+	write(`{"role": "verifier", "http_port": "9090", "valid_iat_period": 10, "admin_api_key": "b"}`)
+	if _, err := Reload(nil); err == nil || !strings.Contains(err.Error(), "http_port") {
+		t.Errorf("Reload() = %v; want http_port rejected", err)
+	}
+	if ConfigurationInstance().ValidIatPeriod != 30 {
+		t.Errorf("configuration replaced though a change was rejected")
+	}
+}
+
+func TestGetConfiguration(t *testing.T) {
+	d := t.TempDir()
+	f := filepath.Join(d, "config.json")
+	b := `{
+		"role": "verifier",
+		"eks_credentials_file_check_interval": 60,
+		"colour": "blue",
+		"valid_iat_period": "60",
+		"sticr_file_check_interval": 60,
+		"sticr_file_check_interval": 30,
+		"http_port": "80a"
+	}`
+	if err := ioutil.WriteFile(f, []byte(b), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	err := defaults().GetConfiguration(f)
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 5 {
+		t.Fatalf("GetConfiguration() = %v; want 5 problems", err)
+	}
+	for i, s := range []string{"use eks_credentials_refresh_interval", "colour", "valid_iat_period MUST be an integer", "more than once", "http_port"} {
+		if !strings.Contains(errs[i], s) {
+			t.Errorf("problem %v = %q; want %q", i, errs[i], s)
+		}
+	}
+
+	// not valid JSON
+	if err := ioutil.WriteFile(f, []byte(`{"role": "verifier" ; }`), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := defaults().GetConfiguration(f); err == nil {
+		t.Errorf("GetConfiguration() of invalid JSON returned no error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := defaults()
+	c.Role = "signer"
+	c.EksCredentialsFile = "eks.json"
+	c.SticrHostFile = "sticr.json"
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Fatalf("Validate() of defaults = %v", errs)
+	}
+	c.ReplayAttackCacheValidationInterval = 30
+	c.HttpPort = "70000"
+	c.GrpcPort = "70000"
+	c.SslCertFile = "cert.pem"
+	c.VerifyAllowedAlgs = []string{"RS256"}
+	c.RootCertsTrustListUrl = "ftp://pa.example.com/list"
+	c.SticrHostFile = ""
+	errs := c.Validate()
+	for _, s := range []string{"replay_attack_cache_validation_interval 30 MUST NOT be less than valid_iat_period 60", "http_port", "grpc_port \"70000\" is not a port number", "grpc_port \"70000\" MUST NOT be http_port", "ssl_key_file", "RS256", "not an HTTP(S) URL", "root_certs_trust_list_key_file", "sticr_host_file (or sticr_host) is required"} {
+		if !strings.Contains(errs.Error(), s) {
+			t.Errorf("Validate() = %v; want %q", errs, s)
+		}
+	}
+	if len(errs) != 9 {
+		t.Errorf("Validate() = %v problems; want 9", len(errs))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	d := t.TempDir()
+	f := filepath.Join(d, "config.yaml")
+	b := `---
+# verifier
+role: verifier
+http_port: 8080       # string
+valid_iat_period: 30
+verify_allowed_algs: [ES384, "ES512"]
+acme_contact:
+  - "mailto:ops@example.com"
+  - mailto:noc@example.com
+normalize_tns: true
+`
+	if err := ioutil.WriteFile(f, []byte(b), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	env, unknown := EnvSettings([]string{"HOME=/root", "VESPER_CONFIG=/etc/vesper.json", "VESPER_VALID_IAT_PERIOD=45", "VESPER_LOG_HOST=h1"})
+	if len(unknown) != 0 || len(env) != 2 {
+		t.Fatalf("EnvSettings() = %+v, %v", env, unknown)
+	}
+	flags, rest, err := ParseFlags([]string{"-log_host", "h2", "-verify_root_ca=false", "-normalize_tns", "config.yaml"})
+	if err != nil || len(flags) != 3 || len(rest) != 1 {
+		t.Fatalf("ParseFlags() = %+v, %v, %v", flags, rest, err)
+	}
+	c := defaults()
+	if err := c.Load(f, append(env, flags...)); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if c.HttpPort != "8080" || c.ValidIatPeriod != 45 || c.LogHost != "h2" || c.VerifyRootCA || !c.NormalizeTNs || len(c.VerifyAllowedAlgs) != 2 || len(c.AcmeContact) != 2 || c.AcmeContact[1] != "mailto:noc@example.com" {
+		t.Errorf("Load() = %+v", c)
+	}
+
+	// unknown variables (e.g. Kubernetes service links) are returned, not applied
+	env, unknown = EnvSettings([]string{"VESPER_HTTP_PROT=80", "VESPER_SERVICE_HOST=10.0.0.1", "VESPER_PORT_9000_TCP=tcp://10.0.0.1:9000", "VESPER_LOG_HOST=h1"})
+	if len(env) != 1 || len(unknown) != 3 || unknown[0] != "VESPER_HTTP_PROT" || unknown[2] != "VESPER_SERVICE_HOST" {
+		t.Errorf("EnvSettings() = %+v, %v", env, unknown)
+	}
+	err = defaults().Load("", []Setting{{Source: "VESPER_VALID_IAT_PERIOD", Key: "valid_iat_period", Value: "1m"}, {Source: "-role", Key: "role", Value: "verifier"}})
+	if err == nil || !strings.Contains(err.Error(), "VESPER_VALID_IAT_PERIOD - valid_iat_period MUST be an integer") {
+		t.Errorf("Load() = %v", err)
+	}
+
+	// YAML not supported
+	if err := ioutil.WriteFile(f, []byte("role: verifier\neks:\n  url: x\n"), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := defaults().Load(f, nil); err == nil || !strings.Contains(err.Error(), "line 3: nested mappings") {
+		t.Errorf("Load() of nested mapping = %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package configuration
+
+import (
+	"fmt"
+	"sort"
+	"flag"
+	"strconv"
+	"strings"
+	"reflect"
+	"io/ioutil"
+)
+
+// EnvPrefix - prefix of environment variables that set config keys. The environment variable of a
+// config key is the key in upper case with this prefix (e.g. VESPER_HTTP_PORT for http_port)
+const EnvPrefix = "VESPER_"
+
+// EnvConfigFile - environment variable with the config file path, if not on the command line
+const EnvConfigFile = EnvPrefix + "CONFIG"
+
+// Setting - value of a config key set outside the config file, by an environment variable or a
+// command line flag. Lists are comma separated
+type Setting struct {
+	Source		string		// environment variable or command line flag
+	Key				string
+	Value			string
+}
+
+// fields returns the fields of c by config key
+func (c *Configuration) fields() map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fields[strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]] = v.Field(i)
+	}
+	return fields
+}
+
+// Keys returns all config keys (sorted)
+func Keys() []string {
+	var keys []string
+	for k := range defaults().fields() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnvSettings returns the settings in environment variables environ (in "name=value" form, see
+// os.Environ) and the environment variables with EnvPrefix that are not config keys (sorted). These
+// are ignored, since they may be set by the platform, e.g. Kubernetes sets VESPER_SERVICE_HOST,
+// VESPER_PORT, VESPER_PORT_9000_TCP... for a service named vesper
+func EnvSettings(environ []string) ([]Setting, []string) {
+	fields := defaults().fields()
+	var settings []Setting
+	var unknown []string
+	for _, e := range environ {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], EnvPrefix) || kv[0] == EnvConfigFile {
+			continue
+		}
+		k := strings.ToLower(strings.TrimPrefix(kv[0], EnvPrefix))
+		if _, ok := fields[k]; !ok {
+			unknown = append(unknown, kv[0])
+			continue
+		}
+		settings = append(settings, Setting{Source: kv[0], Key: k, Value: kv[1]})
+	}
+	// environment is not ordered
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	sort.Strings(unknown)
+	return settings, unknown
+}
+
+// flagValue - command line flag of a config key
+type flagValue struct {
+	key				string
+	isBool		bool
+	settings	*[]Setting
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+
+func (f *flagValue) Set(v string) error {
+	*f.settings = append(*f.settings, Setting{Source: "-" + f.key, Key: f.key, Value: v})
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// ParseFlags returns the settings in command line flags in args (-<config key> <value>) and the
+// remaining arguments. Value of boolean keys may be omitted (-normalize_tns is -normalize_tns=true)
+func ParseFlags(args []string) ([]Setting, []string, error) {
+	var settings []Setting
+	fs := flag.NewFlagSet("vesper", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	for k, v := range defaults().fields() {
+		fs.Var(&flagValue{key: k, isBool: v.Kind() == reflect.Bool, settings: &settings}, k, "")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, fmt.Errorf("%v - command line", err)
+	}
+	return settings, fs.Args(), nil
+}
+
+// apply sets the config keys in settings in c
+func (c *Configuration) apply(settings []Setting) Errors {
+	fields := c.fields()
+	var errs Errors
+	for _, s := range settings {
+		f, ok := fields[s.Key]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%v - %v is not a config key", s.Source, s.Key))
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Int64 :
+			n, err := strconv.ParseInt(strings.TrimSpace(s.Value), 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%v - %v MUST be %v", s.Source, s.Key, kindOf(f.Type())))
+				continue
+			}
+			f.SetInt(n)
+		case reflect.Bool :
+			b, err := strconv.ParseBool(strings.TrimSpace(s.Value))
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%v - %v MUST be %v", s.Source, s.Key, kindOf(f.Type())))
+				continue
+			}
+			f.SetBool(b)
+		case reflect.Slice :
+			l := []string{}
+			for _, v := range strings.Split(s.Value, ",") {
+				if v = strings.TrimSpace(v); len(v) > 0 {
+					l = append(l, v)
+				}
+			}
+			f.Set(reflect.ValueOf(l))
+		default:
+			f.SetString(s.Value)
+		}
+	}
+	return errs
+}
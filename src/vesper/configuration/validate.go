@@ -0,0 +1,252 @@
+package configuration
+
+import (
+	"fmt"
+	"sort"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"reflect"
+	"net/url"
+	"encoding/json"
+)
+
+// Errors - every problem found in a config file (see GetConfiguration)
+type Errors []string
+
+func (e Errors) Error() string {
+	return strings.Join(e, "\n")
+}
+
+// renamed - config keys that are documented (or were used) under another name
+var renamed = map[string]string{
+	"eks_credentials_file_check_interval"				: "eks_credentials_refresh_interval",
+}
+
+// algorithms supported for verification of base PASSporTs (in addition to ES256)
+var verifyAlgs = map[string]bool{"ES256": true, "ES384": true, "ES512": true}
+
+var regexTN = regexp.MustCompile(`^\+?[0-9]+$`)
+
+// decode decodes JSON object b into c. Unknown or duplicate keys and values of the wrong type
+// are reported (in the order of keys in b); the other keys are decoded
+func (c *Configuration) decode(b []byte) Errors {
+	fields := c.fields()
+	d := json.NewDecoder(bytes.NewReader(b))
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return Errors{"config file MUST be a JSON object"}
+	}
+	var errs Errors
+	seen := make(map[string]bool)
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return append(errs, fmt.Sprintf("%v - config file is not valid JSON", err))
+		}
+		k := t.(string)
+		var raw json.RawMessage
+		if err := d.Decode(&raw); err != nil {
+			return append(errs, fmt.Sprintf("%v - value of %v is not valid JSON", err, k))
+		}
+		f, ok := fields[k]
+		switch {
+		case !ok && len(renamed[k]) > 0 :
+			errs = append(errs, fmt.Sprintf("%v is not a config key - use %v", k, renamed[k]))
+		case !ok :
+			errs = append(errs, fmt.Sprintf("%v is not a config key", k))
+		case seen[k] :
+			errs = append(errs, fmt.Sprintf("%v is present more than once", k))
+		default:
+			if err := json.Unmarshal(raw, f.Addr().Interface()); err != nil {
+				errs = append(errs, fmt.Sprintf("%v MUST be %v", k, kindOf(f.Type())))
+			}
+		}
+		seen[k] = true
+	}
+	if _, err := d.Token(); err != nil {
+		errs = append(errs, fmt.Sprintf("%v - config file is not valid JSON", err))
+	}
+	return errs
+}
+
+// kindOf describes the JSON values that are decoded in type t
+func kindOf(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool :
+		return "true or false"
+	case reflect.Int64 :
+		return "an integer"
+	case reflect.Slice :
+		return "an array of strings"
+	}
+	return "a string"
+}
+
+// Validate returns every value (or combination of values) in c that is not valid
+func (c *Configuration) Validate() Errors {
+	var errs Errors
+	add := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, a...))
+	}
+	if len(strings.TrimSpace(c.LogFile)) == 0 {
+		add("log_file is an empty string")
+	}
+	if c.LogFileMaxSize <= 0 {
+		add("log_file_max_size %v MUST be greater than 0", c.LogFileMaxSize)
+	}
+	if len(c.HttpPort) > 0 {
+		if p, err := strconv.Atoi(c.HttpPort); err != nil || p < 1 || p > 65535 {
+			add("http_port %q is not a port number", c.HttpPort)
+		}
+	}
+	if len(c.GrpcPort) > 0 {
+		if p, err := strconv.Atoi(c.GrpcPort); err != nil || p < 1 || p > 65535 {
+			add("grpc_port %q is not a port number", c.GrpcPort)
+		}
+		if c.GrpcPort == c.HttpPort {
+			add("grpc_port %q MUST NOT be http_port", c.GrpcPort)
+		}
+	}
+	switch c.Role {
+	case "signer", "verifier", "both":
+	default:
+		add("role %q is not one of signer, verifier or both", c.Role)
+	}
+	if (len(strings.TrimSpace(c.SslCertFile)) > 0) != (len(strings.TrimSpace(c.SslKeyFile)) > 0) {
+		add("ssl_cert_file and ssl_key_file MUST be configured together")
+	}
+
+	// periods
+	positive := map[string]int64{
+		"eks_credentials_refresh_interval": c.EksCredentialsRefreshInterval,
+		"sticr_file_check_interval": c.SticrFileCheckInterval,
+		"tenants_file_check_interval": c.TenantsFileCheckInterval,
+		"attestation_file_check_interval": c.AttestationFileCheckInterval,
+		"trust_domains_file_check_interval": c.TrustDomainsFileCheckInterval,
+		"deny_list_file_check_interval": c.DenyListFileCheckInterval,
+		"root_certs_fetch_interval": c.RootCertsFetchInterval,
+		"signing_credentials_fetch_interval": c.SigningCredentialsFetchInterval,
+		"acme_check_interval": c.AcmeCheckInterval,
+		"acme_renew_before": c.AcmeRenewBefore,
+		"stipa_crl_fetch_interval": c.StipaCrlFetchInterval,
+		"replay_attack_cache_validation_interval": c.ReplayAttackCacheValidationInterval,
+		"public_keys_cache_flush_interval": c.PublicKeysCacheFlushInterval,
+		"valid_iat_period": c.ValidIatPeriod,
+	}
+	nonNegative := map[string]int64{
+		"sticr_server_max_age": c.SticrServerMaxAge,
+		"root_certs_trust_list_max_age": c.RootCertsTrustListMaxAge,
+		"signing_key_retiring_period": c.SigningKeyRetiringPeriod,
+		"signing_self_check_min_lifetime": c.SigningSelfCheckMinLifetime,
+		"signing_max_iat_drift": c.SigningMaxIatDrift,
+		"canary_interval": c.CanaryInterval,
+	}
+	for _, k := range sortedKeys(positive) {
+		if positive[k] <= 0 {
+			add("%v %v MUST be greater than 0", k, positive[k])
+		}
+	}
+	for _, k := range sortedKeys(nonNegative) {
+		if nonNegative[k] < 0 {
+			add("%v %v MUST NOT be negative", k, nonNegative[k])
+		}
+	}
+	// identity headers are cached (to detect replay) until they are too old to be verified
+	if c.ReplayAttackCacheValidationInterval < c.ValidIatPeriod {
+		add("replay_attack_cache_validation_interval %v MUST NOT be less than valid_iat_period %v", c.ReplayAttackCacheValidationInterval, c.ValidIatPeriod)
+	}
+
+	// URLs
+	for _, u := range [][2]string{
+		{"root_certs_trust_list_url", c.RootCertsTrustListUrl},
+		{"signing_self_check_webhook_url", c.SigningSelfCheckWebhookUrl},
+		{"acme_directory_url", c.AcmeDirectoryUrl},
+		{"stipa_crl_url", c.StipaCrlUrl},
+	} {
+		if len(u[1]) == 0 {
+			continue
+		}
+		if p, err := url.Parse(u[1]); err != nil || (p.Scheme != "http" && p.Scheme != "https") || len(p.Host) == 0 {
+			add("%v %q is not an HTTP(S) URL", u[0], u[1])
+		}
+	}
+
+	// eks and sticr settings are read from their files or set inline
+	inlineEks := 0
+	for _, v := range []string{c.EksAumUrl, c.EksAumKey, c.EksAumSecret, c.EksUrl} {
+		if len(strings.TrimSpace(v)) > 0 {
+			inlineEks++
+		}
+	}
+	switch {
+	case inlineEks > 0 && inlineEks < 4 :
+		add("eks_aum_url, eks_aum_key, eks_aum_secret and eks_url MUST be configured together")
+	case inlineEks > 0 && len(strings.TrimSpace(c.EksCredentialsFile)) > 0 :
+		add("eks_credentials_file and eks_aum_url (with eks_aum_key, eks_aum_secret and eks_url) MUST NOT be configured together")
+	}
+	if len(strings.TrimSpace(c.SticrHostFile)) > 0 && len(strings.TrimSpace(c.SticrHost)) > 0 {
+		add("sticr_host_file and sticr_host MUST NOT be configured together")
+	}
+
+	// signer
+	if c.Role != "verifier" {
+		if len(strings.TrimSpace(c.EksCredentialsFile)) == 0 && inlineEks == 0 {
+			add("eks_credentials_file (or eks_aum_url, eks_aum_key, eks_aum_secret and eks_url) is required for role %v", c.Role)
+		}
+		if len(strings.TrimSpace(c.SticrHostFile)) == 0 && len(strings.TrimSpace(c.SticrHost)) == 0 {
+			add("sticr_host_file (or sticr_host) is required for role %v", c.Role)
+		}
+	}
+	if len(c.AcmeDirectoryUrl) > 0 {
+		if len(strings.TrimSpace(c.AcmeSpc)) == 0 {
+			add("acme_spc is required with acme_directory_url")
+		}
+		if len(strings.TrimSpace(c.AcmeSpcTokenFile)) == 0 && len(strings.TrimSpace(c.StipaCredentialsFile)) == 0 {
+			add("acme_spc_token_file or stipa_credentials_file is required with acme_directory_url")
+		}
+		if len(strings.TrimSpace(c.AcmeStateDir)) == 0 {
+			add("acme_state_dir is required with acme_directory_url")
+		}
+		if len(strings.TrimSpace(c.AcmeCertFilename)) == 0 || strings.Contains(c.AcmeCertFilename, "/") {
+			add("acme_cert_filename %q is not a file name", c.AcmeCertFilename)
+		}
+	}
+	if len(c.StipaCrlUrl) > 0 && len(strings.TrimSpace(c.StipaCredentialsFile)) == 0 {
+		add("stipa_credentials_file is required with stipa_crl_url")
+	}
+	if (len(c.StipaCrlUrl) > 0) != (len(strings.TrimSpace(c.StipaCrlIssuerFile)) > 0) {
+		add("stipa_crl_issuer_file MUST be configured with (and only with) stipa_crl_url")
+	}
+	if c.CanaryInterval > 0 && c.Role == "both" {
+		if !regexTN.MatchString(c.CanaryOrigTN) {
+			add("canary_orig_tn %q is not a telephone number", c.CanaryOrigTN)
+		}
+		if !regexTN.MatchString(c.CanaryDestTN) {
+			add("canary_dest_tn %q is not a telephone number", c.CanaryDestTN)
+		}
+	}
+
+	// verifier
+	if len(c.RootCertsTrustListUrl) > 0 && len(c.RootCertsTrustListFile) > 0 {
+		add("root_certs_trust_list_url and root_certs_trust_list_file MUST NOT be configured together")
+	}
+	if (len(c.RootCertsTrustListUrl) > 0 || len(c.RootCertsTrustListFile) > 0) != (len(strings.TrimSpace(c.RootCertsTrustListKeyFile)) > 0) {
+		add("root_certs_trust_list_key_file MUST be configured with (and only with) root_certs_trust_list_url or root_certs_trust_list_file")
+	}
+	for _, a := range c.VerifyAllowedAlgs {
+		if !verifyAlgs[a] {
+			add("alg %q in verify_allowed_algs is not supported", a)
+		}
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
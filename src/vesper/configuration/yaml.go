@@ -0,0 +1,168 @@
+package configuration
+
+import (
+	"fmt"
+	"bytes"
+	"strconv"
+	"strings"
+	"reflect"
+	"encoding/json"
+)
+
+// yamlToJSON converts YAML config file b into a JSON object with the same keys, in the same order.
+// Only the subset of YAML needed for config files is supported: a mapping of config keys to
+// scalars or to lists of scalars (flow "[a, b]" or block "- a"), comments and document markers.
+// Unquoted scalars are typed as the field of the config key in fields; quoted scalars are strings
+func yamlToJSON(b []byte, fields map[string]reflect.Value) ([]byte, Errors) {
+	var buf bytes.Buffer
+	var errs Errors
+	n := 0
+	// key whose value is a block list (or empty), if any
+	var listKey string
+	var list []string
+	write := func(k, v string) {
+		if n > 0 {
+			buf.WriteString(",")
+		}
+		kb, _ := json.Marshal(k)
+		buf.Write(kb)
+		buf.WriteString(":")
+		buf.WriteString(v)
+		n++
+	}
+	flush := func() {
+		switch {
+		case len(listKey) == 0 :
+		case list == nil :
+			write(listKey, "null")
+		default:
+			write(listKey, "[" + strings.Join(list, ",") + "]")
+		}
+		listKey, list = "", nil
+	}
+	buf.WriteString("{")
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(stripComment(line), " \t\r")
+		content := strings.TrimSpace(line)
+		if len(content) == 0 || content == "---" {
+			continue
+		}
+		if content == "..." {
+			break
+		}
+		indent := line[:len(line) - len(strings.TrimLeft(line, " \t"))]
+		if strings.Contains(indent, "\t") {
+			errs = append(errs, fmt.Sprintf("line %v: tabs are not allowed in indentation", i + 1))
+			continue
+		}
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			if len(listKey) == 0 {
+				errs = append(errs, fmt.Sprintf("line %v: list item is not the value of a config key", i + 1))
+				continue
+			}
+			list = append(list, yamlScalar(strings.TrimSpace(content[1:]), reflect.String))
+			continue
+		}
+		if len(indent) > 0 {
+			errs = append(errs, fmt.Sprintf("line %v: nested mappings are not supported", i + 1))
+			continue
+		}
+		flush()
+		kv := strings.SplitN(content, ":", 2)
+		if len(kv) != 2 || (len(kv[1]) > 0 && kv[1][0] != ' ') {
+			errs = append(errs, fmt.Sprintf("line %v: \"key: value\" expected", i + 1))
+			continue
+		}
+		k := strings.Trim(strings.TrimSpace(kv[0]), `"'`)
+		v := strings.TrimSpace(kv[1])
+		kind := reflect.String
+		if f, ok := fields[k]; ok {
+			kind = f.Kind()
+		}
+		switch {
+		case len(v) == 0 :
+			listKey = k
+		case v[0] == '[' :
+			if v[len(v) - 1] != ']' {
+				errs = append(errs, fmt.Sprintf("line %v: flow list of %v is not closed", i + 1, k))
+				continue
+			}
+			var items []string
+			for _, item := range splitFlow(v[1:len(v) - 1]) {
+				items = append(items, yamlScalar(item, reflect.String))
+			}
+			write(k, "[" + strings.Join(items, ",") + "]")
+		case v[0] == '{' || v[0] == '&' || v[0] == '*' || v[0] == '|' || v[0] == '>' :
+			errs = append(errs, fmt.Sprintf("line %v: value of %v is not a scalar or a list", i + 1, k))
+		default:
+			write(k, yamlScalar(v, kind))
+		}
+	}
+	flush()
+	buf.WriteString("}")
+	return buf.Bytes(), errs
+}
+
+// yamlScalar returns scalar s as a JSON value. Unquoted s is a number or boolean only if kind is
+func yamlScalar(s string, kind reflect.Kind) string {
+	var v interface{} = s
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s) - 1] == '"' :
+		if u, err := strconv.Unquote(s); err == nil {
+			v = u
+		}
+	case len(s) >= 2 && s[0] == '\'' && s[len(s) - 1] == '\'' :
+		v = strings.Replace(s[1:len(s) - 1], "''", "'", -1)
+	case s == "~" || s == "null" :
+		return "null"
+	case kind == reflect.Int64 :
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return s
+		}
+	case kind == reflect.Bool && (s == "true" || s == "false") :
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// stripComment removes the comment (if any) at the end of line
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 :
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' :
+			quote = c
+		case c == '#' && (i == 0 || line[i - 1] == ' ' || line[i - 1] == '\t') :
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitFlow splits the items of a flow list at commas outside quotes
+func splitFlow(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || (quote == 0 && s[i] == ',') {
+			if item := strings.TrimSpace(s[start:i]); len(item) > 0 {
+				items = append(items, item)
+			}
+			start = i + 1
+			continue
+		}
+		switch {
+		case quote != 0 && s[i] == quote :
+			quote = 0
+		case quote == 0 && (s[i] == '"' || s[i] == '\'') :
+			quote = s[i]
+		}
+	}
+	return items
+}
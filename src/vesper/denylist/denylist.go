@@ -0,0 +1,320 @@
+// Package denylist blocks PASSporTs signed by service providers, certificates or
+// certificate repositories (x5u hosts) that are denied by the operator
+package denylist
+
+import (
+	"fmt"
+	"sync"
+	"os"
+	"path"
+	"strings"
+	"net"
+	"net/url"
+	"io/ioutil"
+	"math/big"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"vesper/tnauthlist"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// globals
+var (
+	denyListFileModifiedTime		int64
+	denyListFileName						string
+)
+
+// Entries - deny list entries, as in deny list file
+type Entries struct {
+	SPCs						[]string		`json:"spcs"`						// SPCs in TNAuthList of certificate
+	Serials					[]string		`json:"serials"`				// certificate serial numbers (hex)
+	Fingerprints		[]string		`json:"fingerprints"`		// SHA-256 fingerprints (hex) of certificates
+	Issuers					[]string		`json:"issuers"`				// certificate issuers (distinguished name, e.g. "CN=Example STI-CA,O=Example,C=US")
+	X5uHosts				[]string		`json:"x5uHosts"`				// x5u hosts or host patterns (e.g. "*.sticr.example.com")
+}
+
+// DenyList - deny list
+type DenyList struct {
+	sync.RWMutex	// A field declared with a type but no explicit field name is an
+					// anonymous field, also called an embedded field or an embedding of
+					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	entries			Entries
+}
+
+// Initialize object
+// Saves file modified time for future use
+func InitObject(l kitlog.Logger, f string) (*DenyList, error) {
+	glogger = l
+	if len(strings.TrimSpace(f)) == 0 {
+		return nil, fmt.Errorf("deny list file name is an empty string")
+	}
+	denyListFileName = f
+	e, err := readDenyListFile(denyListFileName)
+	if err != nil {
+		return nil, err
+	}
+	return &DenyList{entries: *e}, nil
+}
+
+// update deny list if deny list file has changed
+func (dl *DenyList) UpdateDenyList() error {
+	dl.Lock()
+	defer dl.Unlock()
+	e, err := readDenyListFile(denyListFileName)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		// no changes in deny list file
+		return nil
+	}
+	dl.entries = *e
+	logInfo("type", "denyList", "module", "UpdateDenyList", "message", "deny list updated", "entries", *e)
+	return nil
+}
+
+// using Rlock() allows multiple goroutines to read at the "same" time
+func (dl *DenyList) Entries() Entries {
+	dl.RLock()
+	defer dl.RUnlock()
+	return dl.entries
+}
+
+// Add adds entries in e to deny list and saves deny list in deny list file
+func (dl *DenyList) Add(e Entries) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	e = e.normalized()
+	dl.Lock()
+	defer dl.Unlock()
+	u := Entries{
+		SPCs: union(dl.entries.SPCs, e.SPCs),
+		Serials: union(dl.entries.Serials, e.Serials),
+		Fingerprints: union(dl.entries.Fingerprints, e.Fingerprints),
+		Issuers: union(dl.entries.Issuers, e.Issuers),
+		X5uHosts: union(dl.entries.X5uHosts, e.X5uHosts),
+	}
+	if err := writeDenyListFile(denyListFileName, u); err != nil {
+		return err
+	}
+	dl.entries = u
+	logInfo("type", "denyList", "module", "Add", "message", "deny list entries added", "added", e)
+	return nil
+}
+
+// Remove removes entries in e from deny list and saves deny list in deny list file
+func (dl *DenyList) Remove(e Entries) error {
+	e = e.normalized()
+	dl.Lock()
+	defer dl.Unlock()
+	u := Entries{
+		SPCs: difference(dl.entries.SPCs, e.SPCs),
+		Serials: difference(dl.entries.Serials, e.Serials),
+		Fingerprints: difference(dl.entries.Fingerprints, e.Fingerprints),
+		Issuers: difference(dl.entries.Issuers, e.Issuers),
+		X5uHosts: difference(dl.entries.X5uHosts, e.X5uHosts),
+	}
+	if err := writeDenyListFile(denyListFileName, u); err != nil {
+		return err
+	}
+	dl.entries = u
+	logInfo("type", "denyList", "module", "Remove", "message", "deny list entries removed", "removed", e)
+	return nil
+}
+
+// Match returns the reason (and true) if certificate c or x5u it was fetched from is denied
+func (dl *DenyList) Match(c *x509.Certificate, x5u string) (string, bool) {
+	dl.RLock()
+	defer dl.RUnlock()
+	if h := x5uHost(x5u); len(h) > 0 {
+		for _, p := range dl.entries.X5uHosts {
+			if ok, _ := path.Match(strings.ToLower(p), h); ok {
+				return fmt.Sprintf("x5u host %v is denied", h), true
+			}
+		}
+	}
+	if c == nil {
+		return "", false
+	}
+	if len(dl.entries.Serials) > 0 {
+		s := serial(c.SerialNumber)
+		for _, v := range dl.entries.Serials {
+			if normalizeHex(v) == s {
+				return fmt.Sprintf("certificate serial %v is denied", s), true
+			}
+		}
+	}
+	if len(dl.entries.Fingerprints) > 0 {
+		f := fmt.Sprintf("%x", sha256.Sum256(c.Raw))
+		for _, v := range dl.entries.Fingerprints {
+			if normalizeHex(v) == f {
+				return fmt.Sprintf("certificate fingerprint %v is denied", f), true
+			}
+		}
+	}
+	for _, v := range dl.entries.Issuers {
+		if strings.EqualFold(v, c.Issuer.String()) {
+			return fmt.Sprintf("certificate issuer %v is denied", c.Issuer.String()), true
+		}
+	}
+	if len(dl.entries.SPCs) > 0 {
+		// certificates without (or with malformed) TNAuthList do not match any SPC
+		l, _ := tnauthlist.FromCertificate(c)
+		for _, s := range l.SPCs() {
+			for _, v := range dl.entries.SPCs {
+				if strings.EqualFold(v, s) {
+					return fmt.Sprintf("SPC %v is denied", s), true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Validate returns an error if any entry is empty, serial or fingerprint is not hex or x5u host pattern is malformed
+func (e Entries) Validate() error {
+	for _, l := range [][]string{e.SPCs, e.Serials, e.Fingerprints, e.Issuers, e.X5uHosts} {
+		for _, v := range l {
+			if len(strings.TrimSpace(v)) == 0 {
+				return fmt.Errorf("deny list entry is an empty string")
+			}
+		}
+	}
+	for _, v := range append(append([]string{}, e.Serials...), e.Fingerprints...) {
+		if _, ok := new(big.Int).SetString(normalizeHex(v), 16); !ok {
+			return fmt.Errorf("deny list entry %v is not a hex string", v)
+		}
+	}
+	for _, p := range e.X5uHosts {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("%v - \"x5uHosts\" pattern %v", err, p)
+		}
+	}
+	return nil
+}
+
+// returns entries with serials and fingerprints in the form they are matched in
+func (e Entries) normalized() Entries {
+	n := e
+	n.Serials = nil
+	for _, v := range e.Serials {
+		n.Serials = append(n.Serials, normalizeHex(v))
+	}
+	n.Fingerprints = nil
+	for _, v := range e.Fingerprints {
+		n.Fingerprints = append(n.Fingerprints, normalizeHex(v))
+	}
+	return n
+}
+
+// returns serial number in lower case hex
+func serial(n *big.Int) string {
+	return fmt.Sprintf("%x", n)
+}
+
+// lower case hex without ":" separators and leading zeros (fingerprints keep leading zeros)
+func normalizeHex(v string) string {
+	v = strings.ToLower(strings.Replace(strings.TrimSpace(v), ":", "", -1))
+	if len(v) == 2*sha256.Size {
+		return v
+	}
+	if n := strings.TrimLeft(v, "0"); len(n) > 0 {
+		return n
+	}
+	return "0"
+}
+
+// returns host (lower case, without port) in x5u
+func x5uHost(x5u string) string {
+	u, err := url.Parse(x5u)
+	if err != nil {
+		return ""
+	}
+	h := u.Host
+	if hh, _, err := net.SplitHostPort(h); err == nil {
+		h = hh
+	}
+	return strings.ToLower(h)
+}
+
+// returns values in a followed by values in b that are not in a
+func union(a, b []string) []string {
+	u := append([]string{}, a...)
+	for _, v := range b {
+		if !contains(u, v) {
+			u = append(u, v)
+		}
+	}
+	return u
+}
+
+// returns values in a that are not in b
+func difference(a, b []string) []string {
+	d := []string{}
+	for _, v := range a {
+		if !contains(b, v) {
+			d = append(d, v)
+		}
+	}
+	return d
+}
+
+func contains(l []string, v string) bool {
+	for _, s := range l {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// write deny list file and save file modified time, so that the change is not read again
+func writeDenyListFile(n string, e Entries) error {
+	b, err := json.MarshalIndent(e, "", "\t")
+	if err != nil {
+		return fmt.Errorf("%v - encode deny list", err)
+	}
+	t := n + ".tmp"
+	if err := ioutil.WriteFile(t, b, 0644); err != nil {
+		return fmt.Errorf("%v - write deny list file", err)
+	}
+	if err := os.Rename(t, n); err != nil {
+		return fmt.Errorf("%v - write deny list file", err)
+	}
+	if fi, err := os.Stat(n); err == nil {
+		denyListFileModifiedTime = fi.ModTime().UnixNano()
+	}
+	return nil
+}
+
+// Read deny list file only if file modified time has changed
+// returns nil (and no error) if the file has not been modified since last lookup
+func readDenyListFile(n string) (*Entries, error) {
+	f, err := os.Open(n)
+	if err != nil {
+		return nil, fmt.Errorf("%v - deny list file", err)
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err == nil {
+		m := fi.ModTime().UnixNano()
+		if denyListFileModifiedTime == m {
+			return nil, nil
+		}
+		// save the latest modified time
+		denyListFileModifiedTime = m
+	}
+	var e Entries
+	decoder := json.NewDecoder(f)
+	err = decoder.Decode(&e)
+	if err != nil {
+		return nil, fmt.Errorf("%v - decode JSON object in deny list file", err)
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	e = e.normalized()
+	return &e, nil
+}
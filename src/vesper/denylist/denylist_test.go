@@ -0,0 +1,80 @@
+package denylist
+
+import (
+	"testing"
+	"time"
+	"fmt"
+	"math/big"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"vesper/tnauthlist"
+)
+
+// certificate with TNAuthList containing SPC spc
+func spcCert(t *testing.T, serial int64, spc string) *x509.Certificate {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	v, _ := asn1.MarshalWithParams(spc, "ia5")
+	der, _ := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: v}})
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject: pkix.Name{CommonName: "SHAKEN " + spc},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: tnauthlist.OID, Value: der}},
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return c
+}
+
+func TestMatch(t *testing.T) {
+	c := spcCert(t, 0x1a2b, "1234")
+	fp := fmt.Sprintf("%X", sha256.Sum256(c.Raw))
+	tests := []struct {
+		e				Entries
+		x5u			string
+		denied	bool
+	}{
+		{Entries{}, "https://cr.example.com/1.pem", false},
+		{Entries{SPCs: []string{"1234"}}, "https://cr.example.com/1.pem", true},
+		{Entries{SPCs: []string{"5678"}}, "https://cr.example.com/1.pem", false},
+		{Entries{Serials: []string{"00:1A:2B"}}, "https://cr.example.com/1.pem", true},
+		{Entries{Serials: []string{"1a2c"}}, "https://cr.example.com/1.pem", false},
+		{Entries{Fingerprints: []string{fp}}, "https://cr.example.com/1.pem", true},
+		{Entries{Issuers: []string{"cn=shaken 1234"}}, "https://cr.example.com/1.pem", true},
+		{Entries{X5uHosts: []string{"*.example.com"}}, "https://CR.example.com:8443/1.pem", true},
+		{Entries{X5uHosts: []string{"*.example.com"}}, "https://cr.example.net/1.pem", false},
+	}
+	for i, tc := range tests {
+		dl := &DenyList{entries: tc.e.normalized()}
+		if reason, denied := dl.Match(c, tc.x5u); denied != tc.denied {
+			t.Errorf("test %v: Match() = %q, %v; want denied %v", i, reason, denied, tc.denied)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Entries{Serials: []string{"0x12"}}).Validate(); err == nil {
+		t.Errorf("Validate accepted serial that is not hex")
+	}
+	if err := (Entries{X5uHosts: []string{"["}}).Validate(); err == nil {
+		t.Errorf("Validate accepted malformed x5u host pattern")
+	}
+	if err := (Entries{SPCs: []string{" "}}).Validate(); err == nil {
+		t.Errorf("Validate accepted empty SPC")
+	}
+}
@@ -0,0 +1,53 @@
+// Package e164 canonicalizes telephone numbers as per RFC 8224 (section 8.3)
+// and validates them as E.164 numbers
+package e164
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxDigits - maximum number of digits in E.164 number
+const MaxDigits = 15
+
+// visual separators (RFC 3966) that are removed during canonicalization
+const separators = "-.() \t"
+
+// Normalize returns canonical form of telephone number tn: leading "+" and
+// visual separators removed. An error is returned if the canonical form does not
+// consist of 1 to 15 digits
+func Normalize(tn string) (string, error) {
+	s := strings.TrimSpace(tn)
+	s = strings.TrimPrefix(s, "+")
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(separators, r) {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) == 0 {
+		return "", fmt.Errorf("telephone number %q has no digits", tn)
+	}
+	if len(s) > MaxDigits {
+		return "", fmt.Errorf("telephone number %q has more than %v digits", tn, MaxDigits)
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("telephone number %q contains invalid character %q", tn, c)
+		}
+	}
+	return s, nil
+}
+
+// NormalizeAll normalizes each telephone number in tns
+func NormalizeAll(tns []string) ([]string, error) {
+	n := make([]string, 0, len(tns))
+	for _, tn := range tns {
+		s, err := Normalize(tn)
+		if err != nil {
+			return nil, err
+		}
+		n = append(n, s)
+	}
+	return n, nil
+}
@@ -0,0 +1,30 @@
+package e164
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		tn			string
+		want		string
+		valid		bool
+	}{
+		{"12155551212", "12155551212", true},
+		{"+12155551212", "12155551212", true},
+		{"+1 (215) 555-1212", "12155551212", true},
+		{"1.215.555.1212", "12155551212", true},
+		{" +44 20 7946 0958 ", "442079460958", true},
+		{"", "", false},
+		{"+", "", false},
+		{"1215555121a", "", false},
+		{"++12155551212", "", false},
+		{"1234567890123456", "", false},
+	}
+	for _, tc := range tests {
+		got, err := Normalize(tc.tn)
+		if (err == nil) != tc.valid || got != tc.want {
+			t.Errorf("Normalize(%q) = %q, %v; want %q, valid %v", tc.tn, got, err, tc.want, tc.valid)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package eks
 
 import (
+	"io"
 	"fmt"
 	"sync"
 	"os"
@@ -80,6 +81,25 @@ func InitObject(f string) (*EksCredentials, error) {
 	return creds, nil
 }
 
+// Create object for eks credentials that are not read from the eks credentials file
+// (for example, set in main config or environment variables)
+func NewObject(aumUrl, key, secret, eksUrl string) (*EksCredentials, error) {
+	if len(strings.TrimSpace(aumUrl)) == 0 || len(strings.TrimSpace(key)) == 0 || len(strings.TrimSpace(secret)) == 0 || len(strings.TrimSpace(eksUrl)) == 0 {
+		return nil, fmt.Errorf("Invalid value(s) detected in eks credentials")
+	}
+	credentialsFileName = ""
+	_, jwt, err := irisjwt.GetServerJwt(aumUrl, key, secret)
+	if err != nil {
+		return nil, fmt.Errorf("%v - aumUrl: %v, aumKey: %v", err, aumUrl, key)
+	}
+	tm, err := irisjwt.JwtExpiryTime(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("%v- %v", err, jwt)
+	}
+	creds = &EksCredentials{aumUrl: aumUrl, aumKey: key, aumSecret: secret, eksUrl: eksUrl, eksJwtExpiryTime: tm, eksJwt: jwt}
+	return creds, nil
+}
+
 // refresh server JWT
 func (k *EksCredentials) RefreshEksCredentials() error {
 	k.RLock()
@@ -101,6 +121,10 @@ func (k *EksCredentials) RefreshEksCredentials() error {
 
 // update eks cfredentials
 func (k *EksCredentials) UpdateEksCredentials() error {
+	if len(credentialsFileName) == 0 {
+		// credentials not read from file; refresh server JWT only
+		return k.RefreshEksCredentials()
+	}
 	aumUrl, key, secret, eksUrl, err := readEksCredentialsFile(credentialsFileName)
 	if err != nil {
 		return fmt.Errorf("%v", err)
@@ -140,9 +164,25 @@ func readEksCredentialsFile(n string) (string, string, string, string, error) {
 	}
 	// We are here because the timestamp for eks credentials  file has changed
 	// This may mean that updates to credentials is available
+	return parseEksCredentials(f)
+}
+
+// CheckFile returns an error if eks credentials file n is not valid. Credentials are not used
+func CheckFile(n string) error {
+	f, err := os.Open(n)
+	if err != nil {
+		return fmt.Errorf("%v - eks credentials file", err)
+	}
+	defer f.Close()
+	_, _, _, _, err = parseEksCredentials(f)
+	return err
+}
+
+// Decode and validate eks credentials
+func parseEksCredentials(r io.Reader) (string, string, string, string, error) {
 	var c map[string]interface{}
-	decoder := json.NewDecoder(f)
-	err = decoder.Decode(&c)
+	decoder := json.NewDecoder(r)
+	err := decoder.Decode(&c)
 	if err != nil {
 		return "", "", "", "", fmt.Errorf("%v - decode JSON object in eks credentials file", err)
 	}
@@ -30,18 +30,44 @@ var ReasonString = map[string]string {
 	"VESPER-4011" : "origid field in request payload MUST be a string",
 	"VESPER-4012" : "orig in request payload is an empty object",
 	"VESPER-4013" : "orig in request payload should contain only one field",
-	"VESPER-4014" : "orig in request payload does not contain field \"tn\"",
+	"VESPER-4014" : "orig in request payload does not contain field \"tn\" or \"uri\"",
 	"VESPER-4015" : "orig tn in request payload is not of type string",
 	"VESPER-4016" : "orig tn in request payload is an empty string",
 	"VESPER-4017" : "orig field in request payload MUST be a JSON object",
 	"VESPER-4018" : "dest in request payload is an empty object",
-	"VESPER-4019" : "dest in request payload should contain only one field",
-	"VESPER-4020" : "dest in request payload does not contain field \"tn\"",
+	"VESPER-4019" : "dest in request payload should contain only \"tn\" and/or \"uri\" fields",
+	"VESPER-4020" : "dest in request payload contains field other than \"tn\" and \"uri\"",
 	"VESPER-4021" : "dest tn in request payload is an empty array",
 	"VESPER-4022" : "one or more dest tns in request payload is not a string",
 	"VESPER-4023" : "one or more dest tns in request payload is an empty string",
 	"VESPER-4024" : "dest tn in request payload is not an array",
 	"VESPER-4025" : "dest field in request payload MUST be a JSON object",
+	"VESPER-4026" : "tenant field in request payload MUST be a string",
+	"VESPER-4027" : "tenant is not configured",
+	"VESPER-4028" : "API key is missing or is not valid for tenant",
+	"VESPER-4029" : "attest level is not permitted by tenant policy",
+	"VESPER-4030" : "caller field in request payload MUST be a JSON object",
+	"VESPER-4031" : "caller in request payload MUST contain customer and/or trunk strings only",
+	"VESPER-4032" : "attest field MUST NOT be present in request payload when caller field is present",
+	"VESPER-4033" : "customer in caller does not match customer of trunk",
+	"VESPER-4034" : "caller field in request payload is not supported",
+	"VESPER-4035" : "iat value in request payload drifts too far from current time",
+	"VESPER-4036" : "origid field in request payload is not a RFC 4122 UUID",
+	"VESPER-4037" : "orig tn in request payload is not a valid telephone number",
+	"VESPER-4038" : "one or more dest tns in request payload is not a valid telephone number",
+	"VESPER-4039" : "orig uri in request payload is not of type string",
+	"VESPER-4040" : "orig uri in request payload is an empty string",
+	"VESPER-4041" : "orig uri in request payload is not a valid URI",
+	"VESPER-4042" : "dest uri in request payload is not an array",
+	"VESPER-4043" : "dest uri in request payload is an empty array",
+	"VESPER-4044" : "one or more dest uris in request payload is not a string",
+	"VESPER-4045" : "one or more dest uris in request payload is an empty string",
+	"VESPER-4046" : "one or more dest uris in request payload is not a valid URI",
+	"VESPER-4047" : "rph field in request payload MUST be a JSON object containing only \"auth\" field",
+	"VESPER-4048" : "rph auth in request payload MUST be a non-empty array",
+	"VESPER-4049" : "one or more rph auth values in request payload is not a resource priority value (namespace.priority)",
+	"VESPER-4050" : "ppt field in request payload is not supported",
+	"VESPER-4051" : "rph PASSporT signing is not permitted for caller",
 	"VESPER-4100" : "empty request body",
 	"VESPER-4102" : "Unable to parse request body",
 	"VESPER-4103" : "one or more of the require fields missing in request payload",
@@ -52,7 +78,7 @@ var ReasonString = map[string]string {
 	"VESPER-4108" : "attest field in request payload MUST be a string",
 	"VESPER-4109" : "orig in request payload is an empty object",
 	"VESPER-4110" : "orig in request payload should contain only one field",
-	"VESPER-4111" : "orig in request payload does not contain field \"tn\"",
+	"VESPER-4111" : "orig in request payload does not contain field \"tn\" or \"uri\"",
 	"VESPER-4112" : "orig tn in request payload is an empty array",
 	"VESPER-4113" : "orig tn array contains more than one element in request payload",
 	"VESPER-4114" : "one or more orig tns in request payload is not a string",
@@ -60,8 +86,8 @@ var ReasonString = map[string]string {
 	"VESPER-4116" : "orig tn in request payload is not an array",
 	"VESPER-4117" : "orig field in request payload MUST be a JSON object",
 	"VESPER-4118" : "dest in request payload is an empty object",
-	"VESPER-4119" : "dest in request payload should contain only one field",
-	"VESPER-4120" : "dest in request payload does not contain field \"tn\"",
+	"VESPER-4119" : "dest in request payload should contain only \"tn\" and/or \"uri\" fields",
+	"VESPER-4120" : "dest in request payload contains field other than \"tn\" and \"uri\"",
 	"VESPER-4121" : "dest tn in request payload is an empty array",
 	"VESPER-4122" : "one or more dest tns in request payload is not a string",
 	"VESPER-4123" : "one or more dest tns in request payload is an empty string",
@@ -77,7 +103,7 @@ var ReasonString = map[string]string {
 	"VESPER-4133" : "one or more of the required fields missing in JWT header",
 	"VESPER-4134" : "alg field value in JWT header is not \"ES256\"",
 	"VESPER-4135" : "alg field value in JWT header is not a string",
-	"VESPER-4136" : "ppt field value in JWT header is not \"shaken\"",
+	"VESPER-4136" : "ppt field value in JWT header is not \"shaken\" or \"rph\"",
 	"VESPER-4137" : "ppt field value in JWT header is not a string",
 	"VESPER-4138" : "typ field value in JWT header is not \"passport\"",
 	"VESPER-4139" : "typ field value in JWT header is not a string",
@@ -102,6 +128,52 @@ var ReasonString = map[string]string {
 	"VESPER-4167" : "iat value indicates stale date",
 	"VESPER-4168" : "unable to validate replay attack",
 	"VESPER-4169" : "JWT claims repeated; possible replay attack",
+	"VESPER-4170" : "orig tn in request payload is not a valid telephone number",
+	"VESPER-4171" : "one or more dest tns in request payload is not a valid telephone number",
+	"VESPER-4172" : "orig tn in JWT claims is not a valid telephone number",
+	"VESPER-4173" : "one or more dest tns in JWT claims is not a valid telephone number",
+	"VESPER-4174" : "orig uri in request payload is not an array",
+	"VESPER-4175" : "orig uri in request payload is an empty array",
+	"VESPER-4176" : "orig uri array contains more than one element in request payload",
+	"VESPER-4177" : "orig uri in request payload is not a string",
+	"VESPER-4178" : "orig uri in request payload is an empty string",
+	"VESPER-4179" : "orig uri in request payload is not a valid URI",
+	"VESPER-4180" : "dest uri in request payload is not an array",
+	"VESPER-4181" : "dest uri in request payload is an empty array",
+	"VESPER-4182" : "one or more dest uris in request payload is not a string",
+	"VESPER-4183" : "one or more dest uris in request payload is an empty string",
+	"VESPER-4184" : "one or more dest uris in request payload is not a valid URI",
+	"VESPER-4185" : "orig URI in request payload does not match orig URI in JWT claims",
+	"VESPER-4186" : "dest URIs in request payload does not match dest URIs in JWT claims",
+	"VESPER-4187" : "ppt parameter in identity field does not match ppt in JWT header",
+	"VESPER-4188" : "alg field value in JWT header is not an allowed algorithm",
+	"VESPER-4189" : "public key in certificate does not match alg in JWT header",
+	"VESPER-4190" : "certificate, service provider or x5u host is on deny list",
+	"VESPER-4191" : "certificate is revoked (STI-PA CRL)",
+	"VESPER-4201" : "admin API key in X-Admin-Api-Key header is missing or invalid",
+	"VESPER-4202" : "admin API is not enabled",
+	"VESPER-4203" : "deny list is not configured",
+	"VESPER-4204" : "empty request body",
+	"VESPER-4205" : "Unable to parse request body",
+	"VESPER-4206" : "one or more deny list entries in request payload is invalid",
+	"VESPER-4207" : "tenant in request is not found",
+	"VESPER-4208" : "there is no pending signing key to activate",
+	"VESPER-4209" : "there is no retiring signing key to roll back to",
+	"VESPER-4210" : "configuration is not reloaded",
+	"VESPER-5050" : "error in converting header to byte array",
+	"VESPER-5051" : "error in converting claims to byte array",
+	"VESPER-5052" : "error in signing request",
+	"VESPER-5053" : "signing or verification is not configured",
+	"VESPER-5054" : "unexpected error in signing request",
+	"VESPER-5055" : "unexpected error in verifying request",
+	"VESPER-5201" : "error in saving deny list",
+}
+
+// Verstat - verification status (3GPP TS 24.229 "verstat" tel URI parameter) for verification
+// errors that have a definite outcome
+var Verstat = map[string]string {
+	"VESPER-4190" : "TN-Validation-Failed",
+	"VESPER-4191" : "TN-Validation-Failed",
 }
 
 // method that encodes error object into json
@@ -0,0 +1,382 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"io"
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+	"strconv"
+	"net/http"
+	"encoding/binary"
+	"github.com/satori/go.uuid"
+	"vesper/configuration"
+	"vesper/errorhandler"
+	"vesper/stats"
+	"vesper/stir"
+	"vesper/tenants"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// gRPC service vesper.stir.v1.Stir (see grpc.proto and APIs.md), served on grpc_port. Messages are
+// protobuf (content type application/grpc or application/grpc+proto) or JSON (content-subtype
+// "json" - the request and response bodies of the HTTP API). Every message is signed or verified
+// as POST /stir/v1/signing or POST /stir/v1/verification (see signPayload and verifyPayload), so
+// validation, tenants, stats and VESPER codes are the same as over HTTP
+const (
+	grpcServicePath					= "/vesper.stir.v1.Stir/"
+	grpcMaxMessageSize			= 4 << 20			// default of gRPC
+	grpcStreamConcurrency		= 64					// messages of a stream processed at the same time
+)
+
+// grpcCodecs - codecs of the messages of the gRPC service by content type
+var grpcCodecs = map[string]grpcCodec{
+	"application/grpc"					: protoCodec{},
+	"application/grpc+proto"		: protoCodec{},
+	"application/grpc+json"			: jsonCodec{},
+}
+
+// gRPC status codes (see https://github.com/grpc/grpc/blob/master/doc/statuscodes.md)
+const (
+	grpcOK									= 0
+	grpcUnknown							= 2
+	grpcInvalidArgument			= 3
+	grpcNotFound						= 5
+	grpcPermissionDenied		= 7
+	grpcResourceExhausted		= 8
+	grpcUnimplemented				= 12
+	grpcInternal						= 13
+	grpcUnavailable					= 14
+	grpcUnauthenticated			= 16
+)
+
+// grpcMethod - RPC of the gRPC service: Sign, Verify (verify is true), SignStream or VerifyStream
+// (stream is true)
+type grpcMethod struct {
+	name			string
+	verify		bool
+	stream		bool
+}
+
+// action returns the name of the response body of the HTTP API of m
+func (m grpcMethod) action() string {
+	if m.verify {
+		return "verificationResponse"
+	}
+	return "signingResponse"
+}
+
+// grpcResult - request payload of a message and its signing (or verification) result or error
+type grpcResult struct {
+	payload		map[string]interface{}
+	sign			*stir.SignResult
+	verify		*stir.VerifyResult
+	err				*stir.Error
+}
+
+// grpcCodec - encoding of the messages of the gRPC service (see grpcproto.go and grpcjson.go)
+type grpcCodec interface {
+	// request returns the request payload in request message b of m
+	request(m grpcMethod, b []byte) (map[string]interface{}, *stir.Error)
+	// response returns the response message of m with result r (that is not an error)
+	response(m grpcMethod, r grpcResult) []byte
+	// streamRequest returns the id, the trace ID and the request payload in stream request message b
+	// of m. The id and trace ID are returned even if the payload cannot be decoded, if possible
+	streamRequest(m grpcMethod, b []byte) (string, string, map[string]interface{}, *stir.Error)
+	// streamResponse returns the stream response message of m with the id and trace ID of its request
+	// and result r
+	streamResponse(m grpcMethod, id, traceID string, r grpcResult) []byte
+}
+
+// newGrpcServer returns the gRPC server on port p: HTTP/2 over TLS if ssl_cert_file and
+// ssl_key_file are configured, HTTP/2 without TLS (h2c) on http_host otherwise
+func newGrpcServer(p string, tls bool) *http.Server {
+	var protocols http.Protocols
+	addr := ":" + p
+	if tls {
+		protocols.SetHTTP2(true)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+		httpHost := "127.0.0.1"
+		if len(strings.TrimSpace(configuration.ConfigurationInstance().HttpHost)) > 0 {
+			httpHost = configuration.ConfigurationInstance().HttpHost
+		}
+		addr = httpHost + addr
+	}
+	return &http.Server{Addr: addr, Handler: http.HandlerFunc(grpcRequest), Protocols: &protocols}
+}
+
+// grpcMethods returns the RPCs served in the role of Vesper
+func grpcMethods() map[string]grpcMethod {
+	m := make(map[string]grpcMethod)
+	if signerRole() {
+		m["Sign"] = grpcMethod{name: "Sign"}
+		m["SignStream"] = grpcMethod{name: "SignStream", stream: true}
+	}
+	if verifierRole() {
+		m["Verify"] = grpcMethod{name: "Verify", verify: true}
+		m["VerifyStream"] = grpcMethod{name: "VerifyStream", verify: true, stream: true}
+	}
+	return m
+}
+
+// -
+func grpcRequest(response http.ResponseWriter, request *http.Request) {
+	switch {
+	case request.ProtoMajor != 2 :
+		http.Error(response, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	case request.Method != "POST" :
+		http.Error(response, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	case !strings.HasPrefix(request.Header.Get("Content-Type"), "application/grpc") :
+		http.Error(response, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	traceID := request.Header.Get("Trace-Id")
+	if traceID == "" {
+		traceID = "VESPER-" + uuid.NewV1().String()
+	}
+	response.Header().Set("Trace-Id", traceID)
+	ct := request.Header.Get("Content-Type")
+	codec, ok := grpcCodecs[ct]
+	if !ok {
+		response.Header().Set("Content-Type", "application/grpc")
+		grpcTrailers(response, grpcUnimplemented, fmt.Sprintf("content type %v is not supported - messages are protobuf or JSON (application/grpc+json)", ct), "", "")
+		return
+	}
+	response.Header().Set("Content-Type", ct)
+	m, ok := grpcMethods()[strings.TrimPrefix(request.URL.Path, grpcServicePath)]
+	if !ok || !strings.HasPrefix(request.URL.Path, grpcServicePath) {
+		grpcTrailers(response, grpcUnimplemented, fmt.Sprintf("unknown method %v", request.URL.Path), "", "")
+		return
+	}
+	if m.stream {
+		grpcStream(response, request, m, codec, traceID)
+		return
+	}
+	// unary RPC - request payload is the only message (no message is an empty request message)
+	b, status, err := readGrpcMessage(request.Body)
+	if err != nil && err != io.EOF {
+		grpcTrailers(response, status, err.Error(), "", "")
+		return
+	}
+	p, e := codec.request(m, b)
+	r := grpcProcess(m, request, traceID, p, e)
+	if r.err != nil {
+		var verstat string
+		if m.verify {
+			verstat = errorhandler.Verstat[r.err.Code]
+		}
+		message := r.err.Code + " - " + errorhandler.ReasonString[r.err.Code]
+		grpcTrailers(response, grpcStatus(r.err.Status), message, r.err.Code, verstat)
+		return
+	}
+	response.WriteHeader(http.StatusOK)
+	response.Write(grpcFrame(codec.response(m, r)))
+	grpcTrailers(response, grpcOK, "", "", "")
+}
+
+// grpcStream serves a SignStream or VerifyStream RPC: request messages are read until the client
+// closes the stream and processed concurrently; each response carries the id of its request.
+// Messages without trace ID use the trace ID of the stream
+func grpcStream(response http.ResponseWriter, request *http.Request, m grpcMethod, codec grpcCodec, traceID string) {
+	var (
+		mtx		sync.Mutex
+		wg		sync.WaitGroup
+	)
+	sem := make(chan struct{}, grpcStreamConcurrency)
+	flusher, _ := response.(http.Flusher)
+	response.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	send := func(b []byte) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		response.Write(grpcFrame(b))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	status, message := grpcOK, ""
+	for {
+		b, s, err := readGrpcMessage(request.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			status, message = s, err.Error()
+			break
+		}
+		id, tid, p, e := codec.streamRequest(m, b)
+		if len(tid) == 0 {
+			tid = traceID
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<- sem
+				wg.Done()
+			}()
+			send(codec.streamResponse(m, id, tid, grpcProcess(m, request, tid, p, e)))
+		}()
+	}
+	wg.Wait()
+	grpcTrailers(response, status, message, "", "")
+}
+
+// grpcProcess signs or verifies request payload p of a message of m with the metadata of gRPC
+// request (x-api-key, x-vesper-tenant) - e is the error decoding the message, if any. The message
+// is counted and logged as a request of the HTTP API. A panic fails the message only (net/http
+// does not recover panics of the goroutines of stream messages)
+func grpcProcess(m grpcMethod, request *http.Request, traceID string, p map[string]interface{}, e *stir.Error) (r grpcResult) {
+	start := time.Now()
+	lg := kitlog.With(glogger, "clientIP", getClientIP(request), "module", "grpcRequest", "method", m.name)
+	defer func() {
+		if v := recover(); v != nil {
+			code := "VESPER-5054"
+			if m.verify {
+				code = "VESPER-5055"
+			}
+			logCritical("type", "grpcRequest", "method", m.name, "traceID", traceID, "errorCode", code, "message", fmt.Sprintf("%v - request message failed", v))
+			r = grpcResult{payload: p, err: &stir.Error{Code: code, Status: http.StatusInternalServerError, Err: fmt.Errorf("%v", v)}}
+		}
+	}()
+	r = grpcResult{payload: p, err: e}
+	if m.verify {
+		stats.IncrVerificationRequestCount()
+		if r.err == nil {
+			r.verify, r.err = verifyPayload(start, request, p)
+		}
+	} else {
+		stats.IncrSigningRequestCount()
+		if r.err == nil {
+			var tenant *tenants.Tenant
+			r.sign, tenant, r.err = signPayload(start, request, p)
+			if tenant != nil {
+				lg = kitlog.With(lg, "tenant", tenant.ID)
+			}
+		}
+	}
+	t := int64(time.Since(start).Seconds()*1000)
+	stats.UpdateApiProcessingTime(t)
+	if r.err != nil {
+		lg = kitlog.With(lg, "type", "requestPayload", "code", "error", "requestPayload", p, "error", r.err.Err, "errorCode", r.err.Code, "httpResponseCode", r.err.Status)
+	} else {
+		lg = kitlog.With(lg, "type", "requestResponseTime", "code", "info", "httpResponseCode", http.StatusOK)
+	}
+	kitlog.With(lg, "traceID", traceID, "apiProcessingTimeInMilliSeconds", t).Log()
+	return r
+}
+
+// grpcResponseBody returns the response body of the HTTP API of m with result r
+func grpcResponseBody(m grpcMethod, r grpcResult) map[string]interface{} {
+	switch {
+	case r.err != nil :
+		return errorResponse(m.action(), r.err.Code)
+	case m.verify :
+		return verificationResponse(r.payload, r.verify)
+	}
+	return signingResponse(r.sign)
+}
+
+// grpcDecodeError returns the error of a request message of m that cannot be decoded: the error of
+// an empty (or not parsable) request body of the HTTP API
+func grpcDecodeError(m grpcMethod, err error, empty bool) *stir.Error {
+	code := "VESPER-4002"
+	switch {
+	case m.verify && empty :
+		code = "VESPER-4100"
+	case m.verify :
+		code = "VESPER-4102"
+	case empty :
+		code = "VESPER-4001"
+	}
+	return &stir.Error{Code: code, Status: http.StatusBadRequest, Err: err}
+}
+
+// grpcStatus returns the gRPC status code of HTTP status code c
+func grpcStatus(c int) int {
+	switch c {
+	case http.StatusOK :
+		return grpcOK
+	case http.StatusBadRequest :
+		return grpcInvalidArgument
+	case http.StatusUnauthorized :
+		return grpcUnauthenticated
+	case http.StatusForbidden :
+		return grpcPermissionDenied
+	case http.StatusNotFound :
+		return grpcNotFound
+	case http.StatusInternalServerError :
+		return grpcInternal
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout :
+		return grpcUnavailable
+	}
+	return grpcUnknown
+}
+
+// readGrpcMessage reads a length-prefixed message. io.EOF is returned if the stream ended
+// before the message; otherwise the gRPC status code of the error is returned with it
+func readGrpcMessage(r io.Reader) ([]byte, int, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, grpcOK, io.EOF
+		}
+		return nil, grpcInternal, fmt.Errorf("%v - message header", err)
+	}
+	if hdr[0] != 0 {
+		return nil, grpcUnimplemented, fmt.Errorf("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > grpcMaxMessageSize {
+		return nil, grpcResourceExhausted, fmt.Errorf("message of %v bytes is larger than %v bytes", n, grpcMaxMessageSize)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, grpcInternal, fmt.Errorf("%v - message", err)
+	}
+	return b, grpcOK, nil
+}
+
+// grpcFrame returns message b, length-prefixed (not compressed)
+func grpcFrame(b []byte) []byte {
+	f := make([]byte, 5 + len(b))
+	binary.BigEndian.PutUint32(f[1:5], uint32(len(b)))
+	copy(f[5:], b)
+	return f
+}
+
+// grpcTrailers ends the response with gRPC status and message and, for errors of the API, the
+// VESPER code and verstat in vesper-code and vesper-verstat trailers
+func grpcTrailers(w http.ResponseWriter, status int, message, code, verstat string) {
+	w.Header().Set(http.TrailerPrefix + "Grpc-Status", strconv.Itoa(status))
+	if len(message) > 0 {
+		w.Header().Set(http.TrailerPrefix + "Grpc-Message", grpcPercentEncode(message))
+	}
+	if len(code) > 0 {
+		w.Header().Set(http.TrailerPrefix + "Vesper-Code", code)
+	}
+	if len(verstat) > 0 {
+		w.Header().Set(http.TrailerPrefix + "Vesper-Verstat", verstat)
+	}
+}
+
+// grpcPercentEncode encodes grpc-message value s (bytes other than printable ASCII and '%')
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
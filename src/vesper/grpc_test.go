@@ -0,0 +1,394 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"io"
+	"os"
+	"net"
+	"time"
+	"bytes"
+	"testing"
+	"strconv"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"sync/atomic"
+	"path/filepath"
+	"encoding/json"
+	"encoding/binary"
+	"vesper/configuration"
+	"vesper/denylist"
+	"vesper/publickeys"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// grpcTestServer configures Vesper (role both) with signing credentials whose certificate is served
+// by a local STI-CR (after *delay nanoseconds), and starts its gRPC service over HTTP/2 without TLS
+// (see newGrpcServer). The URL of the service, an HTTP/2 client and the x5u of the credentials are
+// returned; stop stops both servers
+func grpcTestServer(t *testing.T, delay *int64) (string, *http.Client, string, func()) {
+	glogger = kitlog.NewNopLogger()
+	d, err := ioutil.TempDir("", "vesper")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var cert []byte
+	crl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cr := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(atomic.LoadInt64(delay)))
+		w.Write(cert)
+	})}
+	go cr.Serve(crl)
+	f := filepath.Join(d, "config.json")
+	c := `{"role": "both", "http_port": "8080", "valid_iat_period": 60, "verify_root_ca": false, "sticr_host": "http://` + crl.Addr().String() + `",
+		"eks_aum_url": "https://aum.example.com", "eks_aum_key": "vesper", "eks_aum_secret": "secret", "eks_url": "https://eks.example.com"}`
+	if err := ioutil.WriteFile(f, []byte(c), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// keys not in the config file keep their values - the configuration is restored by stop
+	saved := *configuration.ConfigurationInstance()
+	if err := configuration.ConfigurationInstance().GetConfiguration(f); err != nil {
+		t.Fatalf("%v", err)
+	}
+	cert = newTestCredentials(t, "http://" + crl.Addr().String(), "sp.pem")
+	if err := ioutil.WriteFile(filepath.Join(d, "deny_list.json"), []byte("{}"), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if denyList, err = denylist.InitObject(glogger, filepath.Join(d, "deny_list.json")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	publickeys.FlushCache()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	srv := newGrpcServer("0", false)
+	go srv.Serve(l)
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	return "http://" + l.Addr().String(), client, "http://" + crl.Addr().String() + "/sp.pem", func() {
+		srv.Close()
+		cr.Close()
+		denyList = nil
+		signingCredentials = nil
+		publickeys.FlushCache()
+		*configuration.ConfigurationInstance() = saved
+		os.RemoveAll(d)
+	}
+}
+
+// grpcTestCall calls method with content type ct and request body (messages) b. The response
+// (with its trailers) and the response messages are returned
+func grpcTestCall(t *testing.T, c *http.Client, url, method, ct string, b []byte) (*http.Response, [][]byte) {
+	req, err := http.NewRequest("POST", url + grpcServicePath + method, bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	req.Header.Set("Content-Type", ct)
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("Trace-Id", "trace-1")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("got %v, want HTTP/2", resp.Proto)
+	}
+	var msgs [][]byte
+	for {
+		m, _, err := readGrpcMessage(resp.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		msgs = append(msgs, m)
+	}
+	return resp, msgs
+}
+
+func TestGrpcUnary(t *testing.T) {
+	var delay int64
+	url, c, x5u, stop := grpcTestServer(t, &delay)
+	defer stop()
+	iat := float64(time.Now().Unix())
+	sign := map[string]interface{}{
+		"attest": "A",
+		"dest": map[string]interface{}{"tn": []interface{}{"12155551213"}},
+		"iat": iat,
+		"orig": map[string]interface{}{"tn": "12155551212"},
+		"origid": "123e4567-e89b-12d3-a456-426655440000",
+	}
+
+	// protobuf
+	resp, msgs := grpcTestCall(t, c, url, "Sign", "application/grpc", grpcFrame(protoEncode(sign, protoSigningRequest)))
+	if resp.Trailer.Get("Grpc-Status") != "0" || len(msgs) != 1 {
+		t.Fatalf("Sign - got status %v (%v) and %v messages", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"), len(msgs))
+	}
+	if resp.Header.Get("Content-Type") != "application/grpc" || resp.Header.Get("Trace-Id") != "trace-1" {
+		t.Errorf("Sign - got Content-Type %v and Trace-Id %v", resp.Header.Get("Content-Type"), resp.Header.Get("Trace-Id"))
+	}
+	s, err := protoDecode(msgs[0], protoSigningResponse)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	claims, _ := s["claims"].(map[string]interface{})
+	if len(s["identity"].(string)) == 0 || s["x5u"] != x5u || claims["attest"] != "A" || claims["iat"] != iat {
+		t.Fatalf("Sign - got %v", s)
+	}
+	verify := map[string]interface{}{
+		"dest": map[string]interface{}{"tn": []interface{}{"12155551213"}},
+		"iat": iat,
+		"orig": map[string]interface{}{"tn": []interface{}{"12155551212"}},
+		"identity": s["identity"],
+	}
+	resp, msgs = grpcTestCall(t, c, url, "Verify", "application/grpc+proto", grpcFrame(protoEncode(verify, protoVerificationRequest)))
+	if resp.Trailer.Get("Grpc-Status") != "0" || len(msgs) != 1 {
+		t.Fatalf("Verify - got status %v (%v) and %v messages", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"), len(msgs))
+	}
+	v, err := protoDecode(msgs[0], protoVerificationResponse)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	jwt, _ := v["jwt"].(map[string]interface{})
+	header, _ := jwt["header"].(map[string]interface{})
+	if header["x5u"] != x5u || header["ppt"] != "shaken" || v["iat"] != iat {
+		t.Errorf("Verify - got %v", v)
+	}
+
+	// JSON - response body of the HTTP API
+	b, _ := json.Marshal(sign)
+	resp, msgs = grpcTestCall(t, c, url, "Sign", "application/grpc+json", grpcFrame(b))
+	var sr map[string]map[string]interface{}
+	if resp.Trailer.Get("Grpc-Status") != "0" || len(msgs) != 1 || json.Unmarshal(msgs[0], &sr) != nil || sr["signingResponse"]["x5u"] != x5u {
+		t.Errorf("Sign (JSON) - got status %v (%v) and %q", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"), msgs)
+	}
+
+	// VESPER errors end the call with their code (and verstat) in trailers
+	if err := denyList.Add(denylist.Entries{Serials: []string{"1"}}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	tests := []struct {
+		method, ct							string
+		msg											[]byte
+		status									int
+		code, verstat, message	string
+	}{
+		{"Verify", "application/grpc", protoEncode(verify, protoVerificationRequest), grpcPermissionDenied, "VESPER-4190", "TN-Validation-Failed", "VESPER-4190 - certificate, service provider or x5u host is on deny list"},
+		{"Sign", "application/grpc", protoEncode(map[string]interface{}{"attest": "A"}, protoSigningRequest), grpcInvalidArgument, "VESPER-4003", "", "VESPER-4003 - one or more of the require fields missing in request payload"},
+		{"Sign", "application/grpc+json", []byte("{"), grpcInvalidArgument, "VESPER-4002", "", "VESPER-4002 - Unable to parse request body"},
+		{"Verify", "application/grpc", []byte{0x0a, 0x05}, grpcInvalidArgument, "VESPER-4102", "", "VESPER-4102"},
+	}
+	for i, test := range tests {
+		resp, msgs := grpcTestCall(t, c, url, test.method, test.ct, grpcFrame(test.msg))
+		if len(msgs) != 0 || resp.Trailer.Get("Grpc-Status") != strconv.Itoa(test.status) || resp.Trailer.Get("Vesper-Code") != test.code || resp.Trailer.Get("Vesper-Verstat") != test.verstat {
+			t.Errorf("%v: got %v messages, status %v, code %v and verstat %v; want status %v, code %v and verstat %q", i, len(msgs), resp.Trailer.Get("Grpc-Status"),
+				resp.Trailer.Get("Vesper-Code"), resp.Trailer.Get("Vesper-Verstat"), test.status, test.code, test.verstat)
+		}
+		if m := resp.Trailer.Get("Grpc-Message"); len(m) < len(test.message) || m[:len(test.message)] != test.message {
+			t.Errorf("%v: got grpc-message %q, want %q", i, m, test.message)
+		}
+	}
+}
+
+func TestGrpcFrames(t *testing.T) {
+	var delay int64
+	url, c, _, stop := grpcTestServer(t, &delay)
+	defer stop()
+	compressed := grpcFrame([]byte("{}"))
+	compressed[0] = 1
+	oversized := make([]byte, 5)
+	binary.BigEndian.PutUint32(oversized[1:], grpcMaxMessageSize + 1)
+	tests := []struct {
+		method			string
+		b						[]byte
+		status			int
+	}{
+		{"Sign", compressed, grpcUnimplemented},
+		{"Sign", oversized, grpcResourceExhausted},
+		{"VerifyStream", append(grpcFrame(nil), compressed...), grpcUnimplemented},
+		{"VerifyStream", oversized, grpcResourceExhausted},
+		// truncated message
+		{"Sign", grpcFrame([]byte("{}"))[:6], grpcInternal},
+	}
+	for i, test := range tests {
+		resp, msgs := grpcTestCall(t, c, url, test.method, "application/grpc", test.b)
+		if resp.Trailer.Get("Grpc-Status") != strconv.Itoa(test.status) {
+			t.Errorf("%v: got status %v (%v), want %v", i, resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"), test.status)
+		}
+		// messages before the invalid frame are answered
+		if test.method == "VerifyStream" && test.status == grpcUnimplemented && len(msgs) != 1 {
+			t.Errorf("%v: got %v messages, want 1", i, len(msgs))
+		}
+	}
+	// content types other than protobuf and JSON
+	resp, _ := grpcTestCall(t, c, url, "Sign", "application/grpc+cbor", grpcFrame(nil))
+	if resp.Trailer.Get("Grpc-Status") != strconv.Itoa(grpcUnimplemented) {
+		t.Errorf("got status %v, want %v", resp.Trailer.Get("Grpc-Status"), grpcUnimplemented)
+	}
+}
+
+func TestGrpcStream(t *testing.T) {
+	var delay int64
+	url, c, _, stop := grpcTestServer(t, &delay)
+	defer stop()
+	iat := float64(time.Now().Unix())
+	_, msgs := grpcTestCall(t, c, url, "Sign", "application/grpc", grpcFrame(protoEncode(map[string]interface{}{
+		"attest": "A",
+		"dest": map[string]interface{}{"tn": []interface{}{"12155551213"}},
+		"iat": iat,
+		"orig": map[string]interface{}{"tn": "12155551212"},
+		"origid": "123e4567-e89b-12d3-a456-426655440000",
+	}, protoSigningRequest)))
+	if len(msgs) != 1 {
+		t.Fatalf("Sign - got %v messages", len(msgs))
+	}
+	s, _ := protoDecode(msgs[0], protoSigningResponse)
+
+	// the certificate of the first message is fetched (slowly) - the others are answered first
+	atomic.StoreInt64(&delay, int64(300*time.Millisecond))
+	var b []byte
+	for _, r := range []map[string]interface{}{
+		{"id": "1", "traceId": "call-1", "request": map[string]interface{}{
+			"dest": map[string]interface{}{"tn": []interface{}{"12155551213"}},
+			"iat": iat,
+			"orig": map[string]interface{}{"tn": []interface{}{"12155551212"}},
+			"identity": s["identity"],
+		}},
+		{"id": "2", "request": map[string]interface{}{"iat": iat}},
+		{"id": "3", "traceId": "call-3"},
+	} {
+		b = append(b, grpcFrame(protoEncode(r, protoVerifyStreamRequest))...)
+	}
+	resp, msgs := grpcTestCall(t, c, url, "VerifyStream", "application/grpc", b)
+	if resp.Trailer.Get("Grpc-Status") != "0" || len(msgs) != 3 {
+		t.Fatalf("got status %v (%v) and %v messages, want 0 and 3", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"), len(msgs))
+	}
+	tests := map[string]struct {
+		traceID, code		string
+		status					float64
+	}{
+		"1": {"call-1", "", grpcOK},
+		"2": {"trace-1", "VESPER-4103", grpcInvalidArgument},
+		"3": {"call-3", "VESPER-4103", grpcInvalidArgument},
+	}
+	for i, m := range msgs {
+		r, err := protoDecode(m, protoVerifyStreamResponse)
+		if err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+		id, _ := r["id"].(string)
+		test, ok := tests[id]
+		if !ok {
+			t.Errorf("%v: got response to id %q", i, id)
+			continue
+		}
+		delete(tests, id)
+		status, _ := r["status"].(float64)
+		e, _ := r["error"].(map[string]interface{})
+		if r["traceId"] != test.traceID || status != test.status || (len(test.code) > 0 && e["code"] != test.code) || (test.status == grpcOK && r["response"] == nil) {
+			t.Errorf("%v: got %v", id, r)
+		}
+		// responses are sent as requests are processed
+		if (id == "1") != (i == len(msgs) - 1) {
+			t.Errorf("%v: response to id %v is message %v", i, id, i)
+		}
+	}
+}
+
+func TestProtoDecode(t *testing.T) {
+	tests := []struct {
+		b						[]byte
+		want				string
+		err					bool
+	}{
+		// iat 1, orig.tn "12", dest.tn "3" and "4", unknown field 9 (fixed32)
+		{[]byte{0x10, 0x01, 0x1a, 0x04, 0x0a, 0x02, '1', '2', 0x0a, 0x06, 0x0a, 0x01, '3', 0x0a, 0x01, '4', 0x4d, 0, 0, 0, 0}, `{"dest":{"tn":["3","4"]},"iat":1,"orig":{"tn":["12"]}}`, false},
+		{[]byte{}, `{}`, false},
+		// identity as varint
+		{[]byte{0x20, 0x01}, "", true},
+		// truncated identity
+		{[]byte{0x22, 0x05, 'a'}, "", true},
+		// identity not UTF-8
+		{[]byte{0x22, 0x01, 0xff}, "", true},
+		// field number 0
+		{[]byte{0x02, 0x00}, "", true},
+		// group (wire type 3)
+		{[]byte{0x23}, "", true},
+	}
+	for i, test := range tests {
+		m, err := protoDecode(test.b, protoVerificationRequest)
+		if (err != nil) != test.err {
+			t.Errorf("%v: got error %v", i, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if b, _ := json.Marshal(m); string(b) != test.want {
+			t.Errorf("%v: got %s, want %v", i, b, test.want)
+		}
+		// encoded in order of field numbers, without unknown fields
+		if e, _ := protoDecode(protoEncode(m, protoVerificationRequest), protoVerificationRequest); !bytes.Equal(mustMarshal(e), mustMarshal(m)) {
+			t.Errorf("%v: got %v after encoding, want %v", i, e, m)
+		}
+	}
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// a message failing (even unexpectedly) fails that message only - the stream goes on
+func TestGrpcStreamNull(t *testing.T) {
+	var delay int64
+	url, c, x5u, stop := grpcTestServer(t, &delay)
+	defer stop()
+	sign := map[string]interface{}{
+		"attest": "A",
+		"dest": map[string]interface{}{"tn": []interface{}{"12155551213"}},
+		"iat": time.Now().Unix(),
+		"orig": map[string]interface{}{"tn": "12155551212"},
+		"origid": "123e4567-e89b-12d3-a456-426655440000",
+	}
+	var b []byte
+	b = append(b, grpcFrame(mustMarshal(map[string]interface{}{"id": "1", "request": sign}))...)
+	b = append(b, grpcFrame([]byte(`{"id": "2", "request": {"attest": "A", "dest": {"tn": null}, "iat": 1, "orig": {"tn": "12155551212"}, "origid": "123e4567-e89b-12d3-a456-426655440000"}}`))...)
+	b = append(b, grpcFrame([]byte(`{"id": "3", "request": {"attest": "A", "dest": {"tn": [null]}, "iat": 1, "orig": {"tn": "12155551212"}, "origid": "123e4567-e89b-12d3-a456-426655440000"}}`))...)
+	resp, msgs := grpcTestCall(t, c, url, "SignStream", "application/grpc+json", b)
+	if resp.Trailer.Get("Grpc-Status") != "0" || len(msgs) != 3 {
+		t.Fatalf("got status %v (%v) and %v messages, want 0 and 3", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"), len(msgs))
+	}
+	tests := map[string]struct {
+		status			int
+		want				string
+	}{
+		"1": {grpcOK, x5u},
+		"2": {grpcInvalidArgument, "VESPER-4024"},
+		"3": {grpcInvalidArgument, "VESPER-4022"},
+	}
+	for i, m := range msgs {
+		var r grpcStreamResponse
+		if err := json.Unmarshal(m, &r); err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+		test, ok := tests[r.ID]
+		if !ok {
+			t.Errorf("%v: got response to id %q", i, r.ID)
+			continue
+		}
+		delete(tests, r.ID)
+		if r.Status != test.status || !strings.Contains(string(r.Response), test.want) {
+			t.Errorf("%v: got status %v and %s, want %v and %v", r.ID, r.Status, r.Response, test.status, test.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"encoding/json"
+	"vesper/stir"
+)
+
+// grpcStreamRequest - JSON message of SignStream and VerifyStream requests. id is echoed in the
+// response to the message (responses are sent as messages are processed, not in order)
+type grpcStreamRequest struct {
+	ID					string						`json:"id"`
+	TraceID			string						`json:"traceId"`
+	Request			json.RawMessage		`json:"request"`
+}
+
+// grpcStreamResponse - JSON message of SignStream and VerifyStream responses. Response is the HTTP
+// API response body (with code, message and verstat if Status is not 0)
+type grpcStreamResponse struct {
+	ID					string						`json:"id"`
+	TraceID			string						`json:"traceId"`
+	Status			int								`json:"status"`
+	Response		json.RawMessage		`json:"response"`
+}
+
+// jsonCodec - JSON messages (content-subtype json): the request and response bodies of the HTTP API
+type jsonCodec struct{}
+
+func (jsonCodec) request(m grpcMethod, b []byte) (map[string]interface{}, *stir.Error) {
+	if len(b) == 0 {
+		return nil, grpcDecodeError(m, nil, true)
+	}
+	var p map[string]interface{}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, grpcDecodeError(m, err, false)
+	}
+	return p, nil
+}
+
+func (jsonCodec) response(m grpcMethod, r grpcResult) []byte {
+	b, _ := json.Marshal(grpcResponseBody(m, r))
+	return b
+}
+
+func (c jsonCodec) streamRequest(m grpcMethod, b []byte) (string, string, map[string]interface{}, *stir.Error) {
+	var r grpcStreamRequest
+	if err := json.Unmarshal(b, &r); err != nil {
+		return "", "", nil, grpcDecodeError(m, err, false)
+	}
+	p, e := c.request(m, r.Request)
+	return r.ID, r.TraceID, p, e
+}
+
+func (jsonCodec) streamResponse(m grpcMethod, id, traceID string, r grpcResult) []byte {
+	resp := grpcStreamResponse{ID: id, TraceID: traceID}
+	if r.err != nil {
+		resp.Status = grpcStatus(r.err.Status)
+	}
+	resp.Response, _ = json.Marshal(grpcResponseBody(m, r))
+	b, _ := json.Marshal(resp)
+	return b
+}
@@ -0,0 +1,345 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+	"encoding/json"
+	"encoding/binary"
+	"vesper/stir"
+)
+
+// protobuf messages of the gRPC service (see grpc.proto). Messages are decoded to (and encoded from)
+// the JSON values of the request payloads and response bodies of the HTTP API, so that they are
+// signed and verified as JSON requests are. Each message type maps field numbers to JSON names
+
+// protobuf wire types
+const (
+	protoVarint					= 0
+	protoFixed64				= 1
+	protoBytes					= 2
+	protoFixed32				= 5
+)
+
+// kinds of protobuf fields
+const (
+	protoString					= iota		// string
+	protoInt									// int32 or int64 (JSON number)
+	protoStrings							// repeated string (JSON array of strings)
+	protoMessage							// message (JSON object)
+)
+
+// protoFieldType - field of a protobuf message type: its JSON name and kind (and type, if a message)
+type protoFieldType struct {
+	name				string
+	kind				int
+	message			protoMessageType
+}
+
+// protoMessageType - fields of a protobuf message type by field number
+type protoMessageType map[int]protoFieldType
+
+var (
+	protoOrig = protoMessageType{1: {"tn", protoString, nil}, 2: {"uri", protoString, nil}}
+	protoIdentities = protoMessageType{1: {"tn", protoStrings, nil}, 2: {"uri", protoStrings, nil}}
+	protoCaller = protoMessageType{1: {"customer", protoString, nil}, 2: {"trunk", protoString, nil}}
+	protoRph = protoMessageType{1: {"auth", protoStrings, nil}}
+	protoSigningRequest = protoMessageType{
+		1: {"attest", protoString, nil},
+		2: {"caller", protoMessage, protoCaller},
+		3: {"dest", protoMessage, protoIdentities},
+		4: {"iat", protoInt, nil},
+		5: {"orig", protoMessage, protoOrig},
+		6: {"origid", protoString, nil},
+		7: {"ppt", protoString, nil},
+		8: {"rph", protoMessage, protoRph},
+		9: {"tenant", protoString, nil},
+	}
+	protoClaims = protoMessageType{
+		1: {"attest", protoString, nil},
+		2: {"dest", protoMessage, protoIdentities},
+		3: {"iat", protoInt, nil},
+		4: {"orig", protoMessage, protoOrig},
+		5: {"origid", protoString, nil},
+		6: {"rph", protoMessage, protoRph},
+	}
+	protoDecision = protoMessageType{
+		1: {"attest", protoString, nil},
+		2: {"origid", protoString, nil},
+		3: {"reason", protoString, nil},
+		4: {"customer", protoString, nil},
+		5: {"trunk", protoString, nil},
+	}
+	protoSigningResponse = protoMessageType{
+		1: {"identity", protoString, nil},
+		2: {"x5u", protoString, nil},
+		3: {"claims", protoMessage, protoClaims},
+		4: {"attestation", protoMessage, protoDecision},
+	}
+	protoVerificationRequest = protoMessageType{
+		1: {"dest", protoMessage, protoIdentities},
+		2: {"iat", protoInt, nil},
+		3: {"orig", protoMessage, protoIdentities},
+		4: {"identity", protoString, nil},
+	}
+	protoHeader = protoMessageType{
+		1: {"alg", protoString, nil},
+		2: {"ppt", protoString, nil},
+		3: {"typ", protoString, nil},
+		4: {"x5u", protoString, nil},
+	}
+	protoJWT = protoMessageType{1: {"header", protoMessage, protoHeader}, 2: {"claims", protoMessage, protoClaims}}
+	protoVerificationResponse = protoMessageType{
+		1: {"dest", protoMessage, protoIdentities},
+		2: {"iat", protoInt, nil},
+		3: {"orig", protoMessage, protoIdentities},
+		4: {"jwt", protoMessage, protoJWT},
+		5: {"rph", protoMessage, protoRph},
+		6: {"trustDomain", protoString, nil},
+	}
+	protoError = protoMessageType{1: {"code", protoString, nil}, 2: {"message", protoString, nil}, 3: {"verstat", protoString, nil}}
+	// StreamRequest and StreamResponse - request (response) is field signing or verification (oneof)
+	protoSignStreamRequest = protoMessageType{
+		1: {"id", protoString, nil},
+		2: {"traceId", protoString, nil},
+		3: {"request", protoMessage, protoSigningRequest},
+	}
+	protoVerifyStreamRequest = protoMessageType{
+		1: {"id", protoString, nil},
+		2: {"traceId", protoString, nil},
+		4: {"request", protoMessage, protoVerificationRequest},
+	}
+	protoSignStreamResponse = protoMessageType{
+		1: {"id", protoString, nil},
+		2: {"traceId", protoString, nil},
+		3: {"status", protoInt, nil},
+		4: {"error", protoMessage, protoError},
+		5: {"response", protoMessage, protoSigningResponse},
+	}
+	protoVerifyStreamResponse = protoMessageType{
+		1: {"id", protoString, nil},
+		2: {"traceId", protoString, nil},
+		3: {"status", protoInt, nil},
+		4: {"error", protoMessage, protoError},
+		6: {"response", protoMessage, protoVerificationResponse},
+	}
+)
+
+// protoCodec - protobuf messages (see grpc.proto)
+type protoCodec struct{}
+
+func (protoCodec) request(m grpcMethod, b []byte) (map[string]interface{}, *stir.Error) {
+	t := protoSigningRequest
+	if m.verify {
+		t = protoVerificationRequest
+	}
+	p, err := protoDecode(b, t)
+	if err != nil {
+		return nil, grpcDecodeError(m, err, false)
+	}
+	return p, nil
+}
+
+func (protoCodec) response(m grpcMethod, r grpcResult) []byte {
+	t := protoSigningResponse
+	if m.verify {
+		t = protoVerificationResponse
+	}
+	resp, _ := jsonValues(grpcResponseBody(m, r))[m.action()].(map[string]interface{})
+	return protoEncode(resp, t)
+}
+
+func (protoCodec) streamRequest(m grpcMethod, b []byte) (string, string, map[string]interface{}, *stir.Error) {
+	t := protoSignStreamRequest
+	if m.verify {
+		t = protoVerifyStreamRequest
+	}
+	r, err := protoDecode(b, t)
+	if err != nil {
+		return "", "", nil, grpcDecodeError(m, err, false)
+	}
+	id, _ := r["id"].(string)
+	traceID, _ := r["traceId"].(string)
+	// a message without request is an empty request message
+	p, _ := r["request"].(map[string]interface{})
+	if p == nil {
+		p = make(map[string]interface{})
+	}
+	return id, traceID, p, nil
+}
+
+func (protoCodec) streamResponse(m grpcMethod, id, traceID string, r grpcResult) []byte {
+	t := protoSignStreamResponse
+	if m.verify {
+		t = protoVerifyStreamResponse
+	}
+	resp := map[string]interface{}{"id": id, "traceId": traceID}
+	body := grpcResponseBody(m, r)[m.action()]
+	if r.err != nil {
+		resp["status"] = grpcStatus(r.err.Status)
+		resp["error"] = body
+	} else {
+		resp["response"] = body
+	}
+	return protoEncode(jsonValues(resp), t)
+}
+
+// jsonValues returns v as JSON values: string, float64, bool, []interface{} and map[string]interface{}
+func jsonValues(v interface{}) map[string]interface{} {
+	var m map[string]interface{}
+	b, _ := json.Marshal(v)
+	json.Unmarshal(b, &m)
+	return m
+}
+
+// protoField - field of an encoded protobuf message: the value of a varint field or the bytes of a
+// length-delimited (string, bytes or message) field. Values of fixed size fields are not kept
+type protoField struct {
+	num					int
+	wire				int
+	varint			uint64
+	bytes				[]byte
+}
+
+// protoFields returns the fields of protobuf message b, in order (a repeated field once per value)
+func protoFields(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		k, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field key")
+		}
+		b = b[n:]
+		f := protoField{num: int(k >> 3), wire: int(k & 7)}
+		if f.num <= 0 {
+			return nil, fmt.Errorf("invalid field number %v", k >> 3)
+		}
+		switch f.wire {
+		case protoVarint :
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %v", f.num)
+			}
+			f.varint = v
+			b = b[n:]
+		case protoBytes :
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b) - n) {
+				return nil, fmt.Errorf("invalid length of field %v", f.num)
+			}
+			f.bytes = b[n:n + int(l)]
+			b = b[n + int(l):]
+		case protoFixed64, protoFixed32 :
+			size := 8
+			if f.wire == protoFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return nil, fmt.Errorf("invalid length of field %v", f.num)
+			}
+			b = b[size:]
+		default:
+			return nil, fmt.Errorf("wire type %v of field %v is not supported", f.wire, f.num)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// protoDecode returns protobuf message b of type t as JSON values (see jsonValues). Fields that
+// are not in t are ignored; the last value of a field that is not repeated is kept
+func protoDecode(b []byte, t protoMessageType) (map[string]interface{}, error) {
+	fields, err := protoFields(b)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	for _, f := range fields {
+		ft, ok := t[f.num]
+		if !ok {
+			continue
+		}
+		if (ft.kind == protoInt && f.wire != protoVarint) || (ft.kind != protoInt && f.wire != protoBytes) {
+			return nil, fmt.Errorf("field %v (%v) has wire type %v", f.num, ft.name, f.wire)
+		}
+		if (ft.kind == protoString || ft.kind == protoStrings) && !utf8.Valid(f.bytes) {
+			return nil, fmt.Errorf("field %v (%v) is not a valid UTF-8 string", f.num, ft.name)
+		}
+		switch ft.kind {
+		case protoString :
+			m[ft.name] = string(f.bytes)
+		case protoInt :
+			m[ft.name] = float64(int64(f.varint))
+		case protoStrings :
+			l, _ := m[ft.name].([]interface{})
+			m[ft.name] = append(l, string(f.bytes))
+		case protoMessage :
+			v, err := protoDecode(f.bytes, ft.message)
+			if err != nil {
+				return nil, fmt.Errorf("%v - field %v (%v)", err, f.num, ft.name)
+			}
+			m[ft.name] = v
+		}
+	}
+	return m, nil
+}
+
+// protoEncode returns JSON values m (see jsonValues) as protobuf message of type t, fields in
+// order of their numbers. Values that are not in t (or not of the kind of their field) and default
+// values (empty strings and 0) are not encoded
+func protoEncode(m map[string]interface{}, t protoMessageType) []byte {
+	var nums []int
+	for n := range t {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	var b []byte
+	for _, n := range nums {
+		ft := t[n]
+		switch v := m[ft.name].(type) {
+		case string :
+			if ft.kind == protoString && len(v) > 0 {
+				b = protoAppendBytes(b, n, []byte(v))
+			}
+		case float64 :
+			if ft.kind == protoInt && v != 0 {
+				b = protoAppendVarint(protoAppendKey(b, n, protoVarint), uint64(int64(v)))
+			}
+		case []interface{} :
+			if ft.kind == protoStrings {
+				for _, e := range v {
+					if s, ok := e.(string); ok {
+						b = protoAppendBytes(b, n, []byte(s))
+					}
+				}
+			}
+		case map[string]interface{} :
+			if ft.kind == protoMessage {
+				b = protoAppendBytes(b, n, protoEncode(v, ft.message))
+			}
+		}
+	}
+	return b
+}
+
+// protoAppendKey appends the key of field n with wire type w to b
+func protoAppendKey(b []byte, n, w int) []byte {
+	return protoAppendVarint(b, uint64(n) << 3 | uint64(w))
+}
+
+// protoAppendVarint appends varint v to b
+func protoAppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v) | 0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// protoAppendBytes appends length-delimited field n with value v to b
+func protoAppendBytes(b []byte, n int, v []byte) []byte {
+	b = protoAppendVarint(protoAppendKey(b, n, protoBytes), uint64(len(v)))
+	return append(b, v...)
+}
@@ -19,7 +19,7 @@ func initializeLogging() (err error) {
 			glogger,
 			"timestamp", kitlog.TimestampFormat(func() time.Time { return time.Now().UTC() }, "2006-01-02 15:04:05.000"),
 			"service", "VESPER",
-			"host", configuration.ConfigurationInstance().LogHost,
+			"host", kitlog.Valuer(func() interface{} { return configuration.ConfigurationInstance().LogHost }),
 			"version", softwareVersion,
 		)
 	}
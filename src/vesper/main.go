@@ -13,6 +13,7 @@ import (
 	"time"
 	"strings"
 	"regexp"
+	"crypto/x509"
 	"github.com/httprouter"
 	"github.com/cors"
 	"vesper/configuration"
@@ -20,6 +21,13 @@ import (
 	"vesper/eks"
 	"vesper/sticr"
 	"vesper/signcredentials"
+	"vesper/acme"
+	"vesper/stipa"
+	"vesper/tnauthlist"
+	"vesper/tenants"
+	"vesper/trustdomains"
+	"vesper/denylist"
+	"vesper/attestation"
 	"vesper/replayattack"
 	"vesper/publickeys"
 	kitlog "github.com/go-kit/kit/log"
@@ -28,33 +36,56 @@ import (
 var (
 	glogger											kitlog.Logger
 	rootCerts										*rootcerts.RootCerts
+	trustDomains								*trustdomains.TrustDomains
+	denyList										*denylist.DenyList
 	signingCredentials					*signcredentials.SigningCredentials
+	acmeManager									*acme.Manager
+	stipaClient									*stipa.Client
+	signingTenants							*tenants.Tenants
+	attestationEngine						*attestation.Engine
 	eksCredentials							*eks.EksCredentials
 	x5u													*sticr.SticrHost
 	httpClient									*http.Client
-	regexInfo										*regexp.Regexp
-	regexAlg										*regexp.Regexp
-	regexPpt										*regexp.Regexp
+	regexSticrPath							*regexp.Regexp
 	replayAttackCache						*replayattack.Cache
 )
 
-// ErrorBlob -- This is a standard error object
-type ErrorBlob struct {
-	ReasonCode string `json:"reasonCode"`
-	ReasonString string `json:"reasonString"`
+// roles - STI-AS (signing), STI-VS (verification) or both
+const (
+	roleSigner				= "signer"
+	roleVerifier			= "verifier"
+	roleBoth					= "both"
+)
+
+// returns true if Vesper signs (STI-AS)
+func signerRole() bool {
+	return configuration.ConfigurationInstance().Role != roleVerifier
+}
+
+// returns true if Vesper verifies (STI-VS)
+func verifierRole() bool {
+	return configuration.ConfigurationInstance().Role != roleSigner
+}
+
+// Compile the expression once
+func init() {
+	// path (one or more segments) under which built-in STI-CR serves certificates
+	regexSticrPath = regexp.MustCompile(`^(/[A-Za-z0-9._~\-]+)+$`)
 }
 
 // Read config file
 // Instantiate logging
-func init() {
-	if (len(os.Args) != 2) {
-		log.Fatal("The config file (ABSOLUTE PATH + FILE NAME) must be the only command line arguement")
+// called by main (not init), so that the package can be tested without a config file
+func initialize() {
+	// check mode - validate config files and exit
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(os.Args[2:]))
 	}
 
-	// read config
-	err := configuration.ConfigurationInstance().GetConfiguration(os.Args[1])
+	// read config - defaults, config file, VESPER_* environment variables and command line flags
+	_, ignored, err := loadConfiguration(os.Args[1:])
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("configuration is not valid:\n%v", err)
 	}
 
 	// Initialize logging
@@ -62,67 +93,215 @@ func init() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	for _, e := range ignored {
+		logInfo("type", "configuration", "message", fmt.Sprintf("environment variable %v is not a config key - ignored", e))
+	}
 
 	// create http client object once - to be reused
 	httpClient = &http.Client{Timeout: time.Duration(2 * time.Second)}
 	
 	// initiatlize sks credentials object
-	eksCredentials, err = eks.InitObject(configuration.ConfigurationInstance().EksCredentialsFile)
-	if err != nil {
-		logCritical("type", "eksConfig", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
-		os.Exit(1)
+	// a verifier only needs EKS for root certs (or trust domains) stored in EKS
+	// eks credentials are read from eks credentials file or set inline (main config or environment variables)
+	if c := configuration.ConfigurationInstance(); len(strings.TrimSpace(c.EksAumUrl)) > 0 {
+		eksCredentials, err = eks.NewObject(c.EksAumUrl, c.EksAumKey, c.EksAumSecret, c.EksUrl)
+		if err != nil {
+			logCritical("type", "eksConfig", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+			os.Exit(1)
+		}
+	} else if signerRole() || len(strings.TrimSpace(c.EksCredentialsFile)) > 0 {
+		eksCredentials, err = eks.InitObject(c.EksCredentialsFile)
+		if err != nil {
+			logCritical("type", "eksConfig", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+			os.Exit(1)
+		}
 	}
 
-	// initiatlize sticr object
-	x5u, err = sticr.InitObject(configuration.ConfigurationInstance().SticrHostFile)
-	if err != nil {
-		logCritical("type", "sticrConfig", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
-		os.Exit(2)
-	}	
+	// initiatlize sticr object (signer only)
+	if signerRole() {
+		if h := configuration.ConfigurationInstance().SticrHost; len(strings.TrimSpace(h)) > 0 {
+			x5u, err = sticr.NewObject(h)
+		} else {
+			x5u, err = sticr.InitObject(configuration.ConfigurationInstance().SticrHostFile)
+		}
+		if err != nil {
+			logCritical("type", "sticrConfig", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+			os.Exit(2)
+		}
+	}
 	
-	// After sks credentials object is successfully initialized, initiatlize rootcerts object
-	signingCredentials, err = signcredentials.InitObject(glogger, softwareVersion, httpClient, eksCredentials, x5u)
-	if err != nil {
-		logCritical("type", "signingCredentials", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
-		os.Exit(3)
+	// initialize STI-PA API client (optional) - SPC tokens (for ACME) and STI-PA CRL
+	if len(strings.TrimSpace(configuration.ConfigurationInstance().StipaCredentialsFile)) > 0 {
+		stipaClient, err = stipa.InitObject(glogger, httpClient, configuration.ConfigurationInstance().StipaCredentialsFile, configuration.ConfigurationInstance().StipaCrlUrl, configuration.ConfigurationInstance().StipaCrlIssuerFile)
+		if err != nil {
+			logCritical("type", "stipa", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+			os.Exit(12)
+		}
+		// CRL is not required at startup; it is fetched again by the CRL ticker
+		if err := stipaClient.FetchCrl(); err != nil {
+			logError("type", "refreshStipaCrl", "message", fmt.Sprintf("%v", err))
+		}
+	}
+
+	if signerRole() {
+		// After sks credentials object is successfully initialized, initiatlize signing credentials (signer only)
+		// rotation policy applies to signing credentials of Vesper and of tenants
+		signcredentials.SetRotationPolicy(configuration.ConfigurationInstance().SigningStagedRotation, time.Duration(configuration.ConfigurationInstance().SigningKeyRetiringPeriod)*time.Minute)
+		// signing credentials are enrolled using ACME, if configured. Otherwise, fetched from EKS
+		if len(strings.TrimSpace(configuration.ConfigurationInstance().AcmeDirectoryUrl)) > 0 {
+			signingCredentials, err = signcredentials.InitManagedObject(glogger, softwareVersion, httpClient, eksCredentials, x5u, configuration.ConfigurationInstance().SigningDelegatesEksPath)
+		} else {
+			signingCredentials, err = signcredentials.InitObject(glogger, softwareVersion, httpClient, eksCredentials, x5u, configuration.ConfigurationInstance().SigningDelegatesEksPath)
+		}
+		if err != nil {
+			logCritical("type", "signingCredentials", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+			os.Exit(3)
+		}
+
+		// initialize ACME client (optional) - obtains signing certificate, unless a valid one was obtained earlier
+		if len(strings.TrimSpace(configuration.ConfigurationInstance().AcmeDirectoryUrl)) > 0 {
+			cfg := acme.Config{
+				DirectoryUrl: configuration.ConfigurationInstance().AcmeDirectoryUrl,
+				Contact: configuration.ConfigurationInstance().AcmeContact,
+				TokenFile: configuration.ConfigurationInstance().AcmeSpcTokenFile,
+				StateDir: configuration.ConfigurationInstance().AcmeStateDir,
+				Filename: configuration.ConfigurationInstance().AcmeCertFilename,
+				RenewBefore: time.Duration(configuration.ConfigurationInstance().AcmeRenewBefore)*24*time.Hour,
+			}
+			if spc := strings.TrimSpace(configuration.ConfigurationInstance().AcmeSpc); len(spc) > 0 {
+				cfg.TNAuthList = tnauthlist.List{{SPC: spc}}
+				// SPC token is fetched from STI-PA, if STI-PA API is configured. Otherwise, read from token file
+				if stipaClient != nil {
+					cfg.Tokens = func(fp string) (string, error) {
+						return stipaClient.SpcToken(spc, fp)
+					}
+				}
+			}
+			acmeManager, err = acme.InitObject(glogger, httpClient, cfg, signingCredentials)
+			if err != nil {
+				logCritical("type", "acme", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+				os.Exit(11)
+			}
+		}
+
+		// After signing credentials object is successfully initialized, initiatlize tenants (optional)
+		if len(strings.TrimSpace(configuration.ConfigurationInstance().TenantsFile)) > 0 {
+			signingTenants, err = tenants.InitObject(glogger, configuration.ConfigurationInstance().TenantsFile, signingCredentials, len(configuration.ConfigurationInstance().SticrServerPath) > 0)
+			if err != nil {
+				logCritical("type", "tenants", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+				os.Exit(5)
+			}
+		}
+
+		// initiatlize attestation engine (optional)
+		if len(strings.TrimSpace(configuration.ConfigurationInstance().AttestationFile)) > 0 {
+			attestationEngine, err = attestation.InitObject(configuration.ConfigurationInstance().AttestationFile)
+			if err != nil {
+				logCritical("type", "attestation", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+				os.Exit(6)
+			}
+		}
 	}
 
 	// After sks credentials object is successfully initialized, initiatlize rootcerts object
-	rootCerts, err = rootcerts.InitObject(glogger, softwareVersion, httpClient, eksCredentials)
-	if err != nil {
-		logCritical("type", "rootCerts", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
-		os.Exit(4)
+	// root certs are fetched from STI-PA trust anchor list, if configured. Otherwise, from whitelist in EKS
+	// a signer only needs root certs for self-check of its signing credentials
+	if verifierRole() || configuration.ConfigurationInstance().SigningSelfCheck {
+		var rootSource rootcerts.Source
+		if len(strings.TrimSpace(configuration.ConfigurationInstance().RootCertsTrustListUrl)) > 0 || len(strings.TrimSpace(configuration.ConfigurationInstance().RootCertsTrustListFile)) > 0 {
+			rootSource = rootcerts.Source{
+				TrustListUrl: configuration.ConfigurationInstance().RootCertsTrustListUrl,
+				TrustListFile: configuration.ConfigurationInstance().RootCertsTrustListFile,
+				TrustListKeyFile: configuration.ConfigurationInstance().RootCertsTrustListKeyFile,
+				TrustListMaxAge: configuration.ConfigurationInstance().RootCertsTrustListMaxAge,
+			}
+		}
+		rootCerts, err = rootcerts.InitObject(glogger, softwareVersion, httpClient, eksCredentials, rootSource)
+		if err != nil {
+			logCritical("type", "rootCerts", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+			os.Exit(4)
+		}
+	}
+
+	// After rootcerts object is successfully initialized, initiatlize trust domains (optional, verifier only)
+	if verifierRole() && len(strings.TrimSpace(configuration.ConfigurationInstance().TrustDomainsFile)) > 0 {
+		trustDomains, err = trustdomains.InitObject(glogger, configuration.ConfigurationInstance().TrustDomainsFile, rootCerts)
+		if err != nil {
+			logCritical("type", "trustDomains", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+			os.Exit(8)
+		}
+	}
+
+	// initiatlize deny list (optional, verifier only)
+	if verifierRole() && len(strings.TrimSpace(configuration.ConfigurationInstance().DenyListFile)) > 0 {
+		denyList, err = denylist.InitObject(glogger, configuration.ConfigurationInstance().DenyListFile)
+		if err != nil {
+			logCritical("type", "denyList", "message", fmt.Sprintf("%v.... cannot start Vesper Service .... ", err))
+			os.Exit(9)
+		}
 	}
 	
 	// instantiate cache to hold stringified claims from identity header in request payload, during verification
-	replayAttackCache = replayattack.InitObject()
-	
-	// Compile the expression once
-	regexInfo = regexp.MustCompile(`^info=<..*>$`)
-	regexAlg = regexp.MustCompile(`^alg=ES256$`)
-	regexPpt = regexp.MustCompile(`^ppt=shaken$`)
+	if verifierRole() {
+		replayAttackCache = replayattack.InitObject()
+	}
 }
 
 //
 func main() {
+	initialize()
 	logInfo("type", "start", "message", "Starting vesper .... ")
 	stop := make(chan os.Signal, 1)
 	signal.Ignore(syscall.SIGPIPE)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	// reload configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfiguration("SIGHUP")
+		}
+	}()
 
+	logInfo("type", "role", "message", fmt.Sprintf("role is %v", configuration.ConfigurationInstance().Role))
 	router := httprouter.New()
 	router.GET("/v1/version", version)
+	router.GET("/v1/ready", readiness)
 	router.GET("/stir/v1/stats", getStats)
-	router.POST("/stir/v1/signing", signRequest)
-	router.POST("/stir/v1/verification", verifyRequest)
 	router.POST("/stir/v1/resetstats", resetStats)
+	router.POST("/stir/v1/admin/config/reload", reloadConfigRequest)
+	if signerRole() {
+		router.POST("/stir/v1/signing", signRequest)
+		router.GET("/stir/v1/admin/signingkeys", getSigningKeys)
+		router.POST("/stir/v1/admin/signingkeys/promote", promoteSigningKey)
+		router.POST("/stir/v1/admin/signingkeys/rollback", rollbackSigningKey)
+	}
+	if verifierRole() {
+		router.POST("/stir/v1/verification", verifyRequest)
+		router.GET("/stir/v1/admin/denylist", getDenyList)
+		router.POST("/stir/v1/admin/denylist", addDenyListEntries)
+		router.DELETE("/stir/v1/admin/denylist", removeDenyListEntries)
+	}
+	// canary signs and verifies
+	if signerRole() && verifierRole() {
+		router.POST("/stir/v1/canary", canaryRequest)
+	}
+	// built-in STI-CR (optional, signer only)
+	if p := configuration.ConfigurationInstance().SticrServerPath; len(p) > 0 && signerRole() {
+		if !regexSticrPath.MatchString(p) || strings.HasPrefix(p + "/", "/stir/") || strings.HasPrefix(p + "/", "/v1/") {
+			logCritical("type", "sticrServerPath", "message", fmt.Sprintf("sticr_server_path %v is not valid or conflicts with Vesper APIs.... cannot start Vesper Service .... ", p))
+			os.Exit(10)
+		}
+		router.GET(p + "/:filename", getCertificate)
+		logInfo("type", "sticrServer", "message", fmt.Sprintf("serving certificates at %v", p))
+	}
 
 	// Start the service.
 	// Note: netstats -plnt shows a IPv6 TCP socket listening on localhost:9000
 	//       but no IPv4 TCP socket. This is not an issue
 	c := cors.New(cors.Options{
-		AllowedMethods: []string{"GET", "POST"},
-		AllowedHeaders: []string{"accept", "Content-Type", "Authorization"},
+		AllowedMethods: []string{"GET", "POST", "DELETE"},
+		AllowedHeaders: []string{"accept", "Content-Type", "Authorization", "X-Api-Key", "X-Vesper-Tenant", "X-Admin-Api-Key"},
 		AllowCredentials: true,
 	})
 	handler := c.Handler(router)
@@ -130,130 +309,337 @@ func main() {
 
 	// start periodic tickers - each in a separate goroutine
 	stopEksCredentialsRefreshTicker := make(chan struct{})
-	go func() {
-		// start periodic ticker to refresh server jwt to call EKS APIs
-		// NewTicker returns a new Ticker containing a channel that will send the time with
-		// a period specified by the duration argument. It adjusts the intervals or drops
-		// ticks to make up for slow receiver.
-		// https://golang.org/pkg/time/#NewTicker
-		eksCredentialsRefreshTicker := time.NewTicker(time.Duration(configuration.ConfigurationInstance().EksCredentialsRefreshInterval)*time.Minute)
-		defer eksCredentialsRefreshTicker.Stop()
-		for {
-			select {
-			case <- eksCredentialsRefreshTicker.C:
-				// check for eks config changes
-				err := eksCredentials.UpdateEksCredentials()
-				if err != nil {
-					logInfo("type", "refreshEksCredentials", "message", fmt.Sprintf("%v", err))
+	if eksCredentials != nil {
+		go func() {
+			// start periodic ticker to refresh server jwt to call EKS APIs
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			eksCredentialsRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().EksCredentialsRefreshInterval)*time.Minute })
+			defer eksCredentialsRefreshTicker.Stop()
+			for {
+				select {
+				case <- eksCredentialsRefreshTicker.C:
+					// check for eks config changes
+					err := eksCredentials.UpdateEksCredentials()
+					if err != nil {
+						logInfo("type", "refreshEksCredentials", "message", fmt.Sprintf("%v", err))
+					}
+				case <- eksCredentialsRefreshTicker.reloaded:
+					eksCredentialsRefreshTicker.rearm()
+				case <- stopEksCredentialsRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped eks credentials refresh ticker")
+					return
 				}
-			case <- stopEksCredentialsRefreshTicker:
-				logInfo("type", "timerStop", "message", "stopped eks credentials refresh ticker")
-				return
 			}
-		}
-	}()
+		}()
+	}
 	stopSticrRefreshTicker := make(chan struct{})
-	go func() {
-		// start periodic ticker to check on changes to sticr URL
-		// NewTicker returns a new Ticker containing a channel that will send the time with
-		// a period specified by the duration argument. It adjusts the intervals or drops
-		// ticks to make up for slow receiver.
-		// https://golang.org/pkg/time/#NewTicker
-		sticrRefreshTicker := time.NewTicker(time.Duration(configuration.ConfigurationInstance().SticrFileCheckInterval)*time.Minute)
-		defer sticrRefreshTicker.Stop()
-		for {
-			select {
-			case <- sticrRefreshTicker.C:
-				x5u.UpdateSticrHost()
-			case <- stopSticrRefreshTicker:
-				logInfo("type", "timerStop", "message", "stopped sticr url refresh ticker")
-				return
+	if x5u != nil && len(strings.TrimSpace(configuration.ConfigurationInstance().SticrHostFile)) > 0 {
+		go func() {
+			// start periodic ticker to check on changes to sticr URL
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			sticrRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().SticrFileCheckInterval)*time.Minute })
+			defer sticrRefreshTicker.Stop()
+			for {
+				select {
+				case <- sticrRefreshTicker.C:
+					x5u.UpdateSticrHost()
+				case <- sticrRefreshTicker.reloaded:
+					sticrRefreshTicker.rearm()
+				case <- stopSticrRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped sticr url refresh ticker")
+					return
+				}
 			}
-		}
-	}()
-	
+		}()
+	}
+	stopTenantsRefreshTicker := make(chan struct{})
+	if signingTenants != nil {
+		go func() {
+			// start periodic ticker to check on changes to tenants file
+			// each tenant refreshes its own signing credentials independently
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			tenantsRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().TenantsFileCheckInterval)*time.Minute })
+			defer tenantsRefreshTicker.Stop()
+			for {
+				select {
+				case <- tenantsRefreshTicker.C:
+					err := signingTenants.UpdateTenants()
+					if err != nil {
+						logError("type", "refreshTenants", "message", fmt.Sprintf("%v", err))
+					}
+				case <- tenantsRefreshTicker.reloaded:
+					tenantsRefreshTicker.rearm()
+				case <- stopTenantsRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped tenants file refresh ticker")
+					return
+				}
+			}
+		}()
+	}
+
+	stopTrustDomainsRefreshTicker := make(chan struct{})
+	if trustDomains != nil {
+		go func() {
+			// start periodic ticker to check on changes to trust domains file
+			// each trust domain refreshes its own root certs independently
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			trustDomainsRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().TrustDomainsFileCheckInterval)*time.Minute })
+			defer trustDomainsRefreshTicker.Stop()
+			for {
+				select {
+				case <- trustDomainsRefreshTicker.C:
+					err := trustDomains.UpdateTrustDomains()
+					if err != nil {
+						logError("type", "refreshTrustDomains", "message", fmt.Sprintf("%v", err))
+					}
+				case <- trustDomainsRefreshTicker.reloaded:
+					trustDomainsRefreshTicker.rearm()
+				case <- stopTrustDomainsRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped trust domains file refresh ticker")
+					return
+				}
+			}
+		}()
+	}
+
+	stopDenyListRefreshTicker := make(chan struct{})
+	if denyList != nil {
+		go func() {
+			// start periodic ticker to check on changes to deny list file
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			denyListRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().DenyListFileCheckInterval)*time.Minute })
+			defer denyListRefreshTicker.Stop()
+			for {
+				select {
+				case <- denyListRefreshTicker.C:
+					err := denyList.UpdateDenyList()
+					if err != nil {
+						logError("type", "refreshDenyList", "message", fmt.Sprintf("%v", err))
+					}
+				case <- denyListRefreshTicker.reloaded:
+					denyListRefreshTicker.rearm()
+				case <- stopDenyListRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped deny list file refresh ticker")
+					return
+				}
+			}
+		}()
+	}
+
+	stopAttestationRefreshTicker := make(chan struct{})
+	if attestationEngine != nil {
+		go func() {
+			// start periodic ticker to check on changes to TN inventory in attestation file
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			attestationRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().AttestationFileCheckInterval)*time.Minute })
+			defer attestationRefreshTicker.Stop()
+			for {
+				select {
+				case <- attestationRefreshTicker.C:
+					err := attestationEngine.UpdateInventory()
+					if err != nil {
+						logError("type", "refreshAttestation", "message", fmt.Sprintf("%v", err))
+					}
+				case <- attestationRefreshTicker.reloaded:
+					attestationRefreshTicker.rearm()
+				case <- stopAttestationRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped attestation file refresh ticker")
+					return
+				}
+			}
+		}()
+	}
 
 	stopRootCertsRefreshTicker := make(chan struct{})
-	go func() {
-		// start periodic ticker to pull latest root certs from EKS
-		// NewTicker returns a new Ticker containing a channel that will send the time with
-		// a period specified by the duration argument. It adjusts the intervals or drops
-		// ticks to make up for slow receiver.
-		// https://golang.org/pkg/time/#NewTicker
-		rootCertsRefreshTicker := time.NewTicker(time.Duration(configuration.ConfigurationInstance().RootCertsFetchInterval)*time.Second)
-		defer rootCertsRefreshTicker.Stop()
-		for {
-			select {
-			case <- rootCertsRefreshTicker.C:
-				// fetch root certs from EKS and replace cached ones
-				rootCerts.FetchRootCertsFromEks()
-			case <- stopRootCertsRefreshTicker:
-				logInfo("type", "timerStop", "message", "stopped root certs refresh ticker")
-				return
+	if rootCerts != nil {
+		go func() {
+			// start periodic ticker to pull latest root certs from EKS
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			rootCertsRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().RootCertsFetchInterval)*time.Second })
+			defer rootCertsRefreshTicker.Stop()
+			for {
+				select {
+				case <- rootCertsRefreshTicker.C:
+					// fetch root certs (EKS or STI-PA trust anchor list) and replace cached ones
+					if err := rootCerts.Refresh(); err != nil {
+						logError("type", "refreshRootCerts", "message", fmt.Sprintf("%v", err))
+					}
+				case <- rootCertsRefreshTicker.reloaded:
+					rootCertsRefreshTicker.rearm()
+				case <- stopRootCertsRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped root certs refresh ticker")
+					return
+				}
 			}
-		}
-	}()
+		}()
+	}
 	stopSigningCredentialsRefreshTicker := make(chan struct{})
-	go func() {
-		// start periodic ticker to refresh  current signing credentials - x5u and privatekey
-		// NewTicker returns a new Ticker containing a channel that will send the time with
-		// a period specified by the duration argument. It adjusts the intervals or drops
-		// ticks to make up for slow receiver.
-		// https://golang.org/pkg/time/#NewTicker
-		signingCredentialsRefreshTicker := time.NewTicker(time.Duration(configuration.ConfigurationInstance().SigningCredentialsFetchInterval)*time.Second)
-		defer signingCredentialsRefreshTicker.Stop()
-		for {
-			select {
-			case <- signingCredentialsRefreshTicker.C:
-				// fetch current x5u and privatekey for signing. This will replace cached credentials
-				signingCredentials.FetchSigningCredentialsFromEks()
-			case <- stopSigningCredentialsRefreshTicker:
-				logInfo("type", "timerStop", "message", "stopped signing credentials refresh ticker")
-				return
+	if signingCredentials != nil {
+		go func() {
+			// start periodic ticker to refresh  current signing credentials - x5u and privatekey
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			signingCredentialsRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().SigningCredentialsFetchInterval)*time.Second })
+			defer signingCredentialsRefreshTicker.Stop()
+			for {
+				select {
+				case <- signingCredentialsRefreshTicker.C:
+					// fetch current x5u and privatekey for signing. This will replace cached credentials
+					signingCredentials.FetchSigningCredentialsFromEks()
+				case <- signingCredentialsRefreshTicker.reloaded:
+					signingCredentialsRefreshTicker.rearm()
+				case <- stopSigningCredentialsRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped signing credentials refresh ticker")
+					return
+				}
 			}
-		}
-	}()
+		}()
+	}
+	stopStipaCrlRefreshTicker := make(chan struct{})
+	if stipaClient != nil && len(strings.TrimSpace(configuration.ConfigurationInstance().StipaCrlUrl)) > 0 {
+		go func() {
+			// start periodic ticker to download STI-PA CRL
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			stipaCrlRefreshTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().StipaCrlFetchInterval)*time.Second })
+			defer stipaCrlRefreshTicker.Stop()
+			for {
+				select {
+				case <- stipaCrlRefreshTicker.C:
+					if err := stipaClient.FetchCrl(); err != nil {
+						logError("type", "refreshStipaCrl", "message", fmt.Sprintf("%v", err))
+					}
+				case <- stipaCrlRefreshTicker.reloaded:
+					stipaCrlRefreshTicker.rearm()
+				case <- stopStipaCrlRefreshTicker:
+					logInfo("type", "timerStop", "message", "stopped STI-PA CRL refresh ticker")
+					return
+				}
+			}
+		}()
+	}
+	stopAcmeRenewalTicker := make(chan struct{})
+	if acmeManager != nil {
+		go func() {
+			// start periodic ticker to renew signing certificate (using ACME) ahead of expiry
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			acmeRenewalTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().AcmeCheckInterval)*time.Minute })
+			defer acmeRenewalTicker.Stop()
+			for {
+				select {
+				case <- acmeRenewalTicker.C:
+					if err := acmeManager.Renew(); err != nil {
+						logError("type", "acmeRenewal", "message", fmt.Sprintf("%v", err), "notAfter", acmeManager.NotAfter().UTC().Format(time.RFC3339))
+					}
+				case <- acmeRenewalTicker.reloaded:
+					acmeRenewalTicker.rearm()
+				case <- stopAcmeRenewalTicker:
+					logInfo("type", "timerStop", "message", "stopped ACME renewal ticker")
+					return
+				}
+			}
+		}()
+	}
 	stopReplayAttackCacheValidationTicker := make(chan struct{})
-	go func() {
-		t := time.Now().Unix()		// time at startup
-		// start periodic ticker to clear stale replay attack cache
-		// NewTicker returns a new Ticker containing a channel that will send the time with
-		// a period specified by the duration argument. It adjusts the intervals or drops
-		// ticks to make up for slow receiver.
-		// https://golang.org/pkg/time/#NewTicker
-		replayAttackCacheValidationTicker := time.NewTicker(time.Duration(configuration.ConfigurationInstance().ReplayAttackCacheValidationInterval)*time.Second)
-		defer replayAttackCacheValidationTicker.Stop()
-		for {
-			select {
-			case <- replayAttackCacheValidationTicker.C:
-				// periodic cleanup of stale replay attack cache
-				replayAttackCache.Remove(t)
-				t += 1	// increment time by 1 second; no mutex needed here
-			case <- stopReplayAttackCacheValidationTicker:
-				logInfo("type", "timerStop", "message", "stopped stale replay attack cache ticker")
-				return
+	if replayAttackCache != nil {
+		go func() {
+			t := time.Now().Unix()		// time at startup
+			// start periodic ticker to clear stale replay attack cache
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			replayAttackCacheValidationTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().ReplayAttackCacheValidationInterval)*time.Second })
+			defer replayAttackCacheValidationTicker.Stop()
+			for {
+				select {
+				case <- replayAttackCacheValidationTicker.C:
+					// periodic cleanup of stale replay attack cache
+					replayAttackCache.Remove(t)
+					t += 1	// increment time by 1 second; no mutex needed here
+				case <- replayAttackCacheValidationTicker.reloaded:
+					replayAttackCacheValidationTicker.rearm()
+				case <- stopReplayAttackCacheValidationTicker:
+					logInfo("type", "timerStop", "message", "stopped stale replay attack cache ticker")
+					return
+				}
 			}
-		}
-	}()
+		}()
+	}
+	stopCanaryTicker := make(chan struct{})
+	if configuration.ConfigurationInstance().CanaryInterval > 0 && signerRole() && verifierRole() {
+		go func() {
+			// start periodic ticker to sign and verify a synthetic PASSporT (canary)
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			canaryTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().CanaryInterval)*time.Second })
+			defer canaryTicker.Stop()
+			for {
+				select {
+				case <- canaryTicker.C:
+					runCanary()
+				case <- canaryTicker.reloaded:
+					canaryTicker.rearm()
+				case <- stopCanaryTicker:
+					logInfo("type", "timerStop", "message", "stopped canary ticker")
+					return
+				}
+			}
+		}()
+	}
 	stopPublicKeysCacheFlushTicker := make(chan struct{})
-	go func() {
-		// start periodic ticker to clear all cached public keys
-		// NewTicker returns a new Ticker containing a channel that will send the time with
-		// a period specified by the duration argument. It adjusts the intervals or drops
-		// ticks to make up for slow receiver.
-		// https://golang.org/pkg/time/#NewTicker
-		publicKeysCacheFlushTicker := time.NewTicker(time.Duration(configuration.ConfigurationInstance().PublicKeysCacheFlushInterval)*time.Second)
-		defer publicKeysCacheFlushTicker.Stop()
-		for {
-			select {
-			case <- publicKeysCacheFlushTicker.C:
-				publickeys.FlushCache()
-			case <- stopPublicKeysCacheFlushTicker:
-				logInfo("type", "timerStop", "message", "stopped public keys cache flush ticker")
-				return
+	if verifierRole() {
+		go func() {
+			// start periodic ticker to clear all cached public keys
+			// NewTicker returns a new Ticker containing a channel that will send the time with
+			// a period specified by the duration argument. It adjusts the intervals or drops
+			// ticks to make up for slow receiver.
+			// https://golang.org/pkg/time/#NewTicker
+			publicKeysCacheFlushTicker := newReloadableTicker(func() time.Duration { return time.Duration(configuration.ConfigurationInstance().PublicKeysCacheFlushInterval)*time.Second })
+			defer publicKeysCacheFlushTicker.Stop()
+			for {
+				select {
+				case <- publicKeysCacheFlushTicker.C:
+					publickeys.FlushCache()
+				case <- publicKeysCacheFlushTicker.reloaded:
+					publicKeysCacheFlushTicker.rearm()
+				case <- stopPublicKeysCacheFlushTicker:
+					logInfo("type", "timerStop", "message", "stopped public keys cache flush ticker")
+					return
+				}
 			}
-		}
-	}()
+		}()
+	}
 	
 	var srv http.Server
 	// Start HTTPS server only if cert and key file exist
@@ -296,6 +682,43 @@ func main() {
 		 }()
 	}
 
+	// Start gRPC server (see grpc.go) alongside HTTP(S) server
+	var grpcSrv *http.Server
+	if p := strings.TrimSpace(configuration.ConfigurationInstance().GrpcPort); len(p) > 0 {
+		tls := (len(strings.TrimSpace(configuration.ConfigurationInstance().SslCertFile)) > 0) && (len(strings.TrimSpace(configuration.ConfigurationInstance().SslKeyFile)) > 0)
+		grpcSrv = newGrpcServer(p, tls)
+		go func() {
+			logInfo("type", "grpcServiceStart", "message", fmt.Sprintf("starting gRPC service on %v ...", grpcSrv.Addr))
+			var err error
+			if tls {
+				err = grpcSrv.ListenAndServeTLS(configuration.ConfigurationInstance().SslCertFile, configuration.ConfigurationInstance().SslKeyFile)
+			} else {
+				err = grpcSrv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				logError("type", "grpcServiceFailure", "message", fmt.Sprintf("%v - could not start serving gRPC service", err))
+				errs <- err
+			}
+		}()
+	}
+
+	// self-check of signing credentials validates the certificate chain at x5u against root certs. It is
+	// enabled once the servers are started, since x5u may be served by the built-in STI-CR, and run at once
+	// for Vesper and for tenants. It is then run whenever signing credentials are created, set or refreshed
+	if signerRole() && configuration.ConfigurationInstance().SigningSelfCheck {
+		signcredentials.SetSelfCheck(func() *x509.CertPool {
+			return rootCerts.Root()
+		}, time.Duration(configuration.ConfigurationInstance().SigningSelfCheckMinLifetime)*24*time.Hour, selfCheckAlert)
+		go func() {
+			signingCredentials.RunSelfCheck()
+			if signingTenants != nil {
+				for _, t := range signingTenants.All() {
+					t.Credentials().RunSelfCheck()
+				}
+			}
+		}()
+	}
+
 	// This will run forever until channel receives error
 	select {
 	case err := <-errs:
@@ -308,6 +731,11 @@ func main() {
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 		err := srv.Shutdown(ctx)
+		if grpcSrv != nil {
+			if gerr := grpcSrv.Shutdown(ctx); gerr != nil && err == nil {
+				err = gerr
+			}
+		}
 		if err != nil {
 			logError("type", "httpServiceShutdownFailure", "message", fmt.Sprintf("%v - http server shutdown", err))
 			logInfo("type", "stop", "message", "vesper stopped but NOT gracefully")
@@ -4,11 +4,19 @@ import (
 	"fmt"
 	"sync"
 	"crypto/ecdsa"
+	"crypto/x509"
 )
 
+// entry - cached public key, its certificate and fingerprints of root certs (trust anchors) the certificate chained to
+type entry struct {
+	pk				*ecdsa.PublicKey
+	cert			*x509.Certificate
+	roots			[]string
+}
+
 var (
 	mtx = &sync.RWMutex{}
-	publicKeys = make(map[string]*ecdsa.PublicKey)
+	publicKeys = make(map[string]entry)
 )
 
 // returns cached public key if present
@@ -16,17 +24,28 @@ func Fetch(x5u string) *ecdsa.PublicKey {
 	// check if public key is cached
 	mtx.RLock()
 	defer mtx.RUnlock()
-	if _, ok := publicKeys[x5u]; ok {
-		return publicKeys[x5u]
+	if e, ok := publicKeys[x5u]; ok {
+		return e.pk
+	}
+	return nil
+}
+
+// returns certificate of cached public key if present
+func Certificate(x5u string) *x509.Certificate {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	if e, ok := publicKeys[x5u]; ok {
+		return e.cert
 	}
 	return nil
 }
 
-// caches public key
-func Add(x5u string, pk *ecdsa.PublicKey) {
+// caches public key along with certificate c in x5u
+// roots are SHA-256 fingerprints of root certs c chained to (none, if the chain was not validated)
+func Add(x5u string, pk *ecdsa.PublicKey, c *x509.Certificate, roots ...string) {
 	mtx.Lock()
 	defer mtx.Unlock()
-	publicKeys[x5u] = pk
+	publicKeys[x5u] = entry{pk: pk, cert: c, roots: roots}
 }
 
 // clears all cached public keys
@@ -38,11 +57,33 @@ func FlushCache() {
 	}
 }
 
+// clears cached public keys whose certificate chained to any of the root certs with
+// SHA-256 fingerprints in roots. Returns number of public keys cleared
+func EvictRoots(roots []string) int {
+	removed := make(map[string]bool)
+	for _, r := range roots {
+		removed[r] = true
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	n := 0
+	for k, e := range publicKeys {
+		for _, r := range e.roots {
+			if removed[r] {
+				delete(publicKeys, k)
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
 // prints all entries in cache
 func Entries() {
 	mtx.RLock()
 	defer mtx.RUnlock()
-	for x5u, pk := range publicKeys {
-		fmt.Printf("x5u: %v, pk: %v\n", x5u, pk)
+	for x5u, e := range publicKeys {
+		fmt.Printf("x5u: %v, pk: %v, roots: %v\n", x5u, e.pk, e.roots)
 	}
 }
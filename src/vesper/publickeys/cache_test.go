@@ -18,7 +18,7 @@ func TestAdd(t *testing.T) {
 			fmt.Printf("%v\n", err)
 			return
 		}
-		publickeys.Add("https://sticr.comcast.com/0.cer", p)
+		publickeys.Add("https://sticr.comcast.com/0.cer", p, nil)
 	}
 	block, _ = pem.Decode([]byte("-----BEGIN CERTIFICATE-----\nMIICUTCCAfegAwIBAgIJAIU5HElrC5ISMAoGCCqGSM49BAMCMIGEMQswCQYDVQQG\nEwJVUzEMMAoGA1UECAwDVExWMQwwCgYDVQQHDANUTFYxDDAKBgNVBAoMA0FUVDEP\nMA0GA1UECwwGU0hBS0VOMRswGQYDVQQDDBJTSEFLRU4tQ0VSVElGSUNBVEUxHTAb\nBgkqhkiG9w0BCQEWDmVsNTMydkBhdHQuY29tMB4XDTE4MDIyMjE0MjQ0MloXDTE5\nMDIxMzE0MjQ0MlowgYQxCzAJBgNVBAYTAlVTMQwwCgYDVQQIDANUTFYxDDAKBgNV\nBAcMA1RMVjEMMAoGA1UECgwDQVRUMQ8wDQYDVQQLDAZTSEFLRU4xGzAZBgNVBAMM\nElNIQUtFTi1DRVJUSUZJQ0FURTEdMBsGCSqGSIb3DQEJARYOZWw1MzJ2QGF0dC5j\nb20wWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAATyZEVgX0YGc+tAqSrQv2/0b/yZ\nd4z5i7/sAm165IpqxHHZt9fm1mNy1KX2lxU9hj5VwVgEpQEt26aDQ0YbS1Pto1Aw\nTjAdBgNVHQ4EFgQU/znOK+hXkyHYqPalG+Hhzs3dgBgwHwYDVR0jBBgwFoAU/znO\nK+hXkyHYqPalG+Hhzs3dgBgwDAYDVR0TBAUwAwEB/zAKBggqhkjOPQQDAgNIADBF\nAiEAtlC9ZeZOsy8qer/FNJXC382s6BI/UDUjkXucB+X9URoCIGh0TpYYDurH+OZr\n1PSHSkXDUIfgpwU5ehSgGIOWk58w\n-----END CERTIFICATE-----"))
 	if block != nil {
@@ -28,7 +28,7 @@ func TestAdd(t *testing.T) {
 			fmt.Printf("%v\n", err)
 			return
 		}
-		publickeys.Add("https://sticr.comcast.com/1.cer", p)
+		publickeys.Add("https://sticr.comcast.com/1.cer", p, nil)
 	}
 	publickeys.Entries()
 	fmt.Println("----------")
@@ -44,7 +44,7 @@ func TestFetch(t *testing.T) {
 			fmt.Printf("%v\n", err)
 			return
 		}
-		publickeys.Add("https://sticr.comcast.com/0.cer", p)
+		publickeys.Add("https://sticr.comcast.com/0.cer", p, nil)
 	}
 	block, _ = pem.Decode([]byte("-----BEGIN CERTIFICATE-----\nMIICUTCCAfegAwIBAgIJAIU5HElrC5ISMAoGCCqGSM49BAMCMIGEMQswCQYDVQQG\nEwJVUzEMMAoGA1UECAwDVExWMQwwCgYDVQQHDANUTFYxDDAKBgNVBAoMA0FUVDEP\nMA0GA1UECwwGU0hBS0VOMRswGQYDVQQDDBJTSEFLRU4tQ0VSVElGSUNBVEUxHTAb\nBgkqhkiG9w0BCQEWDmVsNTMydkBhdHQuY29tMB4XDTE4MDIyMjE0MjQ0MloXDTE5\nMDIxMzE0MjQ0MlowgYQxCzAJBgNVBAYTAlVTMQwwCgYDVQQIDANUTFYxDDAKBgNV\nBAcMA1RMVjEMMAoGA1UECgwDQVRUMQ8wDQYDVQQLDAZTSEFLRU4xGzAZBgNVBAMM\nElNIQUtFTi1DRVJUSUZJQ0FURTEdMBsGCSqGSIb3DQEJARYOZWw1MzJ2QGF0dC5j\nb20wWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAATyZEVgX0YGc+tAqSrQv2/0b/yZ\nd4z5i7/sAm165IpqxHHZt9fm1mNy1KX2lxU9hj5VwVgEpQEt26aDQ0YbS1Pto1Aw\nTjAdBgNVHQ4EFgQU/znOK+hXkyHYqPalG+Hhzs3dgBgwHwYDVR0jBBgwFoAU/znO\nK+hXkyHYqPalG+Hhzs3dgBgwDAYDVR0TBAUwAwEB/zAKBggqhkjOPQQDAgNIADBF\nAiEAtlC9ZeZOsy8qer/FNJXC382s6BI/UDUjkXucB+X9URoCIGh0TpYYDurH+OZr\n1PSHSkXDUIfgpwU5ehSgGIOWk58w\n-----END CERTIFICATE-----"))
 	if block != nil {
@@ -54,7 +54,7 @@ func TestFetch(t *testing.T) {
 			fmt.Printf("%v\n", err)
 			return
 		}
-		publickeys.Add("https://sticr.comcast.com/1.cer", p)
+		publickeys.Add("https://sticr.comcast.com/1.cer", p, nil)
 	}
 	fmt.Printf("https://sticr.comcast.com/0.cer - %v\n", publickeys.Fetch("https://sticr.comcast.com/0.cer"))
 	fmt.Printf("https://sticr.comcast.com/1.cer - %v\n", publickeys.Fetch("https://sticr.comcast.com/1.cer"))
@@ -70,7 +70,7 @@ func TestFlush(t *testing.T) {
 			fmt.Printf("%v\n", err)
 			return
 		}
-		publickeys.Add("https://sticr.comcast.com/0.cer", p)
+		publickeys.Add("https://sticr.comcast.com/0.cer", p, nil)
 	}
 	block, _ = pem.Decode([]byte("-----BEGIN CERTIFICATE-----\nMIICUTCCAfegAwIBAgIJAIU5HElrC5ISMAoGCCqGSM49BAMCMIGEMQswCQYDVQQG\nEwJVUzEMMAoGA1UECAwDVExWMQwwCgYDVQQHDANUTFYxDDAKBgNVBAoMA0FUVDEP\nMA0GA1UECwwGU0hBS0VOMRswGQYDVQQDDBJTSEFLRU4tQ0VSVElGSUNBVEUxHTAb\nBgkqhkiG9w0BCQEWDmVsNTMydkBhdHQuY29tMB4XDTE4MDIyMjE0MjQ0MloXDTE5\nMDIxMzE0MjQ0MlowgYQxCzAJBgNVBAYTAlVTMQwwCgYDVQQIDANUTFYxDDAKBgNV\nBAcMA1RMVjEMMAoGA1UECgwDQVRUMQ8wDQYDVQQLDAZTSEFLRU4xGzAZBgNVBAMM\nElNIQUtFTi1DRVJUSUZJQ0FURTEdMBsGCSqGSIb3DQEJARYOZWw1MzJ2QGF0dC5j\nb20wWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAATyZEVgX0YGc+tAqSrQv2/0b/yZ\nd4z5i7/sAm165IpqxHHZt9fm1mNy1KX2lxU9hj5VwVgEpQEt26aDQ0YbS1Pto1Aw\nTjAdBgNVHQ4EFgQU/znOK+hXkyHYqPalG+Hhzs3dgBgwHwYDVR0jBBgwFoAU/znO\nK+hXkyHYqPalG+Hhzs3dgBgwDAYDVR0TBAUwAwEB/zAKBggqhkjOPQQDAgNIADBF\nAiEAtlC9ZeZOsy8qer/FNJXC382s6BI/UDUjkXucB+X9URoCIGh0TpYYDurH+OZr\n1PSHSkXDUIfgpwU5ehSgGIOWk58w\n-----END CERTIFICATE-----"))
 	if block != nil {
@@ -80,7 +80,7 @@ func TestFlush(t *testing.T) {
 			fmt.Printf("%v\n", err)
 			return
 		}
-		publickeys.Add("https://sticr.comcast.com/1.cer", p)
+		publickeys.Add("https://sticr.comcast.com/1.cer", p, nil)
 	}
 	publickeys.Entries()
 	fmt.Println("----------")
@@ -0,0 +1,92 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"fmt"
+	"time"
+	"bytes"
+	"net/http"
+	"encoding/json"
+	"github.com/httprouter"
+	"vesper/configuration"
+	"vesper/signcredentials"
+)
+
+// selfCheckState - last self-check of signing credentials of Vesper or of a tenant
+type selfCheckState struct {
+	Tenant		string		`json:"tenant,omitempty"`
+	Delegate	bool			`json:"delegate,omitempty"`		// self-check of a delegate certificate
+	*signcredentials.SelfCheck
+}
+
+// ReadinessResponse - response to readiness probe
+type ReadinessResponse struct {
+	Ready					bool								`json:"ready"`
+	SelfChecks		[]selfCheckState		`json:"selfChecks,omitempty"`
+	Canary				*CanaryResult				`json:"canary,omitempty"`
+}
+
+// readiness - Vesper is not ready if the last self-check of signing credentials or delegate certificates
+// (of Vesper or of any tenant) found a problem or the last canary run failed. Signing credentials that were
+// not self-checked yet are not reported
+func readiness(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	resp := ReadinessResponse{Ready: true}
+	add := func(tenant string, sc *signcredentials.SigningCredentials) {
+		if s := sc.SelfCheckState(); s != nil {
+			resp.SelfChecks = append(resp.SelfChecks, selfCheckState{Tenant: tenant, SelfCheck: s})
+			resp.Ready = resp.Ready && s.Ok()
+		}
+		for _, d := range sc.DelegateSelfChecks() {
+			d := d
+			resp.SelfChecks = append(resp.SelfChecks, selfCheckState{Tenant: tenant, Delegate: true, SelfCheck: &d})
+			resp.Ready = resp.Ready && d.Ok()
+		}
+	}
+	if signingCredentials != nil {
+		add("", signingCredentials)
+	}
+	if signingTenants != nil {
+		for _, t := range signingTenants.All() {
+			add(t.ID, t.Credentials())
+		}
+	}
+	if resp.Canary = canaryState(); resp.Canary != nil {
+		resp.Ready = resp.Ready && resp.Canary.Ok
+	}
+	response.Header().Set("Content-Type", "application/json")
+	if resp.Ready {
+		response.WriteHeader(http.StatusOK)
+	} else {
+		response.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(response).Encode(resp)
+}
+
+// selfCheckAlert posts self-check s to "signing_self_check_webhook_url" (if configured)
+// whenever the problems found by self-check change
+func selfCheckAlert(s signcredentials.SelfCheck) {
+	u := configuration.ConfigurationInstance().SigningSelfCheckWebhookUrl
+	if len(u) == 0 {
+		return
+	}
+	status := "ok"
+	if !s.Ok() {
+		status = "failing"
+	}
+	b, _ := json.Marshal(map[string]interface{}{"type": "signingSelfCheck", "status": status, "selfCheck": s})
+	go func() {
+		start := time.Now()
+		resp, err := httpClient.Post(u, "application/json", bytes.NewReader(b))
+		if err != nil {
+			logError("type", "signingSelfCheckWebhook", "message", fmt.Sprintf("%v - POST %v failed", err, u))
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			logError("type", "signingSelfCheckWebhook", "message", fmt.Sprintf("POST %v response status - %v", u, resp.StatusCode))
+			return
+		}
+		logInfo("type", "signingSelfCheckWebhook", "status", status, "x5u", s.X5u, "webhookResponseTime", fmt.Sprintf("%v", time.Since(start)))
+	}()
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"net/http"
+	"github.com/httprouter"
+	"github.com/satori/go.uuid"
+	"vesper/configuration"
+	"vesper/publickeys"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+var (
+	reloadMtx					sync.Mutex
+	reloadCh					= make(chan struct{})
+	// public keys in cache were validated (or not) with these settings; cache is flushed if one of
+	// them is changed
+	trustKeys					= map[string]bool{"verify_root_ca": true}
+)
+
+// configReloaded returns a channel that is closed when the configuration is reloaded
+func configReloaded() <-chan struct{} {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+	return reloadCh
+}
+
+// notifyReload wakes up all goroutines waiting on configReloaded
+func notifyReload() {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+	close(reloadCh)
+	reloadCh = make(chan struct{})
+}
+
+// reloadableTicker - ticker whose period is read from the configuration. Its goroutine waits on
+// reloaded and calls rearm when the configuration is reloaded
+type reloadableTicker struct {
+	*time.Ticker
+	interval		func() time.Duration
+	d						time.Duration
+	reloaded		<-chan struct{}
+}
+
+func newReloadableTicker(interval func() time.Duration) *reloadableTicker {
+	// channel is taken before the period is read so that a reload in between is not missed
+	ch := configReloaded()
+	d := interval()
+	return &reloadableTicker{Ticker: time.NewTicker(d), interval: interval, d: d, reloaded: ch}
+}
+
+// rearm resets the ticker if its period is changed in the configuration
+func (t *reloadableTicker) rearm() {
+	t.reloaded = configReloaded()
+	if d := t.interval(); d != t.d {
+		t.Reset(d)
+		logInfo("type", "configReload", "message", fmt.Sprintf("ticker period changed from %v to %v", t.d, d))
+		t.d = d
+	}
+}
+
+// validateReload returns an error if reloadable config values in c cannot be applied. Values
+// are validated when the config file is read (see configuration.Validate)
+func validateReload(c *configuration.Configuration) error {
+	// canary ticker is started at startup only if enabled
+	if (c.CanaryInterval > 0) != (configuration.ConfigurationInstance().CanaryInterval > 0) {
+		return fmt.Errorf("canary_interval cannot be changed from or to 0 without restarting Vesper")
+	}
+	return nil
+}
+
+// reloadConfiguration reloads the config file and logs each change. Tickers are re-armed if
+// the configuration is replaced
+func reloadConfiguration(trigger string) ([]configuration.Change, error) {
+	changes, err := configuration.Reload(validateReload)
+	if err != nil {
+		logError("type", "configReload", "trigger", trigger, "message", fmt.Sprintf("%v - configuration not reloaded", err))
+		return changes, err
+	}
+	flush := false
+	for _, c := range changes {
+		logInfo("type", "configReload", "trigger", trigger, "key", c.Key, "old", c.Old, "new", c.New)
+		flush = flush || trustKeys[c.Key]
+	}
+	if flush {
+		// e.g. public keys cached with verify_root_ca false were never validated against root certs
+		publickeys.FlushCache()
+		logInfo("type", "configReload", "trigger", trigger, "message", "trust settings changed - public keys cache flushed")
+	}
+	logInfo("type", "configReload", "trigger", trigger, "message", fmt.Sprintf("configuration reloaded - %v change(s)", len(changes)))
+	notifyReload()
+	return changes, nil
+}
+
+// Reloads configuration on admin request
+func reloadConfigRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	start := time.Now()
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Content-Type", "application/json")
+	clientIP := getClientIP(request)
+	traceID := request.Header.Get("Trace-Id")
+	if traceID == "" {
+		traceID = "VESPER-" + uuid.NewV1().String()
+	}
+	response.Header().Set("Trace-Id", traceID)
+	if !adminAuthorized(start, response, request, traceID, clientIP, "configReloadResponse") {
+		return
+	}
+	changes, err := reloadConfiguration("adminRequest")
+	if changes == nil {
+		changes = []configuration.Change{}
+	}
+	resp := make(map[string]interface{})
+	if err != nil {
+		// message reports why the configuration is not reloaded
+		resp["configReloadResponse"] = map[string]interface{}{"code": "VESPER-4210", "message": err.Error(), "changes": changes}
+		lg := kitlog.With(glogger, "type", "adminRequest", "clientIP", clientIP, "module", "reloadConfigRequest", "error", err)
+		serveHttpResponse(start, response, lg, http.StatusBadRequest, "error", traceID, "", "", resp)
+		return
+	}
+	resp["configReloadResponse"] = map[string]interface{}{"changes": changes}
+	lg := kitlog.With(glogger, "type", "requestResponseTime", "module", "reloadConfigRequest")
+	serveHttpResponse(start, response, lg, http.StatusOK, "info", traceID, "", "", resp)
+}
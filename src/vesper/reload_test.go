@@ -0,0 +1,77 @@
+// Copyright 2017 Comcast Cable Communications Management, LLC
+
+package main
+
+import (
+	"os"
+	"time"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"vesper/configuration"
+	"vesper/publickeys"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+func TestReloadFlushesPublicKeys(t *testing.T) {
+	glogger = kitlog.NewNopLogger()
+	d, err := ioutil.TempDir("", "vesper")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(d)
+	f := filepath.Join(d, "config.json")
+	write := func(s string) {
+		if err := ioutil.WriteFile(f, []byte(s), 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	write(`{"role": "verifier", "http_port": "8080", "valid_iat_period": 60, "verify_root_ca": false}`)
+	if err := configuration.ConfigurationInstance().GetConfiguration(f); err != nil {
+		t.Fatalf("%v", err)
+	}
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	publickeys.FlushCache()
+	defer publickeys.FlushCache()
+	x5u := "https://cr.example.com/sp.pem"
+
+	tests := []struct {
+		config		string
+		cached		bool
+	}{
+		// not a trust setting
+		{`{"role": "verifier", "http_port": "8080", "valid_iat_period": 30, "verify_root_ca": false}`, true},
+		// public keys cached without root CA validation are flushed
+		{`{"role": "verifier", "http_port": "8080", "valid_iat_period": 30, "verify_root_ca": true}`, false},
+	}
+	for i, test := range tests {
+		publickeys.Add(x5u, &k.PublicKey, nil)
+		write(test.config)
+		if _, err := reloadConfiguration("test"); err != nil {
+			t.Fatalf("%v: %v", i, err)
+		}
+		if cached := publickeys.Fetch(x5u) != nil; cached != test.cached {
+			t.Errorf("%v: public key cached %v, want %v", i, cached, test.cached)
+		}
+	}
+}
+
+func TestReloadableTickerRearm(t *testing.T) {
+	glogger = kitlog.NewNopLogger()
+	d := time.Hour
+	rt := newReloadableTicker(func() time.Duration { return d })
+	defer rt.Stop()
+	d = time.Millisecond
+	rt.rearm()
+	if rt.d != time.Millisecond {
+		t.Fatalf("ticker not reset after its period was changed")
+	}
+	select {
+	case <- rt.C:
+	case <- time.After(time.Second):
+		t.Errorf("no tick from rearmed ticker")
+	}
+}
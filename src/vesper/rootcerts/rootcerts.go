@@ -8,8 +8,11 @@ import (
 	"io/ioutil"
 	"encoding/json"
 	"crypto/x509"
+	"encoding/pem"
+	"crypto/sha256"
 	"net/http"
 	"vesper/eks"
+	"vesper/publickeys"
 	kitlog "github.com/go-kit/kit/log"
 )
 
@@ -20,38 +23,196 @@ var (
 	eksCredentials			*eks.EksCredentials
 )
 
+// DefaultEksPath - EKS path (under /v1/owner/kms.service.srv/) of root certs whitelist
+const DefaultEksPath = "secret/whitelist/data"
+
+// Source - where root certs are fetched from. Only one of EksPath, File, Url, TrustListUrl
+// and TrustListFile is expected to be set
+type Source struct {
+	EksPath						string		`json:"eksPath"`						// EKS path. JSON object in EKS contains "rootcerts" field (PEM)
+	File							string		`json:"file"`								// PEM file
+	Url								string		`json:"url"`								// PEM file fetched over HTTP(S)
+	TrustListUrl			string		`json:"trustListUrl"`				// STI-PA trust anchor list fetched over HTTP(S)
+	TrustListFile			string		`json:"trustListFile"`			// STI-PA trust anchor list file
+	TrustListKeyFile	string		`json:"trustListKeyFile"`		// PEM file with pinned STI-PA public key (or certificate) that signs the trust anchor list
+	TrustListMaxAge		int64			`json:"trustListMaxAge"`		// seconds. trust anchor list issued longer ago is rejected (0 - not checked)
+}
+
 // RootCerts - structure that holds all root certs
 type RootCerts struct {
 	sync.RWMutex	// A field declared with a type but no explicit field name is an
 					// anonymous field, also called an embedded field or an embedding of
 					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	source	Source
 	certs *x509.CertPool
+	list		[]*x509.Certificate
+	iat			int64		// iat of last accepted trust anchor list
 }
   
 // Initialize object
-func InitObject(l kitlog.Logger, v string, h *http.Client, s *eks.EksCredentials) (*RootCerts, error) {
+// root certs are fetched from src (the whitelist in EKS, if src is empty)
+func InitObject(l kitlog.Logger, v string, h *http.Client, s *eks.EksCredentials, src Source) (*RootCerts, error) {
 	glogger = l
 	softwareVersion = v
 	httpClient = h
 	eksCredentials = s
-	rc := new(RootCerts)
-	var err error
-	rc.certs, err = getRootCertsFromEks()
-	if err != nil {
+	if src == (Source{}) {
+		src.EksPath = DefaultEksPath
+	}
+	return NewObject(src)
+}
+
+// NewObject returns root certs fetched from source src
+// InitObject MUST be called before this function
+func NewObject(src Source) (*RootCerts, error) {
+	if err := src.Validate(); err != nil {
+		return nil, err
+	}
+	rc := &RootCerts{source: src}
+	if err := rc.Refresh(); err != nil {
 		return nil, err
 	}
 	return rc, nil
 }
 
+// Validate returns an error if not exactly one root source is set or if the STI-PA trust anchor
+// list is not accompanied by the pinned key that signs it
+func (src Source) Validate() error {
+	n := 0
+	for _, v := range []string{src.EksPath, src.File, src.Url, src.TrustListUrl, src.TrustListFile} {
+		if len(strings.TrimSpace(v)) > 0 {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("root source MUST contain exactly one of EKS path, file, url, trust list url or trust list file")
+	}
+	if (len(src.TrustListUrl) > 0 || len(src.TrustListFile) > 0) != (len(strings.TrimSpace(src.TrustListKeyFile)) > 0) {
+		return fmt.Errorf("pinned key file MUST be configured with (and only with) STI-PA trust anchor list")
+	}
+	if src.TrustListMaxAge < 0 {
+		return fmt.Errorf("trust list max age %v MUST NOT be negative", src.TrustListMaxAge)
+	}
+	return nil
+}
+
 // fetch rootcerts from eks
 func (rc *RootCerts) FetchRootCertsFromEks() error {
-	c, err := getRootCertsFromEks()
+	return rc.Refresh()
+}
+
+// fetch root certs from source and replace cached ones
+// cached root certs are kept if fetching fails. A trust anchor list that is older than the last
+// accepted one (replayed) or than its configured max age is rejected
+// root certs that were added or removed are logged. Cached public keys whose certificate chained to
+// a removed root cert are evicted, so that they are validated again on next use
+func (rc *RootCerts) Refresh() error {
+	var b []byte
+	var err error
+	switch {
+	case len(rc.source.File) > 0 :
+		b, err = ioutil.ReadFile(rc.source.File)
+		if err != nil {
+			err = fmt.Errorf("%v - root certs file", err)
+		}
+	case len(rc.source.Url) > 0 :
+		b, err = getRootCertsFromUrl(rc.source.Url)
+	case len(rc.source.TrustListUrl) > 0 || len(rc.source.TrustListFile) > 0 :
+		b, err = getTrustList(rc.source)
+	default:
+		b, err = getRootCertsFromEks(rc.source.EksPath)
+	}
+	if err != nil {
+		return err
+	}
+	var l []*x509.Certificate
+	var iat int64
+	if len(rc.source.TrustListUrl) > 0 || len(rc.source.TrustListFile) > 0 {
+		l, iat, err = parseTrustList(b, rc.source.TrustListKeyFile)
+		if err == nil {
+			rc.RLock()
+			last := rc.iat
+			rc.RUnlock()
+			err = checkTrustListIat(iat, last, rc.source.TrustListMaxAge, time.Now())
+		}
+	} else {
+		l, err = parseCertificates(b)
+	}
+	if err != nil {
+		return err
+	}
+	certs := x509.NewCertPool()
+	for _, c := range l {
+		certs.AddCert(c)
+	}
 	rc.Lock()
-	defer rc.Unlock()
-	if err == nil {
-		rc.certs = c
+	old := rc.list
+	rc.certs = certs
+	rc.list = l
+	rc.iat = iat
+	rc.Unlock()
+	if old == nil {
+		return nil
+	}
+	added, removed := diff(old, l)
+	for _, c := range added {
+		logInfo("type", "rootCerts", "module", "Refresh", "source", rc.source.String(), "message", "root cert added", "subject", c.Subject.String(), "fingerprint", Fingerprint(c))
 	}
-	return err
+	for _, c := range removed {
+		logInfo("type", "rootCerts", "module", "Refresh", "source", rc.source.String(), "message", "root cert removed", "subject", c.Subject.String(), "fingerprint", Fingerprint(c))
+	}
+	if len(removed) > 0 {
+		var fps []string
+		for _, c := range removed {
+			fps = append(fps, Fingerprint(c))
+		}
+		n := publickeys.EvictRoots(fps)
+		logInfo("type", "rootCerts", "module", "Refresh", "source", rc.source.String(), "message", fmt.Sprintf("%v cached public key(s) evicted - %v root cert(s) removed", n, len(removed)))
+	}
+	return nil
+}
+
+// returns root source, as configured
+func (src Source) String() string {
+	switch {
+	case len(src.File) > 0 :
+		return src.File
+	case len(src.Url) > 0 :
+		return src.Url
+	case len(src.TrustListUrl) > 0 :
+		return src.TrustListUrl
+	case len(src.TrustListFile) > 0 :
+		return src.TrustListFile
+	}
+	return "eks:" + src.EksPath
+}
+
+// Fingerprint returns SHA-256 fingerprint (hex) of certificate c
+func Fingerprint(c *x509.Certificate) string {
+	return fmt.Sprintf("%x", sha256.Sum256(c.Raw))
+}
+
+// returns root certs in n but not in o (added) and in o but not in n (removed)
+func diff(o, n []*x509.Certificate) ([]*x509.Certificate, []*x509.Certificate) {
+	var added, removed []*x509.Certificate
+	of := make(map[string]bool)
+	for _, c := range o {
+		of[Fingerprint(c)] = true
+	}
+	nf := make(map[string]bool)
+	for _, c := range n {
+		f := Fingerprint(c)
+		nf[f] = true
+		if !of[f] {
+			added = append(added, c)
+		}
+	}
+	for _, c := range o {
+		if !nf[Fingerprint(c)] {
+			removed = append(removed, c)
+		}
+	}
+	return added, removed
 }
 
 
@@ -62,12 +223,65 @@ func (rc *RootCerts) Root() *x509.CertPool {
 	return rc.certs
 }
 
-func getRootCertsFromEks() (*x509.CertPool, error) {
-	certs := x509.NewCertPool()
+// returns all root certs
+func (rc *RootCerts) Certificates() []*x509.Certificate {
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.list
+}
+
+// parse all certificates in PEM data b
+// blocks that are not certificates (or cannot be parsed) are skipped, same as x509.CertPool.AppendCertsFromPEM
+func parseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var l []*x509.Certificate
+	for len(b) > 0 {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" || len(block.Headers) != 0 {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		l = append(l, c)
+	}
+	if len(l) == 0 {
+		return nil, fmt.Errorf("No certs appended")
+	}
+	return l, nil
+}
+
+// fetch root certs (PEM) from url
+func getRootCertsFromUrl(url string) ([]byte, error) {
+	start := time.Now()
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("%v - GET %v failed", err, url)
+	}
+	defer resp.Body.Close()
+	logInfo("type", "rootCertsResponseTime", "module", "getRootCertsFromUrl", "url", url, "responseTime", fmt.Sprintf("%v", time.Since(start)))
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("GET %v response status - %v; %v - response body", url, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v response status - %v; response body - %v", url, resp.StatusCode, string(rb))
+	}
+	return rb, nil
+}
+
+func getRootCertsFromEks(path string) ([]byte, error) {
 	// Request root certs from EKS
+	if eksCredentials == nil {
+		return nil, fmt.Errorf("root certs in EKS path %v cannot be fetched - EKS is not configured", path)
+	}
 	start := time.Now()
 	u, t := eksCredentials.GetEksCredentials()
-	url := u + "/v1/owner/kms.service.srv/secret/whitelist/data"
+	url := u + "/v1/owner/kms.service.srv/" + path
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("%v - http.NewRequest failed", err)
@@ -103,11 +317,7 @@ func getRootCertsFromEks() (*x509.CertPool, error) {
 		if r2, ok := s["rootcerts"]; ok {
 			switch r2.(type) {
 			case string:
-				// Append our cert to the system pool
-				if ok := certs.AppendCertsFromPEM([]byte(s["rootcerts"].(string))); !ok {
-					return nil, fmt.Errorf("No certs appended")
-				}
-				return certs, nil
+				return []byte(s["rootcerts"].(string)), nil
 			default:
 				return nil, fmt.Errorf("GET %v response status - %v; \"rootcerts\" field MUST be a string in %+v returned by EKS", url, resp.Status, s)
 			}
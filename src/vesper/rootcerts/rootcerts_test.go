@@ -0,0 +1,71 @@
+package rootcerts
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/pem"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"vesper/publickeys"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+func TestRefreshEvictsPublicKeys(t *testing.T) {
+	glogger = kitlog.NewNopLogger()
+	d := t.TempDir()
+	ca1, ca2, ca3 := caCert(t, "ca1"), caCert(t, "ca2"), caCert(t, "ca3")
+	f := filepath.Join(d, "rootcerts.pem")
+	write := func(certs ...*x509.Certificate) {
+		var b []byte
+		for _, c := range certs {
+			b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+		}
+		if err := ioutil.WriteFile(f, b, 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	write(ca1, ca2, ca3)
+	rc, err := NewObject(Source{File: f})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	publickeys.FlushCache()
+	defer publickeys.FlushCache()
+	publickeys.Add("https://cr.example.com/1.pem", &k.PublicKey, nil, Fingerprint(ca1))
+	publickeys.Add("https://cr.example.com/2.pem", &k.PublicKey, nil, Fingerprint(ca2))
+	publickeys.Add("https://cr.example.com/3.pem", &k.PublicKey, nil, Fingerprint(ca3), Fingerprint(ca1))
+	publickeys.Add("https://cr.example.com/4.pem", &k.PublicKey, nil)
+
+	// ca1 removed - public keys that chained to it are evicted, others are kept
+	write(ca2, ca3)
+	if err := rc.Refresh(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	tests := []struct {
+		x5u				string
+		cached		bool
+	}{
+		{"https://cr.example.com/1.pem", false},
+		{"https://cr.example.com/2.pem", true},
+		{"https://cr.example.com/3.pem", false},
+		{"https://cr.example.com/4.pem", true},
+	}
+	for i, test := range tests {
+		if cached := publickeys.Fetch(test.x5u) != nil; cached != test.cached {
+			t.Errorf("%v: %v cached %v; want %v", i, test.x5u, cached, test.cached)
+		}
+	}
+	if len(rc.list) != 2 || !rc.list[0].Equal(ca2) || !rc.list[1].Equal(ca3) {
+		t.Errorf("got %v root certs, want ca2 and ca3", len(rc.list))
+	}
+	// root certs are kept if they cannot be fetched
+	os.Remove(f)
+	if err := rc.Refresh(); err == nil || len(rc.list) != 2 {
+		t.Errorf("got %v (%v root certs), want error and ca2 and ca3 kept", err, len(rc.list))
+	}
+}
@@ -0,0 +1,140 @@
+package rootcerts
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"math/big"
+	"io/ioutil"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/base64"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+)
+
+// trustList - payload of STI-PA trust anchor list
+// the list is a JWS (RFC 7515, compact serialization) signed by STI-PA using ES256
+// each entry in "trustList" is a base64 (standard, not URL safe) encoded DER certificate, same as "x5c" in RFC 7517
+type trustList struct {
+	Iat					int64			`json:"iat"`
+	TrustList		[]string	`json:"trustList"`
+}
+
+// fetch STI-PA trust anchor list from url or file in source src
+func getTrustList(src Source) ([]byte, error) {
+	if len(src.TrustListUrl) > 0 {
+		return getRootCertsFromUrl(src.TrustListUrl)
+	}
+	b, err := ioutil.ReadFile(src.TrustListFile)
+	if err != nil {
+		return nil, fmt.Errorf("%v - trust list file", err)
+	}
+	return b, nil
+}
+
+// verify signature of STI-PA trust anchor list b using pinned key in file k and return the
+// certificates in the list and the time it was issued (iat)
+func parseTrustList(b []byte, k string) ([]*x509.Certificate, int64, error) {
+	key, err := readPinnedKey(k)
+	if err != nil {
+		return nil, 0, err
+	}
+	parts := strings.Split(strings.TrimSpace(string(b)), ".")
+	if len(parts) != 3 {
+		return nil, 0, fmt.Errorf("trust list is not a JWS in compact serialization")
+	}
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v - decode trust list header", err)
+	}
+	var hdr map[string]interface{}
+	if err := json.Unmarshal(h, &hdr); err != nil {
+		return nil, 0, fmt.Errorf("%v - decode JSON object in trust list header", err)
+	}
+	if alg, _ := hdr["alg"].(string); alg != "ES256" {
+		return nil, 0, fmt.Errorf("alg %v in trust list header is not supported - MUST be ES256", hdr["alg"])
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return nil, 0, fmt.Errorf("trust list signature is not a base64url encoded ES256 signature")
+	}
+	d := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(key, d[:], r, s) {
+		return nil, 0, fmt.Errorf("trust list signature cannot be verified using pinned key")
+	}
+	p, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v - decode trust list payload", err)
+	}
+	var tl trustList
+	if err := json.Unmarshal(p, &tl); err != nil {
+		return nil, 0, fmt.Errorf("%v - decode JSON object in trust list payload", err)
+	}
+	if len(tl.TrustList) == 0 {
+		return nil, 0, fmt.Errorf("\"trustList\" field missing or empty in trust list payload")
+	}
+	var l []*x509.Certificate
+	for i, v := range tl.TrustList {
+		der, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%v - decode certificate at index %v in trust list", err, i)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%v - parse certificate at index %v in trust list", err, i)
+		}
+		l = append(l, c)
+	}
+	return l, tl.Iat, nil
+}
+
+// checkTrustListIat returns an error if trust anchor list issued at iat is older than the last
+// accepted list (issued at last), which means it is replayed, or if it was issued more than maxAge
+// seconds before now (maxAge 0 - not checked)
+func checkTrustListIat(iat, last, maxAge int64, now time.Time) error {
+	if iat < last {
+		return fmt.Errorf("trust list iat %v is older than iat %v of the last accepted trust list", iat, last)
+	}
+	if maxAge > 0 && now.Unix() - iat > maxAge {
+		return fmt.Errorf("trust list iat %v is older than max age %v seconds", iat, maxAge)
+	}
+	return nil
+}
+
+// read pinned STI-PA public key (P-256) from PEM file k. The file contains either the
+// public key or a certificate
+func readPinnedKey(k string) (*ecdsa.PublicKey, error) {
+	b, err := ioutil.ReadFile(k)
+	if err != nil {
+		return nil, fmt.Errorf("%v - trust list key file", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data is found in trust list key file")
+	}
+	var pub interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var c *x509.Certificate
+		c, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			pub = c.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("PEM block type %v in trust list key file is not supported", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v - trust list key file", err)
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok || key.Curve.Params().Name != "P-256" {
+		return nil, fmt.Errorf("trust list key MUST be an ECDSA P-256 public key")
+	}
+	return key, nil
+}
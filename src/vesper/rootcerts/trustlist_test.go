@@ -0,0 +1,176 @@
+package rootcerts
+
+import (
+	"testing"
+	"time"
+	"strings"
+	"math/big"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/base64"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+)
+
+// self signed CA certificate
+func caCert(t *testing.T, cn string) *x509.Certificate {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: cn}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return c
+}
+
+// trust anchor list issued at iat, signed using key k
+func signTrustList(t *testing.T, k *ecdsa.PrivateKey, iat int64, certs ...*x509.Certificate) string {
+	tl := trustList{Iat: iat}
+	for _, c := range certs {
+		tl.TrustList = append(tl.TrustList, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+	p, _ := json.Marshal(tl)
+	si := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(p)
+	d := sha256.Sum256([]byte(si))
+	r, s, err := ecdsa.Sign(rand.Reader, k, d[:])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// r and s are left padded to 32 bytes each
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return si + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// PEM file (in directory d) with public key of k
+func keyFile(t *testing.T, d string, k *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	f := filepath.Join(d, "stipa.pem")
+	if err := ioutil.WriteFile(f, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return f
+}
+
+func TestParseTrustList(t *testing.T) {
+	d := t.TempDir()
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca1, ca2 := caCert(t, "ca1"), caCert(t, "ca2")
+	now := time.Now().Unix()
+	jws := signTrustList(t, k, now, ca1, ca2)
+
+	l, iat, err := parseTrustList([]byte(jws), keyFile(t, d, k))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(l) != 2 || !l[0].Equal(ca1) || !l[1].Equal(ca2) {
+		t.Errorf("parseTrustList returned %v certs; want ca1 and ca2", len(l))
+	}
+	if iat != now {
+		t.Errorf("parseTrustList returned iat %v; want %v", iat, now)
+	}
+	// signed by a key other than the pinned key
+	if _, _, err := parseTrustList([]byte(jws), keyFile(t, d, other)); err == nil {
+		t.Errorf("parseTrustList accepted trust list not signed by pinned key")
+	}
+	// payload replaced (ca2 removed)
+	p := strings.Split(jws, ".")
+	q := strings.Split(signTrustList(t, other, now, ca1), ".")
+	if _, _, err := parseTrustList([]byte(p[0]+"."+q[1]+"."+p[2]), keyFile(t, d, k)); err == nil {
+		t.Errorf("parseTrustList accepted tampered payload")
+	}
+	if _, _, err := parseTrustList([]byte("not a jws"), keyFile(t, d, k)); err == nil {
+		t.Errorf("parseTrustList accepted malformed trust list")
+	}
+}
+
+func TestCheckTrustListIat(t *testing.T) {
+	now := time.Unix(1504282247, 0)
+	tests := []struct {
+		iat, last, maxAge		int64
+		ok									bool
+	}{
+		// first trust list (nothing accepted yet), max age not checked
+		{1, 0, 0, true},
+		// same or newer than last accepted trust list
+		{now.Unix() - 10, now.Unix() - 10, 0, true},
+		{now.Unix(), now.Unix() - 10, 0, true},
+		// older than last accepted trust list (replayed)
+		{now.Unix() - 20, now.Unix() - 10, 0, false},
+		{now.Unix() - 20, now.Unix() - 10, 3600, false},
+		// max age
+		{now.Unix() - 3600, 0, 3600, true},
+		{now.Unix() - 3601, 0, 3600, false},
+		{0, 0, 3600, false},
+	}
+	for i, test := range tests {
+		if err := checkTrustListIat(test.iat, test.last, test.maxAge, now); (err == nil) != test.ok {
+			t.Errorf("%v: checkTrustListIat(%v, %v, %v) - got %v, want ok %v", i, test.iat, test.last, test.maxAge, err, test.ok)
+		}
+	}
+}
+
+func TestRefreshTrustList(t *testing.T) {
+	d := t.TempDir()
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca1, ca2 := caCert(t, "ca1"), caCert(t, "ca2")
+	src := Source{TrustListFile: filepath.Join(d, "trustlist.jws"), TrustListKeyFile: keyFile(t, d, k), TrustListMaxAge: 3600}
+	write := func(jws string) {
+		if err := ioutil.WriteFile(src.TrustListFile, []byte(jws), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	now := time.Now().Unix()
+	older := signTrustList(t, k, now - 60, ca1, ca2)
+	write(signTrustList(t, k, now, ca1))
+	rc, err := NewObject(src)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// older trust list (e.g. replayed by an attacker) is rejected and root certs are kept
+	write(older)
+	if err := rc.Refresh(); err == nil || !strings.Contains(err.Error(), "older than iat") {
+		t.Errorf("older trust list - got %v", err)
+	}
+	if len(rc.list) != 1 || !rc.list[0].Equal(ca1) {
+		t.Errorf("got %v root certs, want ca1", len(rc.list))
+	}
+	// trust list past max age is rejected
+	write(signTrustList(t, k, now - 7200, ca1, ca2))
+	if _, err := NewObject(src); err == nil || !strings.Contains(err.Error(), "max age") {
+		t.Errorf("stale trust list - got %v", err)
+	}
+	src.TrustListMaxAge = -1
+	if _, err := NewObject(src); err == nil {
+		t.Errorf("negative trust list max age accepted")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ca1, ca2, ca3 := caCert(t, "ca1"), caCert(t, "ca2"), caCert(t, "ca3")
+	added, removed := diff([]*x509.Certificate{ca1, ca2}, []*x509.Certificate{ca2, ca3})
+	if len(added) != 1 || !added[0].Equal(ca3) {
+		t.Errorf("diff added %v certs; want ca3", len(added))
+	}
+	if len(removed) != 1 || !removed[0].Equal(ca1) {
+		t.Errorf("diff removed %v certs; want ca1", len(removed))
+	}
+}
@@ -10,15 +10,13 @@ import (
 	"time"
 	"github.com/httprouter"
 	"github.com/satori/go.uuid"
+	"vesper/configuration"
 	"vesper/stats"
+	"vesper/stir"
+	"vesper/tenants"
 	kitlog "github.com/go-kit/kit/log"
 )
 
-// SResponse
-type SResponse struct {
-	SigningResponse ErrorBlob `json:"signingResponse"`
-}
-
 // -
 func signRequest(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
 	start := time.Now()
@@ -30,7 +28,9 @@ func signRequest(response http.ResponseWriter, request *http.Request, _ httprout
 		traceID = "VESPER-" + uuid.NewV1().String()
 	}
 	response.Header().Set("Trace-Id", traceID)
-	stats.IncrSigningRequestCount()
+	if !isCanary(request) {
+		stats.IncrSigningRequestCount()
+	}
 	// verify no query is present
 	// verify the request body is correct
 	var r map[string]interface{}
@@ -48,37 +48,123 @@ func signRequest(response http.ResponseWriter, request *http.Request, _ httprout
 	default:
 		// err == nil. continue
 	}
-	orderedMap, _, _, _, _, errCode, err := validatePayload(r, traceID, clientIP)
-	if err != nil {
-		lg := kitlog.With(glogger, "type", "requestPayload", "clientIP", clientIP, "module", "signRequest", "error", err)
-		serveHttpResponse(start, response, lg, http.StatusBadRequest, "error", traceID, "signingResponse", errCode, nil)
+	res, tenant, e := signPayload(start, request, r)
+	lg := kitlog.With(glogger, "clientIP", clientIP, "module", "signRequest")
+	if tenant != nil {
+		lg = kitlog.With(lg, "tenant", tenant.ID)
+	}
+	if e != nil {
+		serveHttpResponse(start, response, kitlog.With(lg, "type", "requestPayload", "error", e.Err), e.Status, "error", traceID, "signingResponse", e.Code, nil)
 		return
 	}
-	logInfo("type", "signRequest", "traceID", traceID, "clientIP", clientIP, "module", "signRequest", "requestPayload", r)
-	x, p := signingCredentials.Signing()
-	// at this point, the input has been validated
-	hdr := ShakenHdr{	Alg: "ES256", Ppt: "shaken", Typ: "passport", X5u: x}
-	hdrBytes, err := json.Marshal(hdr)
+	// request payload as signed ("caller" replaced by attestation decision, iat and origid set as configured)
+	logInfo("type", "signRequest", "traceID", traceID, "clientIP", clientIP, "module", "signRequest", "ppt", res.Ppt, "requestPayload", r)
+	resp := signingResponse(res)
+	serveHttpResponse(start, response, kitlog.With(lg, "type", "requestResponseTime", "resp", resp), http.StatusOK, "info", traceID, "", "", resp)
+}
+
+// signPayload signs request payload r (of POST /stir/v1/signing or of a gRPC message) at time t, on
+// behalf of the tenant selected by request (see selectTenant), if any. Errors are *stir.Error
+func signPayload(t time.Time, request *http.Request, r map[string]interface{}) (*stir.SignResult, *tenants.Tenant, *stir.Error) {
+	tenant, httpCode, errCode, err := selectTenant(request, r)
 	if err != nil {
-		lg := kitlog.With(glogger, "type", "requestPayload", "clientIP", clientIP, "module", "signRequest", "error", fmt.Sprintf("%v - error in converting header to byte array", err))
-		serveHttpResponse(start, response, lg, http.StatusInternalServerError, "error", traceID, "signingResponse", "VESPER-5050", nil)
-		return
+		return nil, nil, &stir.Error{Code: errCode, Status: httpCode, Err: err}
 	}
-	claimsBytes, _ := json.Marshal(orderedMap)
+	s, err := newSigner(t)
 	if err != nil {
-		lg := kitlog.With(glogger, "type", "requestPayload", "clientIP", clientIP, "module", "signRequest", "error", fmt.Sprintf("%v - error in converting claims to byte array", err))
-		serveHttpResponse(start, response, lg, http.StatusInternalServerError, "error", traceID, "signingResponse", "VESPER-5051", nil)
-		return
+		return nil, tenant, &stir.Error{Code: "VESPER-5053", Status: http.StatusInternalServerError, Err: err}
 	}
-	canonicalString, sig, err := createSignature(hdrBytes, claimsBytes, p)
+	req := stir.SignRequest{Payload: r}
+	if tenant != nil {
+		req.Credentials = tenant.Credentials()
+		req.Policy = tenant
+	}
+	res, err := s.Sign(req)
 	if err != nil {
-		lg := kitlog.With(glogger, "type", "requestPayload", "clientIP", clientIP, "module", "signRequest", "error", fmt.Sprintf("%v - error in signing request for request payload", err))
-		serveHttpResponse(start, response, lg, http.StatusInternalServerError, "error", traceID, "signingResponse", "VESPER-5052", nil)
-		return
+		e, ok := err.(*stir.Error)
+		if !ok {
+			e = &stir.Error{Code: "VESPER-5054", Status: http.StatusInternalServerError, Err: err}
+		}
+		return nil, tenant, e
 	}
+	return res, tenant, nil
+}
+
+// signingResponse returns the response body of POST /stir/v1/signing with signing result res
+func signingResponse(res *stir.SignResult) map[string]interface{} {
 	resp := make(map[string]interface{})
 	resp["signingResponse"] = make(map[string]interface{})
-	resp["signingResponse"].(map[string]interface{})["identity"] = canonicalString + "." + sig + ";info=<" + x + ">;alg=ES256"
-	lg := kitlog.With(glogger, "type", "requestResponseTime", "module", "signRequest", "resp", resp)
-	serveHttpResponse(start, response, lg, http.StatusOK, "info", traceID, "", "", resp)
+	resp["signingResponse"].(map[string]interface{})["identity"] = res.Identity
+	resp["signingResponse"].(map[string]interface{})["x5u"] = res.X5u
+	resp["signingResponse"].(map[string]interface{})["claims"] = res.Claims
+	if res.Attestation != nil {
+		resp["signingResponse"].(map[string]interface{})["attestation"] = res.Attestation
+	}
+	return resp
+}
+
+// newSigner returns the STI-AS with the signing credentials, attestation engine and (current)
+// configuration of Vesper. Requests are signed at time t
+func newSigner(t time.Time) (*stir.Signer, error) {
+	c := configuration.ConfigurationInstance()
+	o := stir.SignerOptions{
+		Clock: func() time.Time { return t },
+		NormalizeTNs: c.NormalizeTNs,
+		StampIat: c.SigningStampIat,
+		MaxIatDrift: c.SigningMaxIatDrift,
+		OrigIDUUID: c.SigningOrigIDUUID,
+	}
+	// nil pointers are not assigned to interfaces (a nil *T in an interface is not nil)
+	if signingCredentials != nil {
+		o.Credentials = signingCredentials
+	}
+	if attestationEngine != nil {
+		o.Attester = attestationEngine
+	}
+	return stir.NewSigner(o)
+}
+
+// selectTenant returns the tenant on whose behalf the request is signed.
+// The tenant is selected (in this order) by API key ("X-Api-Key" header), by tenant
+// ID in "X-Vesper-Tenant" header or by tenant ID in "tenant" field in request payload.
+// The "tenant" field, if present, is removed from request payload.
+// nil tenant (and no error) is returned if no tenant is selected; the default
+// signing credentials are used in that case
+func selectTenant(request *http.Request, r map[string]interface{}) (*tenants.Tenant, int, string, error) {
+	var id string
+	if v, ok := r["tenant"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, http.StatusBadRequest, "VESPER-4026", fmt.Errorf("tenant field in request payload MUST be a string")
+		}
+		id = s
+		delete(r, "tenant")
+	}
+	if signingTenants == nil {
+		if len(id) > 0 || len(request.Header.Get("X-Vesper-Tenant")) > 0 {
+			return nil, http.StatusBadRequest, "VESPER-4027", fmt.Errorf("tenant %v is not configured - no tenants are configured", id)
+		}
+		return nil, http.StatusOK, "", nil
+	}
+	if k := request.Header.Get("X-Api-Key"); len(k) > 0 {
+		t := signingTenants.ByApiKey(k)
+		if t == nil {
+			return nil, http.StatusUnauthorized, "VESPER-4028", fmt.Errorf("API key in request does not belong to any tenant")
+		}
+		return t, http.StatusOK, "", nil
+	}
+	if h := request.Header.Get("X-Vesper-Tenant"); len(h) > 0 {
+		id = h
+	}
+	if len(id) == 0 {
+		return nil, http.StatusOK, "", nil
+	}
+	t := signingTenants.ByID(id)
+	if t == nil {
+		return nil, http.StatusBadRequest, "VESPER-4027", fmt.Errorf("tenant %v is not configured", id)
+	}
+	if t.RequireApiKey() {
+		return nil, http.StatusUnauthorized, "VESPER-4028", fmt.Errorf("tenant %v can only be selected using an API key", id)
+	}
+	return t, http.StatusOK, "", nil
 }
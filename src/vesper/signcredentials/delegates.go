@@ -0,0 +1,96 @@
+package signcredentials
+
+import (
+	"fmt"
+	"time"
+	"crypto/ecdsa"
+	"vesper/sticr"
+	"vesper/tnauthlist"
+)
+
+// delegate - delegate certificate (ATIS-1000092) with its private key and the
+// TNs (TNAuthList scope) the certificate is authorized for
+type delegate struct {
+	filename		string
+	x5u					string
+	privateKey	*ecdsa.PrivateKey
+	certificate	[]byte
+	scope				tnauthlist.List
+	notAfter		time.Time
+}
+
+// selectDelegate returns the most specific delegate whose scope covers tn
+// delegates with expired certificates are never selected
+// nil is returned if no delegate covers tn
+func selectDelegate(delegates []delegate, tn string, now time.Time) *delegate {
+	var d *delegate
+	var size int64
+	for i := range delegates {
+		if now.After(delegates[i].notAfter) {
+			continue
+		}
+		if ok, n := delegates[i].scope.Covers(tn); ok && (d == nil || n < size) {
+			d = &delegates[i]
+			size = n
+		}
+	}
+	return d
+}
+
+// fetch delegate certificates and private keys stored at path in EKS
+// the JSON object in EKS contains
+// { "delegates": [ { "filename": "", "privateKey": "", "certificate": "" }, ... ] }
+func getDelegatesFromEks(path string, certRepo *sticr.SticrHost) ([]delegate, error) {
+	s, url, status, err := getEksSecret(path, "getDelegatesFromEks")
+	if err != nil {
+		return nil, err
+	}
+	l, ok := s["delegates"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("GET %v response status - %v; \"delegates\" field missing or is not an array in object returned by EKS", url, status)
+	}
+	var delegates []delegate
+	for i, v := range l {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("GET %v response status - %v; delegate at index %v MUST be a JSON object", url, status, i)
+		}
+		var fn, pk, c string
+		if fn, ok = m["filename"].(string); !ok {
+			return nil, fmt.Errorf("GET %v response status - %v; \"filename\" field missing or is not a string for delegate at index %v", url, status, i)
+		}
+		if pk, ok = m["privateKey"].(string); !ok {
+			return nil, fmt.Errorf("GET %v response status - %v; \"privateKey\" field missing or is not a string for delegate at index %v", url, status, i)
+		}
+		if c, ok = m["certificate"].(string); !ok {
+			return nil, fmt.Errorf("GET %v response status - %v; \"certificate\" field missing or is not a string for delegate at index %v", url, status, i)
+		}
+		d, err := newDelegate(fn, certRepo.GetSticrHost() + "/" + fn, pk, c)
+		if err != nil {
+			return nil, fmt.Errorf("%v - delegate %v", err, fn)
+		}
+		delegates = append(delegates, *d)
+	}
+	return delegates, nil
+}
+
+// create delegate after validating that the private key belongs to the
+// certificate and that the certificate has a TN scope
+func newDelegate(fn, x5u, pk, c string) (*delegate, error) {
+	p, err := parsePrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := certificateKeyMatch(c, p)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := tnauthlist.FromCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	if len(scope) == len(scope.SPCs()) {
+		return nil, fmt.Errorf("certificate TNAuthList does not contain any TN or TN range")
+	}
+	return &delegate{filename: fn, x5u: x5u, privateKey: p, certificate: []byte(c), scope: scope, notAfter: cert.NotAfter}, nil
+}
@@ -0,0 +1,221 @@
+package signcredentials
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"io/ioutil"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+)
+
+// globals
+var (
+	stagedRotation		bool
+	retiringPeriod		time.Duration
+)
+
+// errors returned by Promote and Rollback
+var (
+	ErrNoPendingKey		= errors.New("there is no pending signing key to activate")
+	ErrNoRetiringKey	= errors.New("there is no retiring signing key to roll back to")
+)
+
+// KeyState - signing key (active, pending or retiring) as reported by the admin API
+type KeyState struct {
+	Filename			string		`json:"filename"`
+	X5u						string		`json:"x5u"`
+	Fingerprint		string		`json:"fingerprint"`								// SHA-256 (hex) of public key
+	Since					string		`json:"since,omitempty"`
+	Until					string		`json:"until,omitempty"`						// retiring key is dropped at this time
+	LastCheck			string		`json:"lastCheck,omitempty"`				// last check of pending key at its x5u
+	LastError			string		`json:"lastError,omitempty"`
+}
+
+// RotationState - state of signing key rotation
+type RotationState struct {
+	Staged				bool				`json:"staged"`
+	Active				*KeyState		`json:"active,omitempty"`
+	Pending				*KeyState		`json:"pending,omitempty"`
+	Retiring			*KeyState		`json:"retiring,omitempty"`
+}
+
+// rotation - staged rotation state of signing credentials. New credentials fetched from EKS are
+// "pending" until the certificate at their x5u is fetchable and matches their private key. They
+// are then "active" and the previously active credentials are "retiring" (still published by the
+// built-in STI-CR, and available for rollback) for the retiring period
+type rotation struct {
+	activeSince			time.Time
+	pending					*credential
+	pendingSince		time.Time
+	pendingCheck		time.Time
+	pendingError		string
+	retiring				*credential
+	retiringUntil		time.Time
+	rejected				string				// identity of credentials rolled back, not staged again until EKS changes
+}
+
+// SetRotationPolicy enables (or disables) staged rotation of signing credentials fetched from EKS.
+// Retiring credentials are kept for period r after new credentials are activated.
+// MUST be called before signing credentials objects are created
+func SetRotationPolicy(staged bool, r time.Duration) {
+	stagedRotation = staged
+	retiringPeriod = r
+}
+
+// keyFingerprint returns SHA-256 (hex) of public key of p
+func keyFingerprint(p *ecdsa.PrivateKey) string {
+	if p == nil {
+		return ""
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&p.PublicKey)
+	return fmt.Sprintf("%x", sha256.Sum256(der))
+}
+
+// identity of credentials - a change in key or x5u is a rotation
+func identity(x5u string, p *ecdsa.PrivateKey) string {
+	return keyFingerprint(p) + " " + x5u
+}
+
+// stage credentials c fetched from EKS. Caller MUST hold the lock
+func (sc *SigningCredentials) stage(c *credential) {
+	id := identity(c.x5u, c.privateKey)
+	switch {
+	case id == identity(sc.x5u, sc.privateKey) :
+		// no rotation (or rotation withdrawn in EKS)
+		sc.certificate = c.certificate
+		if sc.pending != nil {
+			logInfo("type", "signingKeyRotation", "module", "stage", "message", "pending signing key withdrawn", "x5u", sc.pending.x5u)
+		}
+		sc.pending, sc.rejected = nil, ""
+	case id == sc.rejected :
+		// rolled back; EKS still has the rejected credentials
+	case sc.pending != nil && id == identity(sc.pending.x5u, sc.pending.privateKey) :
+		sc.pending.certificate = c.certificate
+	case c.x5u == sc.x5u :
+		// the certificate at x5u is the active one (also as served by the built-in STI-CR), so the new key
+		// can never be found published there. It is reported as pending but is not checked
+		sc.pending, sc.pendingSince, sc.pendingCheck = c, time.Now(), time.Time{}
+		sc.pendingError = fmt.Sprintf("x5u %v of new signing key is the x5u of the active signing key - new signing key MUST be published under another filename (or activated by admin)", c.x5u)
+		logError("type", "signingKeyRotation", "module", "stage", "message", "new signing key is not staged", "x5u", c.x5u, "fingerprint", keyFingerprint(c.privateKey), "reason", sc.pendingError)
+	default:
+		sc.pending, sc.pendingSince, sc.pendingCheck, sc.pendingError = c, time.Now(), time.Time{}, ""
+		logInfo("type", "signingKeyRotation", "module", "stage", "message", "new signing key is pending", "x5u", c.x5u, "fingerprint", keyFingerprint(c.privateKey))
+	}
+	if sc.retiring != nil && time.Now().After(sc.retiringUntil) {
+		logInfo("type", "signingKeyRotation", "module", "stage", "message", "retiring signing key dropped", "x5u", sc.retiring.x5u)
+		sc.retiring = nil
+	}
+}
+
+// checkPending activates pending credentials if the certificate at their x5u matches their private key
+// pending credentials with the x5u of the active ones are not checked (see stage)
+func (sc *SigningCredentials) checkPending() {
+	sc.RLock()
+	p := sc.pending
+	x5u := sc.x5u
+	sc.RUnlock()
+	if p == nil || p.x5u == x5u {
+		return
+	}
+	err := checkPublished(p)
+	sc.Lock()
+	defer sc.Unlock()
+	if sc.pending != p {
+		// pending credentials changed (or were activated) meanwhile
+		return
+	}
+	sc.pendingCheck = time.Now()
+	if err != nil {
+		sc.pendingError = err.Error()
+		logInfo("type", "signingKeyRotation", "module", "checkPending", "message", "pending signing key is not activated", "x5u", p.x5u, "reason", sc.pendingError)
+		return
+	}
+	sc.promote("certificate at x5u matches pending signing key")
+}
+
+// checkPublished returns an error unless the certificate at x5u of c is valid and matches private key of c
+func checkPublished(c *credential) error {
+	resp, err := httpClient.Get(c.x5u)
+	if err != nil {
+		return fmt.Errorf("%v - GET %v failed", err, c.x5u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v response status - %v", c.x5u, resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%v - GET %v response body", err, c.x5u)
+	}
+	cert, err := certificateKeyMatch(string(b), c.privateKey)
+	if err != nil {
+		return fmt.Errorf("%v - certificate at %v", err, c.x5u)
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate at %v is not valid at this time (notBefore %v, notAfter %v)", c.x5u, cert.NotBefore, cert.NotAfter)
+	}
+	return nil
+}
+
+// promote pending credentials to active. Caller MUST hold the lock
+func (sc *SigningCredentials) promote(reason string) {
+	sc.retiring = &credential{filename: sc.filename, x5u: sc.x5u, privateKey: sc.privateKey, certificate: sc.certificate}
+	sc.retiringUntil = time.Now().Add(retiringPeriod)
+	p := sc.pending
+	sc.x5u, sc.privateKey, sc.filename, sc.certificate = p.x5u, p.privateKey, p.filename, p.certificate
+	sc.activeSince, sc.pending, sc.rejected = time.Now(), nil, ""
+	logInfo("type", "signingKeyRotation", "module", "promote", "message", "pending signing key activated", "reason", reason, "x5u", sc.x5u, "retiring", sc.retiring.x5u)
+}
+
+// Promote activates pending credentials without checking their x5u (forced rotation)
+func (sc *SigningCredentials) Promote() error {
+	sc.Lock()
+	defer sc.Unlock()
+	if sc.pending == nil {
+		return ErrNoPendingKey
+	}
+	sc.promote("forced by admin")
+	return nil
+}
+
+// Rollback reactivates retiring credentials. The credentials rolled back are not staged
+// again until different credentials are stored in EKS
+func (sc *SigningCredentials) Rollback() error {
+	sc.Lock()
+	defer sc.Unlock()
+	if sc.retiring == nil {
+		return ErrNoRetiringKey
+	}
+	sc.rejected = identity(sc.x5u, sc.privateKey)
+	logInfo("type", "signingKeyRotation", "module", "Rollback", "message", "signing key rolled back", "x5u", sc.retiring.x5u, "rejected", sc.x5u)
+	r := sc.retiring
+	sc.x5u, sc.privateKey, sc.filename, sc.certificate = r.x5u, r.privateKey, r.filename, r.certificate
+	sc.activeSince, sc.pending, sc.retiring = time.Now(), nil, nil
+	return nil
+}
+
+// RotationState returns the active, pending and retiring signing keys
+func (sc *SigningCredentials) RotationState() RotationState {
+	sc.RLock()
+	defer sc.RUnlock()
+	s := RotationState{Staged: stagedRotation && !sc.managed()}
+	if sc.privateKey != nil {
+		s.Active = &KeyState{Filename: sc.filename, X5u: sc.x5u, Fingerprint: keyFingerprint(sc.privateKey), Since: timestamp(sc.activeSince)}
+	}
+	if p := sc.pending; p != nil {
+		s.Pending = &KeyState{Filename: p.filename, X5u: p.x5u, Fingerprint: keyFingerprint(p.privateKey), Since: timestamp(sc.pendingSince), LastCheck: timestamp(sc.pendingCheck), LastError: sc.pendingError}
+	}
+	if r := sc.retiring; r != nil {
+		s.Retiring = &KeyState{Filename: r.filename, X5u: r.x5u, Fingerprint: keyFingerprint(r.privateKey), Until: timestamp(sc.retiringUntil)}
+	}
+	return s
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,154 @@
+package signcredentials
+
+import (
+	"testing"
+	"time"
+	"strings"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"encoding/pem"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// signing credentials published under filename fn at STI-CR u
+func testCredential(t *testing.T, u, fn string) *credential {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "SHAKEN 1234"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return &credential{filename: fn, x5u: u + "/" + fn, privateKey: k, certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func TestStagedRotation(t *testing.T) {
+	published := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := published[r.URL.Path[1:]]; ok {
+			w.Write(c)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	glogger = kitlog.NewNopLogger()
+	httpClient = srv.Client()
+	SetRotationPolicy(true, time.Hour)
+	defer SetRotationPolicy(false, 0)
+
+	old, next := testCredential(t, srv.URL, "old.pem"), testCredential(t, srv.URL, "new.pem")
+	sc := &SigningCredentials{eksPath: "secret/signing/data", filename: old.filename, x5u: old.x5u, privateKey: old.privateKey, certificate: old.certificate}
+
+	// new credentials in EKS; x5u not published yet
+	sc.Lock()
+	sc.stage(next)
+	sc.Unlock()
+	sc.checkPending()
+	if x5u, _ := sc.Signing(); x5u != old.x5u {
+		t.Fatalf("signing with %v before new x5u is published", x5u)
+	}
+	if s := sc.RotationState(); s.Pending == nil || len(s.Pending.LastError) == 0 {
+		t.Fatalf("pending key not reported with check error: %+v", s)
+	}
+	if sc.Certificate("new.pem") == nil {
+		t.Errorf("certificate of pending key not served by built-in STI-CR")
+	}
+	// published certificate does not match pending key
+	published["new.pem"] = old.certificate
+	sc.checkPending()
+	if x5u, _ := sc.Signing(); x5u != old.x5u {
+		t.Fatalf("pending key activated though certificate at x5u does not match")
+	}
+	// published
+	published["new.pem"] = next.certificate
+	sc.checkPending()
+	if x5u, _ := sc.Signing(); x5u != next.x5u {
+		t.Fatalf("pending key not activated after x5u is published")
+	}
+	if s := sc.RotationState(); s.Pending != nil || s.Retiring == nil || s.Retiring.X5u != old.x5u {
+		t.Fatalf("unexpected rotation state after activation: %+v", s)
+	}
+	// roll back; EKS still has new credentials, which are not staged again
+	if err := sc.Rollback(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	sc.Lock()
+	sc.stage(next)
+	sc.Unlock()
+	if x5u, _ := sc.Signing(); x5u != old.x5u || sc.RotationState().Pending != nil {
+		t.Fatalf("rolled back key staged again")
+	}
+	if err := sc.Rollback(); err != ErrNoRetiringKey {
+		t.Errorf("Rollback() = %v; want %v", err, ErrNoRetiringKey)
+	}
+	// other credentials in EKS; forced activation
+	other := testCredential(t, srv.URL, "other.pem")
+	sc.Lock()
+	sc.stage(other)
+	sc.Unlock()
+	if err := sc.Promote(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if x5u, _ := sc.Signing(); x5u != other.x5u {
+		t.Fatalf("Promote() did not activate pending key")
+	}
+	if err := sc.Promote(); err != ErrNoPendingKey {
+		t.Errorf("Promote() = %v; want %v", err, ErrNoPendingKey)
+	}
+}
+
+func TestStagedRotationSameX5u(t *testing.T) {
+	published := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := published[r.URL.Path[1:]]; ok {
+			w.Write(c)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	glogger = kitlog.NewNopLogger()
+	httpClient = srv.Client()
+	SetRotationPolicy(true, time.Hour)
+	defer SetRotationPolicy(false, 0)
+
+	// new key stored in EKS under the filename (x5u) of the active key
+	old, next := testCredential(t, srv.URL, "sp.pem"), testCredential(t, srv.URL, "sp.pem")
+	published["sp.pem"] = old.certificate
+	sc := &SigningCredentials{eksPath: "secret/signing/data", filename: old.filename, x5u: old.x5u, privateKey: old.privateKey, certificate: old.certificate}
+	sc.Lock()
+	sc.stage(next)
+	sc.Unlock()
+	s := sc.RotationState()
+	if s.Pending == nil || !strings.Contains(s.Pending.LastError, "is the x5u of the active signing key") {
+		t.Fatalf("new key with x5u of active key not reported: %+v", s.Pending)
+	}
+	// not checked, even once the new certificate is published
+	published["sp.pem"] = next.certificate
+	sc.checkPending()
+	if x5u, k := sc.Signing(); x5u != old.x5u || k != old.privateKey {
+		t.Fatalf("new key with x5u of active key activated")
+	}
+	if s := sc.RotationState(); len(s.Pending.LastCheck) > 0 || !strings.Contains(s.Pending.LastError, "another filename") {
+		t.Errorf("pending key checked or error cleared: %+v", s.Pending)
+	}
+	if c := sc.Certificate("sp.pem"); string(c) != string(old.certificate) {
+		t.Errorf("certificate of active key not served")
+	}
+	// activated by admin
+	if err := sc.Promote(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, k := sc.Signing(); k != next.privateKey {
+		t.Errorf("Promote() did not activate new key")
+	}
+}
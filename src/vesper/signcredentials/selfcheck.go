@@ -0,0 +1,209 @@
+package signcredentials
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"io/ioutil"
+	"encoding/pem"
+	"crypto/x509"
+)
+
+// globals
+var (
+	selfCheckRoots				func() *x509.CertPool		// nil - self-check is disabled
+	selfCheckMinLifetime	time.Duration
+	selfCheckAlert				func(SelfCheck)
+)
+
+// SelfCheck - result of a self-check of signing credentials: the certificate at the advertised
+// x5u is fetched, its chain is validated against the root certs and its public key is compared
+// with the private key used for signing
+type SelfCheck struct {
+	X5u						string			`json:"x5u"`
+	Time					string			`json:"time"`
+	NotAfter			string			`json:"notAfter,omitempty"`
+	Problems			[]string		`json:"problems,omitempty"`
+}
+
+// Ok returns true if the self-check found no problem
+func (s SelfCheck) Ok() bool {
+	return len(s.Problems) == 0
+}
+
+// SetSelfCheck enables self-check of signing credentials (and of delegate certificates) when they are
+// created or set and at each refresh. roots returns the root certs the certificate chain at x5u is
+// validated against. A certificate that expires within minLifetime is reported as a problem. alert
+// (if not nil) is called whenever the problems found change, including when they are resolved.
+// Signing credentials created before self-check is enabled are checked at their next refresh, or by RunSelfCheck
+func SetSelfCheck(roots func() *x509.CertPool, minLifetime time.Duration, alert func(SelfCheck)) {
+	selfCheckRoots = roots
+	selfCheckMinLifetime = minLifetime
+	selfCheckAlert = alert
+}
+
+// SelfCheckState returns the result of the last self-check (nil if there was none)
+func (sc *SigningCredentials) SelfCheckState() *SelfCheck {
+	sc.RLock()
+	defer sc.RUnlock()
+	if sc.selfCheck == nil {
+		return nil
+	}
+	s := *sc.selfCheck
+	return &s
+}
+
+// DelegateSelfChecks returns the results of the last self-check of delegate certificates
+func (sc *SigningCredentials) DelegateSelfChecks() []SelfCheck {
+	sc.RLock()
+	defer sc.RUnlock()
+	return append([]SelfCheck(nil), sc.delegateChecks...)
+}
+
+// RunSelfCheck checks the signing credentials and delegate certificates now, if self-check is enabled
+func (sc *SigningCredentials) RunSelfCheck() {
+	sc.runSelfCheck()
+}
+
+// runSelfCheck checks the signing credentials and delegate certificates and saves the results. Problems
+// are logged as critical errors on every check; the alert is raised only when they change
+func (sc *SigningCredentials) runSelfCheck() {
+	if selfCheckRoots == nil {
+		return
+	}
+	sc.RLock()
+	var c *credential
+	if sc.privateKey != nil {
+		// managed signing credentials are not checked until they are set
+		c = &credential{filename: sc.filename, x5u: sc.x5u, privateKey: sc.privateKey}
+	}
+	var delegates []*credential
+	for _, d := range sc.delegates {
+		delegates = append(delegates, &credential{filename: d.filename, x5u: d.x5u, privateKey: d.privateKey})
+	}
+	sc.RUnlock()
+	if c == nil && len(delegates) == 0 {
+		return
+	}
+	roots := selfCheckRoots()
+	now := time.Now()
+	var s *SelfCheck
+	if c != nil {
+		r := checkCredential(c, roots, selfCheckMinLifetime, now)
+		s = &r
+	}
+	var ds []SelfCheck
+	for _, d := range delegates {
+		ds = append(ds, checkCredential(d, roots, selfCheckMinLifetime, now))
+	}
+	sc.Lock()
+	prev := make(map[string]SelfCheck)
+	if sc.selfCheck != nil {
+		prev[sc.selfCheck.X5u] = *sc.selfCheck
+	}
+	for _, p := range sc.delegateChecks {
+		prev[p.X5u] = p
+	}
+	if s != nil {
+		sc.selfCheck = s
+	}
+	sc.delegateChecks = ds
+	sc.Unlock()
+	if s != nil {
+		reportSelfCheck(*s, prev)
+	}
+	for _, d := range ds {
+		reportSelfCheck(d, prev)
+	}
+}
+
+// reportSelfCheck logs the problems found by self-check s and raises the alert if they changed
+// since the previous self-check of the same x5u in prev
+func reportSelfCheck(s SelfCheck, prev map[string]SelfCheck) {
+	for _, p := range s.Problems {
+		logCritical("type", "signingSelfCheck", "module", "runSelfCheck", "x5u", s.X5u, "message", p)
+	}
+	p, ok := prev[s.X5u]
+	changed := !ok && !s.Ok() || ok && strings.Join(p.Problems, "\n") != strings.Join(s.Problems, "\n")
+	if changed {
+		if s.Ok() {
+			logInfo("type", "signingSelfCheck", "module", "runSelfCheck", "x5u", s.X5u, "message", "signing credentials self-check problems resolved")
+		}
+		if selfCheckAlert != nil {
+			selfCheckAlert(s)
+		}
+	}
+}
+
+// checkCredential fetches the certificate chain at x5u of c and reports every problem found:
+// x5u not fetchable, public key not matching private key of c, chain not valid against roots,
+// certificate expired or expiring within minLifetime (at time now)
+func checkCredential(c *credential, roots *x509.CertPool, minLifetime time.Duration, now time.Time) SelfCheck {
+	s := SelfCheck{X5u: c.x5u, Time: timestamp(now)}
+	certs, err := fetchChain(c.x5u)
+	if err != nil {
+		s.Problems = append(s.Problems, err.Error())
+		return s
+	}
+	leaf := certs[0]
+	s.NotAfter = timestamp(leaf.NotAfter)
+	if _, err := certificateKeyMatch(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})), c.privateKey); err != nil {
+		s.Problems = append(s.Problems, fmt.Sprintf("%v - certificate at %v", err, c.x5u))
+	}
+	if roots == nil {
+		s.Problems = append(s.Problems, fmt.Sprintf("certificate chain at %v not validated - no root certs", c.x5u))
+	} else {
+		intermediates := x509.NewCertPool()
+		for _, ic := range certs[1:] {
+			intermediates.AddCert(ic)
+		}
+		// validity is checked against current time; remaining lifetime is reported separately
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+		if _, err := leaf.Verify(opts); err != nil {
+			s.Problems = append(s.Problems, fmt.Sprintf("%v - certificate chain at %v", err, c.x5u))
+		}
+	}
+	switch {
+	case now.After(leaf.NotAfter) :
+		s.Problems = append(s.Problems, fmt.Sprintf("certificate at %v expired at %v", c.x5u, s.NotAfter))
+	case leaf.NotAfter.Sub(now) < minLifetime :
+		s.Problems = append(s.Problems, fmt.Sprintf("certificate at %v expires at %v (in %v)", c.x5u, s.NotAfter, leaf.NotAfter.Sub(now).Round(time.Minute)))
+	}
+	return s
+}
+
+// fetchChain returns the certificates (leaf first) in PEM data at x5u
+func fetchChain(x5u string) ([]*x509.Certificate, error) {
+	resp, err := httpClient.Get(x5u)
+	if err != nil {
+		return nil, fmt.Errorf("%v - GET %v failed", err, x5u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %v response status - %v", x5u, resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%v - GET %v response body", err, x5u)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%v - certificate at %v", err, x5u)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in PEM data at %v", x5u)
+	}
+	return certs, nil
+}
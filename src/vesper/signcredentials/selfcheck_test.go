@@ -0,0 +1,138 @@
+package signcredentials
+
+import (
+	"testing"
+	"time"
+	"strings"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"encoding/pem"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// CA certificate and key
+func testCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "STI-CA"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(10*24*time.Hour), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	return ca, k
+}
+
+// PEM certificate for key k issued by ca, valid for d
+func testLeaf(t *testing.T, ca *x509.Certificate, caKey, k *ecdsa.PrivateKey, d time.Duration) []byte {
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "SHAKEN 1234"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(d)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &k.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestSelfCheck(t *testing.T) {
+	var published []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(published)
+	}))
+	defer srv.Close()
+	glogger = kitlog.NewNopLogger()
+	httpClient = srv.Client()
+	ca, caKey := testCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	var alerts []SelfCheck
+	SetSelfCheck(func() *x509.CertPool { return roots }, 7*24*time.Hour, func(s SelfCheck) { alerts = append(alerts, s) })
+	defer SetSelfCheck(nil, 0, nil)
+
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	sc := &SigningCredentials{eksPath: DefaultEksPath, filename: "cert.pem", x5u: srv.URL + "/cert.pem", privateKey: k}
+
+	published = testLeaf(t, ca, caKey, k, 30*24*time.Hour)
+	sc.runSelfCheck()
+	if s := sc.SelfCheckState(); s == nil || !s.Ok() || len(alerts) != 0 {
+		t.Fatalf("self-check of matching certificate: %+v (%v alerts)", s, len(alerts))
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	published = testLeaf(t, ca, caKey, other, 30*24*time.Hour)
+	sc.runSelfCheck()
+	if s := sc.SelfCheckState(); s.Ok() || !strings.Contains(s.Problems[0], "does not match") || len(alerts) != 1 {
+		t.Errorf("self-check of mismatched certificate: %+v (%v alerts)", s, len(alerts))
+	}
+	// same problem again; no new alert
+	sc.runSelfCheck()
+	if len(alerts) != 1 {
+		t.Errorf("alert raised again for unchanged problem")
+	}
+
+	published = testLeaf(t, ca, caKey, k, 2*24*time.Hour)
+	sc.runSelfCheck()
+	if s := sc.SelfCheckState(); len(s.Problems) != 1 || !strings.Contains(s.Problems[0], "expires") {
+		t.Errorf("self-check of certificate near expiry: %+v", s)
+	}
+
+	untrusted, untrustedKey := testCA(t)
+	published = append(testLeaf(t, untrusted, untrustedKey, k, 30*24*time.Hour), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: untrusted.Raw})...)
+	sc.runSelfCheck()
+	if s := sc.SelfCheckState(); len(s.Problems) != 1 || !strings.Contains(s.Problems[0], "certificate chain") {
+		t.Errorf("self-check of certificate not chaining to root certs: %+v", s)
+	}
+
+	published = testLeaf(t, ca, caKey, k, 30*24*time.Hour)
+	sc.runSelfCheck()
+	if s := sc.SelfCheckState(); !s.Ok() || len(alerts) != 4 || !alerts[3].Ok() {
+		t.Errorf("resolved problems not alerted: %+v (%v alerts)", s, len(alerts))
+	}
+}
+
+func TestSelfCheckDelegates(t *testing.T) {
+	published := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(published[r.URL.Path[1:]])
+	}))
+	defer srv.Close()
+	glogger = kitlog.NewNopLogger()
+	httpClient = srv.Client()
+	ca, caKey := testCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	var alerts []SelfCheck
+	SetSelfCheck(func() *x509.CertPool { return roots }, 7*24*time.Hour, func(s SelfCheck) { alerts = append(alerts, s) })
+	defer SetSelfCheck(nil, 0, nil)
+
+	dk, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	published["d1.pem"] = testLeaf(t, ca, caKey, dk, 30*24*time.Hour)
+	published["d2.pem"] = testLeaf(t, ca, caKey, other, 30*24*time.Hour)
+	// managed signing credentials not set yet; delegate certificates are checked
+	sc := &SigningCredentials{delegates: []delegate{
+		{filename: "d1.pem", x5u: srv.URL + "/d1.pem", privateKey: dk},
+		{filename: "d2.pem", x5u: srv.URL + "/d2.pem", privateKey: dk},
+	}}
+	sc.RunSelfCheck()
+	ds := sc.DelegateSelfChecks()
+	if sc.SelfCheckState() != nil || len(ds) != 2 || !ds[0].Ok() || ds[1].Ok() || !strings.Contains(ds[1].Problems[0], "does not match") {
+		t.Fatalf("self-check of delegate certificates: %+v", ds)
+	}
+	if len(alerts) != 1 || alerts[0].X5u != srv.URL + "/d2.pem" {
+		t.Errorf("got %v alerts, want 1 for d2.pem", len(alerts))
+	}
+	// resolved
+	published["d2.pem"] = testLeaf(t, ca, caKey, dk, 30*24*time.Hour)
+	sc.RunSelfCheck()
+	if ds := sc.DelegateSelfChecks(); !ds[1].Ok() || len(alerts) != 2 || !alerts[1].Ok() {
+		t.Errorf("resolved problem of delegate certificate not alerted: %+v (%v alerts)", ds, len(alerts))
+	}
+}
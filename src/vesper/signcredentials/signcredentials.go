@@ -21,46 +21,160 @@ var (
 	softwareVersion		string
 	httpClient				*http.Client
 	eksCredentials		*eks.EksCredentials
-	certRepo					*sticr.SticrHost
 )
 
+// DefaultEksPath - EKS path (relative to the EKS owner path) from which signing credentials are fetched
+const DefaultEksPath = "secret/signing/data"
+
 // SigningCredentials - structure that holds all root certs
 type SigningCredentials struct {
 	sync.RWMutex	// A field declared with a type but no explicit field name is an
 					// anonymous field, also called an embedded field or an embedding of
 					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	eksPath			string
+	delegatesPath	string
+	certRepo		*sticr.SticrHost
 	x5u					string
 	privateKey	*ecdsa.PrivateKey
+	filename		string
+	certificate	[]byte			// PEM (certificate chain), if stored in EKS along with private key
+	delegates		[]delegate
+	rotation
+	selfCheck		*SelfCheck		// last self-check of x5u (selfcheck.go)
+	delegateChecks	[]SelfCheck	// last self-check of delegate certificates
+}
+
+// credential - signing credentials as stored in EKS
+type credential struct {
+	filename		string
+	x5u					string
+	privateKey	*ecdsa.PrivateKey
+	certificate	[]byte
 }
   
 // Initialize object
-func InitObject(l kitlog.Logger, v string, h *http.Client, ek *eks.EksCredentials, cr *sticr.SticrHost) (*SigningCredentials, error) {
+// dp is the EKS path for delegate certificates; delegate certificates are not used if it is an empty string
+func InitObject(l kitlog.Logger, v string, h *http.Client, ek *eks.EksCredentials, cr *sticr.SticrHost, dp string) (*SigningCredentials, error) {
 	glogger = l
 	softwareVersion = v
 	httpClient = h
 	eksCredentials = ek
-	certRepo = cr
-	sc := new(SigningCredentials)
-	var err error
-	sc.x5u, sc.privateKey, err = getSigningCredentialsFromEks()
+	return NewObject(DefaultEksPath, dp, cr)
+}
+
+// Initialize object for signing credentials that are not stored in EKS but set by their
+// manager (e.g. ACME client) using Set. Delegate certificates are still fetched from EKS
+func InitManagedObject(l kitlog.Logger, v string, h *http.Client, ek *eks.EksCredentials, cr *sticr.SticrHost, dp string) (*SigningCredentials, error) {
+	glogger = l
+	softwareVersion = v
+	httpClient = h
+	eksCredentials = ek
+	sc := &SigningCredentials{delegatesPath: strings.Trim(dp, "/"), certRepo: cr}
+	if len(sc.delegatesPath) > 0 {
+		d, err := getDelegatesFromEks(sc.delegatesPath, sc.certRepo)
+		if err != nil {
+			return nil, err
+		}
+		sc.delegates = d
+	}
+	go sc.runSelfCheck()
+	return sc, nil
+}
+
+// Create object for signing credentials stored at EKS path p (and delegate certificates
+// stored at EKS path dp, if not empty). The x5u is built using the sticr host in cr.
+// InitObject MUST be called before this function
+func NewObject(p, dp string, cr *sticr.SticrHost) (*SigningCredentials, error) {
+	sc := &SigningCredentials{eksPath: strings.Trim(p, "/"), delegatesPath: strings.Trim(dp, "/"), certRepo: cr}
+	c, err := getSigningCredentialsFromEks(sc.eksPath, sc.certRepo)
 	if err != nil {
 		return nil, err
 	}
+	sc.x5u, sc.privateKey, sc.filename, sc.certificate = c.x5u, c.privateKey, c.filename, c.certificate
+	sc.activeSince = time.Now()
+	if len(sc.delegatesPath) > 0 {
+		sc.delegates, err = getDelegatesFromEks(sc.delegatesPath, sc.certRepo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	go sc.runSelfCheck()
 	return sc, nil
 }
 
-// fetch rootcerts from eks
+// fetch signing credentials (and delegate certificates) from eks
+// cached delegate certificates are retained if they cannot be fetched
+// with staged rotation, new signing credentials are activated only once their x5u is published
+// the (active) signing credentials are then self-checked, if enabled
 func (sc *SigningCredentials) FetchSigningCredentialsFromEks() error {
-	x, p, err := getSigningCredentialsFromEks()
+	err := sc.fetch()
+	if stagedRotation && !sc.managed() {
+		sc.checkPending()
+	}
+	sc.runSelfCheck()
+	return err
+}
+
+func (sc *SigningCredentials) fetch() error {
+	var c *credential
+	var err error
+	if !sc.managed() {
+		c, err = getSigningCredentialsFromEks(sc.eksPath, sc.certRepo)
+	}
+	var d []delegate
+	var derr error
+	if len(sc.delegatesPath) > 0 {
+		d, derr = getDelegatesFromEks(sc.delegatesPath, sc.certRepo)
+	}
 	sc.Lock()
 	defer sc.Unlock()
-	if err == nil {
-		sc.x5u = x
-		sc.privateKey = p
+	switch {
+	case sc.managed() :
+		// managed signing credentials are not replaced; x5u is rebuilt in case sticr host has changed
+		if len(sc.filename) > 0 {
+			sc.x5u = sc.certRepo.GetSticrHost() + "/" + sc.filename
+		}
+	case err == nil && stagedRotation :
+		sc.stage(c)
+	case err == nil :
+		if identity(c.x5u, c.privateKey) != identity(sc.x5u, sc.privateKey) {
+			sc.activeSince = time.Now()
+		}
+		sc.x5u, sc.privateKey, sc.filename, sc.certificate = c.x5u, c.privateKey, c.filename, c.certificate
+	}
+	if len(sc.delegatesPath) > 0 && derr == nil {
+		sc.delegates = d
+	}
+	if err == nil && derr != nil {
+		return derr
 	}
 	return err
 }
 
+// returns true if signing credentials are set by their manager instead of fetched from EKS
+func (sc *SigningCredentials) managed() bool {
+	return len(sc.eksPath) == 0
+}
+
+// Set replaces signing credentials with private key p and certificate (chain) c in PEM,
+// published under filename fn. Only managed signing credentials (InitManagedObject) can be set
+func (sc *SigningCredentials) Set(fn string, p *ecdsa.PrivateKey, c []byte) error {
+	if !sc.managed() {
+		return fmt.Errorf("signing credentials are fetched from EKS path %v and cannot be set", sc.eksPath)
+	}
+	if len(strings.TrimSpace(fn)) == 0 {
+		return fmt.Errorf("filename of signing certificate is an empty string")
+	}
+	if _, err := certificateKeyMatch(string(c), p); err != nil {
+		return err
+	}
+	sc.Lock()
+	sc.x5u, sc.privateKey, sc.filename, sc.certificate = sc.certRepo.GetSticrHost() + "/" + fn, p, fn, c
+	sc.activeSince = time.Now()
+	sc.Unlock()
+	go sc.runSelfCheck()
+	return nil
+}
 
 // using Lock() ensures all RLocks() are blocked when alerts are being updated
 func (sc *SigningCredentials) Signing() (string, *ecdsa.PrivateKey) {
@@ -69,23 +183,160 @@ func (sc *SigningCredentials) Signing() (string, *ecdsa.PrivateKey) {
 	return sc.x5u, sc.privateKey
 }
 
-func getSigningCredentialsFromEks() (string, *ecdsa.PrivateKey, error) {
-	// Request root certs from EKS
+// SigningForTN returns the x5u and private key of the most specific delegate
+// certificate whose TNAuthList covers originating TN tn. The SPC level
+// credentials are returned if no delegate certificate covers tn
+func (sc *SigningCredentials) SigningForTN(tn string) (string, *ecdsa.PrivateKey) {
+	sc.RLock()
+	defer sc.RUnlock()
+	if d := selectDelegate(sc.delegates, tn, time.Now()); d != nil {
+		return d.x5u, d.privateKey
+	}
+	return sc.x5u, sc.privateKey
+}
+
+// Certificate returns the certificate (PEM) published under filename fn, either of the signing
+// credentials (active, pending or retiring) or of a delegate certificate. nil is returned if there is no such certificate
+func (sc *SigningCredentials) Certificate(fn string) []byte {
+	sc.RLock()
+	defer sc.RUnlock()
+	if sc.filename == fn && len(sc.certificate) > 0 {
+		return sc.certificate
+	}
+	for _, c := range []*credential{sc.pending, sc.retiring} {
+		if c != nil && c.filename == fn && len(c.certificate) > 0 {
+			return c.certificate
+		}
+	}
+	for _, d := range sc.delegates {
+		if d.filename == fn {
+			return d.certificate
+		}
+	}
+	return nil
+}
+
+// Filenames returns the filenames under which certificates of the signing credentials (active,
+// pending or retiring) and of delegate certificates are published, i.e. served by Certificate
+func (sc *SigningCredentials) Filenames() []string {
+	sc.RLock()
+	defer sc.RUnlock()
+	var l []string
+	if len(sc.filename) > 0 && len(sc.certificate) > 0 {
+		l = append(l, sc.filename)
+	}
+	for _, c := range []*credential{sc.pending, sc.retiring} {
+		if c != nil && len(c.filename) > 0 && len(c.certificate) > 0 {
+			l = append(l, c.filename)
+		}
+	}
+	for _, d := range sc.delegates {
+		l = append(l, d.filename)
+	}
+	return l
+}
+
+func getSigningCredentialsFromEks(path string, certRepo *sticr.SticrHost) (*credential, error) {
+	// Request signing credentials from EKS
+	s, url, status, err := getEksSecret(path, "getSigningCredentialsFromEks")
+	if err != nil {
+		return nil, err
+	}
+	c := new(credential)
+	var pk string
+	// s contains
+	// x5u
+	if r2, ok := s["filename"]; ok {
+		switch r2.(type) {
+		case string:
+			c.filename = s["filename"].(string)
+			c.x5u = certRepo.GetSticrHost() + "/" + c.filename
+		default:
+			return nil, fmt.Errorf("GET %v response status - %v; \"filename\" field MUST be a string in %+v returned by EKS", url, status, s)
+		}
+	} else {
+		return nil, fmt.Errorf("GET %v response status - %v; \"filename\" field missing in in %+v returned by EKS", url, status, s)
+	}
+	// privateKey
+	if r2, ok := s["privateKey"]; ok {
+		switch r2.(type) {
+		case string:
+			pk = s["privateKey"].(string)
+		default:
+			return nil, fmt.Errorf("GET %v response status - %v; \"privateKey\" field MUST be a string in %+v returned by EKS", url, status, s)
+		}
+	} else {
+		return nil, fmt.Errorf("GET %v response status - %v; \"privateKey\" field missing in in %+v returned by EKS", url, status, s)	
+	}
+	c.privateKey, err = parsePrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	// certificate (optional) - published by built-in STI-CR
+	if r2, ok := s["certificate"]; ok {
+		switch r2.(type) {
+		case string:
+			if _, err := certificateKeyMatch(s["certificate"].(string), c.privateKey); err != nil {
+				return nil, fmt.Errorf("GET %v response status - %v; %v - \"certificate\" field returned by EKS", url, status, err)
+			}
+			c.certificate = []byte(s["certificate"].(string))
+		default:
+			return nil, fmt.Errorf("GET %v response status - %v; \"certificate\" field MUST be a string in object returned by EKS", url, status)
+		}
+	}
+	return c, nil
+}
+
+// certificateKeyMatch parses the first certificate in PEM c and returns it if its public key
+// is the public key of private key p
+func certificateKeyMatch(c string, p *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(c))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(p.PublicKey.X) != 0 || pub.Y.Cmp(p.PublicKey.Y) != 0 {
+		return nil, fmt.Errorf("private key does not match public key in certificate")
+	}
+	return cert, nil
+}
+
+// parse PEM encoded EC private key
+func parsePrivateKey(pk string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pk))
+	if block != nil {
+		// alg = ES256
+		p, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("no PEM data found")
+}
+
+// getEksSecret returns the JSON object stored at path in EKS
+// it also returns the URL and the response status, for error reporting
+func getEksSecret(path, module string) (map[string]interface{}, string, string, error) {
 	start := time.Now()
 	u, t := eksCredentials.GetEksCredentials()
-	url := u + "/v1/owner/kms.service.srv/secret/signing/data"
+	url := u + "/v1/owner/kms.service.srv/" + path
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", nil, fmt.Errorf("%v - http.NewRequest failed", err)
+		return nil, url, "", fmt.Errorf("%v - http.NewRequest failed", err)
 	}
 	authHdr := "Bearer " + t
 	req.Header.Set("Authorization", authHdr)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return "", nil, fmt.Errorf("%v - GET %v failed", err, url)
+		return nil, url, "", fmt.Errorf("%v - GET %v failed", err, url)
 	}
 	defer resp.Body.Close()
-	logInfo("type", "eksResponseTime", "module", "getSigningCredentialsFromEks", "eksResponseTime", fmt.Sprintf("%v", time.Since(start)))
+	logInfo("type", "eksResponseTime", "module", module, "eksResponseTime", fmt.Sprintf("%v", time.Since(start)))
 	var s map[string]interface{}
 	rb, err := ioutil.ReadAll(resp.Body)
 	if err == nil {
@@ -94,51 +345,17 @@ func getSigningCredentialsFromEks() (string, *ecdsa.PrivateKey, error) {
 			if strings.Contains(c, "application/json") {
 				err = json.Unmarshal(rb, &s)
 				if err != nil {
-					return "", nil, fmt.Errorf("GET %v response status - %v; unable to parse JSON object in response body (from EKS) - %v", url, resp.StatusCode, err)
+					return nil, url, resp.Status, fmt.Errorf("GET %v response status - %v; unable to parse JSON object in response body (from EKS) - %v", url, resp.StatusCode, err)
 				}
 			}
 		} else {
-			return "", nil, fmt.Errorf("GET %v response status - %v; nothing read from response body (from EKS)", url, resp.StatusCode)
+			return nil, url, resp.Status, fmt.Errorf("GET %v response status - %v; nothing read from response body (from EKS)", url, resp.StatusCode)
 		}
 	} else {
-		return "", nil, fmt.Errorf("GET %v response status - %v ; %v - response body (from EKS)", url, resp.StatusCode, err)
-	}
-	switch resp.StatusCode {
-	case 200:
-		var x, pk string
-		// s contains
-		// x5u
-		if r2, ok := s["filename"]; ok {
-			switch r2.(type) {
-			case string:
-				x = certRepo.GetSticrHost() + "/" + s["filename"].(string)
-			default:
-				return "", nil, fmt.Errorf("GET %v response status - %v; \"filename\" field MUST be a string in %+v returned by EKS", url, resp.Status, s)
-			}
-		} else {
-			return "", nil, fmt.Errorf("GET %v response status - %v; \"filename\" field missing in in %+v returned by EKS", url, resp.Status, s)
-		}
-		// privateKey
-		if r2, ok := s["privateKey"]; ok {
-			switch r2.(type) {
-			case string:
-				pk = s["privateKey"].(string)
-			default:
-				return "", nil, fmt.Errorf("GET %v response status - %v; \"privateKey\" field MUST be a string in %+v returned by EKS", url, resp.Status, s)
-			}
-		} else {
-			return "", nil, fmt.Errorf("GET %v response status - %v; \"privateKey\" field missing in in %+v returned by EKS", url, resp.Status, s)	
-		}
-		block, _ := pem.Decode([]byte(pk))
-		if block != nil {
-			// alg = ES256
-			p, err := x509.ParseECPrivateKey(block.Bytes)
-			if err != nil {
-				return "", nil, err
-			}
-			return x, p, nil
-		}
-		return "", nil, fmt.Errorf("no PEM data found")
+		return nil, url, resp.Status, fmt.Errorf("GET %v response status - %v ; %v - response body (from EKS)", url, resp.StatusCode, err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, url, resp.Status, fmt.Errorf("GET %v response status - %v; response from EKS - %+v", url, resp.StatusCode, s)
 	}
-	return "", nil, fmt.Errorf("GET %v response status - %v; response from EKS - %+v", url, resp.StatusCode, s)
-}
\ No newline at end of file
+	return s, url, resp.Status, nil
+}
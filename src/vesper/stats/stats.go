@@ -18,6 +18,9 @@ var (
 	processingTimeMoreThan1000ms int64
 	signingRequests int64
 	verificationRequests int64
+	canaryRuns int64
+	canaryFailures int64
+	lastCanaryTime int64
 )
 
 type processingTime struct {
@@ -72,6 +75,17 @@ func IncrVerificationRequestCount() {
 	verificationRequests += 1
 }
 
+// count canary run (sign and verify), taking t ms
+func UpdateCanary(ok bool, t int64) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	canaryRuns += 1
+	if !ok {
+		canaryFailures += 1
+	}
+	lastCanaryTime = t
+}
+
 // retrieve stats
 func Stats() map[string]interface{} {
 	mtx.RLock()
@@ -80,6 +94,11 @@ func Stats() map[string]interface{} {
 	resp := make(map[string]interface{})
 	resp["signingRequests"] = signingRequests
 	resp["verificationRequests"] = verificationRequests
+	if canaryRuns > 0 {
+		resp["canaryRuns"] = canaryRuns
+		resp["canaryFailures"] = canaryFailures
+		resp["lastCanaryTime"] = lastCanaryTime
+	}
 	if signingRequests > 0 || verificationRequests > 0 {
 		resp["minApiProcessingTime"] = minApiProcessingTime
 		resp["maxApiProcessingTime"] = maxApiProcessingTime
@@ -112,4 +131,7 @@ func ResetStats() {
 	processingTimeMoreThan1000ms = 0
 	signingRequests = 0
 	verificationRequests = 0
+	canaryRuns = 0
+	canaryFailures = 0
+	lastCanaryTime = 0
 }
\ No newline at end of file
@@ -1,6 +1,7 @@
 package sticr
 
 import (
+	"io"
 	"fmt"
 	"sync"
 	"os"
@@ -55,6 +56,15 @@ func InitObject(f string) (*SticrHost, error) {
 	return nil, err
 }
 
+// Create object for a sticr host that is not read from the sticr host file
+// (for example, a tenant's sticr host)
+func NewObject(h string) (*SticrHost, error) {
+	if len(strings.TrimSpace(h)) == 0 {
+		return nil, fmt.Errorf("\"sticrHost\" value(s) is an empty string")
+	}
+	return &SticrHost{sticrHost: h}, nil
+}
+
 // update sticr host value
 func (x *SticrHost) UpdateSticrHost() error {
 	h, err := readSticrHostFile(sticrFileName)
@@ -85,9 +95,28 @@ func readSticrHostFile(n string) (string, error) {
 	}
 	// We are here because the timestamp for sticr host file has changed
 	// This may mean that updates to x5u host is available
+	return parseSticrHost(f)
+}
+
+// CheckFile returns an error if sticr host file n is not valid
+func CheckFile(n string) error {
+	f, err := os.Open(n)
+	if err != nil {
+		return fmt.Errorf("%v - x5u file", err)
+	}
+	defer f.Close()
+	h, err := parseSticrHost(f)
+	if err == nil && len(strings.TrimSpace(h)) == 0 {
+		return fmt.Errorf("\"sticrHost\" value(s) is an empty string")
+	}
+	return err
+}
+
+// Decode and validate sticr host
+func parseSticrHost(r io.Reader) (string, error) {
 	var c map[string]string
-	decoder := json.NewDecoder(f)
-	err = decoder.Decode(&c)
+	decoder := json.NewDecoder(r)
+	err := decoder.Decode(&c)
 	if err != nil {
 		return "", fmt.Errorf("%v - decode JSON object in sticr host file", err)
 	}
@@ -0,0 +1,404 @@
+// Package stipa is a client of the STI-PA (policy administrator) API. It fetches SPC tokens
+// (ATIS-1000080) per OCN, used to answer ACME tkauth-01 challenges, and the STI-PA CRL
+package stipa
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"os"
+	"bytes"
+	"strings"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"math/big"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/base64"
+	"vesper/tnauthlist"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// globals
+var (
+	httpClient				*http.Client
+	credentialsFileName		string
+)
+
+// cached SPC tokens are refreshed this long before they expire
+const tokenExpiryMargin = 5 * time.Minute
+
+// credentials - STI-PA API credentials, as in credentials file
+type credentials struct {
+	Url					string		`json:"url"`					// STI-PA API base URL (e.g. https://<FQDN>/api/v1)
+	UserId			string		`json:"userId"`
+	Password		string		`json:"password"`
+	AccountId		string		`json:"accountId"`		// STI-PA account of the service provider
+}
+
+// Client - STI-PA API client
+type Client struct {
+	sync.RWMutex	// A field declared with a type but no explicit field name is an
+					// anonymous field, also called an embedded field or an embedding of
+					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	accessToken		string
+	tokens				map[string]spcToken			// key is OCN and account key fingerprint
+	crlUrl				string
+	crlIssuer			*x509.Certificate			// STI-PA certificate that signs the CRL
+	crl						*pkix.CertificateList
+	crlNumber			*big.Int							// nil if CRL has no CRL number extension
+	revoked				map[string]bool				// issuer name and serial number (hex) of certificates in CRL
+}
+
+// OIDs of CRL number extension and certificate issuer CRL entry extension (RFC 5280, 5.2.3 and 5.3.3)
+var (
+	oidCrlNumber					= asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidCertificateIssuer	= asn1.ObjectIdentifier{2, 5, 29, 29}
+)
+
+type spcToken struct {
+	token			string
+	exp				time.Time
+}
+
+// Initialize object
+// Credentials file is read at startup and again whenever Vesper logs in to STI-PA API.
+// CRL is fetched from crlUrl (if not empty) by FetchCrl, and its signature is verified using the
+// STI-PA certificate in PEM file crlIssuerFile (required with crlUrl)
+func InitObject(l kitlog.Logger, h *http.Client, f, crlUrl, crlIssuerFile string) (*Client, error) {
+	glogger = l
+	httpClient = h
+	if len(strings.TrimSpace(f)) == 0 {
+		return nil, fmt.Errorf("STI-PA credentials file name is an empty string")
+	}
+	credentialsFileName = f
+	if _, err := readCredentialsFile(credentialsFileName); err != nil {
+		return nil, err
+	}
+	c := &Client{tokens: make(map[string]spcToken), crlUrl: crlUrl}
+	if len(strings.TrimSpace(crlUrl)) > 0 {
+		if _, err := url.ParseRequestURI(crlUrl); err != nil {
+			return nil, fmt.Errorf("%v - STI-PA CRL URL", err)
+		}
+		cert, err := readCertificateFile(crlIssuerFile)
+		if err != nil {
+			return nil, err
+		}
+		c.crlIssuer = cert
+	}
+	return c, nil
+}
+
+// SpcToken returns SPC token for OCN ocn, bound to ACME account key with fingerprint fp.
+// Tokens are cached until shortly before they expire
+func (c *Client) SpcToken(ocn, fp string) (string, error) {
+	k := ocn + " " + fp
+	c.RLock()
+	t, ok := c.tokens[k]
+	c.RUnlock()
+	if ok && time.Now().Add(tokenExpiryMargin).Before(t.exp) {
+		return t.token, nil
+	}
+	tok, err := c.requestSpcToken(ocn, fp)
+	if err != nil {
+		return "", err
+	}
+	exp, err := tokenExpiry(tok)
+	if err != nil {
+		// token is returned but not cached
+		logError("type", "stipa", "module", "SpcToken", "ocn", ocn, "message", fmt.Sprintf("%v - SPC token is not cached", err))
+		return tok, nil
+	}
+	c.Lock()
+	c.tokens[k] = spcToken{token: tok, exp: exp}
+	c.Unlock()
+	logInfo("type", "stipa", "module", "SpcToken", "ocn", ocn, "message", "SPC token fetched", "exp", exp.UTC().Format(time.RFC3339))
+	return tok, nil
+}
+
+// requestSpcToken requests SPC token from STI-PA, logging in if there is no access token
+// or the access token is rejected
+func (c *Client) requestSpcToken(ocn, fp string) (string, error) {
+	der, err := tnauthlist.List{{SPC: ocn}}.Marshal()
+	if err != nil {
+		return "", err
+	}
+	atc := map[string]interface{}{
+		"atc": map[string]interface{}{
+			"tktype": "TNAuthList",
+			"tkvalue": base64.StdEncoding.EncodeToString(der),
+			"ca": false,
+			"fingerprint": fp,
+		},
+	}
+	body, _ := json.Marshal(atc)
+	for retry := 0; ; retry++ {
+		c.RLock()
+		at := c.accessToken
+		c.RUnlock()
+		if len(at) == 0 || retry > 0 {
+			if at, err = c.login(); err != nil {
+				return "", err
+			}
+		}
+		cr, err := readCredentialsFile(credentialsFileName)
+		if err != nil {
+			return "", err
+		}
+		var r struct {
+			Status		string		`json:"status"`
+			Message		string		`json:"message"`
+			Token			string		`json:"token"`
+		}
+		u := strings.TrimRight(cr.Url, "/") + "/account/" + url.PathEscape(cr.AccountId) + "/token"
+		status, err := post(u, at, body, &r)
+		if status == http.StatusUnauthorized && retry == 0 {
+			// access token expired
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("%v - SPC token for OCN %v", err, ocn)
+		}
+		if r.Status != "success" || len(r.Token) == 0 {
+			return "", fmt.Errorf("POST %v response status - %v; SPC token for OCN %v not returned - %v", u, status, ocn, r.Message)
+		}
+		return r.Token, nil
+	}
+}
+
+// login to STI-PA API and save the access token
+func (c *Client) login() (string, error) {
+	cr, err := readCredentialsFile(credentialsFileName)
+	if err != nil {
+		return "", err
+	}
+	body, _ := json.Marshal(map[string]string{"userId": cr.UserId, "password": cr.Password})
+	var r struct {
+		Status				string		`json:"status"`
+		Message				string		`json:"message"`
+		AccessToken		string		`json:"accessToken"`
+	}
+	u := strings.TrimRight(cr.Url, "/") + "/auth/login"
+	status, err := post(u, "", body, &r)
+	if err != nil {
+		return "", fmt.Errorf("%v - STI-PA login", err)
+	}
+	if r.Status != "success" || len(r.AccessToken) == 0 {
+		return "", fmt.Errorf("POST %v response status - %v; STI-PA login failed - %v", u, status, r.Message)
+	}
+	c.Lock()
+	c.accessToken = r.AccessToken
+	c.Unlock()
+	return r.AccessToken, nil
+}
+
+// FetchCrl downloads the STI-PA CRL (DER or PEM) and replaces the cached one. The CRL MUST be
+// issued and signed by the configured STI-PA certificate
+func (c *Client) FetchCrl() error {
+	if len(c.crlUrl) == 0 {
+		return nil
+	}
+	start := time.Now()
+	resp, err := httpClient.Get(c.crlUrl)
+	if err != nil {
+		return fmt.Errorf("%v - GET %v failed", err, c.crlUrl)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%v - GET %v response body", err, c.crlUrl)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v response status - %v", c.crlUrl, resp.StatusCode)
+	}
+	logInfo("type", "stipaResponseTime", "module", "FetchCrl", "stipaResponseTime", fmt.Sprintf("%v", time.Since(start)))
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	crl, err := x509.ParseDERCRL(b)
+	if err != nil {
+		return fmt.Errorf("%v - STI-PA CRL from %v", err, c.crlUrl)
+	}
+	if err := c.crlIssuer.CheckCRLSignature(crl); err != nil {
+		return fmt.Errorf("%v - signature of STI-PA CRL from %v cannot be verified using STI-PA certificate", err, c.crlUrl)
+	}
+	if issuer := crlIssuerName(crl); issuer != c.crlIssuer.Subject.String() {
+		return fmt.Errorf("issuer %v of STI-PA CRL from %v is not subject %v of STI-PA certificate", issuer, c.crlUrl, c.crlIssuer.Subject.String())
+	}
+	if crl.HasExpired(time.Now()) {
+		return fmt.Errorf("STI-PA CRL from %v is stale (next update %v)", c.crlUrl, crl.TBSCertList.NextUpdate)
+	}
+	// certificates are issued by the CRL issuer, unless a CRL entry names another issuer (indirect CRL).
+	// The issuer of an entry applies to the following entries, until another issuer is named
+	revoked := make(map[string]bool)
+	issuer := crlIssuerName(crl)
+	for i, e := range crl.TBSCertList.RevokedCertificates {
+		for _, x := range e.Extensions {
+			if x.Id.Equal(oidCertificateIssuer) {
+				if issuer, err = directoryName(x.Value); err != nil {
+					return fmt.Errorf("%v - certificate issuer of entry at index %v in STI-PA CRL from %v", err, i, c.crlUrl)
+				}
+			}
+		}
+		revoked[revokedKey(issuer, e.SerialNumber)] = true
+	}
+	var number *big.Int
+	for _, e := range crl.TBSCertList.Extensions {
+		if e.Id.Equal(oidCrlNumber) {
+			n := new(big.Int)
+			if _, err := asn1.Unmarshal(e.Value, &n); err == nil {
+				number = n
+			}
+		}
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.crl == nil || c.crlNumber == nil || number == nil || c.crlNumber.Cmp(number) != 0 {
+		logInfo("type", "stipa", "module", "FetchCrl", "message", "STI-PA CRL updated", "revoked", len(revoked), "thisUpdate", crl.TBSCertList.ThisUpdate.UTC().Format(time.RFC3339))
+	}
+	c.crl, c.crlNumber, c.revoked = crl, number, revoked
+	return nil
+}
+
+// Crl returns the cached STI-PA CRL (nil if it has not been fetched)
+func (c *Client) Crl() *pkix.CertificateList {
+	c.RLock()
+	defer c.RUnlock()
+	return c.crl
+}
+
+// Revoked returns true if certificate cert (its issuer and serial number) is in the cached CRL
+func (c *Client) Revoked(cert *x509.Certificate) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.revoked[revokedKey(cert.Issuer.String(), cert.SerialNumber)]
+}
+
+// returns key of revoked certificate with serial number n, issued by issuer
+func revokedKey(issuer string, n *big.Int) string {
+	return issuer + " " + serial(n)
+}
+
+// returns the directory name in GeneralNames (RFC 5280, 4.2.1.6) b, as in certificate issuer CRL entry extension
+func directoryName(b []byte) (string, error) {
+	var names []asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &names); err != nil {
+		return "", err
+	}
+	for _, v := range names {
+		// directoryName [4] Name (explicitly tagged, since Name is a CHOICE)
+		if v.Class == asn1.ClassContextSpecific && v.Tag == 4 {
+			var rdn pkix.RDNSequence
+			if _, err := asn1.Unmarshal(v.Bytes, &rdn); err != nil {
+				return "", err
+			}
+			var n pkix.Name
+			n.FillFromRDNSequence(&rdn)
+			return n.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no directory name is found")
+}
+
+// returns issuer name of CRL crl
+func crlIssuerName(crl *pkix.CertificateList) string {
+	var n pkix.Name
+	n.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+	return n.String()
+}
+
+// read (first) certificate in PEM file n
+func readCertificateFile(n string) (*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(n)
+	if err != nil {
+		return nil, fmt.Errorf("%v - STI-PA certificate file", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate is found in STI-PA certificate file")
+	}
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%v - STI-PA certificate file", err)
+	}
+	return c, nil
+}
+
+// returns serial number in lower case hex
+func serial(n *big.Int) string {
+	return fmt.Sprintf("%x", n)
+}
+
+// returns "exp" claim of JWT t
+func tokenExpiry(t string) (time.Time, error) {
+	p := strings.Split(t, ".")
+	if len(p) != 3 {
+		return time.Time{}, fmt.Errorf("SPC token is not a JWT")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(p[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%v - SPC token payload", err)
+	}
+	var claims struct {
+		Exp		int64		`json:"exp"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("SPC token has no exp claim")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// post JSON body b to u (with access token at, if not empty) and decode JSON response in v.
+// The response status code is returned along with any error
+func post(u, at string, b []byte, v interface{}) (int, error) {
+	start := time.Now()
+	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	if err != nil {
+		return 0, fmt.Errorf("%v - http.NewRequest failed", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(at) > 0 {
+		req.Header.Set("Authorization", at)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%v - POST %v failed", err, u)
+	}
+	defer resp.Body.Close()
+	logInfo("type", "stipaResponseTime", "module", "post", "stipaResponseTime", fmt.Sprintf("%v", time.Since(start)))
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("%v - POST %v response body", err, u)
+	}
+	if err := json.Unmarshal(rb, v); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("POST %v response status - %v; unable to parse JSON object in response body - %v", u, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("POST %v response status - %v", u, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// read STI-PA API credentials file
+func readCredentialsFile(n string) (*credentials, error) {
+	f, err := os.Open(n)
+	if err != nil {
+		return nil, fmt.Errorf("%v - STI-PA credentials file", err)
+	}
+	defer f.Close()
+	var cr credentials
+	if err := json.NewDecoder(f).Decode(&cr); err != nil {
+		return nil, fmt.Errorf("%v - decode JSON object in STI-PA credentials file", err)
+	}
+	if _, err := url.ParseRequestURI(cr.Url); err != nil {
+		return nil, fmt.Errorf("%v - \"url\" in STI-PA credentials file", err)
+	}
+	if len(strings.TrimSpace(cr.UserId)) == 0 || len(cr.Password) == 0 || len(strings.TrimSpace(cr.AccountId)) == 0 {
+		return nil, fmt.Errorf("\"userId\", \"password\" and \"accountId\" MUST be set in STI-PA credentials file")
+	}
+	return &cr, nil
+}
@@ -0,0 +1,209 @@
+package stipa
+
+import (
+	"testing"
+	"fmt"
+	"time"
+	"math/big"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/base64"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"vesper/tnauthlist"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// standIn - minimal STI-PA API
+type standIn struct {
+	srv					*httptest.Server
+	logins			int
+	tokens			int
+	expired			bool				// reject next token request as if access token expired
+	crl					[]byte
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["userId"] != "vesper" || req["password"] != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"status":"error","message":"invalid credentials"}`))
+			return
+		}
+		s.logins++
+		fmt.Fprintf(w, `{"status":"success","accessToken":"at-%v"}`, s.logins)
+	})
+	mux.HandleFunc("/api/v1/account/acct-1/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != fmt.Sprintf("at-%v", s.logins) || s.expired {
+			s.expired = false
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Atc struct {
+				TkType				string		`json:"tktype"`
+				TkValue				string		`json:"tkvalue"`
+				Fingerprint		string		`json:"fingerprint"`
+			}	`json:"atc"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		der, _ := base64.StdEncoding.DecodeString(req.Atc.TkValue)
+		l, err := tnauthlist.Parse(der)
+		if err != nil || req.Atc.TkType != "TNAuthList" || len(l.SPCs()) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","message":"invalid atc"}`))
+			return
+		}
+		s.tokens++
+		claims, _ := json.Marshal(map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix(), "atc": map[string]string{"tkvalue": req.Atc.TkValue, "fingerprint": req.Atc.Fingerprint}})
+		tok := "eyJhbGciOiJFUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(claims) + ".c2ln"
+		fmt.Fprintf(w, `{"status":"success","token":%q}`, tok)
+	})
+	mux.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(s.crl)
+	})
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// credentials file (in directory d) of STI-PA API at u
+func credentialsFile(t *testing.T, d, u string) string {
+	f := filepath.Join(d, "stipa.json")
+	b, _ := json.Marshal(credentials{Url: u + "/api/v1", UserId: "vesper", Password: "secret", AccountId: "acct-1"})
+	if err := ioutil.WriteFile(f, b, 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return f
+}
+
+func TestSpcToken(t *testing.T) {
+	s := newStandIn(t)
+	defer s.srv.Close()
+	d := t.TempDir()
+	c, err := InitObject(kitlog.NewNopLogger(), s.srv.Client(), credentialsFile(t, d, s.srv.URL), "", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t1, err := c.SpcToken("1234", "SHA256 AB:CD")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// cached
+	if t2, err := c.SpcToken("1234", "SHA256 AB:CD"); err != nil || t2 != t1 || s.tokens != 1 {
+		t.Errorf("SpcToken() not cached (%v tokens requested, err %v)", s.tokens, err)
+	}
+	// another OCN; access token expired
+	s.expired = true
+	if _, err := c.SpcToken("5678", "SHA256 AB:CD"); err != nil || s.tokens != 2 || s.logins != 2 {
+		t.Errorf("SpcToken() = %v with %v logins, %v tokens; want login again after access token expiry", err, s.logins, s.tokens)
+	}
+	if exp, err := tokenExpiry(t1); err != nil || exp.Before(time.Now()) {
+		t.Errorf("tokenExpiry() = %v, %v", exp, err)
+	}
+}
+
+// self signed CA certificate with common name cn and key k
+func caCert(t *testing.T, cn string, k *ecdsa.PrivateKey) *x509.Certificate {
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: cn}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCRLSign | x509.KeyUsageCertSign}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return c
+}
+
+// certificate issuer CRL entry extension naming issuer of certificate c
+func certificateIssuer(t *testing.T, c *x509.Certificate) pkix.Extension {
+	v, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: c.RawSubject}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return pkix.Extension{Id: oidCertificateIssuer, Critical: true, Value: v}
+}
+
+func TestFetchCrl(t *testing.T) {
+	s := newStandIn(t)
+	defer s.srv.Close()
+	d := t.TempDir()
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pa := caCert(t, "STI-PA CA", k)
+	ca := caCert(t, "STI-CA", k)
+	issuerFile := filepath.Join(d, "stipa.pem")
+	if err := ioutil.WriteFile(issuerFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pa.Raw}), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	now := time.Now()
+	crl := func(issuer *x509.Certificate, k *ecdsa.PrivateKey, nextUpdate time.Time) []byte {
+		b, err := issuer.CreateCRL(rand.Reader, k, []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(0x1a2b), RevocationTime: now},
+			// indirect CRL - certificates issued by STI-CA
+			{SerialNumber: big.NewInt(0x3c4d), RevocationTime: now, Extensions: []pkix.Extension{certificateIssuer(t, ca)}},
+			{SerialNumber: big.NewInt(0x5e6f), RevocationTime: now},
+		}, now.Add(-time.Minute), nextUpdate)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return b
+	}
+	if _, err := InitObject(kitlog.NewNopLogger(), s.srv.Client(), credentialsFile(t, d, s.srv.URL), s.srv.URL + "/crl", ""); err == nil {
+		t.Errorf("STI-PA CRL URL accepted without STI-PA certificate")
+	}
+	c, err := InitObject(kitlog.NewNopLogger(), s.srv.Client(), credentialsFile(t, d, s.srv.URL), s.srv.URL + "/crl", issuerFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s.crl = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl(pa, k, now.Add(time.Hour))})
+	if err := c.FetchCrl(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	tests := []struct {
+		issuer			*x509.Certificate
+		serial			int64
+		revoked			bool
+	}{
+		{pa, 0x1a2b, true},
+		{pa, 0x1a2c, false},
+		{pa, 0x3c4d, false},
+		{ca, 0x3c4d, true},
+		{ca, 0x5e6f, true},
+		{ca, 0x1a2b, false},
+	}
+	for i, test := range tests {
+		cert := &x509.Certificate{SerialNumber: big.NewInt(test.serial), Issuer: test.issuer.Subject}
+		if r := c.Revoked(cert); r != test.revoked {
+			t.Errorf("%v: Revoked(%v %x) = %v; want %v", i, test.issuer.Subject.CommonName, test.serial, r, test.revoked)
+		}
+	}
+	// CRL signed by another key, issued by another issuer, stale or malformed does not replace cached CRL
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for i, b := range [][]byte{
+		crl(caCert(t, "STI-PA CA", other), other, now.Add(time.Hour)),
+		crl(ca, k, now.Add(time.Hour)),
+		crl(pa, k, now.Add(-time.Second)),
+		[]byte("not a CRL"),
+	} {
+		s.crl = b
+		if err := c.FetchCrl(); err == nil || c.Crl() == nil {
+			t.Errorf("%v: FetchCrl accepted CRL or dropped cached CRL", i)
+		}
+	}
+	if !c.Revoked(&x509.Certificate{SerialNumber: big.NewInt(0x1a2b), Issuer: pa.Subject}) {
+		t.Errorf("cached CRL not kept")
+	}
+}
@@ -1,21 +1,17 @@
-// Copyright 2017 Comcast Cable Communications Management, LLC
-
-package main
+package stir
 
 import (
 	"fmt"
-	"time"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/elliptic"
+	"hash"
 	"math/big"
 	"encoding/base64"
+	"encoding/json"
 	"strings"
-	"crypto/x509"
-	"encoding/pem"
-	"io/ioutil"
-	"net/http"
-	"vesper/publickeys"
 )
 
 // ShakenHdr - structure that holds JWT header
@@ -50,11 +46,11 @@ func base64Decode(sig string) ([]byte, error) {
 }
 
 // Encoding
-// signer returns a signature for the given data.
-type signer func(data []byte) (sig []byte, err error)
+// signFunc returns a signature for the given data.
+type signFunc func(data []byte) (sig []byte, err error)
 
 // EncodeWithSigner encodes a header and claim set with the provided signer.
-func encodeWithSigner(header, claims []byte, sg signer) (string, string, error) {
+func encodeWithSigner(header, claims []byte, sg signFunc) (string, string, error) {
 	h := base64Encode(header)
 	c := base64Encode(claims)
 	ss := fmt.Sprintf("%s.%s", h, c)
@@ -93,9 +89,10 @@ func encodeEC(header, claims []byte, key *ecdsa.PrivateKey) (string, string, err
 	return encodeWithSigner(header, claims, sg)
 }
 
-type Verifier func(data []byte, signature []byte) (err error)
+// verifyFunc returns nil if signature is a valid signature of the given data.
+type verifyFunc func(data []byte, signature []byte) (err error)
 
-func verifyWithSigner(token string, ver Verifier) error {
+func verifyWithSigner(token string, ver verifyFunc) error {
 	parts := strings.Split(token, ".")
 	signedPart := []byte(strings.Join(parts[0:2], "."))
 	signatureString, err := base64Decode(parts[2])
@@ -105,16 +102,33 @@ func verifyWithSigner(token string, ver Verifier) error {
 	return ver(signedPart, []byte(signatureString))
 }
 
-func verifyEC(token string, key *ecdsa.PublicKey) error {
+// ecAlgorithm - hash function and curve of an ECDSA JWS algorithm (RFC 7518 section 3.4)
+type ecAlgorithm struct {
+	hash		func() hash.Hash
+	curve		elliptic.Curve
+}
+
+// JWS algorithms supported for verification. Signing always uses ES256
+var ecAlgorithms = map[string]ecAlgorithm{
+	"ES256"	: {sha256.New, elliptic.P256()},
+	"ES384"	: {sha512.New384, elliptic.P384()},
+	"ES512"	: {sha512.New, elliptic.P521()},
+}
+
+func verifyEC(token, alg string, key *ecdsa.PublicKey) error {
+	a, ok := ecAlgorithms[alg]
+	if !ok {
+		return fmt.Errorf("alg %v is not supported", alg)
+	}
 	ver := func(data []byte, signature []byte) (err error) {
-		h := sha256.New()
+		h := a.hash()
 		h.Write(data)
 		r := new(big.Int).SetBytes(signature[:len(signature)/2])
 		s := new(big.Int).SetBytes(signature[len(signature)/2:])
 		if ecdsa.Verify(key, h.Sum(nil), r, s) {
 			return nil
 		}
-		return fmt.Errorf("Unable to verify ES256 signature")
+		return fmt.Errorf("Unable to verify %v signature", alg)
 	}
 	return verifyWithSigner(token, ver)
 }
@@ -132,82 +146,25 @@ func createSignature(h, c []byte, p *ecdsa.PrivateKey) (string, string, error)
 	return "", "", err
 }
 
-// verifySignature is called to verify the signature which was created
-// using  ES256 algorithm.
-// If the signature ois verified, the function returns nil. Otherwise,
-// an error message is returned
-func verifySignature(x5u, token string, verifyCA bool) (string, int, error) {
-	// Get the data each time
-	pk := publickeys.Fetch(x5u)
-	if pk == nil {
-		resp, err := http.Get(x5u)
-		if err != nil {
-			logError("%v", err)
-			return "VESPER-4156", http.StatusBadRequest, err
-		}
-		defer resp.Body.Close()
-		// Writer the body to buffer
-		cert_buffer, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			logError("%v", err)
-			return "VESPER-4157", http.StatusBadRequest, err
-		}
-		switch resp.StatusCode {
-		case 200:
-		default:
-			return "VESPER-4156", http.StatusBadRequest, fmt.Errorf("%v", string(cert_buffer))
-		}
-		b := string(cert_buffer[:])
-		block, _ := pem.Decode([]byte(b))
-		if block == nil {
-			err := fmt.Errorf("no PEM data is found")
-			return "VESPER-4158", http.StatusBadRequest, err
-		}
-		// parse certificate
-		cert, err := x509.ParseCertificate(block.Bytes)
-		if err != nil {
-			return "VESPER-4159", http.StatusBadRequest, err
-		}
-		now := time.Now()
-		opts := x509.VerifyOptions{CurrentTime: now,}
-		if verifyCA {
-			opts = x509.VerifyOptions{CurrentTime: now, Roots: rootCerts.Root(),}
-		}
-		if _, err := cert.Verify(opts); err != nil {
-			switch err.Error() {
-			case "x509: certificate has expired or is not yet valid":
-				return "VESPER-4160", http.StatusBadRequest, err
-			case "x509: certificate signed by unknown authority" :
-				if verifyCA {
-					return "VESPER-4161", http.StatusBadRequest, err
-				}
-			case "x509: certificate is not authorized to sign other certificates":
-				if verifyCA {
-					return "VESPER-4162", http.StatusBadRequest, err
-				}
-			case "x509: issuer name does not match subject from issuing certificate":
-				if verifyCA {
-					return "VESPER-4163", http.StatusBadRequest, err
-				}
-			default:
-				if verifyCA {
-					return "VESPER-4164", http.StatusBadRequest, err
-				}
-			}
-		}
-		// ES256
-		var ok bool
-		pk, ok = cert.PublicKey.(*ecdsa.PublicKey)
-		if !ok {
-			err = fmt.Errorf("Value returned from ParsePKIXPublicKey was not an ECDSA public key")
-			return "VESPER-4165", http.StatusBadRequest, err
-		}
-		// add to cache
-		publickeys.Add(x5u, pk)
+// createPassport signs claims c with private key p and returns the Identity header value (RFC 8224)
+// x5u x is used in JWT header and in info parameter. ppt parameter is added for PASSporT extensions
+func createPassport(ppt, x string, p *ecdsa.PrivateKey, c map[string]interface{}) (string, string, error) {
+	hdr := ShakenHdr{	Alg: "ES256", Ppt: ppt, Typ: "passport", X5u: x}
+	hdrBytes, err := json.Marshal(hdr)
+	if err != nil {
+		return "", "VESPER-5050", fmt.Errorf("%v - error in converting header to byte array", err)
 	}
-	err := verifyEC(token, pk)
+	claimsBytes, err := json.Marshal(c)
 	if err != nil {
-		return "VESPER-4166", http.StatusUnauthorized, err
+		return "", "VESPER-5051", fmt.Errorf("%v - error in converting claims to byte array", err)
+	}
+	canonicalString, sig, err := createSignature(hdrBytes, claimsBytes, p)
+	if err != nil {
+		return "", "VESPER-5052", fmt.Errorf("%v - error in signing request for request payload", err)
+	}
+	identity := canonicalString + "." + sig + ";info=<" + x + ">;alg=ES256"
+	if ppt != "shaken" {
+		identity += ";ppt=" + ppt
 	}
-	return "", http.StatusOK, nil
+	return identity, "", nil
 }
@@ -0,0 +1,560 @@
+package stir
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"github.com/satori/go.uuid"
+	"vesper/e164"
+)
+
+// identities - orig and dest identities in request payload or JWT claims
+// orig is either a TN or a URI. dest contains TNs and/or URIs (RFC 8225 section 5.2)
+type identities struct {
+	origTN			string
+	origURI			string
+	destTNs			[]string
+	destURIs		[]string
+}
+
+func validatePayload(r map[string]interface{}, normalize bool) (map[string]interface{}, identities, int64, string, string, error) {
+	var attest, origID string
+	var iat int64
+	var id identities
+	orderedMap := make(map[string]interface{})		// this is a copy of the map passed in input except the keys are ordered
+	
+	// a JSON null value is a missing field
+	if r["attest"] == nil || r["dest"] == nil || r["iat"] == nil || r["orig"] == nil || r["origid"] == nil {
+		return orderedMap, id, iat, "", "VESPER-4003", fmt.Errorf("one or more of the require fields missing in request payload")
+	}
+	// request payload should not contain more than the expected fields
+	if len(r) != 5 {
+		return orderedMap, id, iat, "", "VESPER-4004", fmt.Errorf("request payload has more than expected fields")
+	}
+	
+	// attest ...
+	switch v := r["attest"].(type) {
+	case string:
+		attest = v
+		if len(strings.TrimSpace(attest)) == 0 {
+			return orderedMap, id, iat, "", "VESPER-4005", fmt.Errorf("attest field in request payload is an empty string")
+		}
+		switch attest {
+		case "A", "B", "C":
+			// as per SHAKEN SPEC
+		default :
+			return orderedMap, id, iat, "", "VESPER-4006", fmt.Errorf("attest field in request payload is not as per SHAKEN spec")
+		}
+		orderedMap["attest"] = r["attest"]
+	default:
+		return orderedMap, id, iat, "", "VESPER-4007", fmt.Errorf("attest field in request payload MUST be a string")
+	}
+	
+	// dest ...
+	if errCode, err := validateDest(r, &id); err != nil {
+		return orderedMap, id, iat, "", errCode, err
+	}
+	orderedMap["dest"] = r["dest"]
+	
+	// iat ...
+	switch v := r["iat"].(type) {
+	case float64:
+		iat = int64(v)
+		if iat <= 0 {
+			return orderedMap, id, iat, "", "VESPER-4008", fmt.Errorf("iat value in request payload is <= 0")
+		}
+		orderedMap["iat"] = r["iat"]
+	default:
+		return orderedMap, id, iat, "", "VESPER-4009", fmt.Errorf("iat field in request payload MUST be a number")
+	}
+	
+	// orig ...
+	if errCode, err := validateOrig(r, &id); err != nil {
+		return orderedMap, id, iat, "", errCode, err
+	}
+	orderedMap["orig"] = r["orig"]
+	
+	// origid ...
+	switch v := r["origid"].(type) {
+	case string:
+		origID = v
+		if len(strings.TrimSpace(origID)) == 0 {
+			return orderedMap, id, iat, "", "VESPER-4010", fmt.Errorf("origid field in request payload is an empty string")
+		}
+		orderedMap["origid"] = r["origid"]
+	default:
+		return orderedMap, id, iat, "", "VESPER-4011", fmt.Errorf("origid field in request payload MUST be a string")
+	}
+	
+	// canonicalize TNs (RFC 8224), if configured
+	if errCode, err := normalizeIdentities(&id, normalize); err != nil {
+		return orderedMap, id, iat, "", errCode, err
+	}
+
+	return orderedMap, id, iat, origID, "", nil
+}
+
+// validateDest - validates dest field (TNs and/or URIs) in request payload r
+// dest TNs and URIs are saved in id
+func validateDest(r map[string]interface{}, id *identities) (string, error) {
+	switch dest := r["dest"].(type) {
+	case map[string]interface{}:
+		switch {
+		case len(dest) == 0 :
+			return "VESPER-4018", fmt.Errorf("dest in request payload is an empty object")
+		case len(dest) > 2 :
+			return "VESPER-4019", fmt.Errorf("dest in request payload should contain only \"tn\" and/or \"uri\" fields")
+		default:
+			// fields should be "tn" and/or "uri" only
+			for k := range dest {
+				if k != "tn" && k != "uri" {
+					return "VESPER-4020", fmt.Errorf("dest in request payload contains field %v other than \"tn\" and \"uri\"", k)
+				}
+			}
+			if _, ok := dest["tn"]; ok {
+				// validate "tn" value is of type string and is not an empty string
+				switch dt := dest["tn"].(type) {
+				case []interface{}:
+					// empty array object
+					if len(dt) == 0 {
+						return "VESPER-4021", fmt.Errorf("dest tn in request payload is an empty array")
+					}
+					// contains empty string
+					for _, v := range dt {
+						tn, ok := v.(string)
+						if !ok {
+							return "VESPER-4022", fmt.Errorf("one or more dest tns in request payload is not a string")
+						} else {
+							if len(strings.TrimSpace(tn)) == 0 {
+								return "VESPER-4023", fmt.Errorf("one or more dest tns in request payload is an empty string")
+							}
+							// append desl TNs here
+							id.destTNs = append(id.destTNs, tn)
+						}
+					}
+				default:
+					return "VESPER-4024", fmt.Errorf("dest tn in request payload is not an array")
+				}
+			}
+			if _, ok := dest["uri"]; ok {
+				// validate "uri" value is an array of URIs
+				switch du := dest["uri"].(type) {
+				case []interface{}:
+					if len(du) == 0 {
+						return "VESPER-4043", fmt.Errorf("dest uri in request payload is an empty array")
+					}
+					for _, v := range du {
+						u, ok := v.(string)
+						if !ok {
+							return "VESPER-4044", fmt.Errorf("one or more dest uris in request payload is not a string")
+						}
+						if len(strings.TrimSpace(u)) == 0 {
+							return "VESPER-4045", fmt.Errorf("one or more dest uris in request payload is an empty string")
+						}
+						if !isURI(u) {
+							return "VESPER-4046", fmt.Errorf("dest uri %v in request payload is not a valid URI", u)
+						}
+						id.destURIs = append(id.destURIs, u)
+					}
+				default:
+					return "VESPER-4042", fmt.Errorf("dest uri in request payload is not an array")
+				}
+			}
+		}
+	default:
+		return "VESPER-4025", fmt.Errorf("dest field in request payload MUST be a JSON object")
+	}
+	return "", nil
+}
+
+// validateOrig - validates orig field (TN or URI) in request payload r
+// orig TN or URI is saved in id
+func validateOrig(r map[string]interface{}, id *identities) (string, error) {
+	switch orig := r["orig"].(type) {
+	case map[string]interface{}:
+		switch {
+		case len(orig) == 0 :
+			return "VESPER-4012", fmt.Errorf("orig in request payload is an empty object")
+		case len(orig) > 1 :
+			return "VESPER-4013", fmt.Errorf("orig in request payload should contain only one field")
+		default:
+			// field should be "tn" or "uri"
+			switch origKey(orig) {
+			case "tn":
+				// validate "tn" value is of type string and is not an empty string
+				tn, ok := orig["tn"].(string)
+				if !ok {
+					return "VESPER-4015", fmt.Errorf("orig tn in request payload is not of type string")
+				}
+				id.origTN = tn
+				if len(strings.TrimSpace(id.origTN)) == 0 {
+					return "VESPER-4016", fmt.Errorf("orig tn in request payload is an empty string")
+				}
+			case "uri":
+				// validate "uri" value is of type string and is a URI
+				u, ok := orig["uri"].(string)
+				if !ok {
+					return "VESPER-4039", fmt.Errorf("orig uri in request payload is not of type string")
+				}
+				if len(strings.TrimSpace(u)) == 0 {
+					return "VESPER-4040", fmt.Errorf("orig uri in request payload is an empty string")
+				}
+				if !isURI(u) {
+					return "VESPER-4041", fmt.Errorf("orig uri %v in request payload is not a valid URI", u)
+				}
+				id.origURI = u
+			default:
+				return "VESPER-4014", fmt.Errorf("orig in request payload does not contain field \"tn\" or \"uri\"")
+			}
+		}
+	default:
+		return "VESPER-4017", fmt.Errorf("orig field in request payload MUST be a JSON object")
+	}
+	return "", nil
+}
+
+// origKey returns the field of orig (an object with one field)
+func origKey(orig map[string]interface{}) string {
+	for k := range orig {
+		return k
+	}
+	return ""
+}
+
+// normalizeIdentities - canonicalizes TNs in id (RFC 8224), if normalize is true
+func normalizeIdentities(id *identities, normalize bool) (string, error) {
+	if normalize {
+		if len(id.origTN) > 0 {
+			n, err := e164.Normalize(id.origTN)
+			if err != nil {
+				return "VESPER-4037", fmt.Errorf("%v - orig tn in request payload is not a valid telephone number", err)
+			}
+			id.origTN = n
+		}
+		d, err := e164.NormalizeAll(id.destTNs)
+		if err != nil {
+			return "VESPER-4038", fmt.Errorf("%v - one or more dest tns in request payload is not a valid telephone number", err)
+		}
+		id.destTNs = d
+	}
+	return "", nil
+}
+
+// isURI returns true if s is an absolute URI (e.g. sip:alice@example.com)
+func isURI(s string) bool {
+	if strings.ContainsAny(s, " \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return len(u.Scheme) > 0 && (len(u.Opaque) > 0 || len(u.Host) > 0)
+}
+
+// canonicalURI returns URI s with scheme and host in lower case, so that
+// URIs can be compared (RFC 3261 section 19.1.4 and RFC 3986 section 6.2.2.1)
+func canonicalURI(s string) string {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return s
+	}
+	scheme, rest := strings.ToLower(s[:i]), s[i+1:]
+	// host follows "//" (e.g. http) or user part "user@" (e.g. sip)
+	start := 0
+	if strings.HasPrefix(rest, "//") {
+		start = 2
+		if at := strings.Index(rest[start:], "@"); at >= 0 {
+			start += at + 1
+		}
+	} else if at := strings.Index(rest, "@"); at >= 0 {
+		start = at + 1
+	}
+	end := len(rest)
+	if j := strings.IndexAny(rest[start:], ":;?/#>"); j >= 0 {
+		end = start + j
+	}
+	return scheme + ":" + rest[:start] + strings.ToLower(rest[start:end]) + rest[end:]
+}
+
+// matchIdentities returns true if identities in a and b are the same, in any order
+// identities are compared after applying canonical (if not nil)
+func matchIdentities(a, b []string, canonical func(string) string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, v := range a {
+		isMatch := false
+		for _, vv := range b {
+			if v == vv || (canonical != nil && canonical(v) == canonical(vv)) {
+				isMatch = true
+				break
+			}
+		}
+		if !isMatch {
+			return false
+		}
+	}
+	return true
+}
+
+// validateRphPayload - validates request payload (or JWT claims) of a "rph" PASSporT (RFC 8443)
+// returns ordered claims, identities, iat and the asserted resource priority values
+func validateRphPayload(r map[string]interface{}, normalize bool) (map[string]interface{}, identities, int64, []string, string, error) {
+	var iat int64
+	var id identities
+	var auth []string
+	orderedMap := make(map[string]interface{})		// this is a copy of the map passed in input except the keys are ordered
+
+	if r["dest"] == nil || r["iat"] == nil || r["orig"] == nil || r["rph"] == nil {
+		return orderedMap, id, iat, auth, "VESPER-4003", fmt.Errorf("one or more of the require fields missing in request payload")
+	}
+	// request payload should not contain more than the expected fields
+	if len(r) != 4 {
+		return orderedMap, id, iat, auth, "VESPER-4004", fmt.Errorf("request payload has more than expected fields")
+	}
+
+	// dest ...
+	if errCode, err := validateDest(r, &id); err != nil {
+		return orderedMap, id, iat, auth, errCode, err
+	}
+	orderedMap["dest"] = r["dest"]
+
+	// iat ...
+	switch v := r["iat"].(type) {
+	case float64:
+		iat = int64(v)
+		if iat <= 0 {
+			return orderedMap, id, iat, auth, "VESPER-4008", fmt.Errorf("iat value in request payload is <= 0")
+		}
+		orderedMap["iat"] = r["iat"]
+	default:
+		return orderedMap, id, iat, auth, "VESPER-4009", fmt.Errorf("iat field in request payload MUST be a number")
+	}
+
+	// orig ...
+	if errCode, err := validateOrig(r, &id); err != nil {
+		return orderedMap, id, iat, auth, errCode, err
+	}
+	orderedMap["orig"] = r["orig"]
+
+	// rph ...
+	rph, ok := r["rph"].(map[string]interface{})
+	if !ok || len(rph) != 1 || rph["auth"] == nil {
+		return orderedMap, id, iat, auth, "VESPER-4047", fmt.Errorf("rph field in request payload MUST be a JSON object containing only \"auth\" field")
+	}
+	a, ok := rph["auth"].([]interface{})
+	if !ok || len(a) == 0 {
+		return orderedMap, id, iat, auth, "VESPER-4048", fmt.Errorf("rph auth in request payload MUST be a non-empty array")
+	}
+	for _, v := range a {
+		s, ok := v.(string)
+		if !ok || !regexRph.MatchString(s) {
+			return orderedMap, id, iat, auth, "VESPER-4049", fmt.Errorf("rph auth value %v in request payload is not a resource priority value (namespace.priority)", v)
+		}
+		auth = append(auth, s)
+	}
+	orderedMap["rph"] = r["rph"]
+
+	// canonicalize TNs (RFC 8224), if configured
+	if errCode, err := normalizeIdentities(&id, normalize); err != nil {
+		return orderedMap, id, iat, auth, errCode, err
+	}
+
+	return orderedMap, id, iat, auth, "", nil
+}
+
+// validateBasePayload - validates JWT claims of a base PASSporT (RFC 8225)
+// iat, orig and dest claims are required. Other claims (e.g. mky) are allowed
+// returns ordered claims, identities and iat
+func validateBasePayload(r map[string]interface{}, normalize bool) (map[string]interface{}, identities, int64, string, error) {
+	var iat int64
+	var id identities
+	orderedMap := make(map[string]interface{})		// this is a copy of the map passed in input except the keys are ordered
+
+	if r["dest"] == nil || r["iat"] == nil || r["orig"] == nil {
+		return orderedMap, id, iat, "VESPER-4003", fmt.Errorf("one or more of the require fields missing in request payload")
+	}
+	// dest ...
+	if errCode, err := validateDest(r, &id); err != nil {
+		return orderedMap, id, iat, errCode, err
+	}
+	// iat ...
+	switch v := r["iat"].(type) {
+	case float64:
+		iat = int64(v)
+		if iat <= 0 {
+			return orderedMap, id, iat, "VESPER-4008", fmt.Errorf("iat value in request payload is <= 0")
+		}
+	default:
+		return orderedMap, id, iat, "VESPER-4009", fmt.Errorf("iat field in request payload MUST be a number")
+	}
+	// orig ...
+	if errCode, err := validateOrig(r, &id); err != nil {
+		return orderedMap, id, iat, errCode, err
+	}
+	// canonicalize TNs (RFC 8224), if configured
+	if errCode, err := normalizeIdentities(&id, normalize); err != nil {
+		return orderedMap, id, iat, errCode, err
+	}
+	// all claims
+	for k, v := range r {
+		orderedMap[k] = v
+	}
+	return orderedMap, id, iat, "", nil
+}
+
+// canonicalClaims replaces orig and dest in claims with canonical TNs in id, if normalize is true
+func canonicalClaims(claims map[string]interface{}, id identities, normalize bool) {
+	if !normalize {
+		return
+	}
+	if len(id.origTN) > 0 {
+		claims["orig"] = map[string]interface{}{"tn": id.origTN}
+	}
+	if len(id.destTNs) > 0 {
+		dest := map[string]interface{}{"tn": id.destTNs}
+		if len(id.destURIs) > 0 {
+			dest["uri"] = id.destURIs
+		}
+		claims["dest"] = dest
+	}
+}
+
+// isUUID returns true if s is a UUID (RFC 4122 variant) in its string representation
+func isUUID(s string) bool {
+	if !regexUUID.MatchString(s) {
+		return false
+	}
+	u, err := uuid.FromString(s)
+	return err == nil && u.Variant() == uuid.VariantRFC4122
+}
+
+// validateVerifyPayload - validates request payload of a verification request
+// returns identities (orig and dest to match JWT claims against), iat and identity header value
+func validateVerifyPayload(r map[string]interface{}, normalize bool) (identities, int64, string, string, error) {
+	var iat int64
+	var id identities
+	var identity string
+
+	if r["dest"] == nil || r["iat"] == nil || r["orig"] == nil || r["identity"] == nil {
+		return id, iat, identity, "VESPER-4103", fmt.Errorf("one or more of the require fields missing in request payload")
+	}
+	// request payload should not contain more than the expected fields
+	if len(r) != 4 {
+		return id, iat, identity, "VESPER-4104", fmt.Errorf("request payload has more than expected fields")
+	}
+
+	// iat ...
+	switch v := r["iat"].(type) {
+	case float64:
+		iat = int64(v)
+		if iat <= 0 {
+			return id, iat, identity, "VESPER-4105", fmt.Errorf("iat value in request payload is <= 0")
+		}
+	default:
+		return id, iat, identity, "VESPER-4106", fmt.Errorf("iat field in request payload MUST be a number")
+	}
+
+	// identity ...
+	switch v := r["identity"].(type) {
+	case string:
+		identity = v
+		if len(strings.TrimSpace(identity)) == 0 {
+			return id, iat, identity, "VESPER-4107", fmt.Errorf("identity field in request payload is an empty string")
+		}
+	default:
+		return id, iat, identity, "VESPER-4108", fmt.Errorf("identity field in request payload MUST be a string")
+	}
+
+	// orig ... (an array with one TN or URI)
+	switch orig := r["orig"].(type) {
+	case map[string]interface{}:
+		switch {
+		case len(orig) == 0 :
+			return id, iat, identity, "VESPER-4109", fmt.Errorf("orig in request payload is an empty object")
+		case len(orig) > 1 :
+			return id, iat, identity, "VESPER-4110", fmt.Errorf("orig in request payload should contain only one field")
+		}
+		// field should be "tn" or "uri"
+		switch origKey(orig) {
+		case "tn":
+			ot, ok := orig["tn"].([]interface{})
+			switch {
+			case !ok :
+				return id, iat, identity, "VESPER-4116", fmt.Errorf("orig tn in request payload is not an array")
+			case len(ot) == 0 :
+				return id, iat, identity, "VESPER-4112", fmt.Errorf("orig tn in request payload is an empty array")
+			case len(ot) != 1 :
+				return id, iat, identity, "VESPER-4113", fmt.Errorf("orig tn array contains more than one element in request payload")
+			}
+			tn, ok := ot[0].(string)
+			if !ok {
+				return id, iat, identity, "VESPER-4114", fmt.Errorf("orig tn in request payload is not a string")
+			}
+			if len(strings.TrimSpace(tn)) == 0 {
+				return id, iat, identity, "VESPER-4115", fmt.Errorf("orig tn in request payload is an empty string")
+			}
+			id.origTN = tn
+		case "uri":
+			ou, ok := orig["uri"].([]interface{})
+			switch {
+			case !ok :
+				return id, iat, identity, "VESPER-4174", fmt.Errorf("orig uri in request payload is not an array")
+			case len(ou) == 0 :
+				return id, iat, identity, "VESPER-4175", fmt.Errorf("orig uri in request payload is an empty array")
+			case len(ou) != 1 :
+				return id, iat, identity, "VESPER-4176", fmt.Errorf("orig uri array contains more than one element in request payload")
+			}
+			u, ok := ou[0].(string)
+			if !ok {
+				return id, iat, identity, "VESPER-4177", fmt.Errorf("orig uri in request payload is not a string")
+			}
+			if len(strings.TrimSpace(u)) == 0 {
+				return id, iat, identity, "VESPER-4178", fmt.Errorf("orig uri in request payload is an empty string")
+			}
+			if !isURI(u) {
+				return id, iat, identity, "VESPER-4179", fmt.Errorf("orig uri %v in request payload is not a valid URI", u)
+			}
+			id.origURI = u
+		default:
+			return id, iat, identity, "VESPER-4111", fmt.Errorf("orig in request payload does not contain field \"tn\" or \"uri\"")
+		}
+	default:
+		return id, iat, identity, "VESPER-4117", fmt.Errorf("orig field in request payload MUST be a JSON object")
+	}
+
+	// dest ... same as in signing request payload, with codes of verification
+	if errCode, err := validateDest(r, &id); err != nil {
+		return id, iat, identity, verifyDestCodes[errCode], err
+	}
+
+	// canonicalize TNs (RFC 8224), if configured
+	if errCode, err := normalizeIdentities(&id, normalize); err != nil {
+		switch errCode {
+		case "VESPER-4037":
+			errCode = "VESPER-4170"
+		case "VESPER-4038":
+			errCode = "VESPER-4171"
+		}
+		return id, iat, identity, errCode, err
+	}
+	return id, iat, identity, "", nil
+}
+
+// verifyDestCodes - codes of dest errors (see validateDest) in verification request payload
+var verifyDestCodes = map[string]string{
+	"VESPER-4018"		: "VESPER-4118",
+	"VESPER-4019"		: "VESPER-4119",
+	"VESPER-4020"		: "VESPER-4120",
+	"VESPER-4021"		: "VESPER-4121",
+	"VESPER-4022"		: "VESPER-4122",
+	"VESPER-4023"		: "VESPER-4123",
+	"VESPER-4024"		: "VESPER-4124",
+	"VESPER-4025"		: "VESPER-4125",
+	"VESPER-4042"		: "VESPER-4180",
+	"VESPER-4043"		: "VESPER-4181",
+	"VESPER-4044"		: "VESPER-4182",
+	"VESPER-4045"		: "VESPER-4183",
+	"VESPER-4046"		: "VESPER-4184",
+}
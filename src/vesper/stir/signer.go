@@ -0,0 +1,254 @@
+package stir
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"net/http"
+	"github.com/satori/go.uuid"
+	"vesper/attestation"
+	"vesper/e164"
+)
+
+// Attester - attestation engine that determines attestation level and origid of a call from the
+// calling customer and/or trunk. *attestation.Engine is an attester
+type Attester interface {
+	Decide(customer, trunk, origTN string) (*attestation.Decision, error)
+}
+
+// Policy - signing policy of the tenant on whose behalf a request is signed.
+// *tenants.Tenant is a policy
+type Policy interface {
+	AttestAllowed(attest string) bool
+	RphAllowed(v string) bool
+}
+
+// SignerOptions - options of a Signer. Credentials is required
+type SignerOptions struct {
+	Credentials				Credentials
+	Attester					Attester			// "caller" in request payload is not supported if nil
+	Clock							Clock
+	NormalizeTNs			bool					// canonicalize TNs in claims (RFC 8224)
+	StampIat					bool					// replace iat in request payload by current time
+	MaxIatDrift				int64					// max drift (seconds) of iat from current time, if StampIat (0 - no limit)
+	OrigIDUUID				bool					// origid MUST be a RFC 4122 UUID (generated if not present)
+}
+
+// Signer - STI-AS. Signs request payloads into SHAKEN (or "rph") PASSporTs
+type Signer struct {
+	opts			SignerOptions
+}
+
+// SignRequest - signing request. Payload is the request payload of POST /stir/v1/signing (without
+// "tenant" field); it is modified (e.g. "caller" is replaced by "attest" and "origid")
+type SignRequest struct {
+	Payload					map[string]interface{}
+	Credentials			Credentials			// overrides credentials of Signer (e.g. of a tenant), if not nil
+	Policy					Policy					// policy of the tenant on whose behalf the request is signed, if any
+}
+
+// SignResult - signed PASSporT
+type SignResult struct {
+	Ppt							string									// "shaken" or "rph"
+	Identity				string									// Identity header value
+	X5u							string
+	Claims					map[string]interface{}
+	Attestation			*attestation.Decision		// attestation decision, if request payload contains "caller"
+}
+
+// NewSigner returns a Signer with options o
+func NewSigner(o SignerOptions) (*Signer, error) {
+	if o.Credentials == nil {
+		return nil, fmt.Errorf("signing credentials are not configured")
+	}
+	return &Signer{opts: o}, nil
+}
+
+// Sign validates the request payload in r and signs it. Errors are *Error
+func (s *Signer) Sign(r SignRequest) (*SignResult, error) {
+	t := s.opts.Clock.now()
+	if r.Payload == nil {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4003", "one or more of the require fields missing in request payload")
+	}
+	credentials := s.opts.Credentials
+	if r.Credentials != nil {
+		credentials = r.Credentials
+	}
+	// PASSporT type - "shaken" unless request payload says otherwise
+	ppt, errCode, err := passportType(r.Payload)
+	if err != nil {
+		return nil, &Error{Code: errCode, Status: http.StatusBadRequest, Err: err}
+	}
+	if ppt == "rph" {
+		return s.signRph(r, credentials, t)
+	}
+	// determine attestation level and origid, if request payload contains caller identity
+	decision, err := s.decideAttestation(r.Payload)
+	if err != nil {
+		return nil, err
+	}
+	// server side iat and origid, if configured
+	if err := s.applySigningDefaults(r.Payload, t); err != nil {
+		return nil, err
+	}
+	orderedMap, id, _, _, errCode, err := validatePayload(r.Payload, s.opts.NormalizeTNs)
+	if err != nil {
+		return nil, &Error{Code: errCode, Status: http.StatusBadRequest, Err: err}
+	}
+	canonicalClaims(orderedMap, id, s.opts.NormalizeTNs)
+	if r.Policy != nil && !r.Policy.AttestAllowed(orderedMap["attest"].(string)) {
+		return nil, errorf(http.StatusForbidden, "VESPER-4029", "attest level %v is not permitted by tenant policy", orderedMap["attest"])
+	}
+	// delegate certificate covering orig TN, if any. Otherwise (including orig URI), SPC level certificate
+	x, p := credentials.SigningForTN(id.origTN)
+	// at this point, the input has been validated
+	identity, errCode, err := createPassport(ppt, x, p, orderedMap)
+	if err != nil {
+		return nil, &Error{Code: errCode, Status: http.StatusInternalServerError, Err: err}
+	}
+	return &SignResult{Ppt: ppt, Identity: identity, X5u: x, Claims: orderedMap, Attestation: decision}, nil
+}
+
+// signRph signs a "rph" PASSporT (RFC 8443) asserting the resource priority values in
+// "rph" field of request payload. The request MUST be made on behalf of a tenant whose
+// policy authorizes all asserted values
+func (s *Signer) signRph(r SignRequest, credentials Credentials, t time.Time) (*SignResult, error) {
+	// server side iat, if configured
+	if err := s.stampIat(r.Payload, t); err != nil {
+		return nil, err
+	}
+	orderedMap, id, _, auth, errCode, err := validateRphPayload(r.Payload, s.opts.NormalizeTNs)
+	if err != nil {
+		return nil, &Error{Code: errCode, Status: http.StatusBadRequest, Err: err}
+	}
+	canonicalClaims(orderedMap, id, s.opts.NormalizeTNs)
+	// authorization
+	if r.Policy == nil {
+		return nil, errorf(http.StatusForbidden, "VESPER-4051", "rph PASSporT signing request is not made on behalf of a tenant")
+	}
+	for _, v := range auth {
+		if !r.Policy.RphAllowed(v) {
+			return nil, errorf(http.StatusForbidden, "VESPER-4051", "resource priority value %v is not permitted by tenant policy", v)
+		}
+	}
+	x, p := credentials.SigningForTN(id.origTN)
+	identity, errCode, err := createPassport("rph", x, p, orderedMap)
+	if err != nil {
+		return nil, &Error{Code: errCode, Status: http.StatusInternalServerError, Err: err}
+	}
+	return &SignResult{Ppt: "rph", Identity: identity, X5u: x, Claims: orderedMap}, nil
+}
+
+// decideAttestation determines attestation level and origid using the attestation
+// engine, if request payload contains "caller" field (calling customer and/or trunk).
+// "caller" field is replaced by "attest" and "origid" (unless present) fields in request payload.
+// nil decision (and no error) is returned if request payload does not contain "caller" field
+func (s *Signer) decideAttestation(r map[string]interface{}) (*attestation.Decision, error) {
+	v, ok := r["caller"]
+	if !ok {
+		return nil, nil
+	}
+	if s.opts.Attester == nil {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4034", "caller field in request payload is not supported - attestation engine is not configured")
+	}
+	if _, ok := r["attest"]; ok {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4032", "attest field MUST NOT be present in request payload when caller field is present")
+	}
+	caller, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4030", "caller field in request payload MUST be a JSON object")
+	}
+	customer, cok := caller["customer"].(string)
+	trunk, tok := caller["trunk"].(string)
+	if (caller["customer"] != nil && !cok) || (caller["trunk"] != nil && !tok) || len(caller) > 2 || (len(strings.TrimSpace(customer)) == 0 && len(strings.TrimSpace(trunk)) == 0) {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4031", "caller in request payload MUST contain customer and/or trunk strings only")
+	}
+	// orig tn is validated later along with the rest of the request payload. It is matched
+	// against TN inventory in canonical form, as it is signed
+	var origTN string
+	if o, ok := r["orig"].(map[string]interface{}); ok {
+		origTN, _ = o["tn"].(string)
+	}
+	if s.opts.NormalizeTNs && len(origTN) > 0 {
+		n, err := e164.Normalize(origTN)
+		if err != nil {
+			return nil, errorf(http.StatusBadRequest, "VESPER-4037", "%v - orig tn in request payload is not a valid telephone number", err)
+		}
+		origTN = n
+	}
+	d, err := s.opts.Attester.Decide(customer, trunk, origTN)
+	if err != nil {
+		return nil, &Error{Code: "VESPER-4033", Status: http.StatusBadRequest, Err: err}
+	}
+	delete(r, "caller")
+	r["attest"] = d.Attest
+	// origid in request payload, if any, takes precedence
+	if o, ok := r["origid"].(string); ok {
+		d.OrigID = o
+	} else if _, ok := r["origid"]; !ok {
+		r["origid"] = d.OrigID
+	}
+	return d, nil
+}
+
+// applySigningDefaults stamps iat and generates/validates origid in request payload, as configured.
+// If StampIat is true, iat in request payload is replaced by current time t (after validating
+// that it does not drift from t by more than MaxIatDrift seconds, if set).
+// If OrigIDUUID is true, origid in request payload MUST be a RFC 4122 UUID. A UUID is
+// generated if origid is not present in request payload
+func (s *Signer) applySigningDefaults(r map[string]interface{}, t time.Time) error {
+	if err := s.stampIat(r, t); err != nil {
+		return err
+	}
+	if s.opts.OrigIDUUID {
+		switch v := r["origid"].(type) {
+		case nil:
+			if _, ok := r["origid"]; !ok {
+				r["origid"] = uuid.NewV4().String()
+			}
+		case string:
+			if !isUUID(v) {
+				return errorf(http.StatusBadRequest, "VESPER-4036", "origid %v in request payload is not a RFC 4122 UUID", v)
+			}
+		}
+	}
+	return nil
+}
+
+// stampIat replaces iat in request payload by current time t, if StampIat is true
+// iat in request payload, if any, MUST NOT drift from t by more than MaxIatDrift seconds (if set)
+func (s *Signer) stampIat(r map[string]interface{}, t time.Time) error {
+	if s.opts.StampIat {
+		if v, ok := r["iat"]; ok {
+			iat, ok := v.(float64)
+			if !ok {
+				return errorf(http.StatusBadRequest, "VESPER-4009", "iat field in request payload MUST be a number")
+			}
+			drift := t.Unix() - int64(iat)
+			if drift < 0 {
+				drift = -drift
+			}
+			if d := s.opts.MaxIatDrift; d > 0 && drift > d {
+				return errorf(http.StatusBadRequest, "VESPER-4035", "iat value (%v seconds) in request payload drifts from current time by %v seconds", int64(iat), drift)
+			}
+		}
+		r["iat"] = float64(t.Unix())
+	}
+	return nil
+}
+
+// passportType returns PASSporT type in "ppt" field of request payload ("shaken", if not present)
+// "ppt" field, if present, is removed from request payload
+func passportType(r map[string]interface{}) (string, string, error) {
+	v, ok := r["ppt"]
+	if !ok {
+		return "shaken", "", nil
+	}
+	delete(r, "ppt")
+	switch ppt, _ := v.(string); ppt {
+	case "shaken", "rph":
+		return ppt, "", nil
+	default:
+		return "", "VESPER-4050", fmt.Errorf("ppt %v in request payload is not supported", v)
+	}
+}
@@ -0,0 +1,89 @@
+// Package stir signs and verifies PASSporTs (RFC 8225, SHAKEN and RFC 8443 "rph") and the Identity
+// header values that carry them (RFC 8224). It has no global state: a Signer or Verifier is built
+// from explicit options, with pluggable credential, trust, cache and clock sources, and the
+// Vesper HTTP API is a thin layer on top of it. Request payloads are the JSON objects documented
+// in APIs.md (decoded into map[string]interface{}); errors are *Error carrying the VESPER code
+package stir
+
+import (
+	"fmt"
+	"time"
+	"regexp"
+	"crypto/ecdsa"
+	"crypto/x509"
+)
+
+// Error - error in signing or verification. Code is the VESPER code of the error (see errorhandler
+// for its reason string) and Status the HTTP status the Vesper API reports it with
+type Error struct {
+	Code				string
+	Status			int
+	Err					error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// errorf returns an *Error with code, status and an error formatted as in fmt.Errorf
+func errorf(status int, code, format string, a ...interface{}) error {
+	return &Error{Code: code, Status: status, Err: fmt.Errorf(format, a...)}
+}
+
+// Credentials - source of signing credentials: x5u and private key of the delegate certificate
+// covering orig TN tn, if any. Otherwise (including empty tn), of the SPC level certificate.
+// *signcredentials.SigningCredentials is a source of credentials
+type Credentials interface {
+	SigningForTN(tn string) (string, *ecdsa.PrivateKey)
+}
+
+// Trust - source of root certs. Roots returns the trust domain (empty if there is only one) and
+// the root certs that the certificate at x5u is validated against. apiKey is the API key of the
+// verification request, if any
+type Trust interface {
+	Roots(apiKey, x5u string) (string, *x509.CertPool)
+}
+
+// ReplayCache - claims of verified PASSporTs by iat, to detect replay attacks.
+// *replayattack.Cache is a replay cache
+type ReplayCache interface {
+	IsPresent(key interface{}, value interface{}) bool
+	Add(key interface{}, value interface{})
+}
+
+// KeyCache - public keys (and certificates) fetched from x5u. Keys are x5u, prefixed by the trust
+// domain (if any). roots are fingerprints of the root certs the certificate chained to
+type KeyCache interface {
+	Fetch(key string) *ecdsa.PublicKey
+	Certificate(key string) *x509.Certificate
+	Add(key string, pk *ecdsa.PublicKey, c *x509.Certificate, roots ...string)
+}
+
+// DenyList - certificates (or x5u) that are not trusted. Match returns the reason, if c or x5u is
+// on the deny list. *denylist.DenyList is a deny list
+type DenyList interface {
+	Match(c *x509.Certificate, x5u string) (string, bool)
+}
+
+// Revocations - revoked certificates. Revoked returns true if c is revoked.
+// *stipa.Client (STI-PA CRL) is a source of revocations
+type Revocations interface {
+	Revoked(c *x509.Certificate) bool
+}
+
+// Clock returns the current time. time.Now is used if not set in options
+type Clock func() time.Time
+
+func (c Clock) now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c()
+}
+
+var (
+	regexInfo			= regexp.MustCompile(`^info=<..*>$`)
+	regexUUID			= regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	// resource priority value (RFC 4412) - namespace.priority
+	regexRph			= regexp.MustCompile(`^[a-zA-Z0-9\-!%*_+'~]+\.[a-zA-Z0-9\-!%*_+'~]+$`)
+)
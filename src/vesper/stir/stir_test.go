@@ -0,0 +1,313 @@
+package stir
+
+import (
+	"testing"
+	"time"
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"vesper/attestation"
+)
+
+// credentials - self-signed certificate served at x5u
+type credentials struct {
+	x5u			string
+	key			*ecdsa.PrivateKey
+}
+
+func (c credentials) SigningForTN(tn string) (string, *ecdsa.PrivateKey) {
+	return c.x5u, c.key
+}
+
+// replayCache - replay cache of one (iat, claims) per iat
+type replayCache map[interface{}]interface{}
+
+func (c replayCache) IsPresent(key interface{}, value interface{}) bool {
+	v, ok := c[key]
+	return ok && v == value
+}
+
+func (c replayCache) Add(key interface{}, value interface{}) {
+	c[key] = value
+}
+
+// trust - root certs of a single trust domain
+type trust struct {
+	roots		*x509.CertPool
+}
+
+func (t trust) Roots(apiKey, x5u string) (string, *x509.CertPool) {
+	return "", t.roots
+}
+
+// revocations - revoked serial numbers
+type revocations map[int64]bool
+
+func (r revocations) Revoked(c *x509.Certificate) bool {
+	return r[c.SerialNumber.Int64()]
+}
+
+// newCredentials serves a self-signed (root) certificate and returns its credentials and root certs
+func newCredentials(t *testing.T) (credentials, *x509.CertPool, func()) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "SHAKEN 1234"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: true,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c, _ := x509.ParseCertificate(b)
+	roots := x509.NewCertPool()
+	roots.AddCert(c)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: b})
+	}))
+	return credentials{x5u: s.URL + "/1.pem", key: k}, roots, s.Close
+}
+
+func code(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return ""
+}
+
+func TestSignVerify(t *testing.T) {
+	cr, roots, stop := newCredentials(t)
+	defer stop()
+	now := time.Unix(time.Now().Unix(), 0)
+	clock := func() time.Time { return now }
+	s, err := NewSigner(SignerOptions{Credentials: cr, Clock: clock, StampIat: true, NormalizeTNs: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	res, err := s.Sign(SignRequest{Payload: map[string]interface{}{
+		"attest": "A",
+		"dest": map[string]interface{}{"tn": []interface{}{"+1 215 555 1234"}},
+		"orig": map[string]interface{}{"tn": "12155551212"},
+		"origid": "123e4567-e89b-12d3-a456-426655440000",
+	}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if res.Ppt != "shaken" || res.X5u != cr.x5u || res.Claims["iat"] != float64(now.Unix()) {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	cache := replayCache{}
+	v, err := NewVerifier(VerifierOptions{Trust: trust{roots}, Cache: cache, Clock: clock, NormalizeTNs: true, ValidIatPeriod: 60, VerifyRootCA: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	payload := func(dest string) map[string]interface{} {
+		return map[string]interface{}{
+			"dest": map[string]interface{}{"tn": []interface{}{dest}},
+			"iat": float64(now.Unix()),
+			"orig": map[string]interface{}{"tn": []interface{}{"12155551212"}},
+			"identity": res.Identity,
+		}
+	}
+	if _, err := v.Verify(context.Background(), VerifyRequest{Payload: payload("12155551235")}); code(err) != "VESPER-4155" {
+		t.Errorf("dest mismatch - got %v (%v), want VESPER-4155", code(err), err)
+	}
+	vr, err := v.Verify(context.Background(), VerifyRequest{Payload: payload("12155551234")})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if vr.Ppt != "shaken" || vr.X5u != cr.x5u || vr.Iat != now.Unix() {
+		t.Errorf("unexpected result %+v", vr)
+	}
+	if _, err := v.Verify(context.Background(), VerifyRequest{Payload: payload("12155551234")}); code(err) != "VESPER-4169" {
+		t.Errorf("replay - got %v (%v), want VESPER-4169", code(err), err)
+	}
+	if _, err := v.Verify(context.Background(), VerifyRequest{Payload: payload("12155551234"), NoReplayCheck: true}); err != nil {
+		t.Errorf("replay not checked - %v", err)
+	}
+	// stale
+	now = now.Add(61*time.Second)
+	if _, err := v.Verify(context.Background(), VerifyRequest{Payload: payload("12155551234"), NoReplayCheck: true}); code(err) != "VESPER-4167" {
+		t.Errorf("stale - got %v (%v), want VESPER-4167", code(err), err)
+	}
+	// certificate is revoked
+	now = now.Add(-61*time.Second)
+	v, _ = NewVerifier(VerifierOptions{Trust: trust{roots}, Revocations: revocations{1: true}, ValidIatPeriod: 60, VerifyRootCA: true, Clock: clock})
+	if _, err := v.Verify(context.Background(), VerifyRequest{Payload: payload("12155551234")}); code(err) != "VESPER-4191" {
+		t.Errorf("revoked - got %v (%v), want VESPER-4191", code(err), err)
+	}
+	// certificate is not trusted
+	v, _ = NewVerifier(VerifierOptions{Trust: trust{x509.NewCertPool()}, ValidIatPeriod: 60, VerifyRootCA: true, Clock: clock})
+	if _, err := v.Verify(context.Background(), VerifyRequest{Payload: payload("12155551234")}); code(err) != "VESPER-4161" {
+		t.Errorf("untrusted - got %v (%v), want VESPER-4161", code(err), err)
+	}
+	// no root certs (e.g. not fetched yet)
+	v, _ = NewVerifier(VerifierOptions{Trust: trust{nil}, ValidIatPeriod: 60, VerifyRootCA: true, Clock: clock})
+	if _, err := v.Verify(context.Background(), VerifyRequest{Payload: payload("12155551234")}); code(err) != "VESPER-4161" {
+		t.Errorf("no root certs - got %v (%v), want VESPER-4161", code(err), err)
+	}
+	if _, err := NewVerifier(VerifierOptions{ValidIatPeriod: 60, VerifyRootCA: true}); err == nil {
+		t.Errorf("NewVerifier() without trust returned no error")
+	}
+}
+
+// an expired certificate at x5u is rejected, whether or not it is validated against root certs
+func TestVerifyExpiredCertificate(t *testing.T) {
+	cr, roots, stop := newCredentials(t)
+	defer stop()
+	// certificate expired an hour ago
+	now := time.Unix(time.Now().Add(2*time.Hour).Unix(), 0)
+	clock := func() time.Time { return now }
+	s, _ := NewSigner(SignerOptions{Credentials: cr, Clock: clock, StampIat: true})
+	res, err := s.Sign(SignRequest{Payload: map[string]interface{}{
+		"attest": "A",
+		"dest": map[string]interface{}{"tn": []interface{}{"12155551213"}},
+		"orig": map[string]interface{}{"tn": "12155551212"},
+		"origid": "123e4567-e89b-12d3-a456-426655440000",
+	}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	payload := map[string]interface{}{
+		"dest": map[string]interface{}{"tn": []interface{}{"12155551213"}},
+		"iat": float64(now.Unix()),
+		"orig": map[string]interface{}{"tn": []interface{}{"12155551212"}},
+		"identity": res.Identity,
+	}
+	for _, o := range []VerifierOptions{
+		{Trust: trust{roots}, ValidIatPeriod: 60, VerifyRootCA: true, Clock: clock},
+		{ValidIatPeriod: 60, Clock: clock},
+	} {
+		v, _ := NewVerifier(o)
+		if _, err := v.Verify(context.Background(), VerifyRequest{Payload: payload}); code(err) != "VESPER-4160" {
+			t.Errorf("verify root CA %v - got %v (%v), want VESPER-4160", o.VerifyRootCA, code(err), err)
+		}
+	}
+}
+
+func TestSignErrors(t *testing.T) {
+	cr := credentials{x5u: "https://cr.example.com/1.pem"}
+	cr.key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s, _ := NewSigner(SignerOptions{Credentials: cr})
+	tests := []struct {
+		payload		map[string]interface{}
+		policy		Policy
+		code			string
+		status		int
+	}{
+		{nil, nil, "VESPER-4003", http.StatusBadRequest},
+		{map[string]interface{}{"attest": "D", "dest": map[string]interface{}{"tn": []interface{}{"1"}}, "iat": 1.0, "orig": map[string]interface{}{"tn": "2"}, "origid": "x"}, nil, "VESPER-4006", http.StatusBadRequest},
+		{map[string]interface{}{"attest": "A", "dest": map[string]interface{}{}, "iat": 1.0, "orig": map[string]interface{}{"tn": "2"}, "origid": "x"}, nil, "VESPER-4018", http.StatusBadRequest},
+		{map[string]interface{}{"caller": map[string]interface{}{"trunk": "t"}}, nil, "VESPER-4034", http.StatusBadRequest},
+		{map[string]interface{}{"ppt": "div"}, nil, "VESPER-4050", http.StatusBadRequest},
+		{map[string]interface{}{"ppt": "rph", "rph": map[string]interface{}{"auth": []interface{}{"ets.0"}}, "dest": map[string]interface{}{"tn": []interface{}{"1"}}, "iat": 1.0, "orig": map[string]interface{}{"tn": "2"}}, nil, "VESPER-4051", http.StatusForbidden},
+		// JSON null values
+		{map[string]interface{}{"attest": "A", "dest": map[string]interface{}{"tn": []interface{}{"1"}}, "iat": nil, "orig": map[string]interface{}{"tn": "2"}, "origid": "x"}, nil, "VESPER-4003", http.StatusBadRequest},
+		{map[string]interface{}{"attest": "A", "dest": map[string]interface{}{"tn": nil}, "iat": 1.0, "orig": map[string]interface{}{"tn": "2"}, "origid": "x"}, nil, "VESPER-4024", http.StatusBadRequest},
+		{map[string]interface{}{"attest": "A", "dest": map[string]interface{}{"tn": []interface{}{nil}}, "iat": 1.0, "orig": map[string]interface{}{"tn": "2"}, "origid": "x"}, nil, "VESPER-4022", http.StatusBadRequest},
+		{map[string]interface{}{"attest": "A", "dest": map[string]interface{}{"uri": nil}, "iat": 1.0, "orig": map[string]interface{}{"tn": "2"}, "origid": "x"}, nil, "VESPER-4042", http.StatusBadRequest},
+		{map[string]interface{}{"attest": "A", "dest": map[string]interface{}{"tn": []interface{}{"1"}}, "iat": 1.0, "orig": map[string]interface{}{"tn": nil}, "origid": "x"}, nil, "VESPER-4015", http.StatusBadRequest},
+		{map[string]interface{}{"attest": "A", "dest": map[string]interface{}{"tn": []interface{}{"1"}}, "iat": 1.0, "orig": map[string]interface{}{"uri": nil}, "origid": "x"}, nil, "VESPER-4039", http.StatusBadRequest},
+		{map[string]interface{}{"attest": nil, "dest": map[string]interface{}{"tn": []interface{}{"1"}}, "iat": 1.0, "orig": nil, "origid": nil}, nil, "VESPER-4003", http.StatusBadRequest},
+		{map[string]interface{}{"ppt": "rph", "rph": map[string]interface{}{"auth": nil}, "dest": map[string]interface{}{"tn": []interface{}{"1"}}, "iat": 1.0, "orig": map[string]interface{}{"tn": "2"}}, nil, "VESPER-4047", http.StatusBadRequest},
+	}
+	for i, test := range tests {
+		_, err := s.Sign(SignRequest{Payload: test.payload, Policy: test.policy})
+		e, ok := err.(*Error)
+		if !ok || e.Code != test.code || e.Status != test.status {
+			t.Errorf("%v: got %#v, want %v (%v)", i, err, test.code, test.status)
+		}
+	}
+	if _, err := NewSigner(SignerOptions{}); err == nil {
+		t.Errorf("signer without credentials")
+	}
+}
+
+// JSON null values in request payload and JWT are rejected as invalid
+func TestVerifyErrors(t *testing.T) {
+	v, _ := NewVerifier(VerifierOptions{ValidIatPeriod: 60})
+	x5u := "https://cr.example.com/1.pem"
+	identity := func(header, claims string) string {
+		return base64Encode([]byte(header)) + "." + base64Encode([]byte(claims)) + ".c2ln;info=<" + x5u + ">"
+	}
+	claims := `{"attest":"A","dest":{"tn":["1"]},"iat":1,"orig":{"tn":"2"},"origid":"x"}`
+	header := `{"alg":"ES256","ppt":"shaken","typ":"passport","x5u":"` + x5u + `"}`
+	payload := func(orig, dest, iat, identity interface{}) map[string]interface{} {
+		return map[string]interface{}{"orig": orig, "dest": dest, "iat": iat, "identity": identity}
+	}
+	orig := map[string]interface{}{"tn": []interface{}{"2"}}
+	dest := map[string]interface{}{"tn": []interface{}{"1"}}
+	tests := []struct {
+		payload		map[string]interface{}
+		code			string
+	}{
+		{payload(orig, dest, nil, identity(header, claims)), "VESPER-4103"},
+		{payload(orig, dest, 1.0, nil), "VESPER-4103"},
+		{payload(nil, dest, 1.0, identity(header, claims)), "VESPER-4103"},
+		{payload(map[string]interface{}{"tn": nil}, dest, 1.0, identity(header, claims)), "VESPER-4116"},
+		{payload(map[string]interface{}{"tn": []interface{}{nil}}, dest, 1.0, identity(header, claims)), "VESPER-4114"},
+		{payload(map[string]interface{}{"uri": nil}, dest, 1.0, identity(header, claims)), "VESPER-4174"},
+		{payload(orig, map[string]interface{}{"tn": nil}, 1.0, identity(header, claims)), "VESPER-4124"},
+		{payload(orig, map[string]interface{}{"uri": []interface{}{nil}}, 1.0, identity(header, claims)), "VESPER-4182"},
+		{payload(orig, dest, 1.0, identity(`{"alg":"ES256","ppt":"shaken","typ":"passport","x5u":null}`, claims)), "VESPER-4133"},
+		{payload(orig, dest, 1.0, identity(header, `{"attest":"A","dest":{"tn":null},"iat":1,"orig":{"tn":"2"},"origid":"x"}`)), "VESPER-4024"},
+		{payload(orig, dest, 1.0, identity(header, `{"attest":"A","dest":{"tn":["1"]},"iat":1,"orig":{"tn":null},"origid":"x"}`)), "VESPER-4015"},
+	}
+	for i, test := range tests {
+		_, err := v.Verify(context.Background(), VerifyRequest{Payload: test.payload})
+		if code(err) != test.code {
+			t.Errorf("%v: got %v (%v), want %v", i, code(err), err, test.code)
+		}
+	}
+}
+
+// attester - attests A for orig TN tn only
+type attester struct {
+	tn		string
+}
+
+func (a attester) Decide(customer, trunk, tn string) (*attestation.Decision, error) {
+	if tn == a.tn {
+		return &attestation.Decision{Attest: "A", OrigID: "123e4567-e89b-12d3-a456-426655440000"}, nil
+	}
+	return &attestation.Decision{Attest: "B", OrigID: "123e4567-e89b-12d3-a456-426655440000"}, nil
+}
+
+// orig TN is matched against TN inventory in canonical form, if TNs are normalized
+func TestSignAttestationNormalized(t *testing.T) {
+	cr := credentials{x5u: "https://cr.example.com/1.pem"}
+	cr.key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	payload := func(tn string) map[string]interface{} {
+		return map[string]interface{}{
+			"caller": map[string]interface{}{"customer": "c1"},
+			"dest": map[string]interface{}{"tn": []interface{}{"12155551213"}},
+			"iat": float64(time.Now().Unix()),
+			"orig": map[string]interface{}{"tn": tn},
+		}
+	}
+	s, _ := NewSigner(SignerOptions{Credentials: cr, Attester: attester{"12155551212"}, NormalizeTNs: true})
+	res, err := s.Sign(SignRequest{Payload: payload("+1 (215) 555-1212")})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if res.Attestation.Attest != "A" || res.Claims["attest"] != "A" {
+		t.Errorf("got attestation %v, want A", res.Attestation.Attest)
+	}
+	if _, err := s.Sign(SignRequest{Payload: payload("+1 (215) 555-121x")}); code(err) != "VESPER-4037" {
+		t.Errorf("got %v, want VESPER-4037", err)
+	}
+}
@@ -0,0 +1,415 @@
+package stir
+
+import (
+	"fmt"
+	"errors"
+	"context"
+	"strings"
+	"net/http"
+	"io/ioutil"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"vesper/rootcerts"
+)
+
+// VerifierOptions - options of a Verifier. All sources are optional, except Trust if VerifyRootCA is
+// true: without Cache, replay attacks are not detected; without Keys, the certificate at x5u is
+// fetched for every request
+type VerifierOptions struct {
+	Trust							Trust
+	Cache							ReplayCache
+	Keys							KeyCache
+	DenyList					DenyList
+	Revocations				Revocations
+	HTTPClient				*http.Client				// fetches certificates at x5u (http.DefaultClient if nil)
+	Clock							Clock
+	NormalizeTNs			bool								// canonicalize TNs (RFC 8224) before comparing them
+	BasePassport			bool								// verify base PASSporTs (RFC 8225) as well as SHAKEN and "rph"
+	AllowedAlgs				[]string						// algorithms allowed (in addition to ES256) for base PASSporTs
+	ValidIatPeriod		int64								// seconds after iat in JWT claims that a PASSporT is verified
+	VerifyRootCA			bool								// validate certificate at x5u against root certs
+}
+
+// Verifier - STI-VS. Verifies Identity header values against the orig and dest of the call
+type Verifier struct {
+	opts			VerifierOptions
+}
+
+// VerifyRequest - verification request. Payload is the request payload of POST /stir/v1/verification
+type VerifyRequest struct {
+	Payload					map[string]interface{}
+	APIKey					string					// API key of the request, passed to Trust to select trust domain
+	NoReplayCheck		bool						// PASSporT is not checked against (nor added to) replay cache
+}
+
+// VerifyResult - verified PASSporT
+type VerifyResult struct {
+	Ppt							string									// "shaken", "rph" or empty (base PASSporT)
+	X5u							string
+	Iat							int64										// iat in JWT claims
+	Header					map[string]interface{}	// JWT header
+	Claims					map[string]interface{}	// JWT claims
+	TrustDomain			string									// trust domain the certificate at x5u is validated in
+}
+
+// NewVerifier returns a Verifier with options o
+func NewVerifier(o VerifierOptions) (*Verifier, error) {
+	if o.ValidIatPeriod <= 0 {
+		return nil, fmt.Errorf("valid iat period %v MUST be greater than 0", o.ValidIatPeriod)
+	}
+	if o.VerifyRootCA && o.Trust == nil {
+		return nil, fmt.Errorf("root certs (trust) MUST be set to validate certificates at x5u against root certs")
+	}
+	return &Verifier{opts: o}, nil
+}
+
+// Verify validates the request payload in r and verifies its identity header value: JWT header and
+// claims, orig and dest, iat, replay, certificate at x5u and signature. Errors are *Error
+func (v *Verifier) Verify(ctx context.Context, r VerifyRequest) (*VerifyResult, error) {
+	t := v.opts.Clock.now()
+	id, iat, identity, errCode, err := validateVerifyPayload(r.Payload, v.opts.NormalizeTNs)
+	if err != nil {
+		return nil, &Error{Code: errCode, Status: http.StatusBadRequest, Err: err}
+	}
+
+	// first extract the JWT in identity string
+	token := strings.Split(identity, ";")
+	// validate identity field
+	if len(token) < 2 {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4126", "Identity field does not contain all the relevant parameters in request payload")
+	}
+	// JWT
+	if jwt := strings.Split(token[0], "."); len(jwt) != 3 {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4127", "Invalid JWT format in identity field in request payload")
+	}
+	// Info parameter
+	if !regexInfo.MatchString(token[1]) {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4128", "Invalid info parameter in identity field in request payload")
+	}
+	info := token[1][6:len(token[1])-1]
+
+	// extract header from JWT for validation
+	// also get the x5u information required to verify signature
+	x5u, hh, err := v.validateHeader(token[0])
+	if err != nil {
+		return nil, err
+	}
+	// compare x5u and info
+	if x5u != info {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4131", "x5u value in JWT header does not match info parameter in identity field in request payload")
+	}
+	// ppt parameter in identity field MUST match ppt in JWT header; it is optional for "shaken" (RFC 8224 section 4)
+	// there is no ppt in base PASSporT (RFC 8225)
+	ppt, _ := hh["ppt"].(string)
+	pptParam := ""
+	for _, p := range token[2:] {
+		if strings.HasPrefix(p, "ppt=") {
+			pptParam = strings.Trim(p[4:], "\"")
+		}
+	}
+	if pptParam != ppt && !(ppt == "shaken" && pptParam == "") {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4187", "ppt parameter (%v) in identity field does not match ppt value (%v) in JWT header", pptParam, ppt)
+	}
+
+	// extract claims from JWT for validation
+	orderedMap, iatInClaims, err := v.validateClaims(token[0], ppt, id, iat, t.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	// replay attack validation
+	// convert ordered map to json string and check for replay attacks
+	claimsString, err := json.Marshal(orderedMap)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4168", "%v - unable to validate replay attack", err)
+	}
+	checkReplay := v.opts.Cache != nil && !r.NoReplayCheck
+	if checkReplay && v.opts.Cache.IsPresent(iatInClaims, string(claimsString)) {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4169", "possible replay attack - identity header repeated - JWT claims (%+v) is cached", string(claimsString))
+	}
+
+	// select trust domain (root certs) to validate certificate in x5u against
+	var domain string
+	var roots *x509.CertPool
+	if v.opts.Trust != nil {
+		domain, roots = v.opts.Trust.Roots(r.APIKey, x5u)
+	}
+	// nil roots would validate the certificate against the system roots
+	if v.opts.VerifyRootCA && roots == nil {
+		return nil, errorf(http.StatusBadRequest, "VESPER-4161", "no root certs to validate certificate at x5u %v against", x5u)
+	}
+
+	// verify signature
+	if err := v.verifySignature(ctx, domain, roots, x5u, token[0], hh["alg"].(string)); err != nil {
+		return nil, err
+	}
+	// cache claims in identity header to validate replay attacks in future
+	// note that caching happens only if verification is successful
+	if checkReplay {
+		v.opts.Cache.Add(iatInClaims, string(claimsString))
+	}
+	return &VerifyResult{Ppt: ppt, X5u: x5u, Iat: iatInClaims, Header: hh, Claims: orderedMap, TrustDomain: domain}, nil
+}
+
+// validateHeader - validate JWT header
+// check if expected key-values exist
+// if BasePassport is true, header is validated as per RFC 8225 (ppt is optional
+// and algorithms in AllowedAlgs are allowed). Otherwise, as per SHAKEN
+func (v *Verifier) validateHeader(j string) (string, map[string]interface{}, error) {
+	var x5u string
+	base := v.opts.BasePassport
+	s := strings.Split(j, ".")
+	// s[0] is the encoded header
+	h, err := base64Decode(s[0])
+	if err != nil {
+		return "", nil, errorf(http.StatusBadRequest, "VESPER-4150", "%v - unable to base64 url decode header part of JWT", err)
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(h, &m); err != nil {
+		return "", nil, errorf(http.StatusBadRequest, "VESPER-4151", "%v - unable to unmarshal decoded header to map[string]interface{}", err)
+	}
+	if !base && len(m) != 4 {
+		// not the expected number of fields in header
+		return "", nil, errorf(http.StatusBadRequest, "VESPER-4132", "decoded header does not have the expected number of fields (4)")
+	}
+	if m["alg"] == nil || (!base && m["ppt"] == nil) || m["typ"] == nil || m["x5u"] == nil {
+		return "", nil, errorf(http.StatusBadRequest, "VESPER-4133", "one or more of the required fields missing in JWT header")
+	}
+
+	// alg ...
+	switch alg := m["alg"].(type) {
+	case string:
+		if base && alg != "ES256" && !v.algAllowed(alg) {
+			return "", nil, errorf(http.StatusBadRequest, "VESPER-4188", "alg field value (%v) in JWT header is not an allowed algorithm", alg)
+		}
+		if !base && alg != "ES256" {
+			return "", nil, errorf(http.StatusBadRequest, "VESPER-4134", "alg field value in JWT header is not \"ES256\"")
+		}
+	default:
+		return "", nil, errorf(http.StatusBadRequest, "VESPER-4135", "alg field value in JWT header is not a string")
+	}
+
+	// ppt ... (optional in base PASSporT)
+	switch ppt := m["ppt"].(type) {
+	case nil:
+		// base PASSporT
+	case string:
+		if ppt != "shaken" && ppt != "rph" {
+			return "", nil, errorf(http.StatusBadRequest, "VESPER-4136", "ppt field value in JWT header is not \"shaken\" or \"rph\"")
+		}
+	default:
+		return "", nil, errorf(http.StatusBadRequest, "VESPER-4137", "ppt field value in JWT header is not a string")
+	}
+
+	// typ ...
+	switch typ := m["typ"].(type) {
+	case string:
+		if typ != "passport" {
+			return "", nil, errorf(http.StatusBadRequest, "VESPER-4138", "typ field value in JWT header is not \"passport\"")
+		}
+	default:
+		return "", nil, errorf(http.StatusBadRequest, "VESPER-4139", "typ field value in JWT header is not a string")
+	}
+
+	// x5u ...
+	switch v := m["x5u"].(type) {
+	case string:
+		x5u = v
+	default:
+		return "", nil, errorf(http.StatusBadRequest, "VESPER-4140", "x5u field value in JWT header is not a string")
+	}
+
+	return x5u, m, nil
+}
+
+// algAllowed returns true if alg is in AllowedAlgs
+func (v *Verifier) algAllowed(alg string) bool {
+	for _, a := range v.opts.AllowedAlgs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// validateClaims - validate JWT claims
+// check if expected key-values exist and match identities id in request payload
+func (v *Verifier) validateClaims(j, ppt string, id identities, iat, t int64) (map[string]interface{}, int64, error) {
+	s := strings.Split(j, ".")
+	// s[1] is the encoded claims
+	c, err := base64Decode(s[1])
+	if err != nil {
+		return nil, 0, errorf(http.StatusBadRequest, "VESPER-4152", "%v - unable to base64 url decode claims part of JWT", err)
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(c, &m); err != nil {
+		return nil, 0, errorf(http.StatusBadRequest, "VESPER-4153", "%v - unable to unmarshal decoded claims to map[string]interface{}", err)
+	}
+	var orderedMap map[string]interface{}
+	var idInClaims identities
+	var iatInClaims int64
+	var errCode string
+	switch ppt {
+	case "":
+		orderedMap, idInClaims, iatInClaims, errCode, err = validateBasePayload(m, v.opts.NormalizeTNs)
+	case "rph":
+		orderedMap, idInClaims, iatInClaims, _, errCode, err = validateRphPayload(m, v.opts.NormalizeTNs)
+	default:
+		orderedMap, idInClaims, iatInClaims, _, errCode, err = validatePayload(m, v.opts.NormalizeTNs)
+	}
+	if err != nil {
+		// malformed TNs in JWT claims have their own codes
+		switch errCode {
+		case "VESPER-4037":
+			errCode = "VESPER-4172"
+		case "VESPER-4038":
+			errCode = "VESPER-4173"
+		}
+		return nil, 0, &Error{Code: errCode, Status: http.StatusBadRequest, Err: err}
+	}
+	// validate orig TN
+	if idInClaims.origTN != id.origTN {
+		return nil, 0, errorf(http.StatusBadRequest, "VESPER-4154", "orig TN %v in request payload does not match orig TN in JWT claims (%+v)", id.origTN, m)
+	}
+	// validate orig URI - scheme and host are case-insensitive
+	if canonicalURI(idInClaims.origURI) != canonicalURI(id.origURI) {
+		return nil, 0, errorf(http.StatusBadRequest, "VESPER-4185", "orig URI %v in request payload does not match orig URI in JWT claims (%+v)", id.origURI, m)
+	}
+	// validate dest TNs
+	if !matchIdentities(id.destTNs, idInClaims.destTNs, nil) {
+		return nil, 0, errorf(http.StatusBadRequest, "VESPER-4155", "dest TNs %+v in request payload does not match dest TNs in JWT claims (%+v)", id.destTNs, m)
+	}
+	// validate dest URIs
+	if !matchIdentities(id.destURIs, idInClaims.destURIs, canonicalURI) {
+		return nil, 0, errorf(http.StatusBadRequest, "VESPER-4186", "dest URIs %+v in request payload does not match dest URIs in JWT claims (%+v)", id.destURIs, m)
+	}
+	// iat in JWT validation
+	if t > iatInClaims + v.opts.ValidIatPeriod {
+		return nil, 0, errorf(http.StatusBadRequest, "VESPER-4167", "iat value (%v seconds) in JWT claims indicates stale date", iatInClaims)
+	}
+	return orderedMap, iatInClaims, nil
+}
+
+// verifySignature is called to verify the signature which was created
+// using alg (ES256, unless allowed otherwise by AllowedAlgs) algorithm.
+// If the signature is verified, the function returns nil. Otherwise,
+// an *Error is returned
+// certificate in x5u is validated against roots of trust domain td. Public keys are cached
+// per trust domain since a certificate trusted in one trust domain may not be in another
+func (v *Verifier) verifySignature(ctx context.Context, td string, roots *x509.CertPool, x5u, token, alg string) error {
+	key := x5u
+	if len(td) > 0 {
+		key = td + " " + x5u
+	}
+	var pk *ecdsa.PublicKey
+	var cert *x509.Certificate
+	if v.opts.Keys != nil {
+		pk = v.opts.Keys.Fetch(key)
+		cert = v.opts.Keys.Certificate(key)
+	}
+	if pk == nil {
+		var chains [][]*x509.Certificate
+		var err error
+		cert, chains, err = v.fetchCertificate(ctx, roots, x5u)
+		if err != nil {
+			return err
+		}
+		// ES256
+		var ok bool
+		pk, ok = cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return errorf(http.StatusBadRequest, "VESPER-4165", "Value returned from ParsePKIXPublicKey was not an ECDSA public key")
+		}
+		// add to cache along with root certs the certificate chained to, so that the public key is
+		// evicted if any of the root certs is removed
+		if v.opts.Keys != nil {
+			var anchors []string
+			for _, c := range chains {
+				anchors = append(anchors, rootcerts.Fingerprint(c[len(c)-1]))
+			}
+			v.opts.Keys.Add(key, pk, cert, anchors...)
+		}
+	}
+	// deny list is checked for cached public keys as well, so that entries added to deny list
+	// take effect at once
+	if v.opts.DenyList != nil {
+		if reason, ok := v.opts.DenyList.Match(cert, x5u); ok {
+			return errorf(http.StatusForbidden, "VESPER-4190", "%v - on deny list", reason)
+		}
+	}
+	// same for revocations, so that a certificate is not trusted once it is in the CRL
+	if v.opts.Revocations != nil && cert != nil && v.opts.Revocations.Revoked(cert) {
+		return errorf(http.StatusForbidden, "VESPER-4191", "certificate (serial number %x, issuer %v) at x5u %v is revoked", cert.SerialNumber, cert.Issuer.String(), x5u)
+	}
+	// curve of public key MUST be the one for alg
+	if a, ok := ecAlgorithms[alg]; !ok || pk.Curve.Params().Name != a.curve.Params().Name {
+		return errorf(http.StatusBadRequest, "VESPER-4189", "public key (curve %v) in certificate does not match alg %v in JWT header", pk.Curve.Params().Name, alg)
+	}
+	if err := verifyEC(token, alg, pk); err != nil {
+		return &Error{Code: "VESPER-4166", Status: http.StatusUnauthorized, Err: err}
+	}
+	return nil
+}
+
+// fetchCertificate fetches the certificate at x5u and validates it (against roots, if VerifyRootCA
+// is true). The certificate and the chains it is validated in are returned
+func (v *Verifier) fetchCertificate(ctx context.Context, roots *x509.CertPool, x5u string) (*x509.Certificate, [][]*x509.Certificate, error) {
+	client := v.opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("GET", x5u, nil)
+	if err != nil {
+		return nil, nil, &Error{Code: "VESPER-4156", Status: http.StatusBadRequest, Err: err}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, &Error{Code: "VESPER-4156", Status: http.StatusBadRequest, Err: err}
+	}
+	defer resp.Body.Close()
+	// Writer the body to buffer
+	certBuffer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, &Error{Code: "VESPER-4157", Status: http.StatusBadRequest, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, errorf(http.StatusBadRequest, "VESPER-4156", "%v", string(certBuffer))
+	}
+	block, _ := pem.Decode(certBuffer)
+	if block == nil {
+		return nil, nil, errorf(http.StatusBadRequest, "VESPER-4158", "no PEM data is found")
+	}
+	// parse certificate
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, &Error{Code: "VESPER-4159", Status: http.StatusBadRequest, Err: err}
+	}
+	opts := x509.VerifyOptions{CurrentTime: v.opts.Clock.now()}
+	if v.opts.VerifyRootCA {
+		opts.Roots = roots
+	}
+	chains, err := cert.Verify(opts)
+	if err != nil {
+		// errors are classified by type - their messages differ between Go versions
+		code := "VESPER-4164"
+		var invalid x509.CertificateInvalidError
+		var unknown x509.UnknownAuthorityError
+		switch {
+		case errors.As(err, &invalid) && invalid.Reason == x509.Expired :
+			// reported even if certificate is not validated against root certs
+			return nil, nil, &Error{Code: "VESPER-4160", Status: http.StatusBadRequest, Err: err}
+		case errors.As(err, &unknown) :
+			code = "VESPER-4161"
+		case errors.As(err, &invalid) && invalid.Reason == x509.NotAuthorizedToSign :
+			code = "VESPER-4162"
+		case errors.As(err, &invalid) && invalid.Reason == x509.NameMismatch :
+			code = "VESPER-4163"
+		}
+		if v.opts.VerifyRootCA {
+			return nil, nil, &Error{Code: code, Status: http.StatusBadRequest, Err: err}
+		}
+	}
+	return cert, chains, nil
+}
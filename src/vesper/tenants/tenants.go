@@ -0,0 +1,409 @@
+package tenants
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"os"
+	"sort"
+	"strings"
+	"reflect"
+	"encoding/json"
+	"vesper/sticr"
+	"vesper/signcredentials"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// globals
+var (
+	tenantsFileModifiedTime		int64
+	tenantsFileName						string
+	vesperCredentials					*signcredentials.SigningCredentials
+	uniqueFilenames						bool
+)
+
+// Policies - per tenant signing policies
+type Policies struct {
+	AllowedAttest			[]string	`json:"allowedAttest"`
+	AllowedRph				[]string	`json:"allowedRph"`
+	RequireApiKey			*bool			`json:"requireApiKey"`			// true if not configured
+}
+
+// tenantConfig - tenant as configured in tenants file
+type tenantConfig struct {
+	ID																string		`json:"id"`
+	ApiKeys														[]string	`json:"apiKeys"`
+	EksSigningPath										string		`json:"eksSigningPath"`
+	EksDelegatesPath									string		`json:"eksDelegatesPath"`
+	SticrHost													string		`json:"sticrHost"`
+	SigningCredentialsFetchInterval		int64			`json:"signingCredentialsFetchInterval"`
+	Policies													Policies	`json:"policies"`
+}
+
+// Tenant - a signing tenant (carrier) with its own signing credentials, sticr host and policies
+type Tenant struct {
+	ID							string
+	config					tenantConfig
+	credentials			*signcredentials.SigningCredentials
+	stop						chan struct{}
+}
+
+// Tenants - registry of all configured tenants
+type Tenants struct {
+	sync.RWMutex	// A field declared with a type but no explicit field name is an
+					// anonymous field, also called an embedded field or an embedding of
+					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	byID				map[string]*Tenant
+	byApiKey		map[string]*Tenant
+}
+
+// Initialize object
+// Saves file modified time for future use
+// if unique is true (built-in STI-CR enabled), filenames published by signing credentials sc of Vesper
+// itself (nil if none) or by a tenant cannot be used by another tenant, since the built-in STI-CR serves
+// certificates of Vesper and of all tenants under the same path. Tenants with their own STI-CR may reuse
+// filenames
+// signcredentials.InitObject MUST be called before this function
+func InitObject(l kitlog.Logger, f string, sc *signcredentials.SigningCredentials, unique bool) (*Tenants, error) {
+	glogger = l
+	vesperCredentials = sc
+	uniqueFilenames = unique
+	if len(strings.TrimSpace(f)) == 0 {
+		return nil, fmt.Errorf("tenants file name is an empty string")
+	}
+	tenantsFileName = f
+	tenantsFileModifiedTime = 0
+	tc, m, err := readTenantsFile(tenantsFileName)
+	if err != nil {
+		return nil, err
+	}
+	ts := &Tenants{byID: make(map[string]*Tenant), byApiKey: make(map[string]*Tenant)}
+	for _, c := range tc {
+		t, err := newTenant(c)
+		if err != nil {
+			ts.stopAll()
+			return nil, fmt.Errorf("%v - tenant %v", err, c.ID)
+		}
+		ts.byID[t.ID] = t
+	}
+	owners := map[string][]string{"Vesper": vesperFilenames()}
+	for id, t := range ts.byID {
+		owners["tenant " + id] = t.credentials.Filenames()
+	}
+	if err := checkFilenames(owners); err != nil {
+		ts.stopAll()
+		return nil, err
+	}
+	ts.byApiKey = indexApiKeys(ts.byID)
+	tenantsFileModifiedTime = m
+	return ts, nil
+}
+
+// update tenants if tenants file has changed
+// tenants whose configuration is unchanged keep their credentials (and refresh ticker)
+func (ts *Tenants) UpdateTenants() error {
+	tc, m, err := readTenantsFile(tenantsFileName)
+	if err != nil {
+		return err
+	}
+	if tc == nil {
+		// no changes in tenants file
+		return nil
+	}
+	ts.RLock()
+	current := make(map[string]*Tenant)
+	for k, v := range ts.byID {
+		current[k] = v
+	}
+	ts.RUnlock()
+	updated := make(map[string]*Tenant)
+	for _, c := range tc {
+		if t, ok := current[c.ID]; ok && reflect.DeepEqual(t.config, c) {
+			updated[c.ID] = t
+			continue
+		}
+		t, err := newTenant(c)
+		if err != nil {
+			// keep serving the tenant with the previous configuration, if any
+			logError("type", "tenants", "module", "UpdateTenants", "tenant", c.ID, "error", fmt.Sprintf("%v - unable to apply updated tenant configuration", err))
+			if t, ok := current[c.ID]; ok {
+				updated[c.ID] = t
+			}
+			continue
+		}
+		updated[c.ID] = t
+	}
+	// tenants with updated configuration are not applied if they publish a certificate under a filename
+	// that is already published by Vesper or by another tenant
+	owners := map[string][]string{"Vesper": vesperFilenames()}
+	var changed []string
+	for id, t := range updated {
+		if u, ok := current[id]; ok && u == t {
+			owners["tenant " + id] = t.credentials.Filenames()
+		} else {
+			changed = append(changed, id)
+		}
+	}
+	sort.Strings(changed)
+	for _, id := range changed {
+		t := updated[id]
+		owners["tenant " + id] = t.credentials.Filenames()
+		if err := checkFilenames(owners); err != nil {
+			logError("type", "tenants", "module", "UpdateTenants", "tenant", id, "error", fmt.Sprintf("%v - unable to apply updated tenant configuration", err))
+			close(t.stop)
+			delete(owners, "tenant " + id)
+			delete(updated, id)
+			if u, ok := current[id]; ok {
+				updated[id] = u
+				owners["tenant " + id] = u.credentials.Filenames()
+			}
+			continue
+		}
+		logInfo("type", "tenants", "module", "UpdateTenants", "tenant", id, "message", "tenant configuration applied")
+	}
+	// stop refresh tickers of tenants that were removed or replaced
+	for k, t := range current {
+		if u, ok := updated[k]; !ok || u != t {
+			close(t.stop)
+			if !ok {
+				logInfo("type", "tenants", "module", "UpdateTenants", "tenant", k, "message", "tenant removed")
+			}
+		}
+	}
+	byApiKey := indexApiKeys(updated)
+	ts.Lock()
+	ts.byID = updated
+	ts.byApiKey = byApiKey
+	ts.Unlock()
+	// save the modified time of the applied file, so that a file that is not valid is reported until fixed
+	tenantsFileModifiedTime = m
+	return nil
+}
+
+// using Rlock() allows multiple goroutines to read at the "same" time
+func (ts *Tenants) ByID(id string) *Tenant {
+	ts.RLock()
+	defer ts.RUnlock()
+	return ts.byID[id]
+}
+
+// using Rlock() allows multiple goroutines to read at the "same" time
+func (ts *Tenants) ByApiKey(k string) *Tenant {
+	ts.RLock()
+	defer ts.RUnlock()
+	return ts.byApiKey[k]
+}
+
+// using Rlock() allows multiple goroutines to read at the "same" time
+func (ts *Tenants) All() []*Tenant {
+	ts.RLock()
+	defer ts.RUnlock()
+	var l []*Tenant
+	for _, t := range ts.byID {
+		l = append(l, t)
+	}
+	return l
+}
+
+// signing credentials of tenant
+func (t *Tenant) Credentials() *signcredentials.SigningCredentials {
+	return t.credentials
+}
+
+// returns true if the tenant can only be selected using one of its API keys
+func (t *Tenant) RequireApiKey() bool {
+	return t.config.Policies.requireApiKey()
+}
+
+// a tenant can only be selected using one of its API keys, unless "requireApiKey" policy is false
+func (p Policies) requireApiKey() bool {
+	return p.RequireApiKey == nil || *p.RequireApiKey
+}
+
+// returns true if the tenant is allowed to sign with attestation level a
+// all attestation levels are allowed if the policy is not configured
+func (t *Tenant) AttestAllowed(a string) bool {
+	if len(t.config.Policies.AllowedAttest) == 0 {
+		return true
+	}
+	for _, v := range t.config.Policies.AllowedAttest {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if the tenant is allowed to sign "rph" PASSporTs (RFC 8443) asserting
+// resource priority value v (namespace.priority, e.g. "ets.0")
+// policy entries are either values ("ets.0") or namespaces ("ets", any priority in namespace)
+// no resource priority value is allowed if the policy is not configured
+func (t *Tenant) RphAllowed(v string) bool {
+	ns := v
+	if i := strings.Index(v, "."); i >= 0 {
+		ns = v[:i]
+	}
+	for _, a := range t.config.Policies.AllowedRph {
+		if strings.EqualFold(a, v) || strings.EqualFold(a, ns) {
+			return true
+		}
+	}
+	return false
+}
+
+// build API key index
+func indexApiKeys(byID map[string]*Tenant) map[string]*Tenant {
+	byApiKey := make(map[string]*Tenant)
+	for _, t := range byID {
+		for _, k := range t.config.ApiKeys {
+			byApiKey[k] = t
+		}
+	}
+	return byApiKey
+}
+
+// stop refresh tickers of all tenants
+func (ts *Tenants) stopAll() {
+	for _, t := range ts.byID {
+		close(t.stop)
+	}
+}
+
+// returns filenames published by signing credentials of Vesper itself
+func vesperFilenames() []string {
+	if vesperCredentials == nil {
+		return nil
+	}
+	return vesperCredentials.Filenames()
+}
+
+// checkFilenames returns an error if a filename is published by more than one owner. owners are
+// the filenames (of certificates served by the built-in STI-CR) by owner (Vesper or tenant)
+// filenames are not checked if the built-in STI-CR is not enabled
+func checkFilenames(owners map[string][]string) error {
+	if !uniqueFilenames {
+		return nil
+	}
+	var names []string
+	for k := range owners {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	published := make(map[string]string)
+	for _, o := range names {
+		for _, fn := range owners[o] {
+			if p, ok := published[fn]; ok && p != o {
+				return fmt.Errorf("filename %v is published by both %v and %v - filenames MUST be unique across tenants", fn, p, o)
+			}
+			published[fn] = o
+		}
+	}
+	return nil
+}
+
+// create tenant, fetch its signing credentials and start the ticker that refreshes them
+func newTenant(c tenantConfig) (*Tenant, error) {
+	cr, err := sticr.NewObject(c.SticrHost)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := signcredentials.NewObject(c.EksSigningPath, c.EksDelegatesPath, cr)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tenant{ID: c.ID, config: c, credentials: sc, stop: make(chan struct{})}
+	go t.refresh()
+	return t, nil
+}
+
+// periodic refresh of tenant signing credentials
+// each tenant refreshes independently of other tenants
+func (t *Tenant) refresh() {
+	// NewTicker returns a new Ticker containing a channel that will send the time with
+	// a period specified by the duration argument. It adjusts the intervals or drops
+	// ticks to make up for slow receiver.
+	// https://golang.org/pkg/time/#NewTicker
+	ticker := time.NewTicker(time.Duration(t.config.SigningCredentialsFetchInterval)*time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <- ticker.C:
+			if err := t.credentials.FetchSigningCredentialsFromEks(); err != nil {
+				logError("type", "tenants", "module", "refresh", "tenant", t.ID, "error", fmt.Sprintf("%v - unable to refresh signing credentials", err))
+			}
+		case <- t.stop:
+			logInfo("type", "timerStop", "tenant", t.ID, "message", "stopped tenant signing credentials refresh ticker")
+			return
+		}
+	}
+}
+
+// Read tenants file only if file modified time has changed
+// returns nil (and no error) if the file has not been modified since it was last applied, and the
+// modified time of the file, to be saved once the file is applied
+func readTenantsFile(n string) ([]tenantConfig, int64, error) {
+	var m int64
+	f, err := os.Open(n)
+	if err != nil {
+		return nil, m, fmt.Errorf("%v - tenants file", err)
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err == nil {
+		m = fi.ModTime().Unix()
+		if tenantsFileModifiedTime == m {
+			return nil, m, nil
+		}
+	}
+	var c struct {
+		Tenants []tenantConfig `json:"tenants"`
+	}
+	decoder := json.NewDecoder(f)
+	err = decoder.Decode(&c)
+	if err != nil {
+		return nil, m, fmt.Errorf("%v - decode JSON object in tenants file", err)
+	}
+	if len(c.Tenants) == 0 {
+		return nil, m, fmt.Errorf("\"tenants\" field missing or empty in tenants file")
+	}
+	ids := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, t := range c.Tenants {
+		if len(strings.TrimSpace(t.ID)) == 0 {
+			return nil, m, fmt.Errorf("\"id\" field missing or empty for tenant at index %v", i)
+		}
+		if ids[t.ID] {
+			return nil, m, fmt.Errorf("tenant %v is configured more than once", t.ID)
+		}
+		ids[t.ID] = true
+		for _, k := range t.ApiKeys {
+			if len(strings.TrimSpace(k)) == 0 {
+				return nil, m, fmt.Errorf("\"apiKeys\" field contains an empty string for tenant %v", t.ID)
+			}
+			if keys[k] {
+				return nil, m, fmt.Errorf("API key for tenant %v is assigned to more than one tenant", t.ID)
+			}
+			keys[k] = true
+		}
+		if t.Policies.requireApiKey() && len(t.ApiKeys) == 0 {
+			return nil, m, fmt.Errorf("\"requireApiKey\" policy is true (default) but no \"apiKeys\" are configured for tenant %v", t.ID)
+		}
+		if len(strings.TrimSpace(t.EksSigningPath)) == 0 {
+			return nil, m, fmt.Errorf("\"eksSigningPath\" field missing or empty for tenant %v", t.ID)
+		}
+		if len(strings.TrimSpace(t.SticrHost)) == 0 {
+			return nil, m, fmt.Errorf("\"sticrHost\" field missing or empty for tenant %v", t.ID)
+		}
+		for _, a := range t.Policies.AllowedAttest {
+			switch a {
+			case "A", "B", "C":
+			default:
+				return nil, m, fmt.Errorf("\"allowedAttest\" policy for tenant %v contains %v which is not as per SHAKEN spec", t.ID, a)
+			}
+		}
+		if t.SigningCredentialsFetchInterval <= 0 {
+			c.Tenants[i].SigningCredentialsFetchInterval = 300
+		}
+	}
+	return c.Tenants, m, nil
+}
@@ -0,0 +1,69 @@
+package tenants
+
+import (
+	"os"
+	"testing"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+func TestCheckFilenames(t *testing.T) {
+	tests := []struct {
+		owners		map[string][]string
+		unique		bool
+		err				string
+	}{
+		{map[string][]string{"Vesper": {"sp.pem"}, "tenant a": {"a.pem", "a-delegate.pem"}, "tenant b": {"b.pem"}}, true, ""},
+		// same filename for active and retiring (or pending) certificates of the same owner
+		{map[string][]string{"Vesper": nil, "tenant a": {"a.pem", "a.pem"}}, true, ""},
+		// duplicate across tenants, or with Vesper
+		{map[string][]string{"tenant b": {"b.pem", "x.pem"}, "tenant a": {"a.pem", "x.pem"}}, true, "filename x.pem is published by both tenant a and tenant b"},
+		{map[string][]string{"Vesper": {"sp.pem"}, "tenant a": {"sp.pem"}}, true, "filename sp.pem is published by both Vesper and tenant a"},
+		{map[string][]string{"tenant a": {"a.pem"}, "tenant b": {"b-delegate.pem", "a.pem"}}, true, "filename a.pem"},
+		// built-in STI-CR not enabled - tenants publish to their own STI-CR
+		{map[string][]string{"Vesper": {"sp.pem"}, "tenant a": {"sp.pem"}, "tenant b": {"sp.pem"}}, false, ""},
+	}
+	defer func() { uniqueFilenames = false }()
+	for i, test := range tests {
+		uniqueFilenames = test.unique
+		err := checkFilenames(test.owners)
+		if (err == nil) != (len(test.err) == 0) || (err != nil && !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: got %v, want %q", i, err, test.err)
+		}
+	}
+}
+
+// a tenants file that is not valid is reported on every update until it is fixed
+func TestUpdateTenantsInvalidFile(t *testing.T) {
+	glogger = kitlog.NewNopLogger()
+	d, err := ioutil.TempDir("", "tenants")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(d)
+	tenantsFileName = filepath.Join(d, "tenants.json")
+	defer func() { tenantsFileName, tenantsFileModifiedTime = "", 0 }()
+	ts := &Tenants{byID: make(map[string]*Tenant), byApiKey: make(map[string]*Tenant)}
+	tests := []struct {
+		file			string
+		err				string
+	}{
+		{`{"tenants": []}`, "\"tenants\" field missing or empty"},
+		// same file - not modified since
+		{"", "\"tenants\" field missing or empty"},
+		{`{"tenants": [{"id": "a", "apiKeys": ["k"], "sticrHost": "https://cr.example.com"}]}`, "\"eksSigningPath\" field missing or empty"},
+		{"", "\"eksSigningPath\" field missing or empty"},
+	}
+	for i, test := range tests {
+		if len(test.file) > 0 {
+			if err := ioutil.WriteFile(tenantsFileName, []byte(test.file), 0600); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+		if err := ts.UpdateTenants(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got %v, want %q", i, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Package tnauthlist parses the TNAuthList certificate extension (RFC 8226)
+// carried by SHAKEN certificates and matches telephone numbers against it
+package tnauthlist
+
+import (
+	"fmt"
+	"strconv"
+	"crypto/x509"
+	"encoding/asn1"
+)
+
+// OID of TNAuthList certificate extension
+var OID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 26}
+
+// Entry - one entry in TNAuthList. Exactly one of SPC, TN or Start (with Count) is set
+type Entry struct {
+	SPC				string
+	TN				string
+	Start			string
+	Count			int64
+}
+
+// List - TNAuthList
+type List []Entry
+
+// TelephoneNumberRange ::= SEQUENCE { start TelephoneNumber, count INTEGER (2..MAX) }
+type telephoneNumberRange struct {
+	Start			string	`asn1:"ia5"`
+	Count			int64
+}
+
+// FromCertificate returns the TNAuthList in certificate c.
+// nil List (and no error) is returned if c does not have the extension
+func FromCertificate(c *x509.Certificate) (List, error) {
+	for _, e := range c.Extensions {
+		if e.Id.Equal(OID) {
+			return Parse(e.Value)
+		}
+	}
+	return nil, nil
+}
+
+// Parse DER encoded TNAuthList
+// TNAuthorizationList ::= SEQUENCE SIZE (1..MAX) OF TNEntry
+// TNEntry ::= CHOICE { spc [0] ServiceProviderCode, range [1] TelephoneNumberRange, one [2] TelephoneNumber }
+// Tags are EXPLICIT
+func Parse(der []byte) (List, error) {
+	var raw []asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("%v - unable to parse TNAuthList", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data after TNAuthList")
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("TNAuthList is empty")
+	}
+	var l List
+	for _, v := range raw {
+		if v.Class != asn1.ClassContextSpecific {
+			return nil, fmt.Errorf("TNAuthList entry is not a context specific tagged value")
+		}
+		switch v.Tag {
+		case 0:
+			var spc string
+			if _, err := asn1.UnmarshalWithParams(v.Bytes, &spc, "ia5"); err != nil {
+				return nil, fmt.Errorf("%v - unable to parse SPC in TNAuthList", err)
+			}
+			l = append(l, Entry{SPC: spc})
+		case 1:
+			var r telephoneNumberRange
+			if _, err := asn1.Unmarshal(v.Bytes, &r); err != nil {
+				return nil, fmt.Errorf("%v - unable to parse TN range in TNAuthList", err)
+			}
+			if !isTelephoneNumber(r.Start) || r.Count < 2 {
+				return nil, fmt.Errorf("invalid TN range (start: %v, count: %v) in TNAuthList", r.Start, r.Count)
+			}
+			l = append(l, Entry{Start: r.Start, Count: r.Count})
+		case 2:
+			var tn string
+			if _, err := asn1.UnmarshalWithParams(v.Bytes, &tn, "ia5"); err != nil {
+				return nil, fmt.Errorf("%v - unable to parse TN in TNAuthList", err)
+			}
+			if !isTelephoneNumber(tn) {
+				return nil, fmt.Errorf("invalid TN %v in TNAuthList", tn)
+			}
+			l = append(l, Entry{TN: tn})
+		default:
+			return nil, fmt.Errorf("unknown TNAuthList entry tag [%v]", v.Tag)
+		}
+	}
+	return l, nil
+}
+
+// Marshal returns DER encoded TNAuthList (e.g. for the TNAuthList extension in a CSR
+// or the TNAuthList identifier in an ACME order)
+func (l List) Marshal() ([]byte, error) {
+	if len(l) == 0 {
+		return nil, fmt.Errorf("TNAuthList is empty")
+	}
+	var raw []asn1.RawValue
+	for _, e := range l {
+		var tag int
+		var b []byte
+		var err error
+		switch {
+		case len(e.SPC) > 0 :
+			tag = 0
+			b, err = asn1.MarshalWithParams(e.SPC, "ia5")
+		case len(e.Start) > 0 :
+			if !isTelephoneNumber(e.Start) || e.Count < 2 {
+				return nil, fmt.Errorf("invalid TN range (start: %v, count: %v) in TNAuthList", e.Start, e.Count)
+			}
+			tag = 1
+			b, err = asn1.Marshal(telephoneNumberRange{Start: e.Start, Count: e.Count})
+		case len(e.TN) > 0 :
+			if !isTelephoneNumber(e.TN) {
+				return nil, fmt.Errorf("invalid TN %v in TNAuthList", e.TN)
+			}
+			tag = 2
+			b, err = asn1.MarshalWithParams(e.TN, "ia5")
+		default:
+			return nil, fmt.Errorf("empty TNAuthList entry")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v - unable to encode TNAuthList", err)
+		}
+		raw = append(raw, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: b})
+	}
+	der, err := asn1.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%v - unable to encode TNAuthList", err)
+	}
+	return der, nil
+}
+
+// SPCs returns all service provider codes in list
+func (l List) SPCs() []string {
+	var s []string
+	for _, e := range l {
+		if len(e.SPC) > 0 {
+			s = append(s, e.SPC)
+		}
+	}
+	return s
+}
+
+// Covers returns true if telephone number tn is covered by a TN or TN range entry in list.
+// It also returns the number of TNs covered by the most specific matching entry
+// (1 for a TN, count for a TN range) so that callers can choose the most specific list.
+// SPC entries do not cover any specific TN
+func (l List) Covers(tn string) (bool, int64) {
+	var size int64
+	found := false
+	for _, e := range l {
+		var n int64
+		switch {
+		case len(e.TN) > 0 :
+			if e.TN != tn {
+				continue
+			}
+			n = 1
+		case len(e.Start) > 0 :
+			if !inRange(tn, e.Start, e.Count) {
+				continue
+			}
+			n = e.Count
+		default:
+			continue
+		}
+		if !found || n < size {
+			size = n
+			found = true
+		}
+	}
+	return found, size
+}
+
+// inRange returns true if tn is in [start, start + count - 1]
+// numbers in range have the same number of digits as start
+func inRange(tn, start string, count int64) bool {
+	if len(tn) != len(start) || !isDigits(tn) || !isDigits(start) {
+		return false
+	}
+	t, err := strconv.ParseUint(tn, 10, 64)
+	if err != nil {
+		return false
+	}
+	s, err := strconv.ParseUint(start, 10, 64)
+	if err != nil {
+		return false
+	}
+	return t >= s && t - s < uint64(count)
+}
+
+// TelephoneNumber ::= IA5String (SIZE (1..15)) (FROM ("0123456789#*"))
+func isTelephoneNumber(s string) bool {
+	if len(s) == 0 || len(s) > 15 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && c != '#' && c != '*' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
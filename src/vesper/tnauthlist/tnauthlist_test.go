@@ -0,0 +1,79 @@
+package tnauthlist
+
+import (
+	"testing"
+	"encoding/asn1"
+)
+
+// explicitly tagged TNEntry
+func entry(t *testing.T, tag int, v interface{}, params string) asn1.RawValue {
+	b, err := asn1.MarshalWithParams(v, params)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: b}
+}
+
+func TestParse(t *testing.T) {
+	der, err := asn1.Marshal([]asn1.RawValue{
+		entry(t, 0, "1234", "ia5"),
+		entry(t, 1, telephoneNumberRange{Start: "12155551200", Count: 100}, ""),
+		entry(t, 2, "12155551212", "ia5"),
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	l, err := Parse(der)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(l) != 3 || l[0].SPC != "1234" || l[1].Start != "12155551200" || l[1].Count != 100 || l[2].TN != "12155551212" {
+		t.Fatalf("unexpected TNAuthList %+v", l)
+	}
+	if s := l.SPCs(); len(s) != 1 || s[0] != "1234" {
+		t.Fatalf("unexpected SPCs %+v", s)
+	}
+	if _, err := Parse([]byte{0x30, 0x00}); err == nil {
+		t.Fatalf("empty TNAuthList parsed without error")
+	}
+}
+
+func TestCovers(t *testing.T) {
+	l := List{{SPC: "1234"}, {Start: "12155551200", Count: 100}, {TN: "12155551212"}}
+	tests := []struct {
+		tn			string
+		covered	bool
+		size		int64
+	}{
+		{"12155551212", true, 1},
+		{"12155551200", true, 100},
+		{"12155551299", true, 100},
+		{"12155551300", false, 0},
+		{"2155551250", false, 0},
+		{"1234", false, 0},
+	}
+	for _, tc := range tests {
+		covered, size := l.Covers(tc.tn)
+		if covered != tc.covered || size != tc.size {
+			t.Errorf("Covers(%v) = %v, %v; want %v, %v", tc.tn, covered, size, tc.covered, tc.size)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	l := List{{SPC: "1234"}, {Start: "12155551200", Count: 100}, {TN: "12155551212"}}
+	der, err := l.Marshal()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	p, err := Parse(der)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(p) != len(l) || p[0] != l[0] || p[1] != l[1] || p[2] != l[2] {
+		t.Fatalf("Parse(Marshal()) = %+v; want %+v", p, l)
+	}
+	if _, err := (List{{TN: "1215555121x"}}).Marshal(); err == nil {
+		t.Fatalf("Marshal accepted invalid TN")
+	}
+}
@@ -0,0 +1,313 @@
+package trustdomains
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"os"
+	"path"
+	"strings"
+	"reflect"
+	"net"
+	"net/url"
+	"encoding/json"
+	"vesper/rootcerts"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// globals
+var (
+	trustDomainsFileModifiedTime		int64
+	trustDomainsFileName						string
+)
+
+// DefaultName - name of the trust domain whose root certs are in the EKS whitelist.
+// It is used when no configured trust domain is selected (unless one is configured as default)
+const DefaultName = "default"
+
+// domainConfig - trust domain as configured in trust domains file
+type domainConfig struct {
+	Name								string							`json:"name"`
+	RootSource					rootcerts.Source		`json:"rootSource"`
+	RefreshInterval			int64								`json:"refreshInterval"`
+	X5uHosts						[]string						`json:"x5uHosts"`
+	ApiKeys							[]string						`json:"apiKeys"`
+	Default							bool								`json:"default"`
+}
+
+// Domain - a named trust domain with its own root certs (trust anchors)
+type Domain struct {
+	Name								string
+	config							domainConfig
+	roots								*rootcerts.RootCerts
+	stop								chan struct{}
+}
+
+// TrustDomains - registry of all configured trust domains
+type TrustDomains struct {
+	sync.RWMutex	// A field declared with a type but no explicit field name is an
+					// anonymous field, also called an embedded field or an embedding of
+					// the type in the structembedded. see http://golang.org/ref/spec#Struct_types
+	domains			[]*Domain						// in the order configured
+	byApiKey		map[string]*Domain
+	whitelist		*Domain							// root certs in EKS whitelist
+	fallback		*Domain							// used when no trust domain is selected
+}
+
+// Initialize object
+// Saves file modified time for future use
+// rootcerts.InitObject MUST be called before this function. rc (root certs in EKS whitelist)
+// is the trust domain named DefaultName
+func InitObject(l kitlog.Logger, f string, rc *rootcerts.RootCerts) (*TrustDomains, error) {
+	glogger = l
+	if len(strings.TrimSpace(f)) == 0 {
+		return nil, fmt.Errorf("trust domains file name is an empty string")
+	}
+	trustDomainsFileName = f
+	trustDomainsFileModifiedTime = 0
+	dc, m, err := readTrustDomainsFile(trustDomainsFileName)
+	if err != nil {
+		return nil, err
+	}
+	td := &TrustDomains{whitelist: &Domain{Name: DefaultName, roots: rc}}
+	var domains []*Domain
+	for _, c := range dc {
+		d, err := newDomain(c)
+		if err != nil {
+			stopAll(domains)
+			return nil, fmt.Errorf("%v - trust domain %v", err, c.Name)
+		}
+		domains = append(domains, d)
+	}
+	td.set(domains)
+	trustDomainsFileModifiedTime = m
+	return td, nil
+}
+
+// update trust domains if trust domains file has changed
+// trust domains whose configuration is unchanged keep their root certs (and refresh ticker)
+func (td *TrustDomains) UpdateTrustDomains() error {
+	dc, m, err := readTrustDomainsFile(trustDomainsFileName)
+	if err != nil {
+		return err
+	}
+	if dc == nil {
+		// no changes in trust domains file
+		return nil
+	}
+	td.RLock()
+	current := make(map[string]*Domain)
+	for _, d := range td.domains {
+		current[d.Name] = d
+	}
+	td.RUnlock()
+	var updated []*Domain
+	kept := make(map[*Domain]bool)
+	for _, c := range dc {
+		if d, ok := current[c.Name]; ok && reflect.DeepEqual(d.config, c) {
+			updated = append(updated, d)
+			kept[d] = true
+			continue
+		}
+		d, err := newDomain(c)
+		if err != nil {
+			// keep the trust domain with the previous configuration, if any
+			logError("type", "trustDomains", "module", "UpdateTrustDomains", "trustDomain", c.Name, "error", fmt.Sprintf("%v - unable to apply updated trust domain configuration", err))
+			if d, ok := current[c.Name]; ok {
+				updated = append(updated, d)
+				kept[d] = true
+			}
+			continue
+		}
+		updated = append(updated, d)
+		logInfo("type", "trustDomains", "module", "UpdateTrustDomains", "trustDomain", c.Name, "message", "trust domain configuration applied")
+	}
+	// stop refresh tickers of trust domains that were removed or replaced
+	for k, d := range current {
+		if !kept[d] {
+			close(d.stop)
+			logInfo("type", "trustDomains", "module", "UpdateTrustDomains", "trustDomain", k, "message", "previous trust domain configuration removed")
+		}
+	}
+	td.set(updated)
+	// save the modified time of the applied file, so that a file that is not valid is reported until fixed
+	trustDomainsFileModifiedTime = m
+	return nil
+}
+
+// Select returns the trust domain for a verification request
+// the trust domain is selected (in this order) by API key k, by x5u host or is the default trust domain
+// using Rlock() allows multiple goroutines to read at the "same" time
+func (td *TrustDomains) Select(k, x5u string) *Domain {
+	td.RLock()
+	defer td.RUnlock()
+	if d, ok := td.byApiKey[k]; ok && len(k) > 0 {
+		return d
+	}
+	if h := x5uHost(x5u); len(h) > 0 {
+		for _, d := range td.domains {
+			for _, p := range d.config.X5uHosts {
+				if ok, _ := path.Match(strings.ToLower(p), h); ok {
+					return d
+				}
+			}
+		}
+	}
+	return td.fallback
+}
+
+// Domains returns all trust domains, including the one named DefaultName
+func (td *TrustDomains) Domains() []*Domain {
+	td.RLock()
+	defer td.RUnlock()
+	return append([]*Domain{td.whitelist}, td.domains...)
+}
+
+// returns root certs (trust anchors) of trust domain
+func (d *Domain) Roots() *rootcerts.RootCerts {
+	return d.roots
+}
+
+// replace trust domains and their indices
+func (td *TrustDomains) set(domains []*Domain) {
+	byApiKey := make(map[string]*Domain)
+	fallback := td.whitelist
+	for _, d := range domains {
+		for _, k := range d.config.ApiKeys {
+			byApiKey[k] = d
+		}
+		if d.config.Default {
+			fallback = d
+		}
+	}
+	td.Lock()
+	defer td.Unlock()
+	td.domains = domains
+	td.byApiKey = byApiKey
+	td.fallback = fallback
+}
+
+// stop refresh tickers of trust domains
+func stopAll(domains []*Domain) {
+	for _, d := range domains {
+		close(d.stop)
+	}
+}
+
+// create trust domain, fetch its root certs and start the ticker that refreshes them
+func newDomain(c domainConfig) (*Domain, error) {
+	rc, err := rootcerts.NewObject(c.RootSource)
+	if err != nil {
+		return nil, err
+	}
+	d := &Domain{Name: c.Name, config: c, roots: rc, stop: make(chan struct{})}
+	go d.refresh()
+	return d, nil
+}
+
+// periodic refresh of trust domain root certs
+// each trust domain refreshes independently of other trust domains
+func (d *Domain) refresh() {
+	// NewTicker returns a new Ticker containing a channel that will send the time with
+	// a period specified by the duration argument. It adjusts the intervals or drops
+	// ticks to make up for slow receiver.
+	// https://golang.org/pkg/time/#NewTicker
+	ticker := time.NewTicker(time.Duration(d.config.RefreshInterval)*time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <- ticker.C:
+			if err := d.roots.Refresh(); err != nil {
+				logError("type", "trustDomains", "module", "refresh", "trustDomain", d.Name, "error", fmt.Sprintf("%v - unable to refresh root certs", err))
+			}
+		case <- d.stop:
+			logInfo("type", "timerStop", "trustDomain", d.Name, "message", "stopped trust domain root certs refresh ticker")
+			return
+		}
+	}
+}
+
+// returns host (lower case, without port) in x5u
+func x5uHost(x5u string) string {
+	u, err := url.Parse(x5u)
+	if err != nil {
+		return ""
+	}
+	h := u.Host
+	if hh, _, err := net.SplitHostPort(h); err == nil {
+		h = hh
+	}
+	return strings.ToLower(h)
+}
+
+// Read trust domains file only if file modified time has changed
+// returns nil (and no error) if the file has not been modified since it was last applied, and the
+// modified time of the file, to be saved once the file is applied
+func readTrustDomainsFile(n string) ([]domainConfig, int64, error) {
+	var m int64
+	f, err := os.Open(n)
+	if err != nil {
+		return nil, m, fmt.Errorf("%v - trust domains file", err)
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err == nil {
+		m = fi.ModTime().Unix()
+		if trustDomainsFileModifiedTime == m {
+			return nil, m, nil
+		}
+	}
+	var c struct {
+		TrustDomains []domainConfig `json:"trustDomains"`
+	}
+	decoder := json.NewDecoder(f)
+	err = decoder.Decode(&c)
+	if err != nil {
+		return nil, m, fmt.Errorf("%v - decode JSON object in trust domains file", err)
+	}
+	if len(c.TrustDomains) == 0 {
+		return nil, m, fmt.Errorf("\"trustDomains\" field missing or empty in trust domains file")
+	}
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	defaults := 0
+	for i, d := range c.TrustDomains {
+		if len(strings.TrimSpace(d.Name)) == 0 {
+			return nil, m, fmt.Errorf("\"name\" field missing or empty for trust domain at index %v", i)
+		}
+		if d.Name == DefaultName {
+			return nil, m, fmt.Errorf("trust domain name %v is reserved for root certs in EKS whitelist", d.Name)
+		}
+		if names[d.Name] {
+			return nil, m, fmt.Errorf("trust domain %v is configured more than once", d.Name)
+		}
+		names[d.Name] = true
+		if err := d.RootSource.Validate(); err != nil {
+			return nil, m, fmt.Errorf("%v - \"rootSource\" for trust domain %v", err, d.Name)
+		}
+		for _, k := range d.ApiKeys {
+			if len(strings.TrimSpace(k)) == 0 {
+				return nil, m, fmt.Errorf("\"apiKeys\" field contains an empty string for trust domain %v", d.Name)
+			}
+			if keys[k] {
+				return nil, m, fmt.Errorf("API key for trust domain %v is assigned to more than one trust domain", d.Name)
+			}
+			keys[k] = true
+		}
+		for _, p := range d.X5uHosts {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, m, fmt.Errorf("%v - \"x5uHosts\" pattern %v for trust domain %v", err, p, d.Name)
+			}
+		}
+		if d.Default {
+			defaults++
+		}
+		if d.RefreshInterval <= 0 {
+			c.TrustDomains[i].RefreshInterval = 300
+		}
+	}
+	if defaults > 1 {
+		return nil, m, fmt.Errorf("more than one trust domain is configured as default")
+	}
+	return c.TrustDomains, m, nil
+}
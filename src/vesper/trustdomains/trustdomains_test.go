@@ -0,0 +1,105 @@
+package trustdomains
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// newTrustDomains returns trust domains configured as in c (without root certs)
+func newTrustDomains(c ...domainConfig) *TrustDomains {
+	td := &TrustDomains{whitelist: &Domain{Name: DefaultName}}
+	var domains []*Domain
+	for _, dc := range c {
+		domains = append(domains, &Domain{Name: dc.Name, config: dc})
+	}
+	td.set(domains)
+	return td
+}
+
+func TestSelect(t *testing.T) {
+	td := newTrustDomains(
+		domainConfig{Name: "partner", X5uHosts: []string{"cr.partner.com"}, ApiKeys: []string{"k1"}},
+		domainConfig{Name: "wildcard", X5uHosts: []string{"*.EXAMPLE.com"}, ApiKeys: []string{"k2"}},
+		domainConfig{Name: "overlap", X5uHosts: []string{"cr.example.com", "::1"}},
+	)
+	tests := []struct {
+		k, x5u		string
+		name			string
+	}{
+		// API key takes precedence over x5u host
+		{"k1", "https://cr.example.com/1.pem", "partner"},
+		{"k2", "https://cr.partner.com/1.pem", "wildcard"},
+		// unknown (or no) API key - x5u host
+		{"k3", "https://cr.partner.com/1.pem", "partner"},
+		{"", "https://cr.partner.com/1.pem", "partner"},
+		// host is matched in lower case, without port
+		{"", "https://CR.Partner.COM:8443/1.pem", "partner"},
+		{"", "https://cr.partner.com:443/1.pem", "partner"},
+		// first trust domain (in the order configured) whose pattern matches
+		{"", "https://cr.example.com/1.pem", "wildcard"},
+		{"", "https://a.b.example.com/1.pem", "wildcard"},
+		{"", "https://[::1]:8443/1.pem", "overlap"},
+		// no match - trust domain of EKS whitelist
+		{"", "https://example.com/1.pem", DefaultName},
+		{"", "https://cr.partner.com.evil.net/1.pem", DefaultName},
+		{"", "https://evil.net/cr.partner.com/1.pem", DefaultName},
+		{"", "not a URL %zz", DefaultName},
+		{"", "", DefaultName},
+	}
+	for i, test := range tests {
+		if d := td.Select(test.k, test.x5u); d.Name != test.name {
+			t.Errorf("%v: Select(%q, %q) = %v; want %v", i, test.k, test.x5u, d.Name, test.name)
+		}
+	}
+	// trust domain configured as default is the fallback
+	td = newTrustDomains(
+		domainConfig{Name: "partner", X5uHosts: []string{"cr.partner.com"}},
+		domainConfig{Name: "fallback", Default: true},
+	)
+	if d := td.Select("", "https://cr.example.com/1.pem"); d.Name != "fallback" {
+		t.Errorf("got %v, want fallback", d.Name)
+	}
+	if d := td.Select("", "https://cr.partner.com/1.pem"); d.Name != "partner" {
+		t.Errorf("got %v, want partner", d.Name)
+	}
+	if l := td.Domains(); len(l) != 3 || l[0].Name != DefaultName {
+		t.Errorf("got %v trust domains, want 3 (first %v)", len(l), DefaultName)
+	}
+}
+
+// a trust domains file that is not valid is reported on every update until it is fixed
+func TestUpdateTrustDomainsInvalidFile(t *testing.T) {
+	glogger = kitlog.NewNopLogger()
+	d, err := ioutil.TempDir("", "trustdomains")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(d)
+	trustDomainsFileName = filepath.Join(d, "trust_domains.json")
+	defer func() { trustDomainsFileName, trustDomainsFileModifiedTime = "", 0 }()
+	td := newTrustDomains()
+	tests := []struct {
+		file			string
+		err				string
+	}{
+		{`{"trustDomains": []}`, "\"trustDomains\" field missing or empty"},
+		// same file - not modified since
+		{"", "\"trustDomains\" field missing or empty"},
+		{`{"trustDomains": [{"name": "default"}]}`, "is reserved"},
+		{"", "is reserved"},
+	}
+	for i, test := range tests {
+		if len(test.file) > 0 {
+			if err := ioutil.WriteFile(trustDomainsFileName, []byte(test.file), 0600); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+		if err := td.UpdateTrustDomains(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got %v, want %q", i, err, test.err)
+		}
+	}
+}